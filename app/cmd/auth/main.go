@@ -2,24 +2,60 @@ package main
 
 import (
 	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/dpop"
+	"auth_service/internal/JWT/idtoken"
 	"auth_service/internal/config"
+	"auth_service/internal/controller"
+	"auth_service/internal/cors"
 	grpccontroller "auth_service/internal/grpc_controller"
 	"auth_service/internal/health"
+	"auth_service/internal/inprocess"
+	"auth_service/internal/interceptors"
 	"auth_service/internal/logger"
+	"auth_service/internal/realm"
+	"auth_service/internal/server"
+	"auth_service/internal/services/account"
+	"auth_service/internal/services/admin"
 	"auth_service/internal/services/auth"
+	"auth_service/internal/services/federation"
+	"auth_service/internal/services/ldapauth"
+	"auth_service/internal/services/oauth"
+	"auth_service/internal/services/organization"
+	"auth_service/internal/services/saml"
+	"auth_service/internal/services/serviceaccount"
 	redis "auth_service/internal/storage/Redis"
 	postgresstorage "auth_service/internal/storage/postgresStorage"
+	"auth_service/internal/tlsconfig"
 	"auth_service/protos/gen/go/authservicegen"
+	"context"
+	"crypto/tls"
+	"log/slog"
 	"net"
-	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
+// publicMethods can be called without an access token
+var publicMethods = []string{
+	authservicegen.AuthService_Register_FullMethodName,
+	authservicegen.AuthService_Login_FullMethodName,
+	authservicegen.AuthService_Refresh_FullMethodName,
+	authservicegen.AuthService_Logout_FullMethodName,
+	authservicegen.AuthService_Introspect_FullMethodName,
+	authservicegen.AuthService_AcceptInvitation_FullMethodName,
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.v1.ServerReflection/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+}
+
 func main() {
 	cfg := config.MustLoad()
 
@@ -34,36 +70,241 @@ func main() {
 
 	logger.Info("Redis succesfully connected")
 
-	jwt := &jwtman.JWTManager{
-		SecretKey:     []byte(os.Getenv("JWT_SECRET")),
-		TokenDuration: 15 * time.Minute,
+	defaultRealm := &realm.Realm{
+		Name:   realm.Default,
+		Issuer: strings.TrimSuffix(cfg.OAuth.Issuer, "/"),
+		JWT: &jwtman.JWTManager{
+			SecretKey:     []byte(os.Getenv("JWT_SECRET")),
+			TokenDuration: 15 * time.Minute,
+		},
+	}
+	var realmsCfg realm.Config
+	if cfg.RealmsFile != "" {
+		if realmsCfg, err = realm.LoadConfig(cfg.RealmsFile); err != nil {
+			panic("Failed load realms config: " + err.Error())
+		}
+	}
+	realms, err := realmsCfg.Build(defaultRealm)
+	if err != nil {
+		panic("Failed configure realms: " + err.Error())
 	}
+	if cfg.RealmsFile != "" {
+		logger.Info("Realms enabled", slog.Any("realms", realms.Names()))
+	}
+	// a configured default realm replaces the settings from the environment
+	defaultRealm, _ = realms.Get(realm.Default)
+	jwt := defaultRealm.JWT
 
 	authSvc := auth.NewAuth(logger, storage, rds, jwt)
+	authSvc.DPoP = dpop.NewVerifier(rds, cfg.DPoPWindow)
+	authSvc.APIKeys = storage
+	authSvc.APIKeyMaxTTL = cfg.APIKeyMaxTTL
+	authSvc.Memberships = storage
+	authSvc.Sessions = rds
+	var loginBackends auth.ChainedCredentials
+	for _, backend := range cfg.Login.Backends {
+		switch backend {
+		case "local":
+			loginBackends = append(loginBackends, &auth.LocalCredentials{Logger: logger, Users: storage})
+		case "ldap":
+			if cfg.Login.LDAPFile == "" {
+				panic("LDAP_CONFIG_FILE is required for the ldap login backend")
+			}
+			ldapCfg, err := ldapauth.LoadConfig(cfg.Login.LDAPFile)
+			if err != nil {
+				panic("Failed load LDAP config: " + err.Error())
+			}
+			ldapSvc, err := ldapauth.NewLDAP(logger, storage, ldapCfg)
+			if err != nil {
+				panic("Failed configure LDAP: " + err.Error())
+			}
+			loginBackends = append(loginBackends, ldapSvc)
+			logger.Info("LDAP login enabled", slog.String("url", ldapCfg.URL))
+		default:
+			panic("Unknown login backend " + backend)
+		}
+	}
+	authSvc.Credentials = loginBackends
+	oauthSvc := oauth.NewOAuth(logger, authSvc, storage, rds, cfg.OAuth.CodeTTL, cfg.OAuth.SessionTTL)
+	oauthSvc.DeviceCodeTTL = cfg.OAuth.DeviceCodeTTL
+	oauthSvc.DevicePollInterval = cfg.OAuth.DevicePollInterval
+	if cfg.OAuth.Issuer != "" {
+		var signer *idtoken.Signer
+		if cfg.OAuth.SigningKeyFile != "" {
+			signer, err = idtoken.LoadSigner(cfg.OAuth.SigningKeyFile)
+		} else {
+			logger.Warn("OIDC_SIGNING_KEY_FILE is not set, ID tokens are signed with a temporary key")
+			signer, err = idtoken.GenerateSigner()
+		}
+		if err != nil {
+			panic("Failed load OIDC signing key: " + err.Error())
+		}
+		oauthSvc.OIDC = &oauth.Provider{Issuer: cfg.OAuth.Issuer, Signer: signer}
+		logger.Info("OpenID Connect enabled", slog.String("issuer", cfg.OAuth.Issuer))
+	}
+	saAudience := cfg.ServiceAccounts.Audience
+	if len(saAudience) == 0 && cfg.OAuth.Issuer != "" {
+		issuer := strings.TrimSuffix(cfg.OAuth.Issuer, "/")
+		saAudience = []string{issuer + "/oauth/token", issuer}
+	}
+	if len(saAudience) == 0 {
+		logger.Warn("SERVICE_ACCOUNT_AUDIENCE and OIDC_ISSUER are not set, service account assertions are rejected")
+	}
+	saSvc := serviceaccount.NewService(logger, authSvc, storage, rds, saAudience)
+	saSvc.MaxAssertionTTL = cfg.ServiceAccounts.MaxAssertionTTL
+	oauthSvc.ServiceAccounts = saSvc
+	orgSvc := organization.NewService(logger, authSvc, storage)
+	orgSvc.InviteTTL = cfg.InviteTTL
+	adminSvc := admin.NewService(logger, authSvc, storage)
+	accountSvc := account.NewService(logger, authSvc, storage)
+	accountSvc.GracePeriod = cfg.Accounts.DeletionGrace
+	accountSvc.MaxAuthAge = cfg.Accounts.ReauthMaxAge
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
-	grpcServer := grpc.NewServer()
-
-	authservicegen.RegisterAuthServiceServer(
-		grpcServer,
-		grpccontroller.NewGRPCController(authSvc, logger),
+	healthSvc := health.NewHealth(logger, 2*time.Second,
+		[]string{authservicegen.AuthService_ServiceDesc.ServiceName, authservicegen.AdminService_ServiceDesc.ServiceName},
+		health.Probe{Name: "postgres", Check: storage.Database.PingContext},
+		health.Probe{Name: "redis", Check: func(ctx context.Context) error { return rds.Redis.Ping(ctx).Err() }},
 	)
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	go healthSvc.Run(bgCtx, 10*time.Second)
+	go accountSvc.Run(bgCtx, cfg.Accounts.PurgeInterval)
 
-	go func() {
-		lis, _ := net.Listen("tcp", ":50051")
-		grpcServer.Serve(lis)
-	}()
-	http.HandleFunc("/health", health.HealthCheck)
+	var grpcTLS, httpTLS *tls.Config
+	if cfg.TLS.Enabled() {
+		reloader, err := tlsconfig.NewReloader(logger, cfg.TLS.CertFile, cfg.TLS.KeyFile,
+			cfg.TLS.ClientCAFile, tlsconfig.ClientAuth(cfg.TLS.ClientAuth))
+		if err != nil {
+			panic("Failed load TLS certificates: " + err.Error())
+		}
+		go reloader.Watch(bgCtx, cfg.TLS.ReloadInterval)
+		grpcTLS = reloader.Config("h2")
+		httpTLS = reloader.Config("h2", "http/1.1")
+		logger.Info("TLS enabled", slog.String("client_auth", cfg.TLS.ClientAuth))
+	}
 
+	interceptorCfg := interceptors.Config{
+		Recovery:       cfg.GRPC.Recovery,
+		RequestID:      cfg.GRPC.RequestID,
+		AccessLog:      cfg.GRPC.AccessLog,
+		DefaultTimeout: cfg.GRPC.DefaultTimeout,
+		MaxTimeout:     cfg.GRPC.MaxTimeout,
+		MaxRecvMsgSize: cfg.GRPC.MaxRecvMsgSize,
+		MaxSendMsgSize: cfg.GRPC.MaxSendMsgSize,
+		Authenticator:  authSvc,
+		PublicMethods:  publicMethods,
+		Realms:         realms,
+	}
+	grpcOpts := interceptors.ServerOptions(interceptorCfg, logger)
+	if grpcTLS != nil {
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(grpcTLS)))
+	}
+	grpcServer := grpc.NewServer(grpcOpts...)
+	healthpb.RegisterHealthServer(grpcServer, healthSvc.GRPC)
+	if cfg.GRPC.Reflection {
+		reflection.Register(grpcServer)
+		logger.Info("gRPC reflection enabled")
+	}
+
+	unary, _ := interceptors.Chain(interceptorCfg, logger)
+	gatewayConn := inprocess.NewChannel(interceptors.ChainUnary(unary...))
+
+	grpcController := grpccontroller.NewGRPCController(authSvc, oauthSvc, saSvc, orgSvc, accountSvc, logger)
+	adminController := grpccontroller.NewAdminGRPCController(adminSvc, logger)
+	for _, registrar := range []grpc.ServiceRegistrar{grpcServer, gatewayConn} {
+		authservicegen.RegisterAuthServiceServer(registrar, grpcController)
+		authservicegen.RegisterAdminServiceServer(registrar, adminController)
+	}
+
+	lis, err := net.Listen("tcp", ":50051")
+	if err != nil {
+		panic("Failed listen grpc port: " + err.Error())
+	}
 	go func() {
-		http.ListenAndServe(":8080", nil)
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Error("gRPC server error", slog.Any("error", err))
+		}
 	}()
 
+	httpController, err := controller.NewController(gatewayConn, logger)
+	if err != nil {
+		panic("Failed init http gateway: " + err.Error())
+	}
+	var corsPolicy *cors.CORS
+	if cfg.CORS.File != "" {
+		corsPolicy, err = cors.NewCORS(logger, cfg.CORS.File)
+		if err != nil {
+			panic("Failed load CORS config: " + err.Error())
+		}
+		go corsPolicy.Watch(bgCtx, cfg.CORS.ReloadInterval)
+	}
+	if cfg.Cookie.Enabled {
+		sameSite, err := controller.ParseSameSite(cfg.Cookie.SameSite)
+		if err != nil {
+			panic("COOKIE_SAMESITE: " + err.Error())
+		}
+		httpController.Cookie = &controller.CookieConfig{
+			Name:     cfg.Cookie.Name,
+			Path:     cfg.Cookie.Path,
+			Domain:   cfg.Cookie.Domain,
+			SameSite: sameSite,
+			Secure:   cfg.Cookie.Secure,
+			Origins:  corsPolicy,
+		}
+	}
+	oauthController := controller.NewOAuthController(oauthSvc, cfg.Cookie.Secure, logger)
+	var federationController *controller.FederationController
+	if cfg.Federation.File != "" {
+		fedCfg, err := federation.LoadConfig(cfg.Federation.File)
+		if err != nil {
+			panic("Failed load federation config: " + err.Error())
+		}
+		if fedCfg.BaseURL == "" {
+			fedCfg.BaseURL = cfg.OAuth.Issuer
+		}
+		fedSvc, err := federation.NewFederation(logger, authSvc, storage, rds, fedCfg, nil)
+		if err != nil {
+			panic("Failed configure federation: " + err.Error())
+		}
+		fedSvc.StateTTL = cfg.Federation.StateTTL
+		federationController = controller.NewFederationController(fedSvc, cfg.Cookie.Secure, logger)
+		logger.Info("Federated login enabled", slog.Any("providers", fedSvc.Providers()))
+	}
+	var samlController *controller.SAMLController
+	if cfg.SAML.File != "" {
+		samlCfg, err := saml.LoadConfig(cfg.SAML.File)
+		if err != nil {
+			panic("Failed load SAML config: " + err.Error())
+		}
+		if samlCfg.BaseURL == "" {
+			samlCfg.BaseURL = cfg.OAuth.Issuer
+		}
+		samlSvc, err := saml.NewServiceProvider(logger, authSvc, storage, rds, samlCfg)
+		if err != nil {
+			panic("Failed configure SAML: " + err.Error())
+		}
+		samlSvc.RequestTTL = cfg.SAML.RequestTTL
+		samlController = controller.NewSAMLController(samlSvc, cfg.Cookie.Secure, logger)
+		logger.Info("SAML login enabled", slog.String("entity_id", samlSvc.EntityID), slog.Any("connections", samlSvc.Connections()))
+	}
+
+	httpServer := server.NewServer(httpController, oauthController, federationController, samlController, healthSvc, corsPolicy, realms, httpTLS, logger)
+	httpServer.Start()
+
 	<-stop
 	logger.Info("Stopping server")
 
+	stopBackground()
+	healthSvc.Shutdown()
+	time.Sleep(cfg.ShutdownDrain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Error("Http server shutdown failed", slog.Any("error", err))
+	}
 	grpcServer.GracefulStop()
 
 	logger.Info("Server stopped correctly")
@@ -1,41 +1,219 @@
 package jwtman
 
 import (
+	"context"
+	"crypto/subtle"
 	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+var ErrBindingMismatch = errors.New("token is bound to a different key")
+
+// ServiceAccountPrefix starts the subject of service account tokens
+const ServiceAccountPrefix = "svc:"
+
 type JWTManager struct {
 	SecretKey     []byte
 	TokenDuration time.Duration
+	// Issuer is set as iss of issued tokens and required of verified ones
+	// unless empty
+	Issuer string
+}
+
+// Confirmation is the cnf claim binding a token to a proof of possession key
+type Confirmation struct {
+	X5tS256 string `json:"x5t#S256,omitempty"`
+	JKT     string `json:"jkt,omitempty"`
+}
+
+// Actor is the act claim of a delegated token (RFC 8693 section 4.1). Act
+// is the actor before this one in the delegation chain.
+type Actor struct {
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id,omitempty"`
+	Act      *Actor `json:"act,omitempty"`
+}
+
+// Depth is the number of actors in the chain
+func (a *Actor) Depth() int {
+	n := 0
+	for ; a != nil; a = a.Act {
+		n++
+	}
+	return n
 }
 
 type Claims struct {
-	UserID string
+	UserID       string
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	// Scope and ClientID are set for tokens issued to OAuth clients (RFC 9068)
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// Act is set for tokens issued by token exchange on behalf of the subject
+	Act *Actor `json:"act,omitempty"`
+	// APIKeyID is set for claims of an API key rather than a signed token
+	APIKeyID string `json:"-"`
+	// Roles are set for service account tokens
+	Roles []string `json:"roles,omitempty"`
+	// OrgID is the active organization of the user and OrgRole the user's
+	// role in it
+	OrgID   string `json:"org_id,omitempty"`
+	OrgRole string `json:"org_role,omitempty"`
+	// AuthTime is when the user signed in; it is kept when tokens are
+	// refreshed
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func (manager *JWTManager) GenerateAccessToken(UID int) (string, error) {
-	jti := uuid.New().String()
-	claims := &Claims{UserID: strconv.Itoa(UID),
-		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(manager.TokenDuration)),
-			IssuedAt: jwt.NewNumericDate(time.Now()), ID: jti,
-		},
+type TokenOption func(*Claims)
+
+// WithCertThumbprint binds the token to a client certificate (RFC 8705)
+func WithCertThumbprint(thumbprint string) TokenOption {
+	return func(c *Claims) {
+		if thumbprint == "" {
+			return
+		}
+		if c.Confirmation == nil {
+			c.Confirmation = &Confirmation{}
+		}
+		c.Confirmation.X5tS256 = thumbprint
+	}
+}
+
+// WithJKT binds the token to a DPoP proof key (RFC 9449)
+func WithJKT(jkt string) TokenOption {
+	return func(c *Claims) {
+		if jkt == "" {
+			return
+		}
+		if c.Confirmation == nil {
+			c.Confirmation = &Confirmation{}
+		}
+		c.Confirmation.JKT = jkt
+	}
+}
+
+// WithScope sets the space separated scopes granted to the token
+func WithScope(scope string) TokenOption {
+	return func(c *Claims) {
+		c.Scope = scope
+	}
+}
+
+// WithClientID records the OAuth client the token was issued to
+func WithClientID(clientID string) TokenOption {
+	return func(c *Claims) {
+		c.ClientID = clientID
+	}
+}
+
+// WithAudience restricts the token to the services in aud
+func WithAudience(aud []string) TokenOption {
+	return func(c *Claims) {
+		if len(aud) > 0 {
+			c.Audience = jwt.ClaimStrings(aud)
+		}
+	}
+}
+
+// WithActor sets the act claim of a delegated token
+func WithActor(act *Actor) TokenOption {
+	return func(c *Claims) {
+		c.Act = act
+	}
+}
+
+// WithOrganization sets the active organization and the user's role in it
+func WithOrganization(orgID, role string) TokenOption {
+	return func(c *Claims) {
+		c.OrgID = orgID
+		c.OrgRole = role
+	}
+}
+
+// WithAuthTime records when the user signed in
+func WithAuthTime(t time.Time) TokenOption {
+	return func(c *Claims) {
+		if !t.IsZero() {
+			c.AuthTime = jwt.NewNumericDate(t)
+		}
+	}
+}
+
+func (manager *JWTManager) GenerateAccessToken(UID int, opts ...TokenOption) (string, error) {
+	return manager.sign(&Claims{UserID: strconv.Itoa(UID)}, opts)
+}
+
+// GenerateClientToken issues a token for an OAuth client acting on its own
+// behalf. Its sub is the client id and it has no user.
+func (manager *JWTManager) GenerateClientToken(clientID string, opts ...TokenOption) (string, error) {
+	claims := &Claims{ClientID: clientID}
+	claims.Subject = clientID
+	return manager.sign(claims, opts)
+}
+
+// GenerateServiceAccountToken issues a token for a service account. Its sub
+// is svc:<accountID> and it has no user.
+func (manager *JWTManager) GenerateServiceAccountToken(accountID string, roles []string, opts ...TokenOption) (string, error) {
+	claims := &Claims{Roles: roles}
+	claims.Subject = ServiceAccountPrefix + accountID
+	return manager.sign(claims, opts)
+}
+
+// GenerateExchangedToken issues a token with the same subject, user or
+// client, as the given token
+func (manager *JWTManager) GenerateExchangedToken(subject *Claims, opts ...TokenOption) (string, error) {
+	claims := &Claims{UserID: subject.UserID}
+	claims.Subject = subject.Subject
+	return manager.sign(claims, opts)
+}
+
+func (manager *JWTManager) sign(claims *Claims, opts []TokenOption) (string, error) {
+	now := time.Now()
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(manager.TokenDuration))
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ID = uuid.New().String()
+	if manager.Issuer != "" {
+		claims.Issuer = manager.Issuer
+	}
+	for _, opt := range opts {
+		opt(claims)
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(manager.SecretKey)
 }
 
+// SubjectID is the user id, or the client id for client tokens
+func (c *Claims) SubjectID() string {
+	if c.UserID != "" {
+		return c.UserID
+	}
+	return c.Subject
+}
+
+// ServiceAccountID returns the account id of service account tokens
+func (c *Claims) ServiceAccountID() (string, bool) {
+	if c.UserID != "" || c.ClientID != "" {
+		return "", false
+	}
+	return strings.CutPrefix(c.Subject, ServiceAccountPrefix)
+}
+
 // )))))
 func (manager *JWTManager) VerifyToken(tokenString string) (*Claims, error) {
+	opts := []jwt.ParserOption{jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()})}
+	if manager.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(manager.Issuer))
+	}
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return manager.SecretKey, nil
-	})
+	}, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,3 +225,37 @@ func (manager *JWTManager) VerifyToken(tokenString string) (*Claims, error) {
 
 	return claims, nil
 }
+
+// VerifyBinding checks a bound token against the certificate thumbprint and
+// DPoP key thumbprint it was presented with. Unbound tokens pass.
+func (c *Claims) VerifyBinding(certThumbprint, jkt string) error {
+	if c.Confirmation == nil {
+		return nil
+	}
+	if !matches(c.Confirmation.X5tS256, certThumbprint) || !matches(c.Confirmation.JKT, jkt) {
+		return ErrBindingMismatch
+	}
+	return nil
+}
+
+func matches(bound, presented string) bool {
+	return bound == "" || subtle.ConstantTimeCompare([]byte(bound), []byte(presented)) == 1
+}
+
+// BoundToDPoP reports whether the token must be used with DPoP proofs
+func (c *Claims) BoundToDPoP() bool {
+	return c.Confirmation != nil && c.Confirmation.JKT != ""
+}
+
+type claimsKey struct{}
+
+// NewContext stores verified claims of the caller
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims of the authenticated caller
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
@@ -37,3 +37,31 @@ func TestJWTManager_GenerateAndVerify(t *testing.T) {
 		t.Error("expected error for expired token, got none")
 	}
 }
+
+func TestJWTManager_CertBinding(t *testing.T) {
+	jwt := &jwtman.JWTManager{
+		SecretKey:     []byte("testsecret"),
+		TokenDuration: 15 * time.Minute,
+	}
+
+	token, err := jwt.GenerateAccessToken(1, jwtman.WithCertThumbprint("thumb"))
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	claims, err := jwt.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+	if claims.Confirmation == nil || claims.Confirmation.X5tS256 != "thumb" {
+		t.Fatalf("expected cnf claim, got %+v", claims.Confirmation)
+	}
+	if err := claims.VerifyBinding("thumb", ""); err != nil {
+		t.Errorf("expected binding to match, got %v", err)
+	}
+	if err := claims.VerifyBinding("other", ""); err == nil {
+		t.Error("expected mismatch for another certificate")
+	}
+	if err := claims.VerifyBinding("", ""); err == nil {
+		t.Error("expected mismatch without certificate")
+	}
+}
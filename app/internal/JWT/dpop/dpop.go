@@ -0,0 +1,163 @@
+// Package dpop verifies DPoP proofs (RFC 9449)
+package dpop
+
+import (
+	"auth_service/internal/JWT/jwk"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	Header    = "DPoP"
+	Scheme    = "DPoP"
+	proofType = "dpop+jwt"
+)
+
+var ErrInvalidProof = errors.New("invalid dpop proof")
+
+// Algorithms accepted for proofs. Symmetric algorithms are never allowed.
+var Algorithms = []string{"ES256", "ES384", "ES512", "RS256", "PS256", "EdDSA"}
+
+// ReplayCache remembers proof ids. MarkUsed returns false for a seen key.
+type ReplayCache interface {
+	MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+type Verifier struct {
+	Replay ReplayCache
+	// Window is how far iat may be from the current time
+	Window time.Duration
+	now    func() time.Time
+}
+
+func NewVerifier(replay ReplayCache, window time.Duration) *Verifier {
+	return &Verifier{Replay: replay, Window: window, now: time.Now}
+}
+
+type Claims struct {
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Proof is a verified DPoP proof
+type Proof struct {
+	// JKT is the thumbprint of the proof key, used as cnf.jkt
+	JKT    string
+	Claims *Claims
+}
+
+// Request is the HTTP request a proof is expected to be bound to
+type Request struct {
+	Method string
+	URL    string
+}
+
+type requestKey struct{}
+
+func WithRequest(ctx context.Context, req Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+func RequestFromContext(ctx context.Context) (Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(Request)
+	return req, ok
+}
+
+// AccessTokenHash is the ath claim value for an access token
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidProof, fmt.Sprintf(format, args...))
+}
+
+// Verify checks the proof signature, htm, htu and iat, the access token hash
+// when accessToken is set, and rejects replayed jti values
+func (v *Verifier) Verify(ctx context.Context, proof string, req Request, accessToken string) (*Proof, error) {
+	var key jwk.Key
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(proof, claims, func(t *jwt.Token) (any, error) {
+		if typ, _ := t.Header["typ"].(string); typ != proofType {
+			return nil, invalid("typ must be %s", proofType)
+		}
+		raw, ok := t.Header["jwk"].(map[string]any)
+		if !ok {
+			return nil, invalid("missing jwk header")
+		}
+		var err error
+		if key, err = jwk.FromMap(raw); err != nil {
+			return nil, invalid("malformed jwk")
+		}
+		if key.D != "" {
+			return nil, invalid("jwk contains a private key")
+		}
+		return key.PublicKey()
+	}, jwt.WithValidMethods(Algorithms), jwt.WithIssuedAt(), jwt.WithLeeway(v.Window))
+	if err != nil {
+		if errors.Is(err, ErrInvalidProof) {
+			return nil, err
+		}
+		return nil, invalid("%v", err)
+	}
+
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, invalid("jti and iat are required")
+	}
+	if d := v.now().Sub(claims.IssuedAt.Time); d > v.Window || d < -v.Window {
+		return nil, invalid("iat outside of the accepted window")
+	}
+	if !strings.EqualFold(claims.HTM, req.Method) {
+		return nil, invalid("htm does not match request method")
+	}
+	if !sameURL(claims.HTU, req.URL) {
+		return nil, invalid("htu does not match request uri")
+	}
+	if accessToken != "" {
+		if subtle.ConstantTimeCompare([]byte(claims.ATH), []byte(AccessTokenHash(accessToken))) != 1 {
+			return nil, invalid("ath does not match access token")
+		}
+	}
+
+	jkt, err := key.Thumbprint()
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+
+	fresh, err := v.Replay.MarkUsed(ctx, "dpop_jti:"+jkt+":"+claims.ID, 2*v.Window)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, invalid("proof replayed")
+	}
+
+	return &Proof{JKT: jkt, Claims: claims}, nil
+}
+
+// sameURL compares URIs without query and fragment, as required for htu
+func sameURL(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) &&
+		strings.EqualFold(ua.Host, ub.Host) &&
+		ua.EscapedPath() == ub.EscapedPath()
+}
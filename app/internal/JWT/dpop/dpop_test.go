@@ -0,0 +1,111 @@
+package dpop_test
+
+import (
+	"auth_service/internal/JWT/dpop"
+	"auth_service/internal/JWT/jwk"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type MemoryReplayCache struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (m *MemoryReplayCache) MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.seen[key] {
+		return false, nil
+	}
+	m.seen[key] = true
+	return true, nil
+}
+
+func newProof(t *testing.T, key *ecdsa.PrivateKey, htm, htu, ath string, iat time.Time) string {
+	t.Helper()
+	pub, err := jwk.FromPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, &dpop.Claims{
+		HTM: htm,
+		HTU: htu,
+		ATH: ath,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       uuid.NewString(),
+			IssuedAt: jwt.NewNumericDate(iat),
+		},
+	})
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = pub
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	v := dpop.NewVerifier(&MemoryReplayCache{seen: map[string]bool{}}, time.Minute)
+	ctx := context.Background()
+	req := dpop.Request{Method: "POST", URL: "https://auth.example.com/login"}
+
+	proof := newProof(t, key, "POST", "https://auth.example.com/login?x=1", "", time.Now())
+	got, err := v.Verify(ctx, proof, req, "")
+	if err != nil {
+		t.Fatalf("expected valid proof, got %v", err)
+	}
+	pub, _ := jwk.FromPublicKey(&key.PublicKey)
+	if want, _ := pub.Thumbprint(); got.JKT != want {
+		t.Errorf("expected jkt %s, got %s", want, got.JKT)
+	}
+
+	if _, err := v.Verify(ctx, proof, req, ""); !errors.Is(err, dpop.ErrInvalidProof) {
+		t.Errorf("expected replayed proof to be rejected, got %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		proof string
+		token string
+	}{
+		{"wrong method", newProof(t, key, "GET", req.URL, "", time.Now()), ""},
+		{"wrong uri", newProof(t, key, "POST", "https://auth.example.com/refresh", "", time.Now()), ""},
+		{"stale iat", newProof(t, key, "POST", req.URL, "", time.Now().Add(-10*time.Minute)), ""},
+		{"missing ath", newProof(t, key, "POST", req.URL, "", time.Now()), "access-token"},
+		{"wrong ath", newProof(t, key, "POST", req.URL, dpop.AccessTokenHash("other"), time.Now()), "access-token"},
+	}
+	for _, tt := range tests {
+		if _, err := v.Verify(ctx, tt.proof, req, tt.token); !errors.Is(err, dpop.ErrInvalidProof) {
+			t.Errorf("%s: expected ErrInvalidProof, got %v", tt.name, err)
+		}
+	}
+
+	withATH := newProof(t, key, "POST", req.URL, dpop.AccessTokenHash("access-token"), time.Now())
+	if _, err := v.Verify(ctx, withATH, req, "access-token"); err != nil {
+		t.Errorf("expected proof with ath to be valid, got %v", err)
+	}
+}
+
+func TestVerifier_RejectsSymmetricKeys(t *testing.T) {
+	v := dpop.NewVerifier(&MemoryReplayCache{seen: map[string]bool{}}, time.Minute)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &dpop.Claims{HTM: "POST", HTU: "https://a/b"})
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = map[string]any{"kty": "oct", "k": "c2VjcmV0"}
+	proof, _ := token.SignedString([]byte("secret"))
+
+	if _, err := v.Verify(context.Background(), proof, dpop.Request{Method: "POST", URL: "https://a/b"}, ""); !errors.Is(err, dpop.ErrInvalidProof) {
+		t.Errorf("expected ErrInvalidProof, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+// Package idtoken signs OpenID Connect ID tokens with an asymmetric key that
+// relying parties can verify through the published JWKS.
+package idtoken
+
+import (
+	"auth_service/internal/JWT/jwk"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims of an ID token (OpenID Connect Core 1.0 section 2 and 5.1)
+type Claims struct {
+	Nonce             string           `json:"nonce,omitempty"`
+	AuthTime          *jwt.NumericDate `json:"auth_time,omitempty"`
+	AtHash            string           `json:"at_hash,omitempty"`
+	Email             string           `json:"email,omitempty"`
+	EmailVerified     *bool            `json:"email_verified,omitempty"`
+	PreferredUsername string           `json:"preferred_username,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Signer holds the private key ID tokens are signed with
+type Signer struct {
+	key    crypto.Signer
+	method jwt.SigningMethod
+	public jwk.Key
+}
+
+func NewSigner(key crypto.Signer) (*Signer, error) {
+	var method jwt.SigningMethod
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("rsa key must be at least 2048 bits")
+		}
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 ec keys are supported")
+		}
+		method = jwt.SigningMethodES256
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, jwk.ErrUnsupportedKey
+	}
+
+	public, err := jwk.FromPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if public.Kid, err = public.Thumbprint(); err != nil {
+		return nil, err
+	}
+	public.Use = "sig"
+	public.Alg = method.Alg()
+	return &Signer{key: key, method: method, public: public}, nil
+}
+
+// LoadSigner reads a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key
+func LoadSigner(file string) (*Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block in signing key file")
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, jwk.ErrUnsupportedKey
+	}
+	return NewSigner(signer)
+}
+
+// GenerateSigner creates a signer with a new P-256 key. Tokens signed with it
+// can't be verified after a restart, so it is only meant for development.
+func GenerateSigner() (*Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(key)
+}
+
+// Alg is the JWS algorithm of the signer
+func (s *Signer) Alg() string {
+	return s.method.Alg()
+}
+
+// JWKS returns the public key set for the jwks_uri
+func (s *Signer) JWKS() jwk.Set {
+	return jwk.Set{Keys: []jwk.Key{s.public}}
+}
+
+func (s *Signer) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.method, claims)
+	token.Header["kid"] = s.public.Kid
+	return token.SignedString(s.key)
+}
+
+// Verify parses a token signed by this signer
+func (s *Signer) Verify(tokenString string, opts ...jwt.ParserOption) (*Claims, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{s.method.Alg()}))
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(*jwt.Token) (any, error) {
+		return s.key.Public(), nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return token.Claims.(*Claims), nil
+}
+
+// AccessTokenHash computes at_hash: the left half of the hash of the access
+// token, with the hash function of the signing algorithm
+func (s *Signer) AccessTokenHash(accessToken string) string {
+	var sum []byte
+	if s.method == jwt.SigningMethodEdDSA {
+		h := sha512.Sum512([]byte(accessToken))
+		sum = h[:]
+	} else {
+		h := sha256.Sum256([]byte(accessToken))
+		sum = h[:]
+	}
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
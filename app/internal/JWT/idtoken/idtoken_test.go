@@ -0,0 +1,56 @@
+package idtoken_test
+
+import (
+	"auth_service/internal/JWT/idtoken"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestLoadSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "key.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(file, pemData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := idtoken.LoadSigner(file)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if signer.Alg() != "RS256" {
+		t.Errorf("expected RS256, got %s", signer.Alg())
+	}
+	jwks := signer.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid == "" || jwks.Keys[0].D != "" {
+		t.Fatalf("unexpected jwks %+v", jwks)
+	}
+
+	token, err := signer.Sign(&idtoken.Claims{Nonce: "abc", RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, _ := jwt.NewParser().ParseUnverified(token, &idtoken.Claims{})
+	if parsed.Header["kid"] != jwks.Keys[0].Kid {
+		t.Errorf("expected kid %s in header, got %v", jwks.Keys[0].Kid, parsed.Header["kid"])
+	}
+
+	// key from the JWKS verifies the token, as a relying party would do it
+	pub, err := jwks.Keys[0].PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(token, func(*jwt.Token) (any, error) { return pub, nil }); err != nil {
+		t.Errorf("token does not verify with the published key: %v", err)
+	}
+}
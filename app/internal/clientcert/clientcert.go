@@ -0,0 +1,102 @@
+// Package clientcert exposes the verified client certificate of an mTLS
+// connection to gRPC and HTTP handlers.
+package clientcert
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"slices"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Identity describes the caller authenticated by its client certificate
+type Identity struct {
+	Subject     string
+	CommonName  string
+	DNSNames    []string
+	URIs        []string
+	Certificate *x509.Certificate
+}
+
+// Thumbprint returns the base64url encoded SHA-256 hash of the DER encoded
+// certificate, as used in the x5t#S256 confirmation claim (RFC 8705)
+func (id *Identity) Thumbprint() string {
+	sum := sha256.Sum256(id.Certificate.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Matches reports whether the subject, common name, a DNS name or a URI SAN
+// of the certificate is in allowed
+func (id *Identity) Matches(allowed []string) bool {
+	if id == nil {
+		return false
+	}
+	if slices.Contains(allowed, id.Subject) || slices.Contains(allowed, id.CommonName) {
+		return true
+	}
+	for _, name := range append(slices.Clone(id.DNSNames), id.URIs...) {
+		if slices.Contains(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromState returns the identity of a verified peer certificate, if any
+func FromState(state *tls.ConnectionState) *Identity {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
+	id := &Identity{
+		Subject:     cert.Subject.String(),
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Certificate: cert,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	return id
+}
+
+type identityKey struct{}
+
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the client identity or nil for non mTLS callers
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// FromPeer reads the identity from the gRPC peer of ctx
+func FromPeer(ctx context.Context) *Identity {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return FromState(&info.State)
+}
+
+// Middleware stores the identity of HTTP callers in the request context
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := FromState(r.TLS); id != nil {
+			r = r.WithContext(NewContext(r.Context(), id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
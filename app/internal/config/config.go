@@ -3,13 +3,115 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	TokenTTL     time.Duration
-	RedisAddr    string
-	Storage_path string
+	TokenTTL        time.Duration
+	RedisAddr       string
+	Storage_path    string
+	ShutdownDrain   time.Duration
+	GRPC            GRPCConfig
+	TLS             TLSConfig
+	DPoPWindow      time.Duration
+	Cookie          CookieConfig
+	CORS            CORSConfig
+	OAuth           OAuthConfig
+	Federation      FederationConfig
+	Login           LoginConfig
+	SAML            SAMLConfig
+	APIKeyMaxTTL    time.Duration
+	ServiceAccounts ServiceAccountConfig
+	// RealmsFile configures realms besides the default one
+	RealmsFile string
+	// InviteTTL is the lifetime of organization invitations
+	InviteTTL time.Duration
+	Accounts  AccountConfig
+}
+
+type AccountConfig struct {
+	// DeletionGrace is how long deleted accounts are kept before they are
+	// purged
+	DeletionGrace time.Duration
+	// ReauthMaxAge is how recent a sign-in must be to delete the account
+	ReauthMaxAge  time.Duration
+	PurgeInterval time.Duration
+}
+
+type ServiceAccountConfig struct {
+	// Audience is accepted as aud of assertions; it defaults to the token
+	// endpoint and the issuer
+	Audience        []string
+	MaxAssertionTTL time.Duration
+}
+
+type SAMLConfig struct {
+	// File lists the SAML identity providers; empty disables SAML logins
+	File       string
+	RequestTTL time.Duration
+}
+
+type LoginConfig struct {
+	// Backends are the credential checks tried in order: local or ldap
+	Backends []string
+	// LDAPFile configures the ldap backend
+	LDAPFile string
+}
+
+type FederationConfig struct {
+	// File lists the upstream identity providers; empty disables federation
+	File     string
+	StateTTL time.Duration
+}
+
+type OAuthConfig struct {
+	CodeTTL    time.Duration
+	SessionTTL time.Duration
+	// DeviceCodeTTL and DevicePollInterval configure the device grant
+	DeviceCodeTTL      time.Duration
+	DevicePollInterval time.Duration
+	// Issuer enables OpenID Connect
+	Issuer         string
+	SigningKeyFile string
+}
+
+type CORSConfig struct {
+	File           string
+	ReloadInterval time.Duration
+}
+
+type CookieConfig struct {
+	Enabled  bool
+	Name     string
+	Path     string
+	Domain   string
+	SameSite string
+	Secure   bool
+}
+
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string
+	ClientAuth     string
+	ReloadInterval time.Duration
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+type GRPCConfig struct {
+	Reflection     bool
+	Recovery       bool
+	RequestID      bool
+	AccessLog      bool
+	DefaultTimeout time.Duration
+	MaxTimeout     time.Duration
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
 }
 
 func MustLoad() *Config {
@@ -21,6 +123,83 @@ func MustLoad() *Config {
 	}
 	cfg.TokenTTL = Dur
 
+	cfg.ShutdownDrain = getDuration("SHUTDOWN_DRAIN", 5*time.Second)
+
+	cfg.GRPC = GRPCConfig{
+		Reflection:     getBool("GRPC_REFLECTION", false),
+		Recovery:       getBool("GRPC_RECOVERY", true),
+		RequestID:      getBool("GRPC_REQUEST_ID", true),
+		AccessLog:      getBool("GRPC_ACCESS_LOG", true),
+		DefaultTimeout: getDuration("GRPC_DEFAULT_TIMEOUT", 10*time.Second),
+		MaxTimeout:     getDuration("GRPC_MAX_TIMEOUT", 30*time.Second),
+		MaxRecvMsgSize: getInt("GRPC_MAX_RECV_MSG_SIZE", 1<<20),
+		MaxSendMsgSize: getInt("GRPC_MAX_SEND_MSG_SIZE", 4<<20),
+	}
+
+	cfg.TLS = TLSConfig{
+		CertFile:       os.Getenv("TLS_CERT_FILE"),
+		KeyFile:        os.Getenv("TLS_KEY_FILE"),
+		ClientCAFile:   os.Getenv("TLS_CLIENT_CA_FILE"),
+		ClientAuth:     getString("TLS_CLIENT_AUTH", "none"),
+		ReloadInterval: getDuration("TLS_RELOAD_INTERVAL", 30*time.Second),
+	}
+
+	cfg.DPoPWindow = getDuration("DPOP_PROOF_WINDOW", time.Minute)
+	cfg.APIKeyMaxTTL = getDuration("API_KEY_MAX_TTL", 365*24*time.Hour)
+	cfg.ServiceAccounts = ServiceAccountConfig{
+		Audience:        getList("SERVICE_ACCOUNT_AUDIENCE"),
+		MaxAssertionTTL: getDuration("SERVICE_ACCOUNT_ASSERTION_MAX_TTL", time.Hour),
+	}
+
+	cfg.RealmsFile = os.Getenv("REALMS_CONFIG_FILE")
+	cfg.InviteTTL = getDuration("ORG_INVITE_TTL", 7*24*time.Hour)
+	cfg.Accounts = AccountConfig{
+		DeletionGrace: getDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour),
+		ReauthMaxAge:  getDuration("ACCOUNT_REAUTH_MAX_AGE", 5*time.Minute),
+		PurgeInterval: getDuration("ACCOUNT_PURGE_INTERVAL", time.Hour),
+	}
+
+	cfg.Cookie = CookieConfig{
+		Enabled:  getBool("COOKIE_MODE", false),
+		Name:     getString("COOKIE_NAME", "refresh_token"),
+		Path:     getString("COOKIE_PATH", "/web/session"),
+		Domain:   os.Getenv("COOKIE_DOMAIN"),
+		SameSite: getString("COOKIE_SAMESITE", "strict"),
+		Secure:   getBool("COOKIE_SECURE", true),
+	}
+
+	cfg.CORS = CORSConfig{
+		File:           os.Getenv("CORS_CONFIG_FILE"),
+		ReloadInterval: getDuration("CORS_RELOAD_INTERVAL", 30*time.Second),
+	}
+
+	cfg.Federation = FederationConfig{
+		File:     os.Getenv("FEDERATION_CONFIG_FILE"),
+		StateTTL: getDuration("FEDERATION_STATE_TTL", 10*time.Minute),
+	}
+
+	cfg.SAML = SAMLConfig{
+		File:       os.Getenv("SAML_CONFIG_FILE"),
+		RequestTTL: getDuration("SAML_REQUEST_TTL", 10*time.Minute),
+	}
+
+	cfg.Login = LoginConfig{
+		Backends: getList("LOGIN_BACKENDS"),
+		LDAPFile: os.Getenv("LDAP_CONFIG_FILE"),
+	}
+	if len(cfg.Login.Backends) == 0 {
+		cfg.Login.Backends = []string{"local"}
+	}
+
+	cfg.OAuth = OAuthConfig{
+		CodeTTL:            getDuration("OAUTH_CODE_TTL", time.Minute),
+		SessionTTL:         getDuration("OAUTH_SESSION_TTL", 12*time.Hour),
+		DeviceCodeTTL:      getDuration("OAUTH_DEVICE_CODE_TTL", 10*time.Minute),
+		DevicePollInterval: getDuration("OAUTH_DEVICE_POLL_INTERVAL", 5*time.Second),
+		Issuer:             os.Getenv("OIDC_ISSUER"),
+		SigningKeyFile:     os.Getenv("OIDC_SIGNING_KEY_FILE"),
+	}
+
 	cfg.Storage_path = fmt.Sprintf(
 		"postgres://%s:%s@postgres:5432/%s?sslmode=disable",
 		os.Getenv("POSTGRES_USER"),
@@ -30,3 +209,56 @@ func MustLoad() *Config {
 
 	return &cfg
 }
+
+func getString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func getDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic(key + ": " + err.Error())
+	}
+	return d
+}
+
+func getBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		panic(key + ": " + err.Error())
+	}
+	return b
+}
+
+func getInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		panic(key + ": " + err.Error())
+	}
+	return i
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv replaces ${VAR} references by the value of the environment
+// variable, or by nothing if it is unset. Other $ signs are kept, so secrets
+// and patterns in the file may contain them.
+func ExpandEnv(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+// LoadYAML reads the YAML file into out after expanding ${VAR} references, so
+// secrets can stay out of the file.
+func LoadYAML(file string, out any) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal([]byte(ExpandEnv(string(data))), out); err != nil {
+		return fmt.Errorf("parse %s: %w", file, err)
+	}
+	return nil
+}
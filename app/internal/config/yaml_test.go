@@ -0,0 +1,39 @@
+package config_test
+
+import (
+	"auth_service/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadYAML(t *testing.T) {
+	t.Setenv("TEST_SECRET", "s3cr$t")
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	content := "secret: ${TEST_SECRET}\npassword: pa$$word\nfilter: (uid=$user)\nunset: ${TEST_UNSET}\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		Secret   string `yaml:"secret"`
+		Password string `yaml:"password"`
+		Filter   string `yaml:"filter"`
+		Unset    string `yaml:"unset"`
+	}
+	if err := config.LoadYAML(file, &cfg); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.Secret != "s3cr$t" {
+		t.Errorf("expected ${TEST_SECRET} to be expanded, got %q", cfg.Secret)
+	}
+	if cfg.Password != "pa$$word" || cfg.Filter != "(uid=$user)" {
+		t.Errorf("expected literal $ to be kept, got %q and %q", cfg.Password, cfg.Filter)
+	}
+	if cfg.Unset != "" {
+		t.Errorf("expected an unset variable to expand to nothing, got %q", cfg.Unset)
+	}
+
+	if err := config.LoadYAML(filepath.Join(t.TempDir(), "missing.yaml"), &cfg); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
@@ -1,129 +1,110 @@
 package controller
 
 import (
-	"auth_service/internal/models"
-	"auth_service/internal/services/auth"
-	"encoding/json"
+	"auth_service/internal/JWT/dpop"
+	"auth_service/internal/realm"
+	"auth_service/protos/gen/go/authservicegen"
+	"auth_service/protos/gen/openapi"
+	"context"
 	"log/slog"
 	"net/http"
 	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
+// AuthController serves the REST API. Routes are generated from the
+// google.api.http annotations in auth.proto and call the gRPC services through
+// conn, so REST requests pass the same interceptors as gRPC ones.
 type AuthController struct {
-	Logger      *slog.Logger
-	AuthService *auth.Auth
-}
-
-func NewController(service *auth.Auth, logger *slog.Logger) *AuthController {
-	return &AuthController{AuthService: service, Logger: logger}
+	Logger  *slog.Logger
+	Gateway *runtime.ServeMux
+	// Cookie enables the cookie mode handlers; nil disables them
+	Cookie *CookieConfig
+	client authservicegen.AuthServiceClient
+	spec   []byte
 }
 
-func (c *AuthController) LoginHandler(w http.ResponseWriter, r *http.Request) {
-	var user models.NewUserReq
+func NewController(conn grpc.ClientConnInterface, logger *slog.Logger) (*AuthController, error) {
+	c := &AuthController{Logger: logger}
 
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
-		return
+	c.Gateway = runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+		}),
+		runtime.WithIncomingHeaderMatcher(headerMatcher),
+		runtime.WithErrorHandler(c.errorHandler),
+		runtime.WithRoutingErrorHandler(c.routingErrorHandler),
+	)
+	c.client = authservicegen.NewAuthServiceClient(conn)
+	if err := authservicegen.RegisterAuthServiceHandlerClient(context.Background(), c.Gateway, c.client); err != nil {
+		return nil, err
 	}
-	c.Logger.Debug("pass", slog.String("pass", user.Password))
-
-	if user.Email == "" {
-		http.Error(w, "invalid email", http.StatusBadRequest)
-		return
-	}
-	if user.Password == "" {
-		http.Error(w, "invalid password", http.StatusBadRequest)
-		return
+	if err := authservicegen.RegisterAdminServiceHandlerClient(context.Background(), c.Gateway, authservicegen.NewAdminServiceClient(conn)); err != nil {
+		return nil, err
 	}
 
-	NewUser := models.NewUser{Email: user.Email, HashPass: []byte(user.Password)}
-	tokens, err := c.AuthService.Login(r.Context(), NewUser)
+	spec, err := openapi.JSON()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+		return nil, err
 	}
+	c.spec = spec
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(tokens); err != nil {
-		c.Logger.Debug("Не удалось отправить токены")
-		return
-	}
-	c.Logger.Info("Успешный логин пользователя", slog.String("email", user.Email))
+	return c, nil
 }
 
-func (c *AuthController) RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	var user models.NewUserReq
-
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
-		return
-	}
-	if user.Email == "" {
-		http.Error(w, "invalid email", http.StatusBadRequest)
-		return
-	}
-	if user.Password == "" {
-		http.Error(w, "invalid password", http.StatusBadRequest)
-		return
-	}
-	NewUser := models.NewUser{Email: user.Email, HashPass: []byte(user.Password)}
-
-	if err := c.AuthService.Register(r.Context(), NewUser); err != nil {
-		if strings.Contains(err.Error(), "duplicate key") {
-			http.Error(w, "user already exists", http.StatusConflict)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	c.Logger.Info("Создан пользователь", slog.String("email", user.Email))
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+func (c *AuthController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Gateway.ServeHTTP(w, r.WithContext(c.requestContext(r)))
 }
 
-func (c *AuthController) RefreshHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// requestContext carries the HTTP client address and request URL to the service
+func (c *AuthController) requestContext(r *http.Request) context.Context {
+	ctx := peer.NewContext(r.Context(), &peer.Peer{Addr: remoteAddr(r.RemoteAddr)})
+	return dpop.WithRequest(ctx, dpop.Request{Method: r.Method, URL: requestURL(r)})
+}
 
-	var token models.RefreshToken
-	if err := json.NewDecoder(r.Body).Decode(&token); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
-		return
-	}
-	if token.Token == "" {
-		http.Error(w, "invalid token", http.StatusBadRequest)
-		return
+// headerMatcher forwards the DPoP header in addition to the default ones
+func headerMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, dpop.Header) {
+		return "dpop", true
 	}
+	return runtime.DefaultHeaderMatcher(key)
+}
 
-	tok, err := c.AuthService.Refresh(r.Context(), token.Token)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+// requestURL is the URL the client sent the request to, with its realm
+// prefix, used as DPoP htu
+func requestURL(r *http.Request) string {
+	path := realm.RequestPath(r.Context())
+	if path == "" {
+		path = r.URL.EscapedPath()
 	}
+	return requestOrigin(r) + path
+}
 
-	if err := json.NewEncoder(w).Encode(tok); err != nil {
-		c.Logger.Error("Не удалось отправить токены", slog.Any("error", err))
+// requestOrigin is the scheme and host the client sent the request to
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	} else if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" {
+		scheme = proto
 	}
+	return scheme + "://" + r.Host
 }
 
-func (c *AuthController) LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	var token models.RefreshToken
+// remoteAddr exposes the HTTP client address as the gRPC peer
+type remoteAddr string
 
-	if err := json.NewDecoder(r.Body).Decode(&token); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
-		return
-	}
-	if token.Token == "" {
-		http.Error(w, "invalid token", http.StatusBadRequest)
-		return
-	}
-	if err := c.AuthService.Logout(r.Context(), token.Token); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+func (a remoteAddr) Network() string { return "tcp" }
+func (a remoteAddr) String() string  { return string(a) }
 
-	w.WriteHeader(http.StatusOK)
-	c.Logger.Info("Пользователь разлогинился", slog.String("token", token.Token[:8]))
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+func (c *AuthController) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(c.spec); err != nil {
+		c.Logger.Error("Failed to write openapi spec", slog.Any("error", err))
+	}
 }
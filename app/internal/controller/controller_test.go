@@ -0,0 +1,450 @@
+package controller_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/controller"
+	"auth_service/internal/cors"
+	"auth_service/internal/errmap"
+	"auth_service/internal/inprocess"
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/federation"
+	"auth_service/internal/services/oauth"
+	"auth_service/internal/services/saml"
+	"auth_service/internal/testutil"
+	"auth_service/protos/gen/go/authservicegen"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type MockAuthServer struct {
+	authservicegen.UnimplementedAuthServiceServer
+	got *authservicegen.LoginRequest
+	err error
+}
+
+func (m *MockAuthServer) Login(ctx context.Context, req *authservicegen.LoginRequest) (*authservicegen.TokenPair, error) {
+	m.got = req
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &authservicegen.TokenPair{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+func newController(t *testing.T, mock *MockAuthServer) *controller.AuthController {
+	t.Helper()
+	conn := inprocess.NewChannel(nil)
+	authservicegen.RegisterAuthServiceServer(conn, mock)
+	c, err := controller.NewController(conn, slog.Default())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return c
+}
+
+func TestAuthController_Login(t *testing.T) {
+	mock := &MockAuthServer{}
+	c := newController(t, mock)
+
+	body := strings.NewReader(`{"email":"test@example.com","password":"pass"}`)
+	req := httptest.NewRequest(http.MethodPost, "/login", body)
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if mock.got.GetEmail() != "test@example.com" {
+		t.Errorf("expected email to be passed to service, got %q", mock.got.GetEmail())
+	}
+
+	var tokens map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if tokens["access_token"] != "access" || tokens["refresh_token"] != "refresh" {
+		t.Errorf("unexpected response %v", tokens)
+	}
+}
+
+func TestAuthController_OpenAPI(t *testing.T) {
+	c := newController(t, &MockAuthServer{})
+
+	rec := httptest.NewRecorder()
+	c.OpenAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	var doc map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("spec is not valid json: %v", err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("unexpected openapi version %v", doc["openapi"])
+	}
+}
+
+func TestAuthController_Problem(t *testing.T) {
+	verr := &auth.ValidationError{}
+	verr.Add("email", "email is required")
+
+	tests := []struct {
+		name   string
+		err    error
+		body   string
+		status int
+		code   string
+		fields int
+	}{
+		{"invalid credentials", errmap.Error(auth.ErrInvalidCredentials), `{}`, http.StatusUnauthorized, errmap.ReasonInvalidCredentials, 0},
+		{"validation", errmap.Error(verr), `{}`, http.StatusBadRequest, errmap.ReasonInvalidArgument, 1},
+		{"internal", errmap.Error(errors.New("pq: password authentication failed")), `{}`, http.StatusInternalServerError, errmap.ReasonInternal, 0},
+		{"malformed body", nil, `{`, http.StatusBadRequest, "INVALID_ARGUMENT", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newController(t, &MockAuthServer{err: tt.err})
+
+			rec := httptest.NewRecorder()
+			c.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != controller.ProblemContentType {
+				t.Errorf("unexpected content type %q", ct)
+			}
+			var p controller.Problem
+			if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+				t.Fatalf("failed to decode problem: %v", err)
+			}
+			if p.Code != tt.code || p.Status != tt.status || p.Instance != "/login" {
+				t.Errorf("unexpected problem %+v", p)
+			}
+			if len(p.Errors) != tt.fields {
+				t.Errorf("expected %d field errors, got %v", tt.fields, p.Errors)
+			}
+			if strings.Contains(p.Detail, "pq:") {
+				t.Errorf("internal error leaked: %s", p.Detail)
+			}
+		})
+	}
+}
+
+func TestAuthController_CookieMode(t *testing.T) {
+	mock := &MockAuthServer{}
+	c := newController(t, mock)
+	c.Cookie = &controller.CookieConfig{Name: "refresh_token", Path: "/web/session", Secure: true, SameSite: http.SameSiteStrictMode,
+		Origins: newCORS(t, "allowed_origins: [\"https://app.example.com\"]\nallow_credentials: true")}
+	// the cookies live as long as the realm's refresh tokens
+	ctx := realm.NewContext(context.Background(), &realm.Realm{
+		Name:    realm.Default,
+		JWT:     &jwtman.JWTManager{TokenDuration: 15 * time.Minute},
+		Session: realm.SessionPolicy{RefreshTokenTTL: 24 * time.Hour},
+	})
+
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/web/login", strings.NewReader(`{"email":"test@example.com","password":"pass"}`))
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	c.LoginHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var refresh, csrf *http.Cookie
+	for _, ck := range rec.Result().Cookies() {
+		switch ck.Name {
+		case "refresh_token":
+			refresh = ck
+		case controller.CSRFCookieName:
+			csrf = ck
+		}
+	}
+	if refresh == nil || refresh.Value != "refresh" || !refresh.HttpOnly || !refresh.Secure || refresh.Path != "/web/session" || refresh.MaxAge != 86400 {
+		t.Fatalf("unexpected refresh cookie %+v", refresh)
+	}
+	if csrf == nil || csrf.HttpOnly || csrf.MaxAge != 86400 {
+		t.Fatalf("unexpected csrf cookie %+v", csrf)
+	}
+	if strings.Contains(rec.Body.String(), "refresh_token") {
+		t.Errorf("refresh token leaked into body: %s", rec.Body.String())
+	}
+
+	// the cookies alone, as sent by a cross-site form, are not enough
+	req = httptest.NewRequest(http.MethodPost, "/web/session/refresh", nil)
+	req.AddCookie(refresh)
+	req.AddCookie(csrf)
+	rec = httptest.NewRecorder()
+	c.RefreshHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without csrf header, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/web/login", strings.NewReader(`{}`))
+	req.Header.Set("Origin", "https://evil.example")
+	rec = httptest.NewRecorder()
+	c.LoginHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for foreign origin, got %d", rec.Code)
+	}
+
+	// the scheme has to match for the service's own origin
+	req = httptest.NewRequest(http.MethodPost, "/web/login", strings.NewReader(`{}`))
+	req.Header.Set("Origin", "https://example.com")
+	rec = httptest.NewRecorder()
+	c.LoginHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for the https origin of an http request, got %d", rec.Code)
+	}
+
+	// origins of policies without credentials aren't trusted, * least of all
+	for _, policy := range []string{`allowed_origins: ["https://app.example.com"]`, `allowed_origins: ["*"]`} {
+		c.Cookie.Origins = newCORS(t, policy)
+		req = httptest.NewRequest(http.MethodPost, "/web/login", strings.NewReader(`{}`))
+		req.Header.Set("Origin", "https://app.example.com")
+		rec = httptest.NewRecorder()
+		c.LoginHandler(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", policy, rec.Code)
+		}
+	}
+}
+
+func newCORS(t *testing.T, policy string) *cors.CORS {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "cors.yaml")
+	if err := os.WriteFile(file, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cors.NewCORS(slog.Default(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// newRealmHandler serves the browser OAuth endpoints behind the realm
+// middleware, with the acme realm besides the default one
+func newRealmHandler(t *testing.T) http.Handler {
+	t.Helper()
+	users := &testutil.UserStore{}
+	store := testutil.NewSessionStore()
+	authSvc := auth.NewAuth(slog.Default(), users, store, &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute})
+	if err := authSvc.Register(context.Background(), models.NewUser{Email: "alice@example.com", HashPass: []byte("examplepass")}); err != nil {
+		t.Fatal(err)
+	}
+	clients := &testutil.ClientStore{Clients: map[string]models.OAuthClient{
+		"app": {ClientID: "app", Public: true, GrantTypes: oauth.DefaultGrantTypes, RedirectURIs: []string{"https://app.example.com/callback"}},
+		"tv":  {ClientID: "tv", Public: true, GrantTypes: []string{oauth.GrantDeviceCode}},
+	}}
+	oauthController := controller.NewOAuthController(oauth.NewOAuth(slog.Default(), authSvc, clients, store, time.Minute, time.Hour), true, slog.Default())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/authorize", oauthController.AuthorizeHandler)
+	mux.HandleFunc("/oauth/device_authorization", oauthController.DeviceAuthorizationHandler)
+	mux.HandleFunc("/oauth/device", oauthController.DeviceHandler)
+	return newRealms(t).Middleware(mux, newController(t, &MockAuthServer{}).RealmErrorHandler)
+}
+
+// newRealms returns the default realm and the acme realm
+func newRealms(t *testing.T) *realm.Registry {
+	t.Helper()
+	registry, err := realm.Config{Realms: []realm.RealmConfig{{Name: "acme", Issuer: "https://auth.example.com/realms/acme", Secret: "acme"}}}.
+		Build(&realm.Realm{Name: realm.Default, JWT: &jwtman.JWTManager{SecretKey: []byte("test")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func cookieNamed(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, ck := range rec.Result().Cookies() {
+		if ck.Name == name {
+			return ck
+		}
+	}
+	return nil
+}
+
+func TestOAuthController_RealmPrefix(t *testing.T) {
+	handler := newRealmHandler(t)
+	query := url.Values{
+		"client_id":             {"app"},
+		"response_type":         {"code"},
+		"code_challenge":        {oauth.S256Challenge("verifier")},
+		"code_challenge_method": {"S256"},
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/realms/acme/oauth/authorize?"+query.Encode(), nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `action="/realms/acme/oauth/authorize"`) {
+		t.Fatalf("expected the form to post to the realm, got %d: %s", rec.Code, rec.Body.String())
+	}
+	csrf := cookieNamed(rec, "oauth_csrf")
+	if csrf == nil || csrf.Path != "/realms/acme/oauth" {
+		t.Fatalf("expected the csrf cookie scoped to the realm, got %+v", csrf)
+	}
+
+	form := url.Values{"email": {"alice@example.com"}, "password": {"examplepass"}, "action": {"allow"}, "csrf_token": {csrf.Value}}
+	for k, v := range query {
+		form[k] = v
+	}
+	req := httptest.NewRequest(http.MethodPost, "/realms/acme/oauth/authorize", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(csrf)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect to the client, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if session := cookieNamed(rec, "idp_session"); session == nil || session.Path != "/realms/acme/oauth" {
+		t.Errorf("expected the login session scoped to the realm, got %+v", session)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/realms/acme/oauth/device", nil))
+	if !strings.Contains(rec.Body.String(), `action="/realms/acme/oauth/device"`) {
+		t.Errorf("expected the device form to post to the realm, got %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/realms/acme/oauth/device_authorization", strings.NewReader("client_id=tv"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var device oauth.DeviceAuthorization
+	if err := json.NewDecoder(rec.Body).Decode(&device); err != nil {
+		t.Fatalf("failed to decode device authorization: %v", err)
+	}
+	if device.VerificationURI != "http://example.com/realms/acme/oauth/device" {
+		t.Errorf("expected the verification uri in the realm, got %q", device.VerificationURI)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/realms/other/oauth/device", nil))
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != controller.ProblemContentType {
+		t.Errorf("expected a not found problem for an unknown realm, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+// newLoginHandler serves federated and SAML logins behind the realm
+// middleware. The OpenID provider only publishes its discovery document, as
+// the tests don't get past the callback.
+func newLoginHandler(t *testing.T) http.Handler {
+	t.Helper()
+	var provider *httptest.Server
+	provider = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 provider.URL,
+			"authorization_endpoint": provider.URL + "/authorize",
+			"token_endpoint":         provider.URL + "/token",
+			"jwks_uri":               provider.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(provider.Close)
+
+	users := &testutil.UserStore{}
+	store := testutil.NewSessionStore()
+	authSvc := auth.NewAuth(slog.Default(), users, store, &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute})
+	fed, err := federation.NewFederation(slog.Default(), authSvc, nil, store, federation.Config{
+		BaseURL:   "https://auth.example.com",
+		Providers: []federation.ProviderConfig{{Name: "fake", DiscoveryURL: provider.URL + "/.well-known/openid-configuration", ClientID: "local"}},
+	}, provider.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := saml.NewServiceProvider(slog.Default(), authSvc, users, store, saml.Config{
+		BaseURL:     "https://auth.example.com",
+		Connections: []saml.ConnectionConfig{{Name: "corp", IdPMetadataFile: "../services/saml/testdata/idp-metadata.xml"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fedController := controller.NewFederationController(fed, true, slog.Default())
+	samlController := controller.NewSAMLController(sp, true, slog.Default())
+
+	router := mux.NewRouter()
+	router.HandleFunc("/federation/{provider}/login", fedController.LoginHandler)
+	router.HandleFunc("/federation/{provider}/callback", fedController.CallbackHandler)
+	router.HandleFunc("/saml/metadata", samlController.MetadataHandler)
+	router.HandleFunc("/saml/{connection}/login", samlController.LoginHandler)
+	router.HandleFunc("/saml/{connection}/acs", samlController.ACSHandler)
+	return newRealms(t).Middleware(router, newController(t, &MockAuthServer{}).RealmErrorHandler)
+}
+
+func TestLoginControllers_RealmPrefix(t *testing.T) {
+	handler := newLoginHandler(t)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/realms/acme/federation/fake/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected a redirect to the provider, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if got := location.Query().Get("redirect_uri"); got != "https://auth.example.com/realms/acme/federation/fake/callback" {
+		t.Errorf("expected the callback in the realm, got %q", got)
+	}
+	state := cookieNamed(rec, "federation_state")
+	if state == nil || state.Path != "/realms/acme/federation" {
+		t.Fatalf("expected the state cookie scoped to the realm, got %+v", state)
+	}
+	// a login started in acme can't complete in the default realm
+	req := httptest.NewRequest(http.MethodGet, "/federation/fake/callback?code=x&state="+url.QueryEscape(state.Value), nil)
+	req.AddCookie(state)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "INVALID_LOGIN_STATE") {
+		t.Errorf("expected an invalid state problem, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/realms/acme/saml/corp/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected a redirect to the identity provider, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location, _ = url.Parse(rec.Header().Get("Location"))
+	deflated, err := base64.StdEncoding.DecodeString(location.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(request), `AssertionConsumerServiceURL="https://auth.example.com/realms/acme/saml/corp/acs"`) {
+		t.Errorf("expected the ACS of the realm in the AuthnRequest, got %s", request)
+	}
+	pending := cookieNamed(rec, "saml_request")
+	if pending == nil || pending.Path != "/realms/acme/saml" {
+		t.Fatalf("expected the request cookie scoped to the realm, got %+v", pending)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/saml/corp/acs", strings.NewReader("SAMLResponse=x"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(pending)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "INVALID_LOGIN_STATE") {
+		t.Errorf("expected the response to be unsolicited in the default realm, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/realms/acme/saml/metadata", nil))
+	if !strings.Contains(rec.Body.String(), `Location="https://auth.example.com/realms/acme/saml/corp/acs"`) {
+		t.Errorf("expected the metadata to list the ACS of the realm, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"auth_service/internal/cors"
+	"auth_service/internal/realm"
+	"auth_service/protos/gen/go/authservicegen"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	CSRFCookieName = "csrf_token"
+	CSRFHeader     = "X-CSRF-Token"
+)
+
+// CookieConfig enables the browser cookie mode, where the refresh token is
+// kept in an HttpOnly cookie instead of the response body. The cookies live as
+// long as the refresh tokens of the realm of the request.
+type CookieConfig struct {
+	Name     string
+	Path     string
+	Domain   string
+	SameSite http.SameSite
+	Secure   bool
+	// Origins is the CORS policy; the origins it lists for credentialed
+	// requests may send cookie mode requests besides the service itself. nil
+	// allows only the service.
+	Origins *cors.CORS
+}
+
+type cookieLoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type cookieTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	CSRFToken   string `json:"csrf_token"`
+}
+
+// LoginHandler logs the user in and sets the refresh token cookie
+func (c *AuthController) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.checkOrigin(w, r) {
+		return
+	}
+
+	var req cookieLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteProblem(w, c.Logger, NewProblem(r, http.StatusBadRequest, "INVALID_ARGUMENT", "invalid request body"))
+		return
+	}
+
+	ctx, err := c.annotate(r, authservicegen.AuthService_Login_FullMethodName)
+	if err != nil {
+		WriteError(w, r, c.Logger, err)
+		return
+	}
+	tokens, err := c.client.Login(ctx, &authservicegen.LoginRequest{Email: req.Email, Password: req.Password})
+	if err != nil {
+		c.writeStatus(w, r, err)
+		return
+	}
+	c.writeTokens(w, r, tokens)
+}
+
+// RefreshHandler rotates the refresh token from the cookie
+func (c *AuthController) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	refreshToken, ok := c.cookieSession(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, err := c.annotate(r, authservicegen.AuthService_Refresh_FullMethodName)
+	if err != nil {
+		WriteError(w, r, c.Logger, err)
+		return
+	}
+	tokens, err := c.client.Refresh(ctx, &authservicegen.RefreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		c.clearCookies(w, r)
+		c.writeStatus(w, r, err)
+		return
+	}
+	c.writeTokens(w, r, tokens)
+}
+
+// LogoutHandler revokes the refresh token from the cookie and clears it
+func (c *AuthController) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	refreshToken, ok := c.cookieSession(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, err := c.annotate(r, authservicegen.AuthService_Logout_FullMethodName)
+	if err != nil {
+		WriteError(w, r, c.Logger, err)
+		return
+	}
+	if _, err := c.client.Logout(ctx, &authservicegen.LogoutRequest{RefreshToken: refreshToken}); err != nil {
+		c.writeStatus(w, r, err)
+		return
+	}
+
+	c.clearCookies(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// annotate converts request headers into gRPC metadata like the gateway does
+func (c *AuthController) annotate(r *http.Request, method string) (context.Context, error) {
+	ctx := c.requestContext(r)
+	return runtime.AnnotateContext(ctx, c.Gateway, r.WithContext(ctx), method)
+}
+
+func (c *AuthController) writeStatus(w http.ResponseWriter, r *http.Request, err error) {
+	WriteProblem(w, c.Logger, ProblemFromStatus(r, status.Convert(err)))
+}
+
+// cookieSession returns the refresh token after checking origin and CSRF token
+func (c *AuthController) cookieSession(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !c.checkOrigin(w, r) {
+		return "", false
+	}
+
+	csrf, err := r.Cookie(CSRFCookieName)
+	header := r.Header.Get(CSRFHeader)
+	if err != nil || header == "" || subtle.ConstantTimeCompare([]byte(csrf.Value), []byte(header)) != 1 {
+		WriteProblem(w, c.Logger, NewProblem(r, http.StatusForbidden, "CSRF_TOKEN_MISMATCH", "missing or invalid csrf token"))
+		return "", false
+	}
+
+	cookie, err := r.Cookie(c.Cookie.Name)
+	if err != nil || cookie.Value == "" {
+		WriteProblem(w, c.Logger, NewProblem(r, http.StatusUnauthorized, "UNAUTHENTICATED", "missing refresh token cookie"))
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// checkOrigin rejects cross-site requests from origins that are not allowed.
+// Browsers always send Origin on POST, so a missing one means a non-browser
+// client. Other origins need a CORS policy that allows credentials; a * in a
+// policy without them doesn't count, or any site could log users in.
+func (c *AuthController) checkOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Scheme+"://"+u.Host, requestOrigin(r)) {
+		return true
+	}
+	if c.Cookie.Origins != nil && c.Cookie.Origins.AllowedWithCredentials(origin) {
+		return true
+	}
+	c.Logger.Warn("Cookie request from disallowed origin", slog.String("origin", origin))
+	WriteProblem(w, c.Logger, NewProblem(r, http.StatusForbidden, "ORIGIN_NOT_ALLOWED", "origin not allowed"))
+	return false
+}
+
+func (c *AuthController) writeTokens(w http.ResponseWriter, r *http.Request, tokens *authservicegen.TokenPair) {
+	csrf := randomToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.Cookie.Name,
+		Value:    tokens.RefreshToken,
+		Path:     c.cookiePath(r),
+		Domain:   c.Cookie.Domain,
+		MaxAge:   cookieMaxAge(r),
+		Secure:   c.Cookie.Secure,
+		HttpOnly: true,
+		SameSite: c.Cookie.SameSite,
+	})
+	// readable by the page so it can echo it in the CSRF header
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    csrf,
+		Path:     "/",
+		Domain:   c.Cookie.Domain,
+		MaxAge:   cookieMaxAge(r),
+		Secure:   c.Cookie.Secure,
+		SameSite: c.Cookie.SameSite,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	resp := cookieTokenResponse{AccessToken: tokens.AccessToken, TokenType: tokens.TokenType, CSRFToken: csrf}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		c.Logger.Error("Failed to write tokens", slog.Any("error", err))
+	}
+}
+
+func (c *AuthController) clearCookies(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name: c.Cookie.Name, Path: c.cookiePath(r), Domain: c.Cookie.Domain,
+		MaxAge: -1, Secure: c.Cookie.Secure, HttpOnly: true, SameSite: c.Cookie.SameSite,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name: CSRFCookieName, Path: "/", Domain: c.Cookie.Domain,
+		MaxAge: -1, Secure: c.Cookie.Secure, SameSite: c.Cookie.SameSite,
+	})
+}
+
+// cookiePath scopes the refresh token cookie to the cookie mode endpoints of
+// the realm of the request
+func (c *AuthController) cookiePath(r *http.Request) string {
+	return realm.BasePath(r.Context()) + c.Cookie.Path
+}
+
+// cookieMaxAge is the refresh token lifetime of the realm of the request in
+// seconds. Without a realm the cookies last for the browser session.
+func cookieMaxAge(r *http.Request) int {
+	rlm := realm.FromContext(r.Context())
+	if rlm == nil {
+		return 0
+	}
+	ttl := rlm.Session.RefreshTokenTTL
+	if ttl == 0 && rlm.JWT != nil {
+		ttl = rlm.JWT.TokenDuration
+	}
+	return int(ttl.Seconds())
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseSameSite maps a config value to its SameSite mode
+func ParseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown SameSite mode %q", mode)
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/oauth"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const devicePath = "/oauth/device"
+
+type deviceView struct {
+	Action     string
+	CSRFToken  string
+	UserCode   string
+	NeedLogin  bool
+	ClientName string
+	Scopes     []string
+	Error      string
+	Done       string
+}
+
+// DeviceAuthorizationHandler is the device authorization endpoint of RFC 8628
+func (c *OAuthController) DeviceAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		c.writeTokenError(w, http.StatusBadRequest, &oauth.Error{Code: oauth.ErrorInvalidRequest, Description: "malformed form body"})
+		return
+	}
+	clientID, secret, basic, cerr := clientCredentials(r)
+	if cerr != nil {
+		c.writeTokenError(w, http.StatusBadRequest, cerr)
+		return
+	}
+
+	verificationURI := requestOrigin(r) + realm.BasePath(r.Context()) + devicePath
+	if c.OAuth.OIDC != nil {
+		verificationURI = c.OAuth.Issuer(r.Context()) + devicePath
+	}
+	resp, err := c.OAuth.AuthorizeDevice(r.Context(), clientID, secret, r.PostForm.Get("scope"), verificationURI)
+	if c.writeClientError(w, err, basic) {
+		return
+	}
+	if err != nil {
+		c.Logger.Error("Device authorization failed", slog.Any("error", err))
+		c.writeTokenError(w, http.StatusInternalServerError, &oauth.Error{Code: oauth.ErrorServerError})
+		return
+	}
+	c.writeJSON(w, http.StatusOK, resp)
+}
+
+// DeviceHandler is the verification page where a signed in user enters the
+// user code shown by a device and approves or denies it
+func (c *OAuthController) DeviceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		c.renderDevice(w, r, http.StatusBadRequest, deviceView{Error: "malformed request"})
+		return
+	}
+	session, err := c.OAuth.Session(r.Context(), cookieValue(r, loginSessionCookie))
+	if err != nil && !errors.Is(err, oauth.ErrNoSession) {
+		c.Logger.Error("Loading login session failed", slog.Any("error", err))
+		c.renderDevice(w, r, http.StatusInternalServerError, deviceView{Error: "internal error"})
+		return
+	}
+	loggedIn := err == nil
+	entry := deviceView{UserCode: strings.TrimSpace(r.Form.Get("user_code")), NeedLogin: !loggedIn}
+
+	if r.Method == http.MethodGet {
+		c.renderDevice(w, r, http.StatusOK, entry)
+		return
+	}
+	if !checkFormCSRF(r) {
+		entry.Error = "The form has expired, please try again"
+		c.renderDevice(w, r, http.StatusForbidden, entry)
+		return
+	}
+
+	action := r.PostForm.Get("action")
+	if action == "allow" || action == "deny" {
+		if !loggedIn {
+			c.renderDevice(w, r, http.StatusUnauthorized, entry)
+			return
+		}
+		err := c.OAuth.CompleteDevice(r.Context(), entry.UserCode, session, action == "allow")
+		if errors.Is(err, oauth.ErrUnknownUserCode) {
+			entry.Error = "The code is invalid or has expired"
+			c.renderDevice(w, r, http.StatusBadRequest, entry)
+			return
+		}
+		if err != nil {
+			c.Logger.Error("Completing device authorization failed", slog.Any("error", err))
+			c.renderDevice(w, r, http.StatusInternalServerError, deviceView{Error: "internal error"})
+			return
+		}
+		done := "The device was denied access. You can close this page."
+		if action == "allow" {
+			done = "Your device is connected. You can close this page and return to it."
+		}
+		c.renderDevice(w, r, http.StatusOK, deviceView{Done: done})
+		return
+	}
+
+	if !loggedIn {
+		user := models.NewUser{Email: r.PostForm.Get("email"), HashPass: []byte(r.PostForm.Get("password"))}
+		sessionID, _, err := c.OAuth.Login(r.Context(), user)
+		var verr *auth.ValidationError
+		if errors.Is(err, auth.ErrInvalidCredentials) || errors.As(err, &verr) {
+			entry.Error = "Invalid email or password"
+			c.renderDevice(w, r, http.StatusUnauthorized, entry)
+			return
+		}
+		if auth.AccountInactive(err) {
+			entry.Error = "This account is not active"
+			c.renderDevice(w, r, http.StatusForbidden, entry)
+			return
+		}
+		if err != nil {
+			c.Logger.Error("Login at device verification failed", slog.Any("error", err))
+			c.renderDevice(w, r, http.StatusInternalServerError, deviceView{Error: "internal error"})
+			return
+		}
+		c.setLoginSession(w, r, sessionID)
+		entry.NeedLogin = false
+	}
+
+	req, err := c.OAuth.LookupDevice(r.Context(), entry.UserCode)
+	if errors.Is(err, oauth.ErrUnknownUserCode) || errors.Is(err, oauth.ErrUnknownClient) {
+		entry.Error = "The code is invalid or has expired"
+		c.renderDevice(w, r, http.StatusBadRequest, entry)
+		return
+	}
+	if err != nil {
+		c.Logger.Error("Device lookup failed", slog.Any("error", err))
+		c.renderDevice(w, r, http.StatusInternalServerError, deviceView{Error: "internal error"})
+		return
+	}
+	c.renderDevice(w, r, http.StatusOK, deviceView{
+		UserCode:   req.UserCode,
+		ClientName: req.Client.Name,
+		Scopes:     strings.Fields(req.Scope),
+	})
+}
+
+func (c *OAuthController) renderDevice(w http.ResponseWriter, r *http.Request, status int, view deviceView) {
+	view.Action = realm.BasePath(r.Context()) + devicePath
+	view.CSRFToken = c.formCSRF(w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := devicePage.Execute(w, view); err != nil {
+		c.Logger.Error("Failed render device page", slog.Any("error", err))
+	}
+}
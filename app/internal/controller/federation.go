@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"auth_service/internal/realm"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/federation"
+	"auth_service/protos/gen/go/authservicegen"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const federationStateCookie = "federation_state"
+
+// FederationController serves the redirect and callback of logins with
+// upstream identity providers
+type FederationController struct {
+	Logger     *slog.Logger
+	Federation *federation.Federation
+	// SecureCookies marks the state cookie Secure
+	SecureCookies bool
+}
+
+func NewFederationController(svc *federation.Federation, secureCookies bool, logger *slog.Logger) *FederationController {
+	return &FederationController{Logger: logger, Federation: svc, SecureCookies: secureCookies}
+}
+
+// LoginHandler redirects the browser to the provider
+func (c *FederationController) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := c.Federation.Start(r.Context(), mux.Vars(r)["provider"])
+	if err != nil {
+		c.Logger.Warn("Starting federated login failed", slog.Any("error", err))
+		WriteError(w, r, c.Logger, err)
+		return
+	}
+	// binds the state to this browser so a login can't be completed in
+	// another one (login CSRF)
+	http.SetCookie(w, &http.Cookie{
+		Name:     federationStateCookie,
+		Value:    state,
+		Path:     federationPath(r),
+		MaxAge:   int(c.Federation.StateTTL.Seconds()),
+		Secure:   c.SecureCookies,
+		HttpOnly: true,
+		// sent on the top level navigation back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// CallbackHandler completes the login and responds with a token pair like
+// /login
+func (c *FederationController) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	state := query.Get("state")
+	http.SetCookie(w, &http.Cookie{Name: federationStateCookie, Path: federationPath(r), MaxAge: -1, Secure: c.SecureCookies, HttpOnly: true})
+
+	if upstream := query.Get("error"); upstream != "" {
+		WriteProblem(w, c.Logger, NewProblem(r, http.StatusUnauthorized, "IDENTITY_PROVIDER_DENIED", upstream+": "+query.Get("error_description")))
+		return
+	}
+	cookie := cookieValue(r, federationStateCookie)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		WriteError(w, r, c.Logger, federation.ErrInvalidState)
+		return
+	}
+
+	tokens, err := c.Federation.Callback(r.Context(), mux.Vars(r)["provider"], state, query.Get("code"), httpBinding(r))
+	if err != nil {
+		c.Logger.Warn("Federated login failed", slog.Any("error", err))
+		WriteError(w, r, c.Logger, err)
+		return
+	}
+	writeTokenPair(w, r, c.Logger, tokens)
+}
+
+// federationPath scopes the state cookie to the federation endpoints of the
+// realm of the request
+func federationPath(r *http.Request) string {
+	return realm.BasePath(r.Context()) + "/federation"
+}
+
+// writeTokenPair responds with the tokens of a browser login in the format
+// of /login
+func writeTokenPair(w http.ResponseWriter, r *http.Request, logger *slog.Logger, tokens *auth.AuthResponse) {
+	body, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(&authservicegen.TokenPair{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    tokens.TokenType,
+	})
+	if err != nil {
+		WriteError(w, r, logger, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		logger.Error("Failed write response", slog.Any("error", err))
+	}
+}
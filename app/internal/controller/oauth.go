@@ -0,0 +1,434 @@
+package controller
+
+import (
+	"auth_service/internal/JWT/dpop"
+	"auth_service/internal/clientcert"
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/oauth"
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	loginSessionCookie = "idp_session"
+	oauthCSRFCookie    = "oauth_csrf"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+var (
+	authorizePage = template.Must(template.ParseFS(templates, "templates/authorize.html"))
+	devicePage    = template.Must(template.ParseFS(templates, "templates/device.html"))
+)
+
+// OAuthController serves the OAuth endpoints. They are not gRPC methods
+// because they use form posts, redirects and HTML.
+type OAuthController struct {
+	Logger *slog.Logger
+	OAuth  *oauth.OAuth
+	// SecureCookies marks the login session cookie Secure
+	SecureCookies bool
+}
+
+func NewOAuthController(svc *oauth.OAuth, secureCookies bool, logger *slog.Logger) *OAuthController {
+	return &OAuthController{Logger: logger, OAuth: svc, SecureCookies: secureCookies}
+}
+
+type authorizeView struct {
+	ClientName string
+	Scopes     []string
+	Action     string
+	Params     map[string]string
+	CSRFToken  string
+	NeedLogin  bool
+	LoginError string
+	Error      string
+}
+
+func authorizeRequest(form url.Values) oauth.AuthorizeRequest {
+	return oauth.AuthorizeRequest{
+		ClientID:            form.Get("client_id"),
+		RedirectURI:         form.Get("redirect_uri"),
+		ResponseType:        form.Get("response_type"),
+		Scope:               form.Get("scope"),
+		State:               form.Get("state"),
+		CodeChallenge:       form.Get("code_challenge"),
+		CodeChallengeMethod: form.Get("code_challenge_method"),
+		Nonce:               form.Get("nonce"),
+	}
+}
+
+// AuthorizeHandler shows the login and consent page on GET and handles its
+// submission on POST
+func (c *OAuthController) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		c.renderError(w, http.StatusBadRequest, "malformed request")
+		return
+	}
+	req, client, err := c.OAuth.ValidateAuthorize(r.Context(), authorizeRequest(r.Form))
+	var oerr *oauth.Error
+	switch {
+	case errors.Is(err, oauth.ErrUnknownClient), errors.Is(err, oauth.ErrInvalidRedirectURI):
+		c.renderError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.As(err, &oerr):
+		c.redirect(w, r, req, url.Values{"error": {oerr.Code}, "error_description": {oerr.Description}})
+		return
+	case err != nil:
+		c.Logger.Error("Authorization request failed", slog.Any("error", err))
+		c.renderError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	sessionID := cookieValue(r, loginSessionCookie)
+	session, err := c.OAuth.Session(r.Context(), sessionID)
+	if err != nil && !errors.Is(err, oauth.ErrNoSession) {
+		c.Logger.Error("Loading login session failed", slog.Any("error", err))
+		c.renderError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	loggedIn := err == nil
+
+	if r.Method == http.MethodGet {
+		c.renderConsent(w, r, http.StatusOK, req, client, !loggedIn, "")
+		return
+	}
+
+	if !checkFormCSRF(r) {
+		c.renderError(w, http.StatusForbidden, "the form has expired, please start again")
+		return
+	}
+	if r.PostForm.Get("action") != "allow" {
+		c.redirect(w, r, req, url.Values{"error": {oauth.ErrorAccessDenied}})
+		return
+	}
+
+	if !loggedIn {
+		user := models.NewUser{Email: r.PostForm.Get("email"), HashPass: []byte(r.PostForm.Get("password"))}
+		sessionID, session, err = c.OAuth.Login(r.Context(), user)
+		var verr *auth.ValidationError
+		if errors.Is(err, auth.ErrInvalidCredentials) || errors.As(err, &verr) {
+			c.renderConsent(w, r, http.StatusUnauthorized, req, client, true, "Invalid email or password")
+			return
+		}
+		if auth.AccountInactive(err) {
+			c.renderConsent(w, r, http.StatusForbidden, req, client, true, "This account is not active")
+			return
+		}
+		if err != nil {
+			c.Logger.Error("Login at authorization endpoint failed", slog.Any("error", err))
+			c.renderError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		c.setLoginSession(w, r, sessionID)
+	}
+
+	code, err := c.OAuth.IssueCode(r.Context(), req, session)
+	if err != nil {
+		c.Logger.Error("Issuing authorization code failed", slog.Any("error", err))
+		c.redirect(w, r, req, url.Values{"error": {oauth.ErrorServerError}})
+		return
+	}
+	c.redirect(w, r, req, url.Values{"code": {code}})
+}
+
+func (c *OAuthController) setLoginSession(w http.ResponseWriter, r *http.Request, id string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginSessionCookie,
+		Value:    id,
+		Path:     oauthPath(r),
+		MaxAge:   int(c.OAuth.SessionTTL.Seconds()),
+		Secure:   c.SecureCookies,
+		HttpOnly: true,
+		// sent on the top level navigation from the client to /oauth/authorize
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// formCSRF returns the CSRF token for a form, setting its cookie if needed
+func (c *OAuthController) formCSRF(w http.ResponseWriter, r *http.Request) string {
+	csrf := cookieValue(r, oauthCSRFCookie)
+	if csrf == "" {
+		csrf = randomToken()
+		http.SetCookie(w, &http.Cookie{
+			Name:     oauthCSRFCookie,
+			Value:    csrf,
+			Path:     oauthPath(r),
+			Secure:   c.SecureCookies,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	return csrf
+}
+
+// oauthPath is the path of the OAuth endpoints the cookies of the login
+// and consent pages are scoped to, in the realm of the request
+func oauthPath(r *http.Request) string {
+	return realm.BasePath(r.Context()) + "/oauth"
+}
+
+// checkFormCSRF compares the posted token with the cookie
+func checkFormCSRF(r *http.Request) bool {
+	csrf := cookieValue(r, oauthCSRFCookie)
+	return csrf != "" && subtle.ConstantTimeCompare([]byte(csrf), []byte(r.PostForm.Get("csrf_token"))) == 1
+}
+
+func (c *OAuthController) renderConsent(w http.ResponseWriter, r *http.Request, status int, req oauth.AuthorizeRequest, client models.OAuthClient, needLogin bool, loginError string) {
+	view := authorizeView{
+		ClientName: client.Name,
+		Scopes:     strings.Fields(req.Scope),
+		Action:     realm.BasePath(r.Context()) + r.URL.Path,
+		Params: map[string]string{
+			"client_id":             req.ClientID,
+			"redirect_uri":          req.RedirectURI,
+			"response_type":         req.ResponseType,
+			"scope":                 req.Scope,
+			"state":                 req.State,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": req.CodeChallengeMethod,
+			"nonce":                 req.Nonce,
+		},
+		CSRFToken:  c.formCSRF(w, r),
+		NeedLogin:  needLogin,
+		LoginError: loginError,
+	}
+	c.render(w, status, view)
+}
+
+func (c *OAuthController) renderError(w http.ResponseWriter, status int, msg string) {
+	c.render(w, status, authorizeView{Error: msg})
+}
+
+func (c *OAuthController) render(w http.ResponseWriter, status int, view authorizeView) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := authorizePage.Execute(w, view); err != nil {
+		c.Logger.Error("Failed render authorization page", slog.Any("error", err))
+	}
+}
+
+// redirect sends the authorization response back to the client
+func (c *OAuthController) redirect(w http.ResponseWriter, r *http.Request, req oauth.AuthorizeRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		c.renderError(w, http.StatusBadRequest, "invalid redirect uri")
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			q.Set(k, v[0])
+		}
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	// RFC 9207 lets clients detect mix-up attacks
+	if c.OAuth.OIDC != nil {
+		q.Set("iss", c.OAuth.Issuer(r.Context()))
+	}
+	u.RawQuery = q.Encode()
+
+	status := http.StatusFound
+	if r.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+	http.Redirect(w, r, u.String(), status)
+}
+
+// TokenHandler is the OAuth token endpoint
+func (c *OAuthController) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		c.writeTokenError(w, http.StatusBadRequest, &oauth.Error{Code: oauth.ErrorInvalidRequest, Description: "malformed form body"})
+		return
+	}
+
+	clientID, secret, basic, cerr := clientCredentials(r)
+	if cerr != nil {
+		c.writeTokenError(w, http.StatusBadRequest, cerr)
+		return
+	}
+	req := oauth.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		ClientID:     clientID,
+		ClientSecret: secret,
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		DeviceCode:   r.PostForm.Get("device_code"),
+		Scope:        r.PostForm.Get("scope"),
+
+		SubjectToken:       r.PostForm.Get("subject_token"),
+		SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+		ActorToken:         r.PostForm.Get("actor_token"),
+		ActorTokenType:     r.PostForm.Get("actor_token_type"),
+		RequestedTokenType: r.PostForm.Get("requested_token_type"),
+		Audience:           r.PostForm["audience"],
+
+		Assertion: r.PostForm.Get("assertion"),
+
+		Binding: httpBinding(r),
+	}
+
+	resp, err := c.OAuth.Token(r.Context(), req)
+	if c.writeClientError(w, err, basic) {
+		return
+	}
+	if err != nil {
+		c.Logger.Error("Token request failed", slog.String("grant_type", req.GrantType), slog.Any("error", err))
+		c.writeTokenError(w, http.StatusInternalServerError, &oauth.Error{Code: oauth.ErrorServerError})
+		return
+	}
+	c.writeJSON(w, http.StatusOK, resp)
+}
+
+// clientCredentials reads the client authentication of a form post, either
+// HTTP Basic or client_id/client_secret in the body
+func clientCredentials(r *http.Request) (id, secret string, basic bool, err *oauth.Error) {
+	id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	basicID, basicSecret, basic := r.BasicAuth()
+	if !basic {
+		return id, secret, false, nil
+	}
+	if secret != "" {
+		return "", "", true, &oauth.Error{Code: oauth.ErrorInvalidRequest, Description: "multiple client authentication methods"}
+	}
+	// RFC 6749 section 2.3.1 form encodes the credentials before basic auth
+	id, _ = url.QueryUnescape(basicID)
+	secret, _ = url.QueryUnescape(basicSecret)
+	return id, secret, true, nil
+}
+
+// writeClientError writes an *oauth.Error response and reports whether err
+// was one
+func (c *OAuthController) writeClientError(w http.ResponseWriter, err error, basic bool) bool {
+	var oerr *oauth.Error
+	if !errors.As(err, &oerr) {
+		return false
+	}
+	status := http.StatusBadRequest
+	if oerr.Code == oauth.ErrorInvalidClient {
+		status = http.StatusUnauthorized
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+	}
+	c.writeTokenError(w, status, oerr)
+	return true
+}
+
+type tokenErrorBody struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func (c *OAuthController) writeTokenError(w http.ResponseWriter, status int, err *oauth.Error) {
+	c.writeJSON(w, status, tokenErrorBody{Error: err.Code, ErrorDescription: err.Description})
+}
+
+func (c *OAuthController) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		c.Logger.Error("Failed write response", slog.Any("error", err))
+	}
+}
+
+// httpBinding collects the client certificate and DPoP proof of a request
+// that doesn't go through the gateway
+func httpBinding(r *http.Request) auth.TokenBinding {
+	var b auth.TokenBinding
+	if id := clientcert.FromContext(r.Context()); id != nil {
+		b.CertThumbprint = id.Thumbprint()
+	}
+	if proof := r.Header.Get(dpop.Header); proof != "" {
+		b.DPoPProof = proof
+		b.DPoPRequest = dpop.Request{Method: r.Method, URL: requestURL(r)}
+	}
+	return b
+}
+
+func cookieValue(r *http.Request, name string) string {
+	if cookie, err := r.Cookie(name); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// DiscoveryHandler serves /.well-known/openid-configuration
+func (c *OAuthController) DiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(w).Encode(c.OAuth.Discovery(r.Context())); err != nil {
+		c.Logger.Error("Failed write discovery document", slog.Any("error", err))
+	}
+}
+
+// JWKSHandler serves the keys ID tokens are signed with
+func (c *OAuthController) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(w).Encode(c.OAuth.Signer(r.Context()).JWKS()); err != nil {
+		c.Logger.Error("Failed write jwks", slog.Any("error", err))
+	}
+}
+
+// UserInfoHandler returns claims about the user of the access token
+func (c *OAuthController) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") && !strings.EqualFold(scheme, dpop.Scheme) {
+		token = ""
+	}
+	claims, err := c.OAuth.Auth.VerifyAccessToken(r.Context(), auth.PresentedToken{
+		Token:   token,
+		Scheme:  normalizeScheme(scheme),
+		Binding: httpBinding(r),
+	})
+	if err == nil && len(claims.Audience) > 0 {
+		// exchanged tokens are meant for other services
+		err = auth.ErrTokenInvalid
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.writeJSON(w, http.StatusUnauthorized, tokenErrorBody{Error: "invalid_token", ErrorDescription: err.Error()})
+		return
+	}
+
+	info, err := c.OAuth.UserInfo(r.Context(), claims)
+	if errors.Is(err, oauth.ErrInsufficientScope) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		c.writeJSON(w, http.StatusForbidden, tokenErrorBody{Error: "insufficient_scope"})
+		return
+	}
+	if err != nil {
+		c.Logger.Error("Userinfo request failed", slog.Any("error", err))
+		c.writeJSON(w, http.StatusInternalServerError, tokenErrorBody{Error: oauth.ErrorServerError})
+		return
+	}
+	c.writeJSON(w, http.StatusOK, info)
+}
+
+func normalizeScheme(scheme string) string {
+	if strings.EqualFold(scheme, dpop.Scheme) {
+		return dpop.Scheme
+	}
+	return "Bearer"
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"auth_service/internal/errmap"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 error body
+type Problem struct {
+	Type     string         `json:"type"`
+	Title    string         `json:"title"`
+	Status   int            `json:"status"`
+	Detail   string         `json:"detail,omitempty"`
+	Instance string         `json:"instance,omitempty"`
+	Code     string         `json:"code"`
+	Errors   []FieldProblem `json:"errors,omitempty"`
+}
+
+type FieldProblem struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// NewProblem builds a problem for the request with the given status and code
+func NewProblem(r *http.Request, httpStatus int, code, detail string) *Problem {
+	return &Problem{
+		Type:     problemType(code),
+		Title:    http.StatusText(httpStatus),
+		Status:   httpStatus,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	}
+}
+
+// ProblemFromStatus converts a gRPC status returned by the service
+func ProblemFromStatus(r *http.Request, st *status.Status) *Problem {
+	code := errmap.Reason(st)
+	if code == "" {
+		code = codeName(st.Code())
+	}
+	p := NewProblem(r, runtime.HTTPStatusFromCode(st.Code()), code, st.Message())
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				p.Errors = append(p.Errors, FieldProblem{Field: v.Field, Detail: v.Description})
+			}
+		}
+	}
+	return p
+}
+
+// WriteProblem writes p as application/problem+json
+func WriteProblem(w http.ResponseWriter, logger *slog.Logger, p *Problem) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		logger.Error("Failed to write problem", slog.Any("error", err))
+	}
+}
+
+// WriteError maps a service error and writes it as a problem
+func WriteError(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error) {
+	WriteProblem(w, logger, ProblemFromStatus(r, errmap.Status(err)))
+}
+
+// RealmErrorHandler writes the error of resolving the realm of a request,
+// an unknown realm, as a problem
+func (c *AuthController) RealmErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	WriteError(w, r, c.Logger, err)
+}
+
+// errorHandler is used by the gateway for errors returned from the service
+func (c *AuthController) errorHandler(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	st := errmap.Status(err)
+	if st.Code() == codes.Internal {
+		c.Logger.Error("Http request failed", slog.String("path", r.URL.Path), slog.Any("error", err))
+	}
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		for k, vs := range md.HeaderMD {
+			if h, ok := runtime.DefaultHeaderMatcher(k); ok {
+				for _, v := range vs {
+					w.Header().Add(h, v)
+				}
+			}
+		}
+	}
+	WriteProblem(w, c.Logger, ProblemFromStatus(r, st))
+}
+
+// routingErrorHandler covers unknown routes and methods
+func (c *AuthController) routingErrorHandler(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, httpStatus int) {
+	code := "NOT_FOUND"
+	if httpStatus == http.StatusMethodNotAllowed {
+		code = "METHOD_NOT_ALLOWED"
+	}
+	WriteProblem(w, c.Logger, NewProblem(r, httpStatus, code, ""))
+}
+
+func problemType(code string) string {
+	return "urn:auth_service:problem:" + strings.ToLower(strings.ReplaceAll(code, "_", "-"))
+}
+
+func codeName(c codes.Code) string {
+	var b strings.Builder
+	for i, r := range c.String() {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
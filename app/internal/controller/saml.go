@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"auth_service/internal/realm"
+	"auth_service/internal/services/saml"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const samlRequestCookie = "saml_request"
+
+// SAMLController serves the service provider endpoints of SAML logins
+type SAMLController struct {
+	Logger *slog.Logger
+	SAML   *saml.ServiceProvider
+	// SecureCookies marks the request cookie Secure
+	SecureCookies bool
+}
+
+func NewSAMLController(svc *saml.ServiceProvider, secureCookies bool, logger *slog.Logger) *SAMLController {
+	return &SAMLController{Logger: logger, SAML: svc, SecureCookies: secureCookies}
+}
+
+// MetadataHandler publishes the SP metadata for the identity providers
+func (c *SAMLController) MetadataHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := c.SAML.Metadata(r.Context())
+	if err != nil {
+		WriteError(w, r, c.Logger, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	if _, err := w.Write(body); err != nil {
+		c.Logger.Error("Failed write response", slog.Any("error", err))
+	}
+}
+
+// LoginHandler redirects the browser to the identity provider
+func (c *SAMLController) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	redirect, requestID, err := c.SAML.Start(r.Context(), mux.Vars(r)["connection"])
+	if err != nil {
+		c.Logger.Warn("Starting SAML login failed", slog.Any("error", err))
+		WriteError(w, r, c.Logger, err)
+		return
+	}
+	// binds the request to this browser so a login can't be completed in
+	// another one. The response is a cross site POST, so the cookie needs
+	// SameSite=None, which browsers only accept on Secure cookies.
+	sameSite := http.SameSiteNoneMode
+	if !c.SecureCookies {
+		sameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     samlRequestCookie,
+		Value:    requestID,
+		Path:     samlPath(r),
+		MaxAge:   int(c.SAML.RequestTTL.Seconds()),
+		Secure:   c.SecureCookies,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// ACSHandler consumes the response the identity provider posts and responds
+// with a token pair like /login
+func (c *SAMLController) ACSHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := cookieValue(r, samlRequestCookie)
+	http.SetCookie(w, &http.Cookie{Name: samlRequestCookie, Path: samlPath(r), MaxAge: -1, Secure: c.SecureCookies, HttpOnly: true})
+
+	tokens, err := c.SAML.ACS(r.Context(), mux.Vars(r)["connection"], requestID, r.PostFormValue("SAMLResponse"), httpBinding(r))
+	if err != nil {
+		c.Logger.Warn("SAML login failed", slog.Any("error", err))
+		WriteError(w, r, c.Logger, err)
+		return
+	}
+	writeTokenPair(w, r, c.Logger, tokens)
+}
+
+// samlPath scopes the request cookie to the SAML endpoints of the realm of
+// the request
+func samlPath(r *http.Request) string {
+	return realm.BasePath(r.Context()) + "/saml"
+}
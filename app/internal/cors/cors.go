@@ -0,0 +1,245 @@
+// Package cors implements CORS handling for the HTTP router with a policy file
+// that is reloaded when it changes on disk.
+package cors
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is the content of the CORS config file
+type Policy struct {
+	// AllowedOrigins are exact origins or wildcard subdomains such as
+	// https://*.example.com
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// DefaultPolicy is applied to fields the file leaves empty
+var DefaultPolicy = Policy{
+	AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+	AllowedHeaders: []string{"Authorization", "Content-Type", "DPoP", "X-CSRF-Token", "X-Request-Id"},
+	ExposedHeaders: []string{"X-Request-Id", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "WWW-Authenticate"},
+	MaxAge:         10 * time.Minute,
+}
+
+type origin struct {
+	scheme string
+	// host is the exact host, or the parent domain for wildcards
+	host     string
+	port     string
+	wildcard bool
+}
+
+type policy struct {
+	Policy
+	origins []origin
+	modTime time.Time
+}
+
+// CORS serves the current policy as middleware
+type CORS struct {
+	Logger  *slog.Logger
+	File    string
+	current atomic.Pointer[policy]
+}
+
+func NewCORS(logger *slog.Logger, file string) (*CORS, error) {
+	c := &CORS{Logger: logger, File: file}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func parseOrigin(pattern string) (origin, error) {
+	u, err := url.Parse(pattern)
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return origin{}, fmt.Errorf("invalid origin %q", pattern)
+	}
+	o := origin{scheme: u.Scheme, host: strings.ToLower(u.Hostname()), port: u.Port()}
+	if rest, ok := strings.CutPrefix(o.host, "*."); ok {
+		o.host, o.wildcard = rest, true
+	}
+	if strings.Contains(o.host, "*") {
+		return origin{}, fmt.Errorf("invalid origin %q: only a leading *. wildcard is supported", pattern)
+	}
+	return o, nil
+}
+
+func compile(p Policy) (*policy, error) {
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = DefaultPolicy.AllowedMethods
+	}
+	if len(p.AllowedHeaders) == 0 {
+		p.AllowedHeaders = DefaultPolicy.AllowedHeaders
+	}
+	if len(p.ExposedHeaders) == 0 {
+		p.ExposedHeaders = DefaultPolicy.ExposedHeaders
+	}
+	if p.MaxAge == 0 {
+		p.MaxAge = DefaultPolicy.MaxAge
+	}
+	compiled := &policy{Policy: p}
+	for _, pattern := range p.AllowedOrigins {
+		if pattern == "*" {
+			if p.AllowCredentials {
+				return nil, fmt.Errorf("origin * cannot be combined with credentials")
+			}
+			compiled.origins = append(compiled.origins, origin{wildcard: true})
+			continue
+		}
+		o, err := parseOrigin(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled.origins = append(compiled.origins, o)
+	}
+	return compiled, nil
+}
+
+func (c *CORS) reload() error {
+	info, err := os.Stat(c.File)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return err
+	}
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("parse cors config: %w", err)
+	}
+	compiled, err := compile(p)
+	if err != nil {
+		return err
+	}
+	compiled.modTime = info.ModTime()
+	c.current.Store(compiled)
+	return nil
+}
+
+// Watch polls the policy file and reloads it when it changes. A failed reload
+// keeps the previous policy.
+func (c *CORS) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(c.File)
+		if err != nil || info.ModTime().Equal(c.current.Load().modTime) {
+			continue
+		}
+		if err := c.reload(); err != nil {
+			c.Logger.Error("CORS reload failed", slog.Any("error", err))
+			continue
+		}
+		c.Logger.Info("CORS policy reloaded", slog.String("file", c.File))
+	}
+}
+
+// Allowed reports whether the origin matches the current policy
+func (c *CORS) Allowed(requestOrigin string) bool {
+	return c.current.Load().allowed(requestOrigin, true)
+}
+
+// AllowedWithCredentials reports whether the origin may send credentialed
+// requests: the current policy allows credentials and lists the origin. The *
+// origin never matches.
+func (c *CORS) AllowedWithCredentials(requestOrigin string) bool {
+	p := c.current.Load()
+	return p.AllowCredentials && p.allowed(requestOrigin, false)
+}
+
+// allowed matches the origin against the policy; anyOrigin lets * match all
+func (p *policy) allowed(requestOrigin string, anyOrigin bool) bool {
+	u, err := url.Parse(requestOrigin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, o := range p.origins {
+		if o.scheme == "" {
+			if anyOrigin {
+				return true
+			}
+			continue
+		}
+		if o.scheme != u.Scheme || o.port != u.Port() {
+			continue
+		}
+		if o.wildcard && strings.HasSuffix(host, "."+o.host) || !o.wildcard && host == o.host {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware adds CORS headers for allowed origins and answers preflight
+// requests. Requests from other origins pass through without CORS headers,
+// so the browser blocks the response.
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestOrigin := r.Header.Get("Origin")
+		if requestOrigin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p := c.current.Load()
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !p.allowed(requestOrigin, true) {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", requestOrigin)
+		if p.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		method := r.Header.Get("Access-Control-Request-Method")
+		if !slices.Contains(p.AllowedMethods, method) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,122 @@
+package cors_test
+
+import (
+	"auth_service/internal/cors"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePolicy(t *testing.T, file, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func request(c *cors.CORS, method, origin string) *httptest.ResponseRecorder {
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(method, "/login", nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORS(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cors.yaml")
+	writePolicy(t, file, `
+allowed_origins: ["https://app.example.com", "https://*.example.org"]
+allow_credentials: true
+max_age: 1h
+`, time.Now().Add(-time.Minute))
+
+	c, err := cors.NewCORS(slog.Default(), file)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"http://app.example.com", false},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+	}
+	for _, tt := range tests {
+		rec := request(c, http.MethodPost, tt.origin)
+		if got := rec.Header().Get("Access-Control-Allow-Origin") == tt.origin; got != tt.allowed {
+			t.Errorf("%s: expected allowed %v, got %v", tt.origin, tt.allowed, got)
+		}
+	}
+
+	if !c.AllowedWithCredentials("https://app.example.com") || c.AllowedWithCredentials("https://other.example.com") {
+		t.Error("expected only listed origins to be allowed with credentials")
+	}
+
+	rec := request(c, http.MethodOptions, "https://app.example.com")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for preflight, got %d", rec.Code)
+	}
+	if rec.Header().Get("Access-Control-Max-Age") != "3600" || rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("unexpected preflight headers %v", rec.Header())
+	}
+
+	// the gateway routes API keys, memberships and accounts through PATCH and
+	// DELETE
+	for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+		req := httptest.NewRequest(http.MethodOptions, "/login", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+		rec := httptest.NewRecorder()
+		c.Middleware(http.NotFoundHandler()).ServeHTTP(rec, req)
+		if rec.Header().Get("Access-Control-Allow-Methods") == "" {
+			t.Errorf("expected %s to be allowed by default, got %v", method, rec.Header())
+		}
+	}
+
+	rec = request(c, http.MethodPost, "https://app.example.com")
+	if rec.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Error("expected exposed headers on actual request")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Watch(ctx, 10*time.Millisecond)
+
+	writePolicy(t, file, `allowed_origins: ["https://other.example.com"]`, time.Now())
+	deadline := time.Now().Add(2 * time.Second)
+	for !c.Allowed("https://other.example.com") {
+		if time.Now().After(deadline) {
+			t.Fatal("policy was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.Allowed("https://app.example.com") {
+		t.Error("old origin still allowed after reload")
+	}
+}
+
+func TestCORS_WildcardWithCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cors.yaml")
+	writePolicy(t, file, "allowed_origins: [\"*\"]\nallow_credentials: true\n", time.Now())
+	if _, err := cors.NewCORS(slog.Default(), file); err == nil {
+		t.Fatal("expected error for * with credentials")
+	}
+}
@@ -0,0 +1,176 @@
+// Package errmap translates service errors into transport errors. It is the
+// single place where domain errors get their gRPC code, HTTP status and the
+// stable reason string clients can match on.
+package errmap
+
+import (
+	"auth_service/internal/realm"
+	"auth_service/internal/services/account"
+	"auth_service/internal/services/admin"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/federation"
+	"auth_service/internal/services/oauth"
+	"auth_service/internal/services/organization"
+	"auth_service/internal/services/saml"
+	"auth_service/internal/services/serviceaccount"
+	"errors"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Domain is reported in google.rpc.ErrorInfo
+const Domain = "auth_service"
+
+const (
+	ReasonInvalidArgument    = "INVALID_ARGUMENT"
+	ReasonUserNotFound       = "USER_NOT_FOUND"
+	ReasonInvalidCredentials = "INVALID_CREDENTIALS"
+	ReasonEmailTaken         = "EMAIL_TAKEN"
+	ReasonTokenExpired       = "TOKEN_EXPIRED"
+	ReasonTokenReused        = "TOKEN_REUSED"
+	ReasonTokenInvalid       = "TOKEN_INVALID"
+	ReasonUnauthenticated    = "UNAUTHENTICATED"
+	ReasonBindingMismatch    = "TOKEN_BINDING_MISMATCH"
+	ReasonInvalidDPoPProof   = "INVALID_DPOP_PROOF"
+	ReasonPermissionDenied   = "PERMISSION_DENIED"
+	ReasonClientNotFound     = "CLIENT_NOT_FOUND"
+	ReasonAPIKeyNotFound     = "API_KEY_NOT_FOUND"
+	ReasonAccountNotFound    = "SERVICE_ACCOUNT_NOT_FOUND"
+	ReasonAccountExists      = "SERVICE_ACCOUNT_EXISTS"
+	ReasonKeyNotFound        = "SERVICE_ACCOUNT_KEY_NOT_FOUND"
+	ReasonKeyExists          = "SERVICE_ACCOUNT_KEY_EXISTS"
+	ReasonUnknownProvider    = "UNKNOWN_IDENTITY_PROVIDER"
+	ReasonInvalidLoginState  = "INVALID_LOGIN_STATE"
+	ReasonEmailNotVerified   = "EMAIL_NOT_VERIFIED"
+	ReasonUpstreamFailed     = "IDENTITY_PROVIDER_FAILED"
+	ReasonInvalidAssertion   = "INVALID_SAML_RESPONSE"
+	ReasonUnknownRealm       = "UNKNOWN_REALM"
+	ReasonOrgNotFound        = "ORGANIZATION_NOT_FOUND"
+	ReasonMemberNotFound     = "MEMBER_NOT_FOUND"
+	ReasonMemberExists       = "MEMBER_EXISTS"
+	ReasonInvalidInvitation  = "INVALID_INVITATION"
+	ReasonLastOwner          = "LAST_OWNER"
+	ReasonAccountDisabled    = "ACCOUNT_DISABLED"
+	ReasonAccountLocked      = "ACCOUNT_LOCKED"
+	ReasonAccountPending     = "ACCOUNT_PENDING_VERIFICATION"
+	ReasonAccountDeleted     = "ACCOUNT_DELETED"
+	ReasonReauthRequired     = "REAUTHENTICATION_REQUIRED"
+	ReasonTokenRevoked       = "TOKEN_REVOKED"
+	ReasonLocalAccount       = "LOCAL_ACCOUNT_EXISTS"
+	ReasonSelfAction         = "SELF_ACTION_NOT_ALLOWED"
+	ReasonInternal           = "INTERNAL"
+)
+
+type mapping struct {
+	err    error
+	code   codes.Code
+	reason string
+}
+
+var mappings = []mapping{
+	{auth.ErrUserNotFound, codes.NotFound, ReasonUserNotFound},
+	{auth.ErrInvalidCredentials, codes.Unauthenticated, ReasonInvalidCredentials},
+	{auth.ErrEmailTaken, codes.AlreadyExists, ReasonEmailTaken},
+	{auth.ErrTokenExpired, codes.Unauthenticated, ReasonTokenExpired},
+	{auth.ErrTokenReused, codes.Unauthenticated, ReasonTokenReused},
+	{auth.ErrTokenInvalid, codes.Unauthenticated, ReasonTokenInvalid},
+	{auth.ErrUnauthenticated, codes.Unauthenticated, ReasonUnauthenticated},
+	{auth.ErrBindingMismatch, codes.Unauthenticated, ReasonBindingMismatch},
+	{auth.ErrInvalidDPoPProof, codes.Unauthenticated, ReasonInvalidDPoPProof},
+	{auth.ErrPermissionDenied, codes.PermissionDenied, ReasonPermissionDenied},
+	{auth.ErrAPIKeyNotFound, codes.NotFound, ReasonAPIKeyNotFound},
+	{auth.ErrAccountDisabled, codes.PermissionDenied, ReasonAccountDisabled},
+	{auth.ErrAccountLocked, codes.PermissionDenied, ReasonAccountLocked},
+	{auth.ErrAccountPending, codes.PermissionDenied, ReasonAccountPending},
+	{auth.ErrAccountDeleted, codes.PermissionDenied, ReasonAccountDeleted},
+	{auth.ErrTokenRevoked, codes.Unauthenticated, ReasonTokenRevoked},
+	{auth.ErrLocalAccount, codes.PermissionDenied, ReasonLocalAccount},
+	{oauth.ErrUnknownClient, codes.NotFound, ReasonClientNotFound},
+	{serviceaccount.ErrAccountNotFound, codes.NotFound, ReasonAccountNotFound},
+	{serviceaccount.ErrAccountExists, codes.AlreadyExists, ReasonAccountExists},
+	{serviceaccount.ErrKeyNotFound, codes.NotFound, ReasonKeyNotFound},
+	{serviceaccount.ErrKeyExists, codes.AlreadyExists, ReasonKeyExists},
+	{federation.ErrUnknownProvider, codes.NotFound, ReasonUnknownProvider},
+	{federation.ErrInvalidState, codes.InvalidArgument, ReasonInvalidLoginState},
+	{federation.ErrEmailNotVerified, codes.PermissionDenied, ReasonEmailNotVerified},
+	{federation.ErrUpstream, codes.Unavailable, ReasonUpstreamFailed},
+	{saml.ErrUnknownConnection, codes.NotFound, ReasonUnknownProvider},
+	{saml.ErrUnsolicited, codes.InvalidArgument, ReasonInvalidLoginState},
+	{saml.ErrInvalidResponse, codes.Unauthenticated, ReasonInvalidAssertion},
+	{realm.ErrUnknownRealm, codes.NotFound, ReasonUnknownRealm},
+	{organization.ErrOrganizationNotFound, codes.NotFound, ReasonOrgNotFound},
+	{organization.ErrMemberNotFound, codes.NotFound, ReasonMemberNotFound},
+	{organization.ErrMemberExists, codes.AlreadyExists, ReasonMemberExists},
+	{organization.ErrInvalidInvitation, codes.InvalidArgument, ReasonInvalidInvitation},
+	{organization.ErrLastOwner, codes.FailedPrecondition, ReasonLastOwner},
+	{admin.ErrSelfAction, codes.FailedPrecondition, ReasonSelfAction},
+	{account.ErrReauthenticationRequired, codes.Unauthenticated, ReasonReauthRequired},
+}
+
+// Status converts err into a gRPC status carrying ErrorInfo details.
+// Unknown errors become codes.Internal without the original message.
+func Status(err error) *status.Status {
+	if err == nil {
+		return status.New(codes.OK, "")
+	}
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+
+	var verr *auth.ValidationError
+	if errors.As(err, &verr) {
+		br := &errdetails.BadRequest{}
+		for _, v := range verr.Violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		return withDetails(codes.InvalidArgument, ReasonInvalidArgument, verr.Error(), br)
+	}
+
+	for _, m := range mappings {
+		if errors.Is(err, m.err) {
+			return withDetails(m.code, m.reason, m.err.Error())
+		}
+	}
+	return withDetails(codes.Internal, ReasonInternal, "internal error")
+}
+
+// Error is a shortcut for Status(err).Err()
+func Error(err error) error {
+	return Status(err).Err()
+}
+
+// HTTPStatus returns the HTTP status code for err
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return runtime.HTTPStatusFromCode(Status(err).Code())
+}
+
+// Reason extracts the ErrorInfo reason from a status, if any
+func Reason(st *status.Status) string {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func withDetails(code codes.Code, reason, msg string, extra ...protoadapt.MessageV1) *status.Status {
+	st := status.New(code, msg)
+	details := append([]protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: reason, Domain: Domain}}, extra...)
+	withInfo, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return withInfo
+}
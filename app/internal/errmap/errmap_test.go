@@ -0,0 +1,63 @@
+package errmap_test
+
+import (
+	"auth_service/internal/errmap"
+	"auth_service/internal/services/auth"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   codes.Code
+		http   int
+		reason string
+	}{
+		{auth.ErrInvalidCredentials, codes.Unauthenticated, http.StatusUnauthorized, errmap.ReasonInvalidCredentials},
+		{fmt.Errorf("register: %w", auth.ErrEmailTaken), codes.AlreadyExists, http.StatusConflict, errmap.ReasonEmailTaken},
+		{auth.ErrTokenReused, codes.Unauthenticated, http.StatusUnauthorized, errmap.ReasonTokenReused},
+		{errors.New("pq: connection refused"), codes.Internal, http.StatusInternalServerError, errmap.ReasonInternal},
+	}
+
+	for _, tt := range tests {
+		st := errmap.Status(tt.err)
+		if st.Code() != tt.code {
+			t.Errorf("%v: expected code %s, got %s", tt.err, tt.code, st.Code())
+		}
+		if got := errmap.HTTPStatus(tt.err); got != tt.http {
+			t.Errorf("%v: expected http %d, got %d", tt.err, tt.http, got)
+		}
+		if got := errmap.Reason(st); got != tt.reason {
+			t.Errorf("%v: expected reason %s, got %s", tt.err, tt.reason, got)
+		}
+	}
+
+	if st := errmap.Status(errors.New("pq: connection refused")); st.Message() != "internal error" {
+		t.Errorf("internal error message leaked: %s", st.Message())
+	}
+}
+
+func TestStatus_Validation(t *testing.T) {
+	verr := &auth.ValidationError{}
+	verr.Add("email", "invalid email")
+
+	st := errmap.Status(verr)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %s", st.Code())
+	}
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			if br.FieldViolations[0].Field != "email" {
+				t.Errorf("unexpected field %s", br.FieldViolations[0].Field)
+			}
+			return
+		}
+	}
+	t.Error("expected BadRequest details")
+}
@@ -0,0 +1,39 @@
+package grpccontroller
+
+import (
+	"auth_service/protos/gen/go/authservicegen"
+	"context"
+	"encoding/json"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *AuthGRPCServer) DeleteAccount(ctx context.Context, req *authservicegen.DeleteAccountRequest) (*authservicegen.DeleteAccountResponse, error) {
+	purgeAfter, err := s.Accounts.Delete(ctx)
+	if err != nil {
+		return nil, s.toStatus("DeleteAccount", err)
+	}
+	return &authservicegen.DeleteAccountResponse{PurgeAfter: timestamppb.New(purgeAfter)}, nil
+}
+
+func (s *AuthGRPCServer) ExportMyData(ctx context.Context, req *authservicegen.ExportMyDataRequest) (*authservicegen.ExportMyDataResponse, error) {
+	archive, err := s.Accounts.Export(ctx)
+	if err != nil {
+		return nil, s.toStatus("ExportMyData", err)
+	}
+	// the archive's JSON form is what clients get, over gRPC as well
+	raw, err := json.Marshal(archive)
+	if err != nil {
+		return nil, s.toStatus("ExportMyData", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, s.toStatus("ExportMyData", err)
+	}
+	doc, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, s.toStatus("ExportMyData", err)
+	}
+	return &authservicegen.ExportMyDataResponse{Archive: doc}, nil
+}
@@ -0,0 +1,174 @@
+package grpccontroller
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/services/admin"
+	"auth_service/protos/gen/go/authservicegen"
+	"cmp"
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type AdminGRPCServer struct {
+	authservicegen.UnimplementedAdminServiceServer
+	Admin  *admin.Service
+	Logger *slog.Logger
+}
+
+func NewAdminGRPCController(adminService *admin.Service, logger *slog.Logger) *AdminGRPCServer {
+	return &AdminGRPCServer{Admin: adminService, Logger: logger}
+}
+
+func (s *AdminGRPCServer) toStatus(method string, err error) error {
+	return statusError(s.Logger, method, err)
+}
+
+func (s *AdminGRPCServer) ListUsers(ctx context.Context, req *authservicegen.ListUsersRequest) (*authservicegen.ListUsersResponse, error) {
+	filter := models.UserFilter{
+		Query:      req.Query,
+		Status:     req.Status,
+		AdminsOnly: req.AdminsOnly,
+		Limit:      int(req.PageSize),
+	}
+	users, next, err := s.Admin.ListUsers(ctx, filter, req.PageToken)
+	if err != nil {
+		return nil, s.toStatus("ListUsers", err)
+	}
+	resp := &authservicegen.ListUsersResponse{Users: make([]*authservicegen.AdminUser, 0, len(users)), NextPageToken: next}
+	for _, user := range users {
+		resp.Users = append(resp.Users, adminUserMessage(user))
+	}
+	return resp, nil
+}
+
+func (s *AdminGRPCServer) GetUser(ctx context.Context, req *authservicegen.GetUserRequest) (*authservicegen.GetUserResponse, error) {
+	details, err := s.Admin.GetUser(ctx, int(req.UserId))
+	if err != nil {
+		return nil, s.toStatus("GetUser", err)
+	}
+	resp := &authservicegen.GetUserResponse{
+		User:     adminUserMessage(details.User),
+		Sessions: make([]*authservicegen.UserSession, 0, len(details.Sessions)),
+		Factors:  make([]*authservicegen.UserFactor, 0, len(details.Factors)),
+	}
+	for _, session := range details.Sessions {
+		resp.Sessions = append(resp.Sessions, &authservicegen.UserSession{
+			Id:             session.ID,
+			ClientId:       session.ClientID,
+			Scope:          session.Scope,
+			OrganizationId: session.OrgID,
+			DpopBound:      session.JKT != "",
+			CreatedAt:      optionalTimestamp(session.CreatedAt),
+			AuthTime:       optionalTimestamp(session.AuthTime),
+		})
+	}
+	for _, factor := range details.Factors {
+		resp.Factors = append(resp.Factors, &authservicegen.UserFactor{
+			Type:      factor.Type,
+			Provider:  factor.Provider,
+			Subject:   factor.Subject,
+			CreatedAt: optionalTimestamp(factor.CreatedAt),
+		})
+	}
+	return resp, nil
+}
+
+func (s *AdminGRPCServer) SetUserStatus(ctx context.Context, req *authservicegen.SetUserStatusRequest) (*authservicegen.StatusResponse, error) {
+	if err := s.Admin.SetStatus(ctx, int(req.UserId), req.Status, req.Reason); err != nil {
+		return nil, s.toStatus("SetUserStatus", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func (s *AdminGRPCServer) DisableUser(ctx context.Context, req *authservicegen.DisableUserRequest) (*authservicegen.StatusResponse, error) {
+	if err := s.Admin.Disable(ctx, int(req.UserId), req.Reason); err != nil {
+		return nil, s.toStatus("DisableUser", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func (s *AdminGRPCServer) EnableUser(ctx context.Context, req *authservicegen.EnableUserRequest) (*authservicegen.StatusResponse, error) {
+	if err := s.Admin.Enable(ctx, int(req.UserId)); err != nil {
+		return nil, s.toStatus("EnableUser", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func (s *AdminGRPCServer) ResetUserPassword(ctx context.Context, req *authservicegen.ResetUserPasswordRequest) (*authservicegen.ResetUserPasswordResponse, error) {
+	password, err := s.Admin.ResetPassword(ctx, int(req.UserId), req.Password)
+	if err != nil {
+		return nil, s.toStatus("ResetUserPassword", err)
+	}
+	return &authservicegen.ResetUserPasswordResponse{Password: password}, nil
+}
+
+func (s *AdminGRPCServer) RevokeUserSessions(ctx context.Context, req *authservicegen.RevokeUserSessionsRequest) (*authservicegen.RevokeUserSessionsResponse, error) {
+	revoked, err := s.Admin.RevokeSessions(ctx, int(req.UserId))
+	if err != nil {
+		return nil, s.toStatus("RevokeUserSessions", err)
+	}
+	return &authservicegen.RevokeUserSessionsResponse{Revoked: int32(revoked)}, nil
+}
+
+func (s *AdminGRPCServer) UpdateUserEmail(ctx context.Context, req *authservicegen.UpdateUserEmailRequest) (*authservicegen.StatusResponse, error) {
+	if err := s.Admin.UpdateEmail(ctx, int(req.UserId), req.Email); err != nil {
+		return nil, s.toStatus("UpdateUserEmail", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func (s *AdminGRPCServer) DeleteUser(ctx context.Context, req *authservicegen.DeleteUserRequest) (*authservicegen.StatusResponse, error) {
+	if err := s.Admin.Delete(ctx, int(req.UserId)); err != nil {
+		return nil, s.toStatus("DeleteUser", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func (s *AdminGRPCServer) ListAuditEvents(ctx context.Context, req *authservicegen.ListAuditEventsRequest) (*authservicegen.ListAuditEventsResponse, error) {
+	events, next, err := s.Admin.AuditEvents(ctx, int(req.UserId), int(req.PageSize), req.PageToken)
+	if err != nil {
+		return nil, s.toStatus("ListAuditEvents", err)
+	}
+	resp := &authservicegen.ListAuditEventsResponse{Events: make([]*authservicegen.AuditEvent, 0, len(events)), NextPageToken: next}
+	for _, event := range events {
+		resp.Events = append(resp.Events, &authservicegen.AuditEvent{
+			Id:        event.ID,
+			Actor:     event.Actor,
+			Action:    event.Action,
+			UserId:    int64(event.UserID),
+			Details:   event.Details,
+			CreatedAt: timestamppb.New(event.CreatedAt),
+		})
+	}
+	return resp, nil
+}
+
+func adminUserMessage(user models.User) *authservicegen.AdminUser {
+	msg := &authservicegen.AdminUser{
+		Id:              int64(user.UID),
+		Email:           user.Email,
+		EmailVerified:   user.EmailVerified,
+		Admin:           user.IsAdmin,
+		Roles:           user.Roles,
+		CreatedAt:       timestamppb.New(user.CreatedAt),
+		Status:          cmp.Or(user.Status, models.UserActive),
+		StatusReason:    user.StatusReason,
+		StatusChangedAt: optionalTimestamp(user.StatusChangedAt),
+		PurgeAfter:      optionalTimestamp(user.PurgeAfter),
+	}
+	if user.Status == models.UserDisabled {
+		msg.Disabled, msg.DisabledAt = true, msg.StatusChangedAt
+	}
+	return msg
+}
+
+// optionalTimestamp leaves zero times unset
+func optionalTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
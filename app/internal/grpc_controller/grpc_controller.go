@@ -1,45 +1,58 @@
 package grpccontroller
 
 import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/errmap"
 	"auth_service/internal/models"
+	"auth_service/internal/services/account"
 	"auth_service/internal/services/auth"
+	"auth_service/internal/services/oauth"
+	"auth_service/internal/services/organization"
+	"auth_service/internal/services/serviceaccount"
 	"auth_service/protos/gen/go/authservicegen"
 	"context"
 	"log/slog"
-	"net/mail"
-	"strings"
+	"time"
 
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type AuthGRPCServer struct {
 	authservicegen.UnimplementedAuthServiceServer
-	AuthService *auth.Auth
-	Logger      *slog.Logger
+	AuthService     *auth.Auth
+	OAuthService    *oauth.OAuth
+	ServiceAccounts *serviceaccount.Service
+	Organizations   *organization.Service
+	Accounts        *account.Service
+	Logger          *slog.Logger
 }
 
-func NewGRPCController(service *auth.Auth, logger *slog.Logger) *AuthGRPCServer {
-	return &AuthGRPCServer{AuthService: service, Logger: logger}
+func NewGRPCController(service *auth.Auth, oauthService *oauth.OAuth, serviceAccounts *serviceaccount.Service, organizations *organization.Service, accounts *account.Service, logger *slog.Logger) *AuthGRPCServer {
+	return &AuthGRPCServer{AuthService: service, OAuthService: oauthService, ServiceAccounts: serviceAccounts, Organizations: organizations, Accounts: accounts, Logger: logger}
 }
 
-func (s *AuthGRPCServer) Register(ctx context.Context, req *authservicegen.RegisterRequest) (*authservicegen.StatusResponse, error) {
-	if req.Email == "" || req.Password == "" {
-		return nil, status.Error(codes.InvalidArgument, "email or password missing")
-	}
-	if _, err := mail.ParseAddress(req.Email); err != nil {
-		return nil, status.Error(codes.InvalidArgument, "invalid email")
+// toStatus maps a service error and logs the ones that are not expected
+func (s *AuthGRPCServer) toStatus(method string, err error) error {
+	return statusError(s.Logger, method, err)
+}
+
+func statusError(logger *slog.Logger, method string, err error) error {
+	st := errmap.Status(err)
+	if st.Code() == codes.Internal {
+		logger.Error("Request failed", slog.String("method", method), slog.Any("error", err))
 	}
+	return st.Err()
+}
+
+func (s *AuthGRPCServer) Register(ctx context.Context, req *authservicegen.RegisterRequest) (*authservicegen.StatusResponse, error) {
 	user := models.NewUser{
 		Email:    req.Email,
 		HashPass: []byte(req.Password),
 	}
 
 	if err := s.AuthService.Register(ctx, user); err != nil {
-		if strings.Contains(err.Error(), "duplicate key") {
-			return nil, status.Error(codes.AlreadyExists, "user already exists")
-		}
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, s.toStatus("Register", err)
 	}
 
 	s.Logger.Info("User created", slog.String("email", req.Email))
@@ -47,42 +60,167 @@ func (s *AuthGRPCServer) Register(ctx context.Context, req *authservicegen.Regis
 }
 
 func (s *AuthGRPCServer) Login(ctx context.Context, req *authservicegen.LoginRequest) (*authservicegen.TokenPair, error) {
-	if req.Email == "" || req.Password == "" {
-		return nil, status.Error(codes.InvalidArgument, "email or password missing")
-	}
 	user := models.NewUser{
 		Email:    req.Email,
 		HashPass: []byte(req.Password),
 	}
 
-	tokens, err := s.AuthService.Login(ctx, user)
+	tokens, err := s.AuthService.Login(ctx, user, auth.BindingFromContext(ctx))
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate key") {
-			return nil, status.Error(codes.AlreadyExists, "user already exists")
-		}
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, s.toStatus("Login", err)
 	}
 	s.Logger.Debug("User logged in", slog.String("email", req.Email))
-	return &authservicegen.TokenPair{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+	return &authservicegen.TokenPair{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken, TokenType: tokens.TokenType}, nil
 }
 
 func (s *AuthGRPCServer) Refresh(ctx context.Context, req *authservicegen.RefreshRequest) (*authservicegen.TokenPair, error) {
-	if req.RefreshToken == "" {
-		return nil, status.Error(codes.Unauthenticated, "missing refresh token")
-	}
-
-	token, err := s.AuthService.Refresh(ctx, req.RefreshToken)
+	token, err := s.AuthService.Refresh(ctx, req.RefreshToken, auth.BindingFromContext(ctx))
 	if err != nil {
-		return nil, status.Error(codes.Unauthenticated, "refresh token expired")
+		return nil, s.toStatus("Refresh", err)
 	}
 	s.Logger.Debug("Token refreshed", slog.String("prev_token", req.RefreshToken))
-	return &authservicegen.TokenPair{AccessToken: token.AccessToken, RefreshToken: token.RefreshToken}, nil
+	return &authservicegen.TokenPair{AccessToken: token.AccessToken, RefreshToken: token.RefreshToken, TokenType: token.TokenType}, nil
 }
 
 func (s *AuthGRPCServer) Logout(ctx context.Context, req *authservicegen.LogoutRequest) (*authservicegen.StatusResponse, error) {
 	if err := s.AuthService.Logout(ctx, req.RefreshToken); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, s.toStatus("Logout", err)
 	}
 	s.Logger.Debug("User logout", slog.String("token", req.RefreshToken))
 	return &authservicegen.StatusResponse{Status: "ok"}, nil
 }
+
+func (s *AuthGRPCServer) Introspect(ctx context.Context, req *authservicegen.IntrospectRequest) (*authservicegen.IntrospectResponse, error) {
+	claims, err := s.AuthService.VerifyAccessToken(ctx, auth.PresentedToken{Token: req.Token, Binding: auth.BindingFromContext(ctx)})
+	if err != nil {
+		s.Logger.Debug("Inactive token introspected", slog.Any("reason", err))
+		return &authservicegen.IntrospectResponse{Active: false}, nil
+	}
+
+	resp := &authservicegen.IntrospectResponse{
+		Active:   true,
+		Sub:      claims.SubjectID(),
+		Exp:      claims.ExpiresAt.Unix(),
+		Iat:      claims.IssuedAt.Unix(),
+		Jti:      claims.ID,
+		Scope:    claims.Scope,
+		ClientId: claims.ClientID,
+		Aud:      claims.Audience,
+		Act:      actorMessage(claims.Act),
+		Roles:    claims.Roles,
+		OrgId:    claims.OrgID,
+		OrgRole:  claims.OrgRole,
+	}
+	if claims.Confirmation != nil {
+		resp.Cnf = &authservicegen.Confirmation{X5TS256: claims.Confirmation.X5tS256, Jkt: claims.Confirmation.JKT}
+	}
+	return resp, nil
+}
+
+func actorMessage(act *jwtman.Actor) *authservicegen.Actor {
+	if act == nil {
+		return nil
+	}
+	return &authservicegen.Actor{Sub: act.Subject, ClientId: act.ClientID, Act: actorMessage(act.Act)}
+}
+
+func (s *AuthGRPCServer) RegisterClient(ctx context.Context, req *authservicegen.RegisterClientRequest) (*authservicegen.RegisterClientResponse, error) {
+	client, secret, err := s.OAuthService.RegisterClient(ctx, models.OAuthClient{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectUris,
+		Scopes:       req.Scopes,
+		GrantTypes:   req.GrantTypes,
+		Public:       req.Public,
+		Exchange: models.ExchangePolicy{
+			Audiences:     req.GetExchangePolicy().GetAudiences(),
+			Impersonation: req.GetExchangePolicy().GetImpersonation(),
+		},
+	})
+	if err != nil {
+		return nil, s.toStatus("RegisterClient", err)
+	}
+	return &authservicegen.RegisterClientResponse{
+		Client: &authservicegen.OAuthClient{
+			ClientId:     client.ClientID,
+			Name:         client.Name,
+			RedirectUris: client.RedirectURIs,
+			Scopes:       client.Scopes,
+			Public:       client.Public,
+			GrantTypes:   client.GrantTypes,
+			ExchangePolicy: &authservicegen.ExchangePolicy{
+				Audiences:     client.Exchange.Audiences,
+				Impersonation: client.Exchange.Impersonation,
+			},
+		},
+		ClientSecret: secret,
+	}, nil
+}
+
+func (s *AuthGRPCServer) RotateClientSecret(ctx context.Context, req *authservicegen.RotateClientSecretRequest) (*authservicegen.RotateClientSecretResponse, error) {
+	secret, previousExpiresAt, err := s.OAuthService.RotateClientSecret(ctx, req.ClientId, req.GracePeriod.AsDuration())
+	if err != nil {
+		return nil, s.toStatus("RotateClientSecret", err)
+	}
+	return &authservicegen.RotateClientSecretResponse{
+		ClientSecret:            secret,
+		PreviousSecretExpiresAt: timestamppb.New(previousExpiresAt),
+	}, nil
+}
+
+func (s *AuthGRPCServer) CreateAPIKey(ctx context.Context, req *authservicegen.CreateAPIKeyRequest) (*authservicegen.CreateAPIKeyResponse, error) {
+	uid, err := s.AuthService.RequireUser(ctx)
+	if err != nil {
+		return nil, s.toStatus("CreateAPIKey", err)
+	}
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt.AsTime()
+	}
+	key, secret, err := s.AuthService.CreateAPIKey(ctx, uid, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		return nil, s.toStatus("CreateAPIKey", err)
+	}
+	return &authservicegen.CreateAPIKeyResponse{ApiKey: apiKeyMessage(key), Key: secret}, nil
+}
+
+func (s *AuthGRPCServer) ListAPIKeys(ctx context.Context, req *authservicegen.ListAPIKeysRequest) (*authservicegen.ListAPIKeysResponse, error) {
+	uid, err := s.AuthService.RequireUser(ctx)
+	if err != nil {
+		return nil, s.toStatus("ListAPIKeys", err)
+	}
+	keys, err := s.AuthService.ListAPIKeys(ctx, uid)
+	if err != nil {
+		return nil, s.toStatus("ListAPIKeys", err)
+	}
+	resp := &authservicegen.ListAPIKeysResponse{ApiKeys: make([]*authservicegen.APIKey, 0, len(keys))}
+	for _, key := range keys {
+		resp.ApiKeys = append(resp.ApiKeys, apiKeyMessage(key))
+	}
+	return resp, nil
+}
+
+func (s *AuthGRPCServer) RevokeAPIKey(ctx context.Context, req *authservicegen.RevokeAPIKeyRequest) (*authservicegen.StatusResponse, error) {
+	uid, err := s.AuthService.RequireUser(ctx)
+	if err != nil {
+		return nil, s.toStatus("RevokeAPIKey", err)
+	}
+	if err := s.AuthService.RevokeAPIKey(ctx, uid, req.Id); err != nil {
+		return nil, s.toStatus("RevokeAPIKey", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func apiKeyMessage(key models.APIKey) *authservicegen.APIKey {
+	msg := &authservicegen.APIKey{
+		Id:        key.ID,
+		Name:      key.Name,
+		Hint:      key.Hint,
+		Scopes:    key.Scopes,
+		ExpiresAt: timestamppb.New(key.ExpiresAt),
+		CreatedAt: timestamppb.New(key.CreatedAt),
+	}
+	if !key.LastUsedAt.IsZero() {
+		msg.LastUsedAt = timestamppb.New(key.LastUsedAt)
+	}
+	return msg
+}
@@ -0,0 +1,95 @@
+package grpccontroller
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/protos/gen/go/authservicegen"
+	"context"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *AuthGRPCServer) CreateOrganization(ctx context.Context, req *authservicegen.CreateOrganizationRequest) (*authservicegen.Organization, error) {
+	org, err := s.Organizations.Create(ctx, req.Name)
+	if err != nil {
+		return nil, s.toStatus("CreateOrganization", err)
+	}
+	return &authservicegen.Organization{
+		Id:        org.ID,
+		Name:      org.Name,
+		Role:      models.OrgRoleOwner,
+		CreatedAt: timestamppb.New(org.CreatedAt),
+	}, nil
+}
+
+func (s *AuthGRPCServer) ListOrganizations(ctx context.Context, req *authservicegen.ListOrganizationsRequest) (*authservicegen.ListOrganizationsResponse, error) {
+	memberships, err := s.Organizations.List(ctx)
+	if err != nil {
+		return nil, s.toStatus("ListOrganizations", err)
+	}
+	resp := &authservicegen.ListOrganizationsResponse{Organizations: make([]*authservicegen.Organization, 0, len(memberships))}
+	for _, m := range memberships {
+		resp.Organizations = append(resp.Organizations, &authservicegen.Organization{
+			Id:        m.OrgID,
+			Name:      m.OrgName,
+			Role:      m.Role,
+			CreatedAt: timestamppb.New(m.CreatedAt),
+		})
+	}
+	return resp, nil
+}
+
+func (s *AuthGRPCServer) InviteMember(ctx context.Context, req *authservicegen.InviteMemberRequest) (*authservicegen.InviteMemberResponse, error) {
+	token, expiresAt, err := s.Organizations.Invite(ctx, req.OrganizationId, req.Email, req.Role)
+	if err != nil {
+		return nil, s.toStatus("InviteMember", err)
+	}
+	return &authservicegen.InviteMemberResponse{InviteToken: token, ExpiresAt: timestamppb.New(expiresAt)}, nil
+}
+
+func (s *AuthGRPCServer) AcceptInvitation(ctx context.Context, req *authservicegen.AcceptInvitationRequest) (*authservicegen.TokenPair, error) {
+	tokens, err := s.Organizations.Accept(ctx, req.InviteToken, req.Password, auth.BindingFromContext(ctx))
+	if err != nil {
+		return nil, s.toStatus("AcceptInvitation", err)
+	}
+	return &authservicegen.TokenPair{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken, TokenType: tokens.TokenType}, nil
+}
+
+func (s *AuthGRPCServer) ListMembers(ctx context.Context, req *authservicegen.ListMembersRequest) (*authservicegen.ListMembersResponse, error) {
+	members, err := s.Organizations.Members(ctx, req.OrganizationId)
+	if err != nil {
+		return nil, s.toStatus("ListMembers", err)
+	}
+	resp := &authservicegen.ListMembersResponse{Members: make([]*authservicegen.Member, 0, len(members))}
+	for _, m := range members {
+		resp.Members = append(resp.Members, &authservicegen.Member{
+			UserId:   int64(m.UserID),
+			Email:    m.Email,
+			Role:     m.Role,
+			JoinedAt: timestamppb.New(m.CreatedAt),
+		})
+	}
+	return resp, nil
+}
+
+func (s *AuthGRPCServer) UpdateMemberRole(ctx context.Context, req *authservicegen.UpdateMemberRoleRequest) (*authservicegen.StatusResponse, error) {
+	if err := s.Organizations.UpdateRole(ctx, req.OrganizationId, int(req.UserId), req.Role); err != nil {
+		return nil, s.toStatus("UpdateMemberRole", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func (s *AuthGRPCServer) RemoveMember(ctx context.Context, req *authservicegen.RemoveMemberRequest) (*authservicegen.StatusResponse, error) {
+	if err := s.Organizations.Remove(ctx, req.OrganizationId, int(req.UserId)); err != nil {
+		return nil, s.toStatus("RemoveMember", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func (s *AuthGRPCServer) SwitchOrganization(ctx context.Context, req *authservicegen.SwitchOrganizationRequest) (*authservicegen.TokenPair, error) {
+	tokens, err := s.Organizations.Switch(ctx, req.OrganizationId, auth.BindingFromContext(ctx))
+	if err != nil {
+		return nil, s.toStatus("SwitchOrganization", err)
+	}
+	return &authservicegen.TokenPair{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken, TokenType: tokens.TokenType}, nil
+}
@@ -0,0 +1,85 @@
+package grpccontroller
+
+import (
+	"auth_service/internal/models"
+	"auth_service/protos/gen/go/authservicegen"
+	"context"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *AuthGRPCServer) CreateServiceAccount(ctx context.Context, req *authservicegen.CreateServiceAccountRequest) (*authservicegen.ServiceAccount, error) {
+	account, err := s.ServiceAccounts.Create(ctx, models.ServiceAccount{
+		Name:        req.Name,
+		Description: req.Description,
+		Owner:       req.Owner,
+		Roles:       req.Roles,
+	})
+	if err != nil {
+		return nil, s.toStatus("CreateServiceAccount", err)
+	}
+	return serviceAccountMessage(account), nil
+}
+
+func (s *AuthGRPCServer) ListServiceAccounts(ctx context.Context, req *authservicegen.ListServiceAccountsRequest) (*authservicegen.ListServiceAccountsResponse, error) {
+	accounts, err := s.ServiceAccounts.List(ctx)
+	if err != nil {
+		return nil, s.toStatus("ListServiceAccounts", err)
+	}
+	resp := &authservicegen.ListServiceAccountsResponse{ServiceAccounts: make([]*authservicegen.ServiceAccount, 0, len(accounts))}
+	for _, account := range accounts {
+		resp.ServiceAccounts = append(resp.ServiceAccounts, serviceAccountMessage(account))
+	}
+	return resp, nil
+}
+
+func (s *AuthGRPCServer) DeleteServiceAccount(ctx context.Context, req *authservicegen.DeleteServiceAccountRequest) (*authservicegen.StatusResponse, error) {
+	if err := s.ServiceAccounts.Delete(ctx, req.Id); err != nil {
+		return nil, s.toStatus("DeleteServiceAccount", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func (s *AuthGRPCServer) AddServiceAccountKey(ctx context.Context, req *authservicegen.AddServiceAccountKeyRequest) (*authservicegen.AddServiceAccountKeyResponse, error) {
+	key, err := s.ServiceAccounts.AddKey(ctx, req.ServiceAccountId, []byte(req.PublicKey))
+	if err != nil {
+		return nil, s.toStatus("AddServiceAccountKey", err)
+	}
+	return &authservicegen.AddServiceAccountKeyResponse{KeyId: key.ID}, nil
+}
+
+func (s *AuthGRPCServer) RemoveServiceAccountKey(ctx context.Context, req *authservicegen.RemoveServiceAccountKeyRequest) (*authservicegen.StatusResponse, error) {
+	if err := s.ServiceAccounts.RemoveKey(ctx, req.ServiceAccountId, req.KeyId); err != nil {
+		return nil, s.toStatus("RemoveServiceAccountKey", err)
+	}
+	return &authservicegen.StatusResponse{Status: "ok"}, nil
+}
+
+func (s *AuthGRPCServer) ListServiceAccountEvents(ctx context.Context, req *authservicegen.ListServiceAccountEventsRequest) (*authservicegen.ListServiceAccountEventsResponse, error) {
+	events, err := s.ServiceAccounts.Events(ctx, req.ServiceAccountId)
+	if err != nil {
+		return nil, s.toStatus("ListServiceAccountEvents", err)
+	}
+	resp := &authservicegen.ListServiceAccountEventsResponse{Events: make([]*authservicegen.ServiceAccountEvent, 0, len(events))}
+	for _, event := range events {
+		resp.Events = append(resp.Events, &authservicegen.ServiceAccountEvent{
+			Action:    event.Action,
+			Actor:     event.Actor,
+			KeyId:     event.KeyID,
+			CreatedAt: timestamppb.New(event.CreatedAt),
+		})
+	}
+	return resp, nil
+}
+
+func serviceAccountMessage(account models.ServiceAccount) *authservicegen.ServiceAccount {
+	return &authservicegen.ServiceAccount{
+		Id:          account.ID,
+		Name:        account.Name,
+		Description: account.Description,
+		Owner:       account.Owner,
+		Roles:       account.Roles,
+		KeyIds:      account.KeyIDs,
+		CreatedAt:   timestamppb.New(account.CreatedAt),
+	}
+}
@@ -1,8 +1,151 @@
 package health
 
-import "net/http"
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
-func HealthCheck(w http.ResponseWriter, r *http.Request) {
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Probe checks a single dependency
+type Probe struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// DependencyStatus is "ok" or "down". /readyz is public, so probe errors,
+// which name addresses and drivers, are only logged.
+type DependencyStatus struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+}
+
+type Report struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Health serves liveness and readiness over HTTP and keeps the
+// grpc.health.v1 serving status in sync with dependency probes
+type Health struct {
+	Logger   *slog.Logger
+	GRPC     *health.Server
+	Services []string
+	Timeout  time.Duration
+	probes   []Probe
+	stopping atomic.Bool
+}
+
+func NewHealth(logger *slog.Logger, timeout time.Duration, services []string, probes ...Probe) *Health {
+	h := &Health{
+		Logger:   logger,
+		GRPC:     health.NewServer(),
+		Services: services,
+		Timeout:  timeout,
+		probes:   probes,
+	}
+	h.setServing(healthpb.HealthCheckResponse_NOT_SERVING)
+	return h
+}
+
+// Check runs every probe concurrently, each bounded by the timeout
+func (h *Health) Check(ctx context.Context) Report {
+	report := Report{Status: "ok", Dependencies: make(map[string]DependencyStatus, len(h.probes))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range h.probes {
+		wg.Add(1)
+		go func(p Probe) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := p.Check(ctx)
+			st := DependencyStatus{Status: "ok", Latency: time.Since(start).String()}
+			if err != nil {
+				st.Status = "down"
+				h.Logger.Warn("Dependency check failed", slog.String("dependency", p.Name), slog.Any("error", err))
+			}
+
+			mu.Lock()
+			report.Dependencies[p.Name] = st
+			if err != nil {
+				report.Status = "fail"
+			}
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+
+	if h.stopping.Load() {
+		report.Status = "shutting_down"
+	}
+	return report
+}
+
+// Run probes dependencies every interval and updates the gRPC serving status
+func (h *Health) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		h.update(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Health) update(ctx context.Context) {
+	if h.stopping.Load() {
+		return
+	}
+	report := h.Check(ctx)
+	if report.Status == "ok" {
+		h.setServing(healthpb.HealthCheckResponse_SERVING)
+		return
+	}
+	h.Logger.Warn("Readiness check failed", slog.Any("dependencies", report.Dependencies))
+	h.setServing(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Shutdown marks the service as not serving so load balancers stop routing to it
+func (h *Health) Shutdown() {
+	h.stopping.Store(true)
+	h.GRPC.Shutdown()
+}
+
+func (h *Health) setServing(st healthpb.HealthCheckResponse_ServingStatus) {
+	h.GRPC.SetServingStatus("", st)
+	for _, svc := range h.Services {
+		h.GRPC.SetServingStatus(svc, st)
+	}
+}
+
+// Livez reports that the process is up
+func (h *Health) Livez(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
+
+// Readyz reports per dependency status as JSON
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := h.Check(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		h.Logger.Error("Failed to write readiness report", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,81 @@
+package health_test
+
+import (
+	"auth_service/internal/health"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealth_Readyz(t *testing.T) {
+	h := health.NewHealth(slog.Default(), time.Second, nil,
+		health.Probe{Name: "postgres", Check: func(ctx context.Context) error { return nil }},
+		health.Probe{Name: "redis", Check: func(ctx context.Context) error { return errors.New("connection refused") }},
+	)
+
+	rec := httptest.NewRecorder()
+	h.Readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	var report health.Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if report.Status != "fail" || report.Dependencies["postgres"].Status != "ok" || report.Dependencies["redis"].Status != "down" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if strings.Contains(rec.Body.String(), "connection refused") {
+		t.Errorf("probe error leaked: %s", rec.Body.String())
+	}
+}
+
+func TestHealth_ProbeTimeout(t *testing.T) {
+	h := health.NewHealth(slog.Default(), 10*time.Millisecond, nil,
+		health.Probe{Name: "postgres", Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	)
+
+	if report := h.Check(context.Background()); report.Status != "fail" {
+		t.Errorf("expected hanging probe to fail, got %+v", report)
+	}
+}
+
+func TestHealth_Shutdown(t *testing.T) {
+	const svc = "auth_service.AuthService"
+	h := health.NewHealth(slog.Default(), time.Second, []string{svc},
+		health.Probe{Name: "postgres", Check: func(ctx context.Context) error { return nil }},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Run(ctx, time.Hour)
+
+	waitStatus := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			resp, err := h.GRPC.Check(ctx, &healthpb.HealthCheckRequest{Service: svc})
+			if err == nil && resp.Status == want {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("status did not become %s", want)
+	}
+
+	waitStatus(healthpb.HealthCheckResponse_SERVING)
+	h.Shutdown()
+	waitStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
@@ -0,0 +1,103 @@
+// Package inprocess implements grpc.ClientConnInterface by calling registered
+// service implementations directly. The HTTP gateway uses it so REST calls
+// go through the same unary interceptor chain as gRPC calls.
+package inprocess
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type method struct {
+	impl    any
+	handler grpc.MethodHandler
+}
+
+type Channel struct {
+	Interceptor grpc.UnaryServerInterceptor
+	methods     map[string]method
+}
+
+func NewChannel(interceptor grpc.UnaryServerInterceptor) *Channel {
+	return &Channel{Interceptor: interceptor, methods: make(map[string]method)}
+}
+
+// RegisterService implements grpc.ServiceRegistrar
+func (c *Channel) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	for _, m := range desc.Methods {
+		c.methods["/"+desc.ServiceName+"/"+m.MethodName] = method{impl: impl, handler: m.Handler}
+	}
+}
+
+// Invoke implements grpc.ClientConnInterface
+func (c *Channel) Invoke(ctx context.Context, fullMethod string, args, reply any, opts ...grpc.CallOption) error {
+	m, ok := c.methods[fullMethod]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "method %s not registered", fullMethod)
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	ctx = metadata.NewIncomingContext(ctx, md.Copy())
+	stream := &transportStream{method: fullMethod}
+	ctx = grpc.NewContextWithServerTransportStream(ctx, stream)
+
+	dec := func(v any) error {
+		proto.Merge(v.(proto.Message), args.(proto.Message))
+		return nil
+	}
+	resp, err := m.handler(m.impl, ctx, dec, c.Interceptor)
+
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = stream.header
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = stream.trailer
+		}
+	}
+	if err != nil {
+		return err
+	}
+	proto.Merge(reply.(proto.Message), resp.(proto.Message))
+	return nil
+}
+
+// NewStream implements grpc.ClientConnInterface. Streaming is not supported.
+func (c *Channel) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Errorf(codes.Unimplemented, "streaming is not supported in process")
+}
+
+type transportStream struct {
+	mu      sync.Mutex
+	method  string
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *transportStream) Method() string {
+	return s.method
+}
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *transportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
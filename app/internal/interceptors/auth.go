@@ -0,0 +1,90 @@
+package interceptors
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/dpop"
+	"auth_service/internal/errmap"
+	"auth_service/internal/services/auth"
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Authenticator verifies access tokens presented to protected methods
+type Authenticator interface {
+	VerifyAccessToken(ctx context.Context, presented auth.PresentedToken) (*jwtman.Claims, error)
+}
+
+func isPublic(method string, public []string) bool {
+	for _, p := range public {
+		if method == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(method, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessToken reads a "Bearer" or "DPoP" authorization header
+func accessToken(ctx context.Context) (scheme, token string) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", ""
+	}
+	for _, v := range md.Get("authorization") {
+		scheme, token, ok := strings.Cut(v, " ")
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.EqualFold(scheme, "bearer"):
+			return "Bearer", token
+		case strings.EqualFold(scheme, dpop.Scheme):
+			return dpop.Scheme, token
+		}
+	}
+	return "", ""
+}
+
+func authenticate(ctx context.Context, a Authenticator, method string, public []string) (context.Context, error) {
+	if isPublic(method, public) {
+		return ctx, nil
+	}
+	scheme, token := accessToken(ctx)
+	claims, err := a.VerifyAccessToken(ctx, auth.PresentedToken{
+		Token:   token,
+		Scheme:  scheme,
+		Binding: auth.BindingFromContext(ctx),
+	})
+	if err != nil {
+		return nil, errmap.Error(err)
+	}
+	// exchanged tokens restricted to other services can't call this one
+	if len(claims.Audience) > 0 {
+		return nil, errmap.Error(auth.ErrTokenInvalid)
+	}
+	return jwtman.NewContext(ctx, claims), nil
+}
+
+// UnaryAuth requires a valid bearer token for every method not listed in
+// public. Entries ending with "/" match a whole service.
+func UnaryAuth(a Authenticator, public []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticate(ctx, a, info.FullMethod, public)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamAuth(a Authenticator, public []string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), a, info.FullMethod, public)
+		if err != nil {
+			return err
+		}
+		return handler(srv, wrapStream(ss, ctx))
+	}
+}
@@ -0,0 +1,29 @@
+package interceptors
+
+import (
+	"auth_service/internal/clientcert"
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+func withClientCert(ctx context.Context) context.Context {
+	if id := clientcert.FromPeer(ctx); id != nil {
+		return clientcert.NewContext(ctx, id)
+	}
+	return ctx
+}
+
+// UnaryClientCert makes the verified client certificate available through
+// clientcert.FromContext
+func UnaryClientCert() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(withClientCert(ctx), req)
+	}
+}
+
+func StreamClientCert() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, wrapStream(ss, withClientCert(ss.Context())))
+	}
+}
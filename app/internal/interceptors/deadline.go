@@ -0,0 +1,41 @@
+package interceptors
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// withDeadline applies def when the caller sent no deadline and caps it at max
+func withDeadline(ctx context.Context, def, max time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+
+	var timeout time.Duration
+	if !ok {
+		timeout = def
+	}
+	if max > 0 && (timeout > max || (!ok && timeout == 0) || (ok && time.Until(deadline) > max)) {
+		timeout = max
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func UnaryDeadline(def, max time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, cancel := withDeadline(ctx, def, max)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+func StreamDeadline(def, max time.Duration) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := withDeadline(ss.Context(), def, max)
+		defer cancel()
+		return handler(srv, wrapStream(ss, ctx))
+	}
+}
@@ -0,0 +1,103 @@
+// Package interceptors contains the gRPC server middleware chain. Every
+// interceptor has a unary and a stream variant so streaming handlers get the
+// same treatment as unary ones.
+package interceptors
+
+import (
+	"auth_service/internal/realm"
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+type Config struct {
+	Recovery       bool
+	RequestID      bool
+	AccessLog      bool
+	DefaultTimeout time.Duration
+	MaxTimeout     time.Duration
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+	Authenticator  Authenticator
+	PublicMethods  []string
+	// Realms resolves the realm of every call before it is authenticated
+	Realms *realm.Registry
+}
+
+// Chain returns the configured unary and stream interceptors in call order
+func Chain(cfg Config, logger *slog.Logger) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	unary := []grpc.UnaryServerInterceptor{UnaryClientCert()}
+	stream := []grpc.StreamServerInterceptor{StreamClientCert()}
+
+	if cfg.RequestID {
+		unary = append(unary, UnaryRequestID())
+		stream = append(stream, StreamRequestID())
+	}
+	if cfg.AccessLog {
+		unary = append(unary, UnaryAccessLog(logger))
+		stream = append(stream, StreamAccessLog(logger))
+	}
+	if cfg.Recovery {
+		unary = append(unary, UnaryRecovery(logger))
+		stream = append(stream, StreamRecovery(logger))
+	}
+	if cfg.DefaultTimeout > 0 || cfg.MaxTimeout > 0 {
+		unary = append(unary, UnaryDeadline(cfg.DefaultTimeout, cfg.MaxTimeout))
+		stream = append(stream, StreamDeadline(cfg.DefaultTimeout, cfg.MaxTimeout))
+	}
+	if cfg.Realms != nil {
+		unary = append(unary, UnaryRealm(cfg.Realms))
+		stream = append(stream, StreamRealm(cfg.Realms))
+	}
+	if cfg.Authenticator != nil {
+		unary = append(unary, UnaryAuth(cfg.Authenticator, cfg.PublicMethods))
+		stream = append(stream, StreamAuth(cfg.Authenticator, cfg.PublicMethods))
+	}
+	return unary, stream
+}
+
+// ServerOptions builds the interceptor chain and message size limits from cfg
+func ServerOptions(cfg Config, logger *slog.Logger) []grpc.ServerOption {
+	unary, stream := Chain(cfg, logger)
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+	if cfg.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(cfg.MaxSendMsgSize))
+	}
+	return opts
+}
+
+// ChainUnary combines interceptors into one, the first being the outermost
+func ChainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// wrappedStream lets stream interceptors replace the stream context
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
+
+func wrapStream(ss grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	return &wrappedStream{ServerStream: ss, ctx: ctx}
+}
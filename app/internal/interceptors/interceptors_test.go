@@ -0,0 +1,131 @@
+package interceptors_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/interceptors"
+	"auth_service/internal/services/auth"
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var info = &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+
+func TestUnaryRecovery(t *testing.T) {
+	interceptor := interceptors.UnaryRecovery(slog.Default())
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal, got %v", err)
+	}
+}
+
+func TestUnaryDeadline(t *testing.T) {
+	interceptor := interceptors.UnaryDeadline(time.Second, 5*time.Second)
+
+	check := func(ctx context.Context, max time.Duration) {
+		t.Helper()
+		_, _ = interceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("expected deadline")
+			}
+			if left := time.Until(deadline); left > max {
+				t.Errorf("expected deadline within %s, got %s", max, left)
+			}
+			return nil, nil
+		})
+	}
+
+	check(context.Background(), time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	check(ctx, 5*time.Second)
+}
+
+func TestServerOptions_RequestID(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(interceptors.ServerOptions(interceptors.Config{
+		Recovery:  true,
+		RequestID: true,
+		AccessLog: true,
+	}, slog.Default())...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), interceptors.RequestIDHeader, "req-123")
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header)); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if got := header.Get(interceptors.RequestIDHeader); len(got) != 1 || got[0] != "req-123" {
+		t.Errorf("expected request id to be propagated, got %v", got)
+	}
+
+	header = nil
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Header(&header)); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if got := header.Get(interceptors.RequestIDHeader); len(got) != 1 || got[0] == "" {
+		t.Errorf("expected generated request id, got %v", got)
+	}
+}
+
+type MockAuthenticator struct{}
+
+func (m MockAuthenticator) VerifyAccessToken(ctx context.Context, presented auth.PresentedToken) (*jwtman.Claims, error) {
+	if presented.Token != "valid" {
+		return nil, auth.ErrTokenInvalid
+	}
+	return &jwtman.Claims{UserID: "1"}, nil
+}
+
+func TestUnaryAuth(t *testing.T) {
+	interceptor := interceptors.UnaryAuth(MockAuthenticator{}, []string{"/test.Public/"})
+	handler := func(ctx context.Context, req any) (any, error) {
+		claims, _ := jwtman.FromContext(ctx)
+		return claims, nil
+	}
+
+	if _, err := interceptor(context.Background(), nil, info, handler); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without token, got %v", err)
+	}
+
+	publicInfo := &grpc.UnaryServerInfo{FullMethod: "/test.Public/Method"}
+	if _, err := interceptor(context.Background(), nil, publicInfo, handler); err != nil {
+		t.Errorf("expected public method to pass, got %v", err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer valid"))
+	resp, err := interceptor(ctx, nil, info, handler)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if claims, ok := resp.(*jwtman.Claims); !ok || claims.UserID != "1" {
+		t.Errorf("expected claims in context, got %v", resp)
+	}
+}
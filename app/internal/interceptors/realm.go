@@ -0,0 +1,53 @@
+package interceptors
+
+import (
+	"auth_service/internal/errmap"
+	"auth_service/internal/realm"
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// withRealm resolves the realm from the x-realm metadata or the authority.
+// Calls from the HTTP gateway already carry the realm of the HTTP request.
+func withRealm(ctx context.Context, registry *realm.Registry) (context.Context, error) {
+	if realm.FromContext(ctx) != nil {
+		return ctx, nil
+	}
+	var name, host string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(realm.MetadataKey); len(v) > 0 {
+			name = v[0]
+		}
+		if v := md.Get(":authority"); len(v) > 0 {
+			host = v[0]
+		}
+	}
+	r, err := registry.Resolve(name, host)
+	if err != nil {
+		return nil, errmap.Error(err)
+	}
+	return realm.NewContext(ctx, r), nil
+}
+
+// UnaryRealm makes the realm of the call available through realm.FromContext
+func UnaryRealm(registry *realm.Registry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := withRealm(ctx, registry)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamRealm(registry *realm.Registry) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := withRealm(ss.Context(), registry)
+		if err != nil {
+			return err
+		}
+		return handler(srv, wrapStream(ss, ctx))
+	}
+}
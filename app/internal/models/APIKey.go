@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// APIKey is a personal access token of a user. Only a hash of the secret is
+// stored; Hint is its start, to recognize the key in listings.
+type APIKey struct {
+	ID        string
+	UserID    int
+	Name      string
+	Hash      []byte
+	Hint      string
+	Scopes    []string
+	ExpiresAt time.Time
+	// LastUsedAt is zero for keys that were never used
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LinkedIdentity maps an account at an upstream identity provider to a user
+type LinkedIdentity struct {
+	Provider string
+	// Subject is the sub claim of the provider's ID tokens
+	Subject   string
+	UserID    int
+	Email     string
+	CreatedAt time.Time
+}
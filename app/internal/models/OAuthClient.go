@@ -0,0 +1,40 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// OAuthClient is an application registered to use the OAuth endpoints
+type OAuthClient struct {
+	ClientID     string
+	Name         string
+	SecretHash   []byte
+	RedirectURIs []string
+	Scopes       []string
+	GrantTypes   []string
+	// Public clients cannot keep a secret and authenticate with PKCE only
+	Public bool
+	// PreviousSecretHash stays valid until PreviousSecretExpiresAt after a
+	// secret rotation, so deployments can roll over to the new secret
+	PreviousSecretHash      []byte
+	PreviousSecretExpiresAt time.Time
+	// Exchange limits the tokens the client can get with token exchange
+	Exchange  ExchangePolicy
+	CreatedAt time.Time
+}
+
+// ExchangePolicy is what a client may request with token exchange
+type ExchangePolicy struct {
+	// Audiences the client may request tokens for
+	Audiences []string
+	// Impersonation lets the client exchange a token without an actor token
+	// and without becoming the actor itself, so the issued token has no new
+	// act claim
+	Impersonation bool
+}
+
+// AllowsGrant reports whether the client may use the grant type
+func (c OAuthClient) AllowsGrant(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
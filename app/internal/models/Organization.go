@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Organization groups users of a customer
+type Organization struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// Roles of organization members, from most to least privileged
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// Membership is the role of a user in an organization
+type Membership struct {
+	OrgID string
+	// OrgName is set when the organizations of a user are listed
+	OrgName string
+	UserID  int
+	// Email is set when the members of an organization are listed
+	Email     string
+	Role      string
+	CreatedAt time.Time
+}
+
+// Invitation lets the owner of Email join the organization with Role.
+// AcceptedAt is zero until it is accepted; invitations are accepted once.
+type Invitation struct {
+	ID         string
+	OrgID      string
+	Email      string
+	Role       string
+	InvitedBy  int
+	ExpiresAt  time.Time
+	AcceptedAt time.Time
+	CreatedAt  time.Time
+}
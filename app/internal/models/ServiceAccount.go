@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// ServiceAccount is a non-human principal owned by a team. It authenticates
+// with JWT assertions signed by one of its keys and its tokens have the
+// subject svc:<ID>.
+type ServiceAccount struct {
+	ID          string
+	Name        string
+	Description string
+	// Owner is the team responsible for the account
+	Owner string
+	Roles []string
+	// KeyIDs are the thumbprints of the registered public keys
+	KeyIDs    []string
+	CreatedAt time.Time
+}
+
+// ServiceAccountKey is a public key a service account signs assertions with.
+// ID is the RFC 7638 thumbprint of the key, used as kid.
+type ServiceAccountKey struct {
+	ID        string
+	AccountID string
+	// PublicKey is the key as JWK
+	PublicKey []byte
+	CreatedAt time.Time
+}
+
+// Actions recorded in the audit trail of service accounts
+const (
+	ServiceAccountCreated     = "created"
+	ServiceAccountDeleted     = "deleted"
+	ServiceAccountKeyAdded    = "key_added"
+	ServiceAccountKeyRemoved  = "key_removed"
+	ServiceAccountTokenIssued = "token_issued"
+)
+
+// ServiceAccountEvent is an entry of the audit trail of a service account
+type ServiceAccountEvent struct {
+	AccountID string
+	Action    string
+	// Actor is the subject that caused the event
+	Actor string
+	// KeyID is the key added, removed or used, if any
+	KeyID     string
+	CreatedAt time.Time
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Session is the refresh token record kept in Redis
+type Session struct {
+	// ID identifies the session in listings; it is not stored
+	ID        string    `json:"-"`
+	UserID    string    `json:"uid"`
+	JKT       string    `json:"jkt,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// AuthTime is when the user authenticated; it is kept across rotations
+	AuthTime time.Time `json:"auth_time,omitzero"`
+	// Realm the session belongs to; empty for sessions of the default realm
+	// stored before realms existed
+	Realm string `json:"realm,omitempty"`
+	// OrgID is the active organization of the session
+	OrgID   string `json:"org_id,omitempty"`
+	OrgRole string `json:"org_role,omitempty"`
+}
@@ -1,21 +1,104 @@
 package models
 
+import "time"
+
 type NewUser struct {
 	Email    string
 	HashPass []byte
 }
 
 type User struct {
-	UID      int
-	Email    string
-	HashPass []byte
+	UID           int
+	Email         string
+	HashPass      []byte
+	EmailVerified bool
+	// Roles granted by the directory for users provisioned from LDAP or SAML
+	Roles   []string
+	IsAdmin bool
+	// Provisioned users were created by a directory login; only they are
+	// updated by directories
+	Provisioned bool
+	// Status is one of the UserStatuses or UserDeleted; only active users may
+	// sign in
+	Status string
+	// StatusReason is why the status was set, e.g. why the user was disabled
+	StatusReason string
+	// StatusChangedAt is zero for users that were always active
+	StatusChangedAt time.Time
+	// PurgeAfter is when a deleted user is purged, zero for other users
+	PurgeAfter time.Time
+	CreatedAt  time.Time
 }
 
-type NewUserReq struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+// ProvisionedUser is a user asserted by a directory, LDAP or a SAML identity
+// provider, on login
+type ProvisionedUser struct {
+	Email string
+	Roles []string
+	// GrantAdmin lets the admin role make the user an administrator, and its
+	// absence revoke it. Otherwise the admin flag is left as it is.
+	GrantAdmin bool
 }
 
-type RefreshToken struct {
-	Token string `json:"refresh_token"`
+// Active reports whether the user may sign in
+func (u User) Active() bool {
+	return u.Status == "" || u.Status == UserActive
+}
+
+// RoleAdmin is the role that makes a user an administrator
+const RoleAdmin = "admin"
+
+// User statuses
+const (
+	UserActive   = "active"
+	UserDisabled = "disabled"
+	// UserLocked is for temporary blocks, e.g. while an incident is
+	// investigated
+	UserLocked              = "locked"
+	UserPendingVerification = "pending_verification"
+	// UserDeleted is set by users deleting their account; the user is purged
+	// after a grace period unless an administrator sets another status
+	UserDeleted = "deleted"
+)
+
+// UserStatuses lists the statuses administrators can set
+var UserStatuses = []string{UserActive, UserDisabled, UserLocked, UserPendingVerification}
+
+// UserFilter selects users listed by administrators
+type UserFilter struct {
+	// Query is matched case-insensitively against a part of the email
+	Query string
+	// Status is one of the UserStatuses, UserDeleted or empty for all
+	Status     string
+	AdminsOnly bool
+	// AfterID continues a listing after the user with this id
+	AfterID int
+	Limit   int
+}
+
+// Actions recorded in the admin audit log
+const (
+	AuditUserDisabled    = "user_disabled"
+	AuditUserEnabled     = "user_enabled"
+	AuditStatusChanged   = "status_changed"
+	AuditPasswordReset   = "password_reset"
+	AuditSessionsRevoked = "sessions_revoked"
+	AuditEmailChanged    = "email_changed"
+	AuditUserDeleted     = "user_deleted"
+	// AuditDeletionRequested and AuditDataExported are recorded with the
+	// user as actor
+	AuditDeletionRequested = "deletion_requested"
+	AuditDataExported      = "data_exported"
+)
+
+// AuditEvent is an entry of the admin audit log
+type AuditEvent struct {
+	ID int64
+	// Actor is the subject of the administrator
+	Actor  string
+	Action string
+	// UserID is the user the action was taken on
+	UserID    int
+	Details   map[string]string
+	CreatedAt time.Time
 }
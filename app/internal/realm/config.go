@@ -0,0 +1,106 @@
+package realm
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/idtoken"
+	"auth_service/internal/config"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Config is the content of the realms config file
+type Config struct {
+	Realms []RealmConfig `yaml:"realms"`
+}
+
+type RealmConfig struct {
+	Name string `yaml:"name"`
+	// Issuer is required for every realm but the default one, e.g.
+	// https://auth.example.com/realms/<name>
+	Issuer string   `yaml:"issuer"`
+	Hosts  []string `yaml:"hosts"`
+	// Secret signs the realm's access tokens; the default realm falls back
+	// to JWT_SECRET
+	Secret string `yaml:"secret"`
+	// IDTokenKeyFile is the PEM key ID tokens of the realm are signed with
+	IDTokenKeyFile string `yaml:"id_token_key_file"`
+	Password       struct {
+		MinLength int `yaml:"min_length"`
+	} `yaml:"password"`
+	Session struct {
+		AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+		RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	} `yaml:"session"`
+	// MFA policies are not supported, as the service has no second factor.
+	// The section is only read so a realm asking for one is rejected rather
+	// than left without it.
+	MFA any `yaml:"mfa"`
+}
+
+// LoadConfig reads the YAML config file. ${VAR} references are replaced by
+// environment variables so secrets can stay out of the file.
+func LoadConfig(file string) (Config, error) {
+	var cfg Config
+	if err := config.LoadYAML(file, &cfg); err != nil {
+		return Config{}, fmt.Errorf("load realms config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Build creates the registry of the configured realms. def is the default
+// realm; a configured default realm overrides the settings it sets.
+func (cfg Config) Build(def *Realm) (*Registry, error) {
+	realms := []*Realm{def}
+	secrets := map[string]string{string(def.JWT.SecretKey): def.Name}
+	for _, rc := range cfg.Realms {
+		realm := &Realm{Name: rc.Name, JWT: &jwtman.JWTManager{TokenDuration: def.JWT.TokenDuration}}
+		if rc.Name == Default {
+			base := *def
+			realm = &base
+			realms[0] = realm
+			jwt := *def.JWT
+			realm.JWT = &jwt
+		} else if rc.Secret == "" || rc.Issuer == "" {
+			return nil, fmt.Errorf("realm %s: secret and issuer are required", rc.Name)
+		}
+		if rc.MFA != nil {
+			return nil, fmt.Errorf("realm %s: MFA policies are not supported", rc.Name)
+		}
+
+		if rc.Issuer != "" {
+			realm.Issuer = strings.TrimSuffix(rc.Issuer, "/")
+			realm.JWT.Issuer = realm.Issuer
+		}
+		if len(rc.Hosts) > 0 {
+			realm.Hosts = rc.Hosts
+		}
+		if rc.Secret != "" {
+			if other, ok := secrets[rc.Secret]; ok && other != rc.Name {
+				return nil, fmt.Errorf("realm %s reuses the secret of realm %s", rc.Name, other)
+			}
+			secrets[rc.Secret] = rc.Name
+			realm.JWT.SecretKey = []byte(rc.Secret)
+		}
+		if rc.IDTokenKeyFile != "" {
+			signer, err := idtoken.LoadSigner(rc.IDTokenKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("realm %s: %w", rc.Name, err)
+			}
+			realm.IDTokens = signer
+		}
+		if rc.Password.MinLength > 0 {
+			realm.Password.MinLength = rc.Password.MinLength
+		}
+		if rc.Session.AccessTokenTTL > 0 {
+			realm.JWT.TokenDuration = rc.Session.AccessTokenTTL
+		}
+		if rc.Session.RefreshTokenTTL > 0 {
+			realm.Session.RefreshTokenTTL = rc.Session.RefreshTokenTTL
+		}
+		if rc.Name != Default {
+			realms = append(realms, realm)
+		}
+	}
+	return NewRegistry(realms...)
+}
@@ -0,0 +1,60 @@
+package realm
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// PathPrefix starts paths naming their realm, /realms/<name>/...
+const PathPrefix = "/realms/"
+
+// requestPath is the part of the request path stripped by the middleware and
+// the path as the client sent it
+type requestPath struct {
+	base     string
+	original string
+}
+
+type pathKey struct{}
+
+// Middleware stores the realm of HTTP requests in the request context. The
+// realm is named by the path prefix, which is stripped, or the X-Realm
+// header, or else found by the Host header. Requests naming an unknown realm
+// are passed to onError.
+func (r *Registry) Middleware(next http.Handler, onError func(http.ResponseWriter, *http.Request, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := req.Header.Get(Header)
+		path := requestPath{original: req.URL.EscapedPath()}
+		if rest, ok := strings.CutPrefix(req.URL.Path, PathPrefix); ok {
+			var stripped string
+			name, stripped, _ = strings.Cut(rest, "/")
+			path.base = PathPrefix + name
+			req = req.Clone(req.Context())
+			req.URL.Path = "/" + stripped
+			req.URL.RawPath = ""
+		}
+		realm, err := r.Resolve(name, req.Host)
+		if err != nil {
+			onError(w, req, err)
+			return
+		}
+		ctx := context.WithValue(NewContext(req.Context(), realm), pathKey{}, path)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// BasePath returns the realm prefix stripped from the request path,
+// /realms/<name>, or "" if the path named no realm. Paths sent back to the
+// client, in links, forms and cookies, start with it.
+func BasePath(ctx context.Context) string {
+	path, _ := ctx.Value(pathKey{}).(requestPath)
+	return path.base
+}
+
+// RequestPath returns the escaped path the client sent the request to, before
+// the realm prefix was stripped, or "" outside the middleware
+func RequestPath(ctx context.Context) string {
+	path, _ := ctx.Value(pathKey{}).(requestPath)
+	return path.original
+}
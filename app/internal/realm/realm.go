@@ -0,0 +1,158 @@
+// Package realm isolates tenants of the service. Each realm has its own
+// users, OAuth clients and service accounts, its own token signing keys and
+// issuer and its own password and session policies. There are no MFA
+// policies, as the service has no second factor.
+package realm
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/idtoken"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Default is the realm of requests that name no realm. Data created before
+// realms existed belongs to it.
+const Default = "default"
+
+// MetadataKey is the gRPC metadata key, and Header the HTTP header, naming
+// the realm of a request
+const (
+	MetadataKey = "x-realm"
+	Header      = "X-Realm"
+)
+
+var ErrUnknownRealm = errors.New("unknown realm")
+
+// PasswordPolicy constrains the passwords users register with
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters; zero accepts any
+	// non-empty password
+	MinLength int
+}
+
+// SessionPolicy bounds the lifetime of sessions
+type SessionPolicy struct {
+	// RefreshTokenTTL is the lifetime of refresh tokens; zero uses the
+	// access token lifetime
+	RefreshTokenTTL time.Duration
+}
+
+type Realm struct {
+	Name string
+	// Issuer is the iss of tokens and the OpenID Connect issuer of the realm
+	Issuer string
+	// Hosts are the host names requests are assigned to the realm by
+	Hosts []string
+	// JWT signs and verifies the access tokens of the realm
+	JWT *jwtman.JWTManager
+	// IDTokens signs ID tokens; nil uses the OpenID Connect provider's key
+	IDTokens *idtoken.Signer
+	Password PasswordPolicy
+	Session  SessionPolicy
+}
+
+// Registry holds the configured realms
+type Registry struct {
+	realms map[string]*Realm
+	hosts  map[string]*Realm
+	names  []string
+}
+
+// NewRegistry checks that names and hosts are unique and that the default
+// realm is among the realms
+func NewRegistry(realms ...*Realm) (*Registry, error) {
+	r := &Registry{realms: map[string]*Realm{}, hosts: map[string]*Realm{}}
+	for _, realm := range realms {
+		if !validName(realm.Name) {
+			return nil, fmt.Errorf("invalid realm name %q", realm.Name)
+		}
+		if realm.JWT == nil {
+			return nil, fmt.Errorf("realm %s: no token signing key", realm.Name)
+		}
+		if _, ok := r.realms[realm.Name]; ok {
+			return nil, fmt.Errorf("realm %s configured twice", realm.Name)
+		}
+		r.realms[realm.Name] = realm
+		r.names = append(r.names, realm.Name)
+		for _, host := range realm.Hosts {
+			host = strings.ToLower(host)
+			if other, ok := r.hosts[host]; ok {
+				return nil, fmt.Errorf("host %s is assigned to realms %s and %s", host, other.Name, realm.Name)
+			}
+			r.hosts[host] = realm
+		}
+	}
+	if _, ok := r.realms[Default]; !ok {
+		return nil, fmt.Errorf("the %s realm is not configured", Default)
+	}
+	slices.Sort(r.names)
+	return r, nil
+}
+
+// validName accepts names usable in URL paths and hosts
+func validName(name string) bool {
+	if name == "" || len(name) > 63 {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Registry) Get(name string) (*Realm, error) {
+	realm, ok := r.realms[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownRealm, name)
+	}
+	return realm, nil
+}
+
+// Names returns the names of all realms, sorted
+func (r *Registry) Names() []string {
+	return slices.Clone(r.names)
+}
+
+// Resolve returns the realm named by a request, or else the realm its host
+// is assigned to, or else the default realm
+func (r *Registry) Resolve(name, host string) (*Realm, error) {
+	if name != "" {
+		return r.Get(name)
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if realm, ok := r.hosts[strings.ToLower(host)]; ok {
+		return realm, nil
+	}
+	return r.realms[Default], nil
+}
+
+type realmKey struct{}
+
+func NewContext(ctx context.Context, realm *Realm) context.Context {
+	return context.WithValue(ctx, realmKey{}, realm)
+}
+
+// FromContext returns the realm of the request or nil if none was resolved
+func FromContext(ctx context.Context) *Realm {
+	realm, _ := ctx.Value(realmKey{}).(*Realm)
+	return realm
+}
+
+// Name returns the name of the realm of ctx, the default realm if none was
+// resolved. Storage scopes its queries by it.
+func Name(ctx context.Context) string {
+	if realm := FromContext(ctx); realm != nil {
+		return realm.Name
+	}
+	return Default
+}
@@ -0,0 +1,122 @@
+package realm_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/realm"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newRegistry(t *testing.T) *realm.Registry {
+	t.Helper()
+	def := &realm.Realm{Name: realm.Default, JWT: &jwtman.JWTManager{SecretKey: []byte("default"), TokenDuration: 15 * time.Minute}}
+	cfg := realm.Config{Realms: []realm.RealmConfig{{
+		Name:   "acme",
+		Issuer: "https://auth.example.com/realms/acme/",
+		Hosts:  []string{"auth.acme.example"},
+		Secret: "acme-secret",
+	}}}
+	cfg.Realms[0].Password.MinLength = 12
+	registry, err := cfg.Build(def)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return registry
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	registry := newRegistry(t)
+
+	for _, tc := range []struct{ name, host, want string }{
+		{"", "", realm.Default},
+		{"", "auth.example.com", realm.Default},
+		{"", "AUTH.acme.example:8443", "acme"},
+		{"acme", "auth.example.com", "acme"},
+		{realm.Default, "auth.acme.example", realm.Default},
+	} {
+		r, err := registry.Resolve(tc.name, tc.host)
+		if err != nil || r.Name != tc.want {
+			t.Errorf("Resolve(%q, %q): expected %s, got %v %v", tc.name, tc.host, tc.want, r, err)
+		}
+	}
+	if _, err := registry.Resolve("other", ""); !errors.Is(err, realm.ErrUnknownRealm) {
+		t.Errorf("expected ErrUnknownRealm, got %v", err)
+	}
+
+	acme, _ := registry.Get("acme")
+	if acme.Issuer != "https://auth.example.com/realms/acme" || acme.JWT.Issuer != acme.Issuer ||
+		acme.JWT.TokenDuration != 15*time.Minute || acme.Password.MinLength != 12 {
+		t.Errorf("unexpected realm %+v", acme)
+	}
+}
+
+func TestConfig_Build(t *testing.T) {
+	def := &realm.Realm{Name: realm.Default, JWT: &jwtman.JWTManager{SecretKey: []byte("default")}}
+	for name, realms := range map[string][]realm.RealmConfig{
+		"missing secret": {{Name: "acme", Issuer: "https://acme.example.com"}},
+		"reused secret":  {{Name: "acme", Issuer: "https://acme.example.com", Secret: "default"}},
+		"invalid name":   {{Name: "Acme", Issuer: "https://acme.example.com", Secret: "acme"}},
+		"mfa policy":     {{Name: "acme", Issuer: "https://acme.example.com", Secret: "acme", MFA: map[string]any{"required": true}}},
+		"duplicate host": {
+			{Name: "a", Issuer: "https://a.example.com", Secret: "a", Hosts: []string{"auth.example.com"}},
+			{Name: "b", Issuer: "https://b.example.com", Secret: "b", Hosts: []string{"auth.example.com"}},
+		},
+	} {
+		if _, err := (realm.Config{Realms: realms}).Build(def); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	cfg := realm.Config{Realms: []realm.RealmConfig{{Name: realm.Default, Hosts: []string{"auth.example.com"}}}}
+	cfg.Realms[0].Session.RefreshTokenTTL = 24 * time.Hour
+	registry, err := cfg.Build(def)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	r, _ := registry.Resolve("", "auth.example.com")
+	if r.Name != realm.Default || string(r.JWT.SecretKey) != "default" || r.Session.RefreshTokenTTL != 24*time.Hour {
+		t.Errorf("expected the configured default realm to keep the environment's secret, got %+v", r)
+	}
+}
+
+func TestRegistry_Middleware(t *testing.T) {
+	registry := newRegistry(t)
+	var gotRealm, gotPath, gotBase, gotOriginal string
+	var gotErr error
+	handler := registry.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRealm, gotPath = realm.Name(r.Context()), r.URL.Path
+		gotBase, gotOriginal = realm.BasePath(r.Context()), realm.RequestPath(r.Context())
+	}), func(w http.ResponseWriter, r *http.Request, err error) {
+		gotErr = err
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	for _, tc := range []struct {
+		target, host, header string
+		realm, path, base    string
+	}{
+		{"/oauth/token", "auth.example.com", "", realm.Default, "/oauth/token", ""},
+		{"/realms/acme/oauth/token", "auth.example.com", "", "acme", "/oauth/token", "/realms/acme"},
+		{"/v1/login", "auth.acme.example", "", "acme", "/v1/login", ""},
+		{"/v1/login", "auth.example.com", "acme", "acme", "/v1/login", ""},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+		req.Host = tc.host
+		if tc.header != "" {
+			req.Header.Set(realm.Header, tc.header)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if gotRealm != tc.realm || gotPath != tc.path || gotBase != tc.base || gotOriginal != tc.target {
+			t.Errorf("%s %s: expected %s %s %q, got %s %s %q %s", tc.host, tc.target, tc.realm, tc.path, tc.base, gotRealm, gotPath, gotBase, gotOriginal)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/realms/other/oauth/token", nil))
+	if rec.Code != http.StatusNotFound || !errors.Is(gotErr, realm.ErrUnknownRealm) {
+		t.Errorf("expected ErrUnknownRealm for an unknown realm, got %d %v", rec.Code, gotErr)
+	}
+}
@@ -1,8 +1,13 @@
 package server
 
 import (
+	"auth_service/internal/clientcert"
 	"auth_service/internal/controller"
+	"auth_service/internal/cors"
+	"auth_service/internal/health"
+	"auth_service/internal/realm"
 	"context"
+	"crypto/tls"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -16,17 +21,54 @@ type Server struct {
 	Logger     *slog.Logger
 }
 
-func NewServer(controller *controller.AuthController, logger *slog.Logger) *Server {
+// NewServer builds the HTTP router; a nil corsPolicy disables CORS, a nil
+// federationController disables federated login and a nil samlController
+// disables SAML logins. The realm of each request is resolved before routing
+// so realm path prefixes are stripped.
+func NewServer(controller *controller.AuthController, oauthController *controller.OAuthController, federationController *controller.FederationController, samlController *controller.SAMLController, healthCheck *health.Health, corsPolicy *cors.CORS, realms *realm.Registry, tlsConfig *tls.Config, logger *slog.Logger) *Server {
 	router := mux.NewRouter()
+	if corsPolicy != nil {
+		router.Use(corsPolicy.Middleware)
+		// preflight requests have to match a route for the middleware to run
+		router.Methods(http.MethodOptions).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	router.Use(clientcert.Middleware)
 
-	router.HandleFunc("/login", controller.LoginHandler).Methods("POST")
-	router.HandleFunc("/logout", controller.LogoutHandler).Methods("POST")
-	router.HandleFunc("/refresh", controller.RefreshHandler).Methods("POST")
-	router.HandleFunc("/register", controller.RegisterHandler).Methods("POST")
+	router.HandleFunc("/livez", healthCheck.Livez).Methods("GET")
+	router.HandleFunc("/readyz", healthCheck.Readyz).Methods("GET")
+	router.HandleFunc("/health", healthCheck.Livez).Methods("GET")
+	router.HandleFunc("/openapi.json", controller.OpenAPIHandler).Methods("GET")
+	if controller.Cookie != nil {
+		router.HandleFunc("/web/login", controller.LoginHandler).Methods("POST")
+		router.HandleFunc(controller.Cookie.Path+"/refresh", controller.RefreshHandler).Methods("POST")
+		router.HandleFunc(controller.Cookie.Path+"/logout", controller.LogoutHandler).Methods("POST")
+	}
+	router.HandleFunc("/oauth/authorize", oauthController.AuthorizeHandler).Methods("GET", "POST")
+	router.HandleFunc("/oauth/token", oauthController.TokenHandler).Methods("POST")
+	router.HandleFunc("/oauth/device_authorization", oauthController.DeviceAuthorizationHandler).Methods("POST")
+	router.HandleFunc("/oauth/device", oauthController.DeviceHandler).Methods("GET", "POST")
+	if oauthController.OAuth.OIDC != nil {
+		router.HandleFunc("/.well-known/openid-configuration", oauthController.DiscoveryHandler).Methods("GET")
+		router.HandleFunc("/.well-known/jwks.json", oauthController.JWKSHandler).Methods("GET")
+		router.HandleFunc("/userinfo", oauthController.UserInfoHandler).Methods("GET", "POST")
+	}
+	if federationController != nil {
+		router.HandleFunc("/federation/{provider}/login", federationController.LoginHandler).Methods("GET")
+		router.HandleFunc("/federation/{provider}/callback", federationController.CallbackHandler).Methods("GET")
+	}
+	if samlController != nil {
+		router.HandleFunc("/saml/metadata", samlController.MetadataHandler).Methods("GET")
+		router.HandleFunc("/saml/{connection}/login", samlController.LoginHandler).Methods("GET")
+		router.HandleFunc("/saml/{connection}/acs", samlController.ACSHandler).Methods("POST")
+	}
+	router.PathPrefix("/").Handler(controller)
 
 	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: router,
+		Addr:      ":8080",
+		Handler:   realms.Middleware(router, controller.RealmErrorHandler),
+		TLSConfig: tlsConfig,
 	}
 	return &Server{
 		HttpServer: srv,
@@ -38,7 +80,13 @@ func NewServer(controller *controller.AuthController, logger *slog.Logger) *Serv
 func (s *Server) Start() {
 	go func() {
 		s.Logger.Info("Server starting", slog.String("port", s.HttpServer.Addr))
-		if err := s.HttpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if s.HttpServer.TLSConfig != nil {
+			err = s.HttpServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.HttpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.Logger.Error("Server error", slog.Any("error", err))
 		}
 	}()
@@ -0,0 +1,161 @@
+// Package account lets users delete their account and export the data
+// stored about them. Deleted accounts are kept for a grace period, during
+// which administrators can restore them, and then purged.
+package account
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/storage"
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// ErrReauthenticationRequired is returned when the caller's token was issued
+// for a sign-in that is not recent enough
+var ErrReauthenticationRequired = errors.New("sign in again to confirm this action")
+
+const (
+	// purgeBatch is how many users a purge run deletes at most
+	purgeBatch = 100
+	// auditPageSize is the page size audit events are exported with
+	auditPageSize = 200
+)
+
+type Repository interface {
+	GetUserByID(ctx context.Context, UID int) (models.User, error)
+	ScheduleUserDeletion(ctx context.Context, userID int, purgeAfter time.Time) error
+	DueUserDeletions(ctx context.Context, limit int) ([]int, error)
+	PurgeUser(ctx context.Context, userID int) error
+	ListUserIdentities(ctx context.Context, userID int) ([]models.LinkedIdentity, error)
+	ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error)
+	ListUserOrganizations(ctx context.Context, userID int) ([]models.Membership, error)
+	AddAuditEvent(ctx context.Context, event models.AuditEvent) error
+	ListAuditEvents(ctx context.Context, userID int, beforeID int64, limit int) ([]models.AuditEvent, error)
+}
+
+type Service struct {
+	Logger *slog.Logger
+	Auth   *auth.Auth
+	Users  Repository
+	// GracePeriod is how long deleted accounts are kept before they are
+	// purged
+	GracePeriod time.Duration
+	// MaxAuthAge is how long after signing in a user can delete the account
+	MaxAuthAge time.Duration
+}
+
+func NewService(logger *slog.Logger, authSvc *auth.Auth, users Repository) *Service {
+	return &Service{
+		Logger:      logger,
+		Auth:        authSvc,
+		Users:       users,
+		GracePeriod: 30 * 24 * time.Hour,
+		MaxAuthAge:  5 * time.Minute,
+	}
+}
+
+func mapError(err error) error {
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return auth.ErrUserNotFound
+	}
+	return err
+}
+
+// record adds an event done by the user to the audit log. Failures are
+// logged by the repository and don't fail the action.
+func (s *Service) record(ctx context.Context, uid int, action string, details map[string]string) {
+	_ = s.Users.AddAuditEvent(ctx, models.AuditEvent{
+		Actor:   strconv.Itoa(uid),
+		Action:  action,
+		UserID:  uid,
+		Details: details,
+	})
+}
+
+// requireRecentAuth returns the id of the calling user if their token was
+// issued for a sign-in within MaxAuthAge
+func (s *Service) requireRecentAuth(ctx context.Context) (int, error) {
+	uid, err := s.Auth.RequireUser(ctx)
+	if err != nil {
+		return 0, err
+	}
+	claims, _ := jwtman.FromContext(ctx)
+	if claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > s.MaxAuthAge {
+		return 0, ErrReauthenticationRequired
+	}
+	return uid, nil
+}
+
+// Delete schedules the deletion of the caller's account and returns when it
+// is purged. The account can't be used from now on: its sessions and access
+// tokens are revoked.
+func (s *Service) Delete(ctx context.Context) (time.Time, error) {
+	uid, err := s.requireRecentAuth(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	purgeAfter := time.Now().Add(s.GracePeriod).Truncate(time.Second)
+	if err := s.Users.ScheduleUserDeletion(ctx, uid, purgeAfter); err != nil {
+		return time.Time{}, mapError(err)
+	}
+	revoked, err := s.Auth.RevokeSessions(ctx, uid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := s.Auth.RevokeAccessTokens(ctx, uid); err != nil {
+		return time.Time{}, err
+	}
+	s.record(ctx, uid, models.AuditDeletionRequested, map[string]string{
+		"purge_after":      purgeAfter.UTC().Format(time.RFC3339),
+		"revoked_sessions": strconv.Itoa(revoked),
+	})
+	s.Logger.Info("Account deletion scheduled", slog.Int("uid", uid), slog.Time("purge_after", purgeAfter))
+	return purgeAfter, nil
+}
+
+// Purge deletes the accounts whose grace period is over and returns how many
+// were purged
+func (s *Service) Purge(ctx context.Context) (int, error) {
+	ids, err := s.Users.DueUserDeletions(ctx, purgeBatch)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, uid := range ids {
+		// sessions were revoked on deletion; this catches any left behind
+		if _, err := s.Auth.RevokeSessions(ctx, uid); err != nil {
+			return purged, err
+		}
+		if err := s.Users.PurgeUser(ctx, uid); err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				// restored since it was listed
+				continue
+			}
+			return purged, err
+		}
+		s.Logger.Info("Account purged", slog.Int("uid", uid))
+		purged++
+	}
+	return purged, nil
+}
+
+// Run purges due accounts every interval until ctx is done
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Purge(ctx); err != nil && ctx.Err() == nil {
+			s.Logger.Error("Purging deleted accounts failed", slog.Any("error", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package account_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/services/account"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/testutil"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var alice = models.NewUser{Email: "alice@example.com", HashPass: []byte("examplepass")}
+
+// accountUsers adds the organizations of users, which are not exported by
+// these tests
+type accountUsers struct {
+	*testutil.UserStore
+}
+
+func (accountUsers) ListUserOrganizations(ctx context.Context, userID int) ([]models.Membership, error) {
+	return []models.Membership{}, nil
+}
+
+func newService(t *testing.T) (*account.Service, *testutil.UserStore) {
+	t.Helper()
+	users := &testutil.UserStore{
+		Identities: []models.LinkedIdentity{{Provider: "google", Subject: "1234", UserID: 1}},
+		APIKeys:    []models.APIKey{{ID: "key", UserID: 1, Name: "ci", Hash: []byte("secret hash"), Hint: "ak_1234"}},
+	}
+	sessions := testutil.NewSessionStore()
+	jwtManager := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), users, sessions, jwtManager)
+	authSvc.Sessions = sessions
+	for _, user := range []models.NewUser{alice, {Email: "bob@example.com", HashPass: []byte("examplepass")}} {
+		if err := authSvc.Register(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return account.NewService(slog.Default(), authSvc, accountUsers{users}), users
+}
+
+// login signs the user in and returns the tokens and a context carrying the
+// claims of the access token
+func login(t *testing.T, svc *account.Service, user models.NewUser) (*auth.AuthResponse, context.Context) {
+	t.Helper()
+	tokens, err := svc.Auth.Login(context.Background(), user, auth.TokenBinding{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := svc.Auth.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: tokens.AccessToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens, jwtman.NewContext(context.Background(), claims)
+}
+
+func TestService_Delete(t *testing.T) {
+	svc, users := newService(t)
+	tokens, ctx := login(t, svc, alice)
+
+	claims, _ := jwtman.FromContext(ctx)
+	stale := *claims
+	stale.AuthTime = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	if _, err := svc.Delete(jwtman.NewContext(context.Background(), &stale)); !errors.Is(err, account.ErrReauthenticationRequired) {
+		t.Fatalf("expected ErrReauthenticationRequired for an old sign-in, got %v", err)
+	}
+
+	purgeAfter, err := svc.Delete(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if time.Until(purgeAfter) < svc.GracePeriod-time.Minute {
+		t.Errorf("expected the purge after the grace period, got %v", purgeAfter)
+	}
+	if users.Users[0].Status != models.UserDeleted {
+		t.Errorf("expected the user to be deleted, got %q", users.Users[0].Status)
+	}
+	if _, err := svc.Auth.Refresh(context.Background(), tokens.RefreshToken, auth.TokenBinding{}); err == nil {
+		t.Error("expected the sessions of a deleted user to be revoked")
+	}
+	if _, err := svc.Auth.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: tokens.AccessToken}); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("expected the access token of a deleted user to be revoked, got %v", err)
+	}
+	if _, err := svc.Auth.Login(context.Background(), alice, auth.TokenBinding{}); !errors.Is(err, auth.ErrAccountDeleted) {
+		t.Errorf("expected ErrAccountDeleted, got %v", err)
+	}
+	if len(users.Events) != 1 || users.Events[0].Action != models.AuditDeletionRequested || users.Events[0].Actor != "1" {
+		t.Errorf("expected the deletion to be recorded, got %+v", users.Events)
+	}
+}
+
+func TestService_Purge(t *testing.T) {
+	svc, users := newService(t)
+	svc.GracePeriod = 0
+	_, aliceCtx := login(t, svc, alice)
+	_, bobCtx := login(t, svc, models.NewUser{Email: "bob@example.com", HashPass: []byte("examplepass")})
+	for _, ctx := range []context.Context{aliceCtx, bobCtx} {
+		if _, err := svc.Delete(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an administrator restores bob before the purge
+	users.Users[1].Status = models.UserActive
+
+	purged, err := svc.Purge(context.Background())
+	if err != nil || purged != 1 {
+		t.Fatalf("expected one purged user, got %d %v", purged, err)
+	}
+	if len(users.Users) != 1 || users.Users[0].Email != "bob@example.com" {
+		t.Errorf("expected only bob to be left, got %+v", users.Users)
+	}
+}
+
+func TestService_Export(t *testing.T) {
+	svc, _ := newService(t)
+	_, ctx := login(t, svc, alice)
+
+	archive, err := svc.Export(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if archive.Profile.Email != alice.Email || !archive.Profile.HasPassword || len(archive.Sessions) != 1 ||
+		len(archive.LinkedIdentities) != 1 || len(archive.APIKeys) != 1 {
+		t.Errorf("expected the profile, session, identity and key of alice, got %+v", archive)
+	}
+	raw, err := json.Marshal(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "secret hash") || strings.Contains(string(raw), "$2a$") {
+		t.Errorf("expected no secrets in the archive, got %s", raw)
+	}
+
+	if again, _ := svc.Export(ctx); len(again.AuditEvents) != 1 || again.AuditEvents[0].Action != models.AuditDataExported {
+		t.Errorf("expected the first export to be recorded, got %+v", again.AuditEvents)
+	}
+}
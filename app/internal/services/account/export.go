@@ -0,0 +1,185 @@
+package account
+
+import (
+	"auth_service/internal/models"
+	"cmp"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Archive is everything stored about a user. Sign-ins are only kept as the
+// sessions they started, and OAuth consent is asked for on each
+// authorization rather than stored, so neither has a section of its own.
+type Archive struct {
+	ExportedAt       time.Time      `json:"exported_at"`
+	Profile          Profile        `json:"profile"`
+	Sessions         []Session      `json:"sessions"`
+	LinkedIdentities []Identity     `json:"linked_identities"`
+	APIKeys          []APIKey       `json:"api_keys"`
+	Organizations    []Organization `json:"organizations"`
+	AuditEvents      []AuditEvent   `json:"audit_events"`
+}
+
+// Profile is the user record without the password hash
+type Profile struct {
+	ID            int       `json:"id"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	HasPassword   bool      `json:"has_password"`
+	Roles         []string  `json:"roles"`
+	Admin         bool      `json:"admin"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Session is an active sign-in of the user
+type Session struct {
+	ID        string    `json:"id"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
+	OrgID     string    `json:"org_id,omitempty"`
+	AuthTime  time.Time `json:"auth_time,omitzero"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Identity is an account at an upstream identity provider linked to the user
+type Identity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// APIKey is a personal access token without its secret
+type APIKey struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Hint       string    `json:"hint"`
+	Scopes     []string  `json:"scopes"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Organization is a membership of the user
+type Organization struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// AuditEvent is an action recorded about the user, by an administrator or
+// the user
+type AuditEvent struct {
+	Actor     string            `json:"actor"`
+	Action    string            `json:"action"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Export returns everything stored about the caller
+func (s *Service) Export(ctx context.Context) (Archive, error) {
+	uid, err := s.Auth.RequireUser(ctx)
+	if err != nil {
+		return Archive{}, err
+	}
+	user, err := s.Users.GetUserByID(ctx, uid)
+	if err != nil {
+		return Archive{}, mapError(err)
+	}
+	archive := Archive{
+		ExportedAt: time.Now().UTC(),
+		Profile: Profile{
+			ID:            user.UID,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			HasPassword:   len(user.HashPass) > 0,
+			Roles:         append([]string{}, user.Roles...),
+			Admin:         user.IsAdmin,
+			Status:        cmp.Or(user.Status, models.UserActive),
+			CreatedAt:     user.CreatedAt,
+		},
+		Sessions:         []Session{},
+		LinkedIdentities: []Identity{},
+		APIKeys:          []APIKey{},
+		Organizations:    []Organization{},
+		AuditEvents:      []AuditEvent{},
+	}
+
+	sessions, err := s.Auth.ListSessions(ctx, uid)
+	if err != nil {
+		return Archive{}, err
+	}
+	for _, session := range sessions {
+		archive.Sessions = append(archive.Sessions, Session{
+			ID:        session.ID,
+			ClientID:  session.ClientID,
+			Scope:     session.Scope,
+			OrgID:     session.OrgID,
+			AuthTime:  session.AuthTime,
+			CreatedAt: session.CreatedAt,
+		})
+	}
+
+	identities, err := s.Users.ListUserIdentities(ctx, uid)
+	if err != nil {
+		return Archive{}, err
+	}
+	for _, identity := range identities {
+		archive.LinkedIdentities = append(archive.LinkedIdentities, Identity{
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+			LinkedAt: identity.CreatedAt,
+		})
+	}
+
+	keys, err := s.Users.ListAPIKeys(ctx, uid)
+	if err != nil {
+		return Archive{}, err
+	}
+	for _, key := range keys {
+		archive.APIKeys = append(archive.APIKeys, APIKey{
+			ID:         key.ID,
+			Name:       key.Name,
+			Hint:       key.Hint,
+			Scopes:     append([]string{}, key.Scopes...),
+			ExpiresAt:  key.ExpiresAt,
+			LastUsedAt: key.LastUsedAt,
+			CreatedAt:  key.CreatedAt,
+		})
+	}
+
+	memberships, err := s.Users.ListUserOrganizations(ctx, uid)
+	if err != nil {
+		return Archive{}, err
+	}
+	for _, m := range memberships {
+		archive.Organizations = append(archive.Organizations, Organization{ID: m.OrgID, Name: m.OrgName, Role: m.Role})
+	}
+
+	var before int64
+	for {
+		events, err := s.Users.ListAuditEvents(ctx, uid, before, auditPageSize)
+		if err != nil {
+			return Archive{}, err
+		}
+		for _, e := range events {
+			archive.AuditEvents = append(archive.AuditEvents, AuditEvent{
+				Actor:     e.Actor,
+				Action:    e.Action,
+				Details:   e.Details,
+				CreatedAt: e.CreatedAt,
+			})
+		}
+		if len(events) < auditPageSize {
+			break
+		}
+		before = events[len(events)-1].ID
+	}
+
+	s.record(ctx, uid, models.AuditDataExported, map[string]string{})
+	s.Logger.Info("Account data exported", slog.Int("uid", uid))
+	return archive, nil
+}
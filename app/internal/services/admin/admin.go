@@ -0,0 +1,369 @@
+// Package admin lets administrators manage the users of a realm. Every change
+// is recorded in the admin audit log.
+package admin
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/storage"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSelfAction is returned when administrators disable or delete their own
+// account
+var ErrSelfAction = errors.New("administrators can't disable or delete their own account")
+
+func statusViolation() error {
+	return &auth.ValidationError{Violations: []auth.FieldViolation{{
+		Field:       "status",
+		Description: "status must be active, disabled, locked or pending_verification",
+	}}}
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// Sign-in factor types
+const (
+	FactorPassword  = "password"
+	FactorFederated = "federated"
+)
+
+type Repository interface {
+	GetUserByID(ctx context.Context, UID int) (models.User, error)
+	ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error)
+	ListUserIdentities(ctx context.Context, userID int) ([]models.LinkedIdentity, error)
+	SetUserStatus(ctx context.Context, userID int, status, reason string) error
+	UpdateUserPassword(ctx context.Context, userID int, hash []byte) error
+	UpdateUserEmail(ctx context.Context, userID int, email string) error
+	DeleteUser(ctx context.Context, userID int) error
+	AddAuditEvent(ctx context.Context, event models.AuditEvent) error
+	ListAuditEvents(ctx context.Context, userID int, beforeID int64, limit int) ([]models.AuditEvent, error)
+}
+
+type Service struct {
+	Logger *slog.Logger
+	Auth   *auth.Auth
+	Users  Repository
+}
+
+func NewService(logger *slog.Logger, authSvc *auth.Auth, users Repository) *Service {
+	return &Service{Logger: logger, Auth: authSvc, Users: users}
+}
+
+// Factor is a way a user can sign in
+type Factor struct {
+	Type string
+	// Provider and Subject identify federated factors
+	Provider  string
+	Subject   string
+	CreatedAt time.Time
+}
+
+// UserDetails is a user with their active sessions and sign-in factors
+type UserDetails struct {
+	User     models.User
+	Sessions []models.Session
+	Factors  []Factor
+}
+
+func mapError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		return auth.ErrUserNotFound
+	case errors.Is(err, storage.ErrUserExists):
+		return auth.ErrEmailTaken
+	}
+	return err
+}
+
+// record adds an event to the audit log. Failures are logged by the
+// repository and don't fail the audited action.
+func (s *Service) record(ctx context.Context, admin *jwtman.Claims, action string, userID int, details map[string]string) {
+	_ = s.Users.AddAuditEvent(ctx, models.AuditEvent{
+		Actor:   admin.SubjectID(),
+		Action:  action,
+		UserID:  userID,
+		Details: details,
+	})
+	s.Logger.Info("Admin "+strings.ReplaceAll(action, "_", " "),
+		slog.Int("uid", userID), slog.String("actor", admin.SubjectID()))
+}
+
+// pageSize returns the requested page size within bounds
+func pageSize(n int) int {
+	if n <= 0 {
+		return defaultPageSize
+	}
+	return min(n, maxPageSize)
+}
+
+// Page tokens are the opaque form of the id a listing continues after
+func encodePageToken(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodePageToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		var id int64
+		if id, err = strconv.ParseInt(string(raw), 10, 64); err == nil && id > 0 {
+			return id, nil
+		}
+	}
+	return 0, &auth.ValidationError{Violations: []auth.FieldViolation{{Field: "page_token", Description: "invalid page token"}}}
+}
+
+// ListUsers returns a page of the users matching the filter ordered by id,
+// and the token of the next page, which is empty on the last one
+func (s *Service) ListUsers(ctx context.Context, filter models.UserFilter, pageToken string) ([]models.User, string, error) {
+	if _, err := s.Auth.RequireAdmin(ctx); err != nil {
+		return nil, "", err
+	}
+	if filter.Status != "" && filter.Status != models.UserDeleted && !slices.Contains(models.UserStatuses, filter.Status) {
+		return nil, "", statusViolation()
+	}
+	after, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	filter.AfterID = int(after)
+	filter.Query = strings.TrimSpace(filter.Query)
+	filter.Limit = pageSize(filter.Limit)
+	limit := filter.Limit
+	// one more than requested tells whether there is a next page
+	filter.Limit++
+	users, err := s.Users.ListUsers(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(users) <= limit {
+		return users, "", nil
+	}
+	users = users[:limit]
+	return users, encodePageToken(int64(users[limit-1].UID)), nil
+}
+
+// GetUser returns the user with their active sessions and sign-in factors
+func (s *Service) GetUser(ctx context.Context, uid int) (UserDetails, error) {
+	if _, err := s.Auth.RequireAdmin(ctx); err != nil {
+		return UserDetails{}, err
+	}
+	user, err := s.Users.GetUserByID(ctx, uid)
+	if err != nil {
+		return UserDetails{}, mapError(err)
+	}
+	details := UserDetails{User: user, Factors: []Factor{}}
+	if len(user.HashPass) > 0 {
+		details.Factors = append(details.Factors, Factor{Type: FactorPassword})
+	}
+	identities, err := s.Users.ListUserIdentities(ctx, uid)
+	if err != nil {
+		return UserDetails{}, err
+	}
+	for _, identity := range identities {
+		details.Factors = append(details.Factors, Factor{
+			Type:      FactorFederated,
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			CreatedAt: identity.CreatedAt,
+		})
+	}
+	if details.Sessions, err = s.Auth.ListSessions(ctx, uid); err != nil {
+		return UserDetails{}, err
+	}
+	return details, nil
+}
+
+// target checks that the caller is an administrator and the user exists
+func (s *Service) target(ctx context.Context, uid int) (*jwtman.Claims, models.User, error) {
+	admin, err := s.Auth.RequireAdmin(ctx)
+	if err != nil {
+		return nil, models.User{}, err
+	}
+	user, err := s.Users.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, models.User{}, mapError(err)
+	}
+	return admin, user, nil
+}
+
+// SetStatus changes the status of the user. Any status but active blocks
+// logins and refreshes, and revokes the user's sessions and access tokens
+// right away.
+func (s *Service) SetStatus(ctx context.Context, uid int, status, reason string) error {
+	if !slices.Contains(models.UserStatuses, status) {
+		return statusViolation()
+	}
+	admin, _, err := s.target(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if status != models.UserActive && admin.UserID == strconv.Itoa(uid) {
+		return ErrSelfAction
+	}
+	if err := s.Users.SetUserStatus(ctx, uid, status, reason); err != nil {
+		return mapError(err)
+	}
+
+	details := map[string]string{"status": status, "reason": reason}
+	action := models.AuditStatusChanged
+	switch status {
+	case models.UserActive:
+		action = models.AuditUserEnabled
+	case models.UserDisabled:
+		action = models.AuditUserDisabled
+	}
+	if status != models.UserActive {
+		revoked, err := s.Auth.RevokeSessions(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if err := s.Auth.RevokeAccessTokens(ctx, uid); err != nil {
+			return err
+		}
+		details["revoked_sessions"] = strconv.Itoa(revoked)
+	}
+	s.record(ctx, admin, action, uid, details)
+	return nil
+}
+
+// Disable sets the status of the user to disabled
+func (s *Service) Disable(ctx context.Context, uid int, reason string) error {
+	return s.SetStatus(ctx, uid, models.UserDisabled, reason)
+}
+
+// Enable sets the status of the user to active
+func (s *Service) Enable(ctx context.Context, uid int) error {
+	return s.SetStatus(ctx, uid, models.UserActive, "")
+}
+
+// ResetPassword replaces the user's password and revokes their sessions and
+// access tokens. If password is empty a random one is generated and returned.
+func (s *Service) ResetPassword(ctx context.Context, uid int, password string) (string, error) {
+	admin, _, err := s.target(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+	generated := ""
+	if password == "" {
+		buf := make([]byte, 18)
+		_, _ = rand.Read(buf)
+		generated = base64.RawURLEncoding.EncodeToString(buf)
+		password = generated
+	}
+	if err := auth.CheckPasswordPolicy(ctx, []byte(password)); err != nil {
+		return "", err
+	}
+	hash, err := auth.HashPassword([]byte(password))
+	if err != nil {
+		return "", err
+	}
+	if err := s.Users.UpdateUserPassword(ctx, uid, hash); err != nil {
+		return "", mapError(err)
+	}
+	revoked, err := s.Auth.RevokeSessions(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Auth.RevokeAccessTokens(ctx, uid); err != nil {
+		return "", err
+	}
+	s.record(ctx, admin, models.AuditPasswordReset, uid, map[string]string{
+		"generated":        strconv.FormatBool(generated != ""),
+		"revoked_sessions": strconv.Itoa(revoked),
+	})
+	return generated, nil
+}
+
+// RevokeSessions revokes all refresh tokens of the user and returns how many
+// sessions were active
+func (s *Service) RevokeSessions(ctx context.Context, uid int) (int, error) {
+	admin, _, err := s.target(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := s.Auth.RevokeSessions(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+	s.record(ctx, admin, models.AuditSessionsRevoked, uid, map[string]string{"revoked_sessions": strconv.Itoa(revoked)})
+	return revoked, nil
+}
+
+// UpdateEmail changes the user's email, which has to be verified again
+func (s *Service) UpdateEmail(ctx context.Context, uid int, email string) error {
+	email = strings.TrimSpace(email)
+	if _, err := mail.ParseAddress(email); err != nil {
+		return &auth.ValidationError{Violations: []auth.FieldViolation{{Field: "email", Description: "invalid email"}}}
+	}
+	admin, user, err := s.target(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if err := s.Users.UpdateUserEmail(ctx, uid, email); err != nil {
+		return mapError(err)
+	}
+	s.record(ctx, admin, models.AuditEmailChanged, uid, map[string]string{"old_email": user.Email, "new_email": email})
+	return nil
+}
+
+// Delete revokes the user's sessions and access tokens and deletes the user with their API
+// keys, linked identities and memberships
+func (s *Service) Delete(ctx context.Context, uid int) error {
+	admin, user, err := s.target(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if admin.UserID == strconv.Itoa(uid) {
+		return ErrSelfAction
+	}
+	if _, err := s.Auth.RevokeSessions(ctx, uid); err != nil {
+		return err
+	}
+	if err := s.Auth.RevokeAccessTokens(ctx, uid); err != nil {
+		return err
+	}
+	if err := s.Users.DeleteUser(ctx, uid); err != nil {
+		return mapError(err)
+	}
+	s.record(ctx, admin, models.AuditUserDeleted, uid, map[string]string{"email": user.Email})
+	return nil
+}
+
+// AuditEvents returns a page of the audit log, newest first, limited to the
+// events about userID unless it is zero
+func (s *Service) AuditEvents(ctx context.Context, userID, size int, pageToken string) ([]models.AuditEvent, string, error) {
+	if _, err := s.Auth.RequireAdmin(ctx); err != nil {
+		return nil, "", err
+	}
+	before, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	limit := pageSize(size)
+	events, err := s.Users.ListAuditEvents(ctx, userID, before, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(events) <= limit {
+		return events, "", nil
+	}
+	events = events[:limit]
+	return events, encodePageToken(events[limit-1].ID), nil
+}
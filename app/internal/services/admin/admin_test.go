@@ -0,0 +1,152 @@
+package admin_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/services/admin"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/testutil"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func userContext(uid int) context.Context {
+	return jwtman.NewContext(context.Background(), &jwtman.Claims{UserID: strconv.Itoa(uid)})
+}
+
+func newService(t *testing.T) (*admin.Service, *testutil.UserStore) {
+	t.Helper()
+	users := &testutil.UserStore{Admins: []int{1}}
+	sessions := testutil.NewSessionStore()
+	jwt := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), users, sessions, jwt)
+	authSvc.Sessions = sessions
+	for _, email := range []string{"admin@example.com", "alice@example.com", "bob@example.com"} {
+		if err := authSvc.Register(context.Background(), models.NewUser{Email: email, HashPass: []byte("examplepass")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return admin.NewService(slog.Default(), authSvc, users), users
+}
+
+func TestService_ListUsers(t *testing.T) {
+	svc, _ := newService(t)
+	adminCtx := userContext(1)
+
+	if _, _, err := svc.ListUsers(userContext(2), models.UserFilter{}, ""); !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Fatalf("expected non-admins to be denied, got %v", err)
+	}
+	users, next, err := svc.ListUsers(adminCtx, models.UserFilter{Limit: 2}, "")
+	if err != nil || len(users) != 2 || next == "" {
+		t.Fatalf("expected a first page of 2 users, got %d %q %v", len(users), next, err)
+	}
+	users, next, err = svc.ListUsers(adminCtx, models.UserFilter{Limit: 2}, next)
+	if err != nil || len(users) != 1 || users[0].Email != "bob@example.com" || next != "" {
+		t.Errorf("expected the last page with bob, got %+v %q %v", users, next, err)
+	}
+	var verr *auth.ValidationError
+	if _, _, err := svc.ListUsers(adminCtx, models.UserFilter{}, "not a token"); !errors.As(err, &verr) {
+		t.Errorf("expected a validation error for a bad page token, got %v", err)
+	}
+	if users, _, _ := svc.ListUsers(adminCtx, models.UserFilter{Query: "alice"}, ""); len(users) != 1 {
+		t.Errorf("expected the query to match alice only, got %+v", users)
+	}
+}
+
+func TestService_DisableUser(t *testing.T) {
+	svc, users := newService(t)
+	adminCtx := userContext(1)
+	alice := models.NewUser{Email: "alice@example.com", HashPass: []byte("examplepass")}
+
+	tokens, err := svc.Auth.Login(context.Background(), alice, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	details, err := svc.GetUser(adminCtx, 2)
+	if err != nil || len(details.Sessions) != 1 || len(details.Factors) != 1 || details.Factors[0].Type != admin.FactorPassword {
+		t.Fatalf("expected one session and the password factor, got %+v: %v", details, err)
+	}
+
+	var verr *auth.ValidationError
+	if err := svc.SetStatus(adminCtx, 2, "banned", ""); !errors.As(err, &verr) {
+		t.Errorf("expected a validation error for an unknown status, got %v", err)
+	}
+	if err := svc.Disable(adminCtx, 1, "oops"); !errors.Is(err, admin.ErrSelfAction) {
+		t.Errorf("expected ErrSelfAction, got %v", err)
+	}
+	if err := svc.Disable(adminCtx, 2, "compromised"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := svc.Auth.Refresh(context.Background(), tokens.RefreshToken, auth.TokenBinding{}); err == nil {
+		t.Error("expected the sessions of a disabled user to be revoked")
+	}
+	if _, err := svc.Auth.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: tokens.AccessToken}); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("expected the access token of a disabled user to be revoked, got %v", err)
+	}
+	if _, err := svc.Auth.Login(context.Background(), alice, auth.TokenBinding{}); !errors.Is(err, auth.ErrAccountDisabled) {
+		t.Errorf("expected ErrAccountDisabled, got %v", err)
+	}
+	if disabled, _, _ := svc.ListUsers(adminCtx, models.UserFilter{Status: models.UserDisabled}, ""); len(disabled) != 1 || disabled[0].UID != 2 {
+		t.Errorf("expected alice to be listed as disabled, got %+v", disabled)
+	}
+
+	if err := svc.Enable(adminCtx, 2); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// enabling doesn't bring back the tokens revoked by the disable
+	if _, err := svc.Auth.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: tokens.AccessToken}); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("expected the access token to stay revoked after enable, got %v", err)
+	}
+	if tokens, err = svc.Auth.Login(context.Background(), alice, auth.TokenBinding{}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	password, err := svc.ResetPassword(adminCtx, 2, "")
+	if err != nil || password == "" {
+		t.Fatalf("expected a generated password, got %q %v", password, err)
+	}
+	if _, err := svc.Auth.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: tokens.AccessToken}); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("expected the access token to be revoked on password reset, got %v", err)
+	}
+	// tokens of the second of the revocation are revoked as well
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	if tokens, err = svc.Auth.Login(context.Background(), models.NewUser{Email: alice.Email, HashPass: []byte(password)}, auth.TokenBinding{}); err != nil {
+		t.Errorf("expected the generated password to work, got %v", err)
+	}
+
+	if err := svc.UpdateEmail(adminCtx, 2, "bob@example.com"); !errors.Is(err, auth.ErrEmailTaken) {
+		t.Errorf("expected ErrEmailTaken, got %v", err)
+	}
+	if err := svc.Delete(adminCtx, 2); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := svc.Auth.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: tokens.AccessToken}); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("expected the access token to be revoked on deletion, got %v", err)
+	}
+	if _, err := svc.GetUser(adminCtx, 2); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	events, _, err := svc.AuditEvents(adminCtx, 2, 0, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+		if e.Actor != "1" {
+			t.Errorf("expected the admin as actor, got %q", e.Actor)
+		}
+	}
+	want := []string{models.AuditUserDeleted, models.AuditPasswordReset, models.AuditUserEnabled, models.AuditUserDisabled}
+	if !slices.Equal(actions, want) {
+		t.Errorf("expected audit events %v, got %v", want, actions)
+	}
+	if users.Events[0].Details["reason"] != "compromised" {
+		t.Errorf("expected the reason to be recorded, got %+v", users.Events[0].Details)
+	}
+}
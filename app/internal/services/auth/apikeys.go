@@ -0,0 +1,201 @@
+package auth
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/storage"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"hash/crc32"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// API keys look like ak_<30 random base62 characters><6 character checksum>.
+// The prefix lets secret scanners find leaked keys and the CRC32 checksum
+// rejects mistyped keys without a database lookup.
+const (
+	APIKeyPrefix      = "ak_"
+	apiKeyRandomLen   = 30
+	apiKeyChecksumLen = 6
+	apiKeyLen         = len(APIKeyPrefix) + apiKeyRandomLen + apiKeyChecksumLen
+	// apiKeyHintLen is how much of the key is kept to recognize it
+	apiKeyHintLen = len(APIKeyPrefix) + 4
+
+	// APIKeyScopeAdmin lets a key of an administrator call admin methods
+	APIKeyScopeAdmin = "admin"
+)
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// scopeToken is the scope-token syntax of RFC 6749 section 3.3
+var scopeToken = regexp.MustCompile(`^[\x21\x23-\x5b\x5d-\x7e]+$`)
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash []byte) (models.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID int, id string) error
+	TouchAPIKey(ctx context.Context, id string) error
+}
+
+func apiKeyChecksum(random string) string {
+	sum := crc32.ChecksumIEEE([]byte(random))
+	b := make([]byte, apiKeyChecksumLen)
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = base62[sum%62]
+		sum /= 62
+	}
+	return string(b)
+}
+
+func newAPIKey() string {
+	random := make([]byte, 0, apiKeyRandomLen)
+	buf := make([]byte, apiKeyRandomLen)
+	for len(random) < apiKeyRandomLen {
+		_, _ = rand.Read(buf)
+		for _, c := range buf {
+			// rejection sampling keeps the characters uniform
+			if c < 248 && len(random) < apiKeyRandomLen {
+				random = append(random, base62[c%62])
+			}
+		}
+	}
+	return APIKeyPrefix + string(random) + apiKeyChecksum(string(random))
+}
+
+// validAPIKey checks the format and checksum of a presented key
+func validAPIKey(key string) bool {
+	if len(key) != apiKeyLen || !strings.HasPrefix(key, APIKeyPrefix) {
+		return false
+	}
+	random := key[len(APIKeyPrefix) : len(APIKeyPrefix)+apiKeyRandomLen]
+	return apiKeyChecksum(random) == key[len(APIKeyPrefix)+apiKeyRandomLen:]
+}
+
+// keys are long random strings, so a fast hash is enough to store them
+func hashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// CreateAPIKey issues a key for the user. A zero expiresAt means the longest
+// lifetime allowed. The key is returned once and only its hash is stored.
+func (auth *Auth) CreateAPIKey(ctx context.Context, userID int, name string, scopes []string, expiresAt time.Time) (models.APIKey, string, error) {
+	now := time.Now()
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(auth.APIKeyMaxTTL)
+	}
+	verr := &ValidationError{}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		verr.Add("name", "name is required")
+	} else if len(name) > 100 {
+		verr.Add("name", "name must be at most 100 characters")
+	}
+	if len(scopes) == 0 {
+		verr.Add("scopes", "at least one scope is required")
+	}
+	for _, s := range scopes {
+		if !scopeToken.MatchString(s) {
+			verr.Add("scopes", "invalid scope "+strconv.Quote(s))
+		}
+	}
+	if !expiresAt.After(now) {
+		verr.Add("expires_at", "expires_at must be in the future")
+	} else if expiresAt.After(now.Add(auth.APIKeyMaxTTL + time.Minute)) {
+		verr.Add("expires_at", "keys can be valid for at most "+auth.APIKeyMaxTTL.String())
+	}
+	if err := verr.Err(); err != nil {
+		return models.APIKey{}, "", err
+	}
+	if auth.APIKeys == nil {
+		return models.APIKey{}, "", ErrPermissionDenied
+	}
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+	secret := newAPIKey()
+	key, err := auth.APIKeys.CreateAPIKey(ctx, models.APIKey{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      name,
+		Hash:      hashAPIKey(secret),
+		Hint:      secret[:apiKeyHintLen],
+		Scopes:    slices.Compact(scopes),
+		ExpiresAt: expiresAt.UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	auth.Logger.Info("API key created", slog.Int("uid", userID), slog.String("key_id", key.ID))
+	return key, secret, nil
+}
+
+func (auth *Auth) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	if auth.APIKeys == nil {
+		return nil, ErrPermissionDenied
+	}
+	return auth.APIKeys.ListAPIKeys(ctx, userID)
+}
+
+// RevokeAPIKey deletes a key of the user
+func (auth *Auth) RevokeAPIKey(ctx context.Context, userID int, id string) error {
+	if auth.APIKeys == nil {
+		return ErrPermissionDenied
+	}
+	if err := auth.APIKeys.DeleteAPIKey(ctx, userID, id); err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	auth.Logger.Info("API key revoked", slog.Int("uid", userID), slog.String("key_id", id))
+	return nil
+}
+
+// verifyAPIKey returns claims for the key like those of an access token of
+// its user, restricted to the key's scopes
+func (auth *Auth) verifyAPIKey(ctx context.Context, token string) (*jwtman.Claims, error) {
+	if auth.APIKeys == nil || !validAPIKey(token) {
+		return nil, ErrTokenInvalid
+	}
+	key, err := auth.APIKeys.GetAPIKeyByHash(ctx, hashAPIKey(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if !time.Now().Before(key.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if err := auth.requireActive(ctx, key.UserID); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if err := auth.APIKeys.TouchAPIKey(ctx, key.ID); err != nil {
+		auth.Logger.Warn("Recording api key use failed", slog.String("key_id", key.ID), slog.Any("error", err))
+	}
+
+	claims := &jwtman.Claims{
+		UserID:   strconv.Itoa(key.UserID),
+		Scope:    strings.Join(key.Scopes, " "),
+		APIKeyID: key.ID,
+	}
+	claims.ID = key.ID
+	claims.IssuedAt = jwt.NewNumericDate(key.CreatedAt)
+	claims.ExpiresAt = jwt.NewNumericDate(key.ExpiresAt)
+	return claims, nil
+}
@@ -2,15 +2,25 @@ package auth
 
 import (
 	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/dpop"
 	"auth_service/internal/JWT/refresh"
 	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/storage"
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/mail"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/redis/go-redis/v9"
@@ -18,6 +28,7 @@ import (
 
 type UserRepository interface {
 	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	GetUserByID(ctx context.Context, UID int) (models.User, error)
 	CreateNewUser(ctx context.Context, newUser models.NewUser) error
 	IsAdmin(ctx context.Context, UID int) bool
 }
@@ -31,18 +42,80 @@ type SessionStorage interface {
 type Auth struct {
 	Logger  *slog.Logger
 	Storage UserRepository
-	JWT     *jwtman.JWTManager
-	Redis   SessionStorage
+	// JWT signs access tokens of requests without a realm; see TokenManager
+	JWT   *jwtman.JWTManager
+	Redis SessionStorage
+	// Credentials checks passwords at login, by default against Storage
+	Credentials CredentialChecker
+	// DPoP verifies proof of possession proofs; nil disables DPoP
+	DPoP *dpop.Verifier
+	// APIKeys stores personal access tokens
+	APIKeys APIKeyRepository
+	// APIKeyMaxTTL is the longest lifetime of an API key
+	APIKeyMaxTTL time.Duration
+	// Memberships updates the organization role of tokens on refresh; nil
+	// keeps the role of the session
+	Memberships MembershipRepository
+	// Sessions indexes the refresh tokens of each user; nil disables listing
+	// and revoking the sessions of a user
+	Sessions SessionIndex
+}
+
+// MembershipRepository looks up the role of a user in an organization
+type MembershipRepository interface {
+	GetMembership(ctx context.Context, orgID string, userID int) (models.Membership, error)
 }
 
 type AuthResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope,omitempty"`
+	// Grant the tokens were issued for
+	Grant Grant `json:"-"`
+}
+
+// Grant is the user, and for OAuth clients the client and scopes, a token
+// pair is issued for
+type Grant struct {
+	UserID   int
+	ClientID string
+	Scope    string
+	// AuthTime is when the user authenticated. Tokens reissued without a new
+	// login carry it over; zero if unknown, so the tokens have no auth_time
+	// and fail recent sign-in checks.
+	AuthTime time.Time
+	// OrgID is the active organization and OrgRole the user's role in it
+	OrgID   string
+	OrgRole string
 }
 
 // Init service logic floor
 func NewAuth(logger *slog.Logger, Storage UserRepository, Redis SessionStorage, JWT *jwtman.JWTManager) *Auth {
-	return &Auth{Logger: logger, Storage: Storage, JWT: JWT, Redis: Redis}
+	return &Auth{
+		Logger:       logger,
+		Storage:      Storage,
+		JWT:          JWT,
+		Redis:        Redis,
+		Credentials:  &LocalCredentials{Logger: logger, Users: Storage},
+		APIKeyMaxTTL: 365 * 24 * time.Hour,
+	}
+}
+
+// TokenManager returns the access token keys of the realm of ctx
+func (auth *Auth) TokenManager(ctx context.Context) *jwtman.JWTManager {
+	if r := realm.FromContext(ctx); r != nil && r.JWT != nil {
+		return r.JWT
+	}
+	return auth.JWT
+}
+
+// refreshTTL is the lifetime of refresh tokens in the realm of ctx
+func (auth *Auth) refreshTTL(ctx context.Context) time.Duration {
+	if r := realm.FromContext(ctx); r != nil && r.Session.RefreshTokenTTL > 0 {
+		return r.Session.RefreshTokenTTL
+	}
+	return auth.TokenManager(ctx).TokenDuration
 }
 
 func HashPassword(password []byte) ([]byte, error) {
@@ -54,8 +127,39 @@ func CheckPasswordHash(password, hash []byte) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
 
+func validateCredentials(user models.NewUser, checkFormat bool) error {
+	verr := &ValidationError{}
+	if user.Email == "" {
+		verr.Add("email", "email is required")
+	} else if _, err := mail.ParseAddress(user.Email); checkFormat && err != nil {
+		verr.Add("email", "invalid email")
+	}
+	if len(user.HashPass) == 0 {
+		verr.Add("password", "password is required")
+	}
+	return verr.Err()
+}
+
+// CheckPasswordPolicy checks a new password against the policy of the realm
+func CheckPasswordPolicy(ctx context.Context, password []byte) error {
+	if r := realm.FromContext(ctx); r != nil && utf8.RuneCount(password) < r.Password.MinLength {
+		return &ValidationError{Violations: []FieldViolation{{
+			Field:       "password",
+			Description: fmt.Sprintf("password must have at least %d characters", r.Password.MinLength),
+		}}}
+	}
+	return nil
+}
+
 // Creating new user
 func (auth *Auth) Register(ctx context.Context, user models.NewUser) error {
+	if err := validateCredentials(user, true); err != nil {
+		return err
+	}
+	if err := CheckPasswordPolicy(ctx, user.HashPass); err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
@@ -65,101 +169,415 @@ func (auth *Auth) Register(ctx context.Context, user models.NewUser) error {
 	}
 	user.HashPass = hashed
 
-	return auth.Storage.CreateNewUser(ctx, user)
+	if err := auth.Storage.CreateNewUser(ctx, user); err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			return ErrEmailTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// Authenticate checks the user's login name and password with the
+// credential backend
+func (auth *Auth) Authenticate(ctx context.Context, user models.NewUser) (models.User, error) {
+	if err := validateCredentials(user, false); err != nil {
+		return models.User{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	storedUser, err := auth.Credentials.CheckCredentials(ctx, user)
+	if err != nil {
+		return models.User{}, err
+	}
+	// checked after the password, so the status of an account isn't
+	// revealed to callers who don't know it
+	if err := StatusError(storedUser); err != nil {
+		auth.Logger.Info("Login of inactive user rejected", slog.Int("uid", storedUser.UID), slog.String("status", storedUser.Status))
+		return models.User{}, err
+	}
+	return storedUser, nil
 }
 
 // Getting pair of refresh + access tokens
-func (auth *Auth) Login(ctx context.Context, user models.NewUser) (*AuthResponse, error) {
+func (auth *Auth) Login(ctx context.Context, user models.NewUser, binding TokenBinding) (*AuthResponse, error) {
+	storedUser, err := auth.Authenticate(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return auth.IssueTokens(ctx, Grant{UserID: storedUser.UID, AuthTime: time.Now()}, binding)
+}
+
+// IssueTokens creates a token pair for an already authenticated grant. Only
+// active users get tokens.
+func (auth *Auth) IssueTokens(ctx context.Context, grant Grant, binding TokenBinding) (*AuthResponse, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	storedUser, err := auth.Storage.GetUserByEmail(ctx, user.Email)
-	if err != nil {
+	if err := auth.requireActive(ctx, grant.UserID); err != nil {
 		return nil, err
 	}
 
-	if ok := CheckPasswordHash(user.HashPass, storedUser.HashPass); !ok {
-		auth.Logger.Info("Wrong password from user", slog.String(user.Email, ""))
-		return nil, errors.New("wrong password")
+	jkt, err := auth.proofKey(ctx, binding, "")
+	if err != nil {
+		return nil, err
 	}
+	return auth.issue(ctx, grant, binding.CertThumbprint, jkt)
+}
 
-	accessToken, err := auth.JWT.GenerateAccessToken(storedUser.UID)
+func (auth *Auth) issue(ctx context.Context, grant Grant, certThumbprint, jkt string) (*AuthResponse, error) {
+	opts := append(tokenOptions(certThumbprint, jkt), jwtman.WithScope(grant.Scope), jwtman.WithClientID(grant.ClientID),
+		jwtman.WithOrganization(grant.OrgID, grant.OrgRole), jwtman.WithAuthTime(grant.AuthTime))
+	accessToken, err := auth.TokenManager(ctx).GenerateAccessToken(grant.UserID, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	refreshToken := refresh.GenerateRefreshToken()
-	struid := strconv.Itoa(storedUser.UID)
-	if err := auth.StoreRefreshToken(ctx, struid, refreshToken); err != nil {
+	struid := strconv.Itoa(grant.UserID)
+	session := models.Session{UserID: struid, JKT: jkt, ClientID: grant.ClientID, Scope: grant.Scope, CreatedAt: time.Now(), AuthTime: grant.AuthTime, Realm: realm.Name(ctx), OrgID: grant.OrgID, OrgRole: grant.OrgRole}
+	if err := auth.StoreRefreshToken(ctx, refreshToken, session); err != nil {
 		return nil, err
 	}
 
 	auth.Logger.Debug("Token created succesfully", slog.String("user_id", struid))
-	return &AuthResponse{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+	return &AuthResponse{AccessToken: accessToken, RefreshToken: refreshToken, TokenType: tokenType(jkt), Scope: grant.Scope, Grant: grant}, nil
+}
+
+// IssueClientToken creates an access token for an OAuth client acting on its
+// own behalf. No refresh token is issued; the client can authenticate again.
+func (auth *Auth) IssueClientToken(ctx context.Context, clientID, scope string, binding TokenBinding) (*AuthResponse, error) {
+	jkt, err := auth.proofKey(ctx, binding, "")
+	if err != nil {
+		return nil, err
+	}
+	opts := append(tokenOptions(binding.CertThumbprint, jkt), jwtman.WithScope(scope))
+	accessToken, err := auth.TokenManager(ctx).GenerateClientToken(clientID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	auth.Logger.Debug("Client token created", slog.String("client_id", clientID))
+	return &AuthResponse{AccessToken: accessToken, TokenType: tokenType(jkt), Scope: scope}, nil
+}
+
+// IssueServiceAccountToken creates an access token for a service account
+// carrying its roles. No refresh token is issued; the account can sign a new
+// assertion.
+func (auth *Auth) IssueServiceAccountToken(ctx context.Context, accountID string, roles []string, binding TokenBinding) (*AuthResponse, error) {
+	jkt, err := auth.proofKey(ctx, binding, "")
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := auth.TokenManager(ctx).GenerateServiceAccountToken(accountID, roles, tokenOptions(binding.CertThumbprint, jkt)...)
+	if err != nil {
+		return nil, err
+	}
+	auth.Logger.Debug("Service account token created", slog.String("account_id", accountID))
+	return &AuthResponse{AccessToken: accessToken, TokenType: tokenType(jkt)}, nil
+}
+
+// Exchange is a token requested by a client through token exchange for the
+// subject of another token
+type Exchange struct {
+	Subject  *jwtman.Claims
+	Actor    *jwtman.Actor
+	ClientID string
+	Scope    string
+	Audience []string
+	// Keys of the token request, which the exchanged token is bound to
+	Keys ProofKeys
+}
+
+// IssueExchangedToken creates an access token for a token exchange. No
+// refresh token is issued.
+func (auth *Auth) IssueExchangedToken(ctx context.Context, exchange Exchange) (*AuthResponse, error) {
+	opts := append(tokenOptions(exchange.Keys.CertThumbprint, exchange.Keys.JKT),
+		jwtman.WithScope(exchange.Scope),
+		jwtman.WithClientID(exchange.ClientID),
+		jwtman.WithAudience(exchange.Audience),
+		jwtman.WithActor(exchange.Actor),
+	)
+	accessToken, err := auth.TokenManager(ctx).GenerateExchangedToken(exchange.Subject, opts...)
+	if err != nil {
+		return nil, err
+	}
+	auth.Logger.Debug("Exchanged token created", slog.String("client_id", exchange.ClientID),
+		slog.String("sub", exchange.Subject.SubjectID()), slog.Any("aud", exchange.Audience))
+	return &AuthResponse{AccessToken: accessToken, TokenType: tokenType(exchange.Keys.JKT), Scope: exchange.Scope}, nil
 }
 
 // Saving refresh token in redis
-func (auth *Auth) StoreRefreshToken(ctx context.Context, UID, refreshToken string) error {
+func (auth *Auth) StoreRefreshToken(ctx context.Context, refreshToken string, session models.Session) error {
 	key := fmt.Sprintf("refresh:%s", refreshToken)
-	return auth.Redis.SetSession(ctx, key, UID, auth.JWT.TokenDuration)
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if err := auth.Redis.SetSession(ctx, key, string(value), auth.refreshTTL(ctx)); err != nil {
+		return err
+	}
+	auth.indexSession(ctx, session.UserID, refreshToken)
+	return nil
 }
 
 // Verify incoming refresh token
-func (auth *Auth) VerifyRefreshToken(ctx context.Context, refreshToken string) (string, error) {
+func (auth *Auth) VerifyRefreshToken(ctx context.Context, refreshToken string) (models.Session, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	key := fmt.Sprintf("refresh:%s", refreshToken)
-	userID, err := auth.Redis.GetSession(ctx, key)
-	if err == redis.Nil {
-		return "", errors.New("invalid or expired refresh token")
+	value, err := auth.Redis.GetSession(ctx, key)
+	if errors.Is(err, redis.Nil) {
+		// Rotated tokens are remembered for their remaining lifetime, so a
+		// second use of the same token can be told apart from an expired one
+		usedBy, usedErr := auth.Redis.GetSession(ctx, fmt.Sprintf("refresh_used:%s", refreshToken))
+		if usedErr == nil {
+			auth.Logger.Warn("Refresh token reuse detected", slog.String("user_id", usedBy))
+			return models.Session{}, ErrTokenReused
+		}
+		return models.Session{}, ErrTokenExpired
 	}
-	return userID, err
+	if err != nil {
+		return models.Session{}, err
+	}
+	return decodeSession(value)
+}
+
+// decodeSession also accepts sessions stored as a bare user id
+func decodeSession(value string) (models.Session, error) {
+	if !strings.HasPrefix(value, "{") {
+		return models.Session{UserID: value}, nil
+	}
+	var session models.Session
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return models.Session{}, fmt.Errorf("invalid stored session: %w", err)
+	}
+	return session, nil
 }
 
 // Creating new pair of refresh + access tokens
-func (auth *Auth) Refresh(ctx context.Context, refreshToken string) (*AuthResponse, error) {
+func (auth *Auth) Refresh(ctx context.Context, refreshToken string, binding TokenBinding) (*AuthResponse, error) {
+	return auth.RefreshGrant(ctx, refreshToken, "", binding)
+}
+
+// RefreshGrant rotates a refresh token issued to the OAuth client. First
+// party sessions have an empty client id and can't be refreshed by clients.
+func (auth *Auth) RefreshGrant(ctx context.Context, refreshToken, clientID string, binding TokenBinding) (*AuthResponse, error) {
+	if refreshToken == "" {
+		return nil, &ValidationError{Violations: []FieldViolation{{Field: "refresh_token", Description: "refresh token is required"}}}
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	userID, err := auth.VerifyRefreshToken(ctx, refreshToken)
+	session, err := auth.VerifyRefreshToken(ctx, refreshToken)
 	if err != nil {
 		return nil, err
 	}
+	if session.ClientID != clientID {
+		auth.Logger.Warn("Refresh token used by another client", slog.String("client_id", clientID))
+		return nil, ErrTokenInvalid
+	}
+	if cmp.Or(session.Realm, realm.Default) != realm.Name(ctx) {
+		auth.Logger.Warn("Refresh token used in another realm", slog.String("realm", realm.Name(ctx)))
+		return nil, ErrTokenInvalid
+	}
+	userID := session.UserID
 	uid, err := strconv.Atoi(userID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid stored user id: %w", err)
 	}
+	// the user may have been disabled or deleted since the session started
+	if err := auth.requireActive(ctx, uid); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
 
-	accessToken, err := auth.JWT.GenerateAccessToken(uid)
+	// A session bound to a DPoP key can only be refreshed with a proof
+	// signed by the same key
+	jkt, err := auth.proofKey(ctx, binding, "")
 	if err != nil {
 		return nil, err
 	}
-	auth.Logger.Debug("Created new token", slog.String("user_id", userID))
+	if session.JKT != "" && session.JKT != jkt {
+		auth.Logger.Warn("DPoP bound refresh token used without its key", slog.String("user_id", userID))
+		return nil, ErrBindingMismatch
+	}
+
+	grant := Grant{UserID: uid, ClientID: session.ClientID, Scope: session.Scope, AuthTime: session.AuthTime, OrgID: session.OrgID, OrgRole: session.OrgRole}
+	if grant.OrgID != "" && auth.Memberships != nil {
+		// the role may have changed, or the user left, since the session
+		// started
+		membership, err := auth.Memberships.GetMembership(ctx, grant.OrgID, uid)
+		switch {
+		case errors.Is(err, storage.ErrMemberNotFound):
+			grant.OrgID, grant.OrgRole = "", ""
+		case err != nil:
+			return nil, err
+		default:
+			grant.OrgRole = membership.Role
+		}
+	}
+
 	oldKey := fmt.Sprintf("refresh:%s", refreshToken)
 	if err := auth.Redis.DeleteSession(ctx, oldKey); err != nil {
 		auth.Logger.Warn("Failed delete previous refresh token", slog.String("refresh", refreshToken))
 	}
-	newRefreshToken := refresh.GenerateRefreshToken()
-
-	if err := auth.StoreRefreshToken(ctx, userID, newRefreshToken); err != nil {
-		return nil, err
+	if auth.Sessions != nil {
+		if err := auth.Sessions.RemoveFromIndex(ctx, userSessionsKey(userID), refreshToken); err != nil {
+			auth.Logger.Warn("Failed remove previous refresh token from index", slog.Any("error", err))
+		}
 	}
-	return &AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: newRefreshToken,
-	}, nil
+	usedKey := fmt.Sprintf("refresh_used:%s", refreshToken)
+	if err := auth.Redis.SetSession(ctx, usedKey, userID, auth.refreshTTL(ctx)); err != nil {
+		auth.Logger.Warn("Failed mark refresh token as used", slog.Any("error", err))
+	}
+
+	auth.Logger.Debug("Created new token", slog.String("user_id", userID))
+	return auth.issue(ctx, grant, binding.CertThumbprint, jkt)
 }
 
 // Deleting refresh token
 func (auth *Auth) Logout(ctx context.Context, refreshToken string) error {
+	if refreshToken == "" {
+		return &ValidationError{Violations: []FieldViolation{{Field: "refresh_token", Description: "refresh token is required"}}}
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	key := fmt.Sprintf("refresh:%s", refreshToken)
-	err := auth.Redis.DeleteSession(ctx, key)
-	if err != nil {
+	// the session tells whose index the token has to leave
+	value, err := auth.Redis.GetSession(ctx, key)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if err := auth.Redis.DeleteSession(ctx, key); err != nil {
 		return err
 	}
+	if session, err := decodeSession(value); err == nil && session.UserID != "" && auth.Sessions != nil {
+		if err := auth.Sessions.RemoveFromIndex(ctx, userSessionsKey(session.UserID), refreshToken); err != nil {
+			auth.Logger.Warn("Failed remove refresh token from index", slog.Any("error", err))
+		}
+	}
 
 	auth.Logger.Debug("User logout", slog.String("refresh_token", refreshToken))
 	return nil
 }
+
+// Verify access token and its binding to the presenting key. API keys are
+// accepted as bearer tokens too.
+func (auth *Auth) VerifyAccessToken(ctx context.Context, presented PresentedToken) (*jwtman.Claims, error) {
+	if presented.Token == "" {
+		return nil, ErrUnauthenticated
+	}
+	if strings.HasPrefix(presented.Token, APIKeyPrefix) {
+		if presented.Scheme == dpop.Scheme {
+			return nil, ErrTokenInvalid
+		}
+		return auth.verifyAPIKey(ctx, presented.Token)
+	}
+
+	claims, err := auth.verifyToken(ctx, presented.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	var jkt string
+	if claims.BoundToDPoP() {
+		if presented.Scheme != "" && presented.Scheme != dpop.Scheme {
+			return nil, ErrBindingMismatch
+		}
+		if jkt, err = auth.proofKey(ctx, presented.Binding, presented.Token); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := claims.VerifyBinding(presented.Binding.CertThumbprint, jkt); err != nil {
+		auth.Logger.Warn("Bound token presented with another key", slog.String("jti", claims.ID))
+		return nil, ErrBindingMismatch
+	}
+	return claims, nil
+}
+
+// VerifyExchangeToken checks a subject or actor token of a token exchange
+// like VerifyAccessToken checks access tokens. As the exchange issues a new
+// token, the user has to be active as well. Bound tokens have to be presented
+// with the keys of the token request.
+func (auth *Auth) VerifyExchangeToken(ctx context.Context, token string, keys ProofKeys) (*jwtman.Claims, error) {
+	claims, err := auth.verifyToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if uid, err := strconv.Atoi(claims.UserID); err == nil {
+		if err := auth.requireActive(ctx, uid); err != nil {
+			return nil, err
+		}
+	}
+	if err := claims.VerifyBinding(keys.CertThumbprint, keys.JKT); err != nil {
+		auth.Logger.Warn("Bound token exchanged without its key", slog.String("jti", claims.ID))
+		return nil, ErrBindingMismatch
+	}
+	return claims, nil
+}
+
+// verifyToken checks the signature and expiry of an access token and that it
+// was not revoked
+func (auth *Auth) verifyToken(ctx context.Context, token string) (*jwtman.Claims, error) {
+	claims, err := auth.TokenManager(ctx).VerifyToken(token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrTokenInvalid
+	}
+
+	revoked, err := auth.tokenRevoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// RequireAdmin returns the claims of the caller if it is an administrator
+// using a first party token, an API key with the admin scope or a service
+// account with the admin role
+func (auth *Auth) RequireAdmin(ctx context.Context) (*jwtman.Claims, error) {
+	claims, ok := jwtman.FromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if _, ok := claims.ServiceAccountID(); ok {
+		if claims.Act != nil || !slices.Contains(claims.Roles, models.RoleAdmin) {
+			return nil, ErrPermissionDenied
+		}
+		return claims, nil
+	}
+	if claims.APIKeyID != "" && !slices.Contains(strings.Fields(claims.Scope), APIKeyScopeAdmin) {
+		return nil, ErrPermissionDenied
+	}
+	uid, err := strconv.Atoi(claims.UserID)
+	if err != nil || claims.ClientID != "" || !auth.Storage.IsAdmin(ctx, uid) {
+		return nil, ErrPermissionDenied
+	}
+	return claims, nil
+}
+
+// RequireUser returns the id of the calling user. Only first party tokens of
+// the user itself qualify: OAuth clients, delegated tokens and API keys
+// can't act for the account.
+func (auth *Auth) RequireUser(ctx context.Context) (int, error) {
+	claims, ok := jwtman.FromContext(ctx)
+	if !ok {
+		return 0, ErrUnauthenticated
+	}
+	uid, err := strconv.Atoi(claims.UserID)
+	if err != nil || claims.ClientID != "" || claims.Act != nil || claims.APIKeyID != "" {
+		return 0, ErrPermissionDenied
+	}
+	return uid, nil
+}
@@ -2,13 +2,27 @@ package auth_test
 
 import (
 	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/dpop"
+	"auth_service/internal/JWT/jwk"
 	"auth_service/internal/models"
+	"auth_service/internal/realm"
 	"auth_service/internal/services/auth"
+	"auth_service/internal/storage"
+	"auth_service/internal/testutil"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
 	"log/slog"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -20,6 +34,10 @@ func (m *MockStorage) GetUserByEmail(ctx context.Context, email string) (models.
 	return m.user, nil
 }
 
+func (m *MockStorage) GetUserByID(ctx context.Context, UID int) (models.User, error) {
+	return m.user, nil
+}
+
 func (m *MockStorage) CreateNewUser(ctx context.Context, user models.NewUser) error {
 	m.user = models.User{UID: 1, Email: user.Email, HashPass: user.HashPass}
 	return nil
@@ -36,7 +54,7 @@ func (r *MockRedisStorage) SetSession(ctx context.Context, key string, userID st
 }
 
 func (r *MockRedisStorage) GetSession(ctx context.Context, token string) (string, error) {
-	return "", nil
+	return "", redis.Nil
 }
 
 func (r *MockRedisStorage) DeleteSession(ctx context.Context, token string) error {
@@ -63,7 +81,7 @@ func TestAuthService_Login(t *testing.T) {
 
 	user := models.NewUser{Email: "test123@example.com", HashPass: []byte("examplepass")}
 
-	token, err := authSvc.Login(ctx, user)
+	token, err := authSvc.Login(ctx, user, auth.TokenBinding{})
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -73,6 +91,37 @@ func TestAuthService_Login(t *testing.T) {
 	cancel()
 }
 
+func TestAuthService_Logout(t *testing.T) {
+	users := &testutil.UserStore{}
+	sessions := testutil.NewSessionStore()
+	jwt := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), users, sessions, jwt)
+	authSvc.Sessions = sessions
+	ctx := context.Background()
+	user := models.NewUser{Email: "test123@example.com", HashPass: []byte("examplepass")}
+	if err := authSvc.Register(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := authSvc.Login(ctx, user, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := authSvc.Logout(ctx, tokens.RefreshToken); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := authSvc.Refresh(ctx, tokens.RefreshToken, auth.TokenBinding{}); err == nil {
+		t.Error("expected the logged out refresh token to be rejected")
+	}
+	if members, _ := sessions.IndexMembers(ctx, "user_sessions:1"); len(members) != 0 {
+		t.Errorf("expected the session to leave the index, got %v", members)
+	}
+	// unknown tokens are logged out already
+	if err := authSvc.Logout(ctx, "unknown"); err != nil {
+		t.Errorf("expected no error for an unknown token, got %v", err)
+	}
+}
+
 func TestAuthService_Register(t *testing.T) {
 	mockStorage := &MockStorage{}
 	email := "example@password"
@@ -99,3 +148,389 @@ func TestAuthService_Register(t *testing.T) {
 		t.Error("password checks incorrectly")
 	}
 }
+
+func TestAuthService_LoginWrongPassword(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("examplepass"), bcrypt.DefaultCost)
+	mockStorage := &MockStorage{
+		user: models.User{UID: 1, Email: "test123@example.com", HashPass: hash},
+	}
+	jwt := &jwtman.JWTManager{
+		SecretKey:     []byte("test"),
+		TokenDuration: 15 * time.Minute,
+	}
+	authSvc := auth.NewAuth(slog.Default(), mockStorage, &MockRedisStorage{}, jwt)
+
+	user := models.NewUser{Email: "test123@example.com", HashPass: []byte("wrongpass")}
+	if _, err := authSvc.Login(context.Background(), user, auth.TokenBinding{}); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestAuthService_RegisterInvalidEmail(t *testing.T) {
+	jwt := &jwtman.JWTManager{
+		SecretKey:     []byte("test"),
+		TokenDuration: 15 * time.Minute,
+	}
+	authSvc := auth.NewAuth(slog.Default(), &MockStorage{}, &MockRedisStorage{}, jwt)
+
+	err := authSvc.Register(context.Background(), models.NewUser{Email: "not-an-email", HashPass: []byte("pass")})
+	var verr *auth.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	if len(verr.Violations) != 1 || verr.Violations[0].Field != "email" {
+		t.Errorf("unexpected violations %v", verr.Violations)
+	}
+}
+
+func TestAuthService_VerifyAccessToken_CertBound(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("examplepass"), bcrypt.DefaultCost)
+	mockStorage := &MockStorage{
+		user: models.User{UID: 1, Email: "test123@example.com", HashPass: hash},
+	}
+	jwt := &jwtman.JWTManager{
+		SecretKey:     []byte("test"),
+		TokenDuration: 15 * time.Minute,
+	}
+	authSvc := auth.NewAuth(slog.Default(), mockStorage, &MockRedisStorage{}, jwt)
+	ctx := context.Background()
+
+	user := models.NewUser{Email: "test123@example.com", HashPass: []byte("examplepass")}
+	tokens, err := authSvc.Login(ctx, user, auth.TokenBinding{CertThumbprint: "client-a"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := authSvc.VerifyAccessToken(ctx, auth.PresentedToken{
+		Token:   tokens.AccessToken,
+		Binding: auth.TokenBinding{CertThumbprint: "client-a"},
+	}); err != nil {
+		t.Errorf("expected token to be valid with the same certificate, got %v", err)
+	}
+	if _, err := authSvc.VerifyAccessToken(ctx, auth.PresentedToken{
+		Token:   tokens.AccessToken,
+		Binding: auth.TokenBinding{CertThumbprint: "client-b"},
+	}); !errors.Is(err, auth.ErrBindingMismatch) {
+		t.Errorf("expected ErrBindingMismatch, got %v", err)
+	}
+}
+
+type MemorySessionStorage struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (m *MemorySessionStorage) SetSession(ctx context.Context, key string, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	return nil
+}
+
+func (m *MemorySessionStorage) GetSession(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[key]
+	if !ok {
+		return "", redis.Nil
+	}
+	return v, nil
+}
+
+func (m *MemorySessionStorage) DeleteSession(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *MemorySessionStorage) MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[key]; ok {
+		return false, nil
+	}
+	m.data[key] = "1"
+	return true, nil
+}
+
+func dpopProof(t *testing.T, key *ecdsa.PrivateKey, req dpop.Request, accessToken string) string {
+	t.Helper()
+	pub, _ := jwk.FromPublicKey(&key.PublicKey)
+	claims := &dpop.Claims{
+		HTM:              req.Method,
+		HTU:              req.URL,
+		RegisteredClaims: jwtlib.RegisteredClaims{ID: uuid.NewString(), IssuedAt: jwtlib.NewNumericDate(time.Now())},
+	}
+	if accessToken != "" {
+		claims.ATH = dpop.AccessTokenHash(accessToken)
+	}
+	token := jwtlib.NewWithClaims(jwtlib.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = pub
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAuthService_DPoP(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("examplepass"), bcrypt.DefaultCost)
+	mockStorage := &MockStorage{
+		user: models.User{UID: 1, Email: "test123@example.com", HashPass: hash},
+	}
+	sessions := &MemorySessionStorage{data: map[string]string{}}
+	jwt := &jwtman.JWTManager{
+		SecretKey:     []byte("test"),
+		TokenDuration: 15 * time.Minute,
+	}
+	authSvc := auth.NewAuth(slog.Default(), mockStorage, sessions, jwt)
+	authSvc.DPoP = dpop.NewVerifier(sessions, time.Minute)
+	ctx := context.Background()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bind := func(key *ecdsa.PrivateKey, url, accessToken string) auth.TokenBinding {
+		req := dpop.Request{Method: "POST", URL: url}
+		return auth.TokenBinding{DPoPProof: dpopProof(t, key, req, accessToken), DPoPRequest: req}
+	}
+
+	user := models.NewUser{Email: "test123@example.com", HashPass: []byte("examplepass")}
+	tokens, err := authSvc.Login(ctx, user, bind(key, "https://auth/login", ""))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if tokens.TokenType != "DPoP" {
+		t.Errorf("expected DPoP token type, got %s", tokens.TokenType)
+	}
+
+	presented := auth.PresentedToken{Token: tokens.AccessToken, Scheme: "DPoP", Binding: bind(key, "https://auth/api", tokens.AccessToken)}
+	if _, err := authSvc.VerifyAccessToken(ctx, presented); err != nil {
+		t.Errorf("expected access token to be valid with its key, got %v", err)
+	}
+	presented = auth.PresentedToken{Token: tokens.AccessToken, Scheme: "DPoP", Binding: bind(otherKey, "https://auth/api", tokens.AccessToken)}
+	if _, err := authSvc.VerifyAccessToken(ctx, presented); !errors.Is(err, auth.ErrBindingMismatch) {
+		t.Errorf("expected ErrBindingMismatch for another key, got %v", err)
+	}
+	presented = auth.PresentedToken{Token: tokens.AccessToken, Scheme: "Bearer"}
+	if _, err := authSvc.VerifyAccessToken(ctx, presented); !errors.Is(err, auth.ErrBindingMismatch) {
+		t.Errorf("expected ErrBindingMismatch for bearer use, got %v", err)
+	}
+
+	if _, err := authSvc.Refresh(ctx, tokens.RefreshToken, auth.TokenBinding{}); !errors.Is(err, auth.ErrBindingMismatch) {
+		t.Errorf("expected refresh without proof to fail, got %v", err)
+	}
+	if _, err := authSvc.Refresh(ctx, tokens.RefreshToken, bind(otherKey, "https://auth/refresh", "")); !errors.Is(err, auth.ErrBindingMismatch) {
+		t.Errorf("expected refresh with another key to fail, got %v", err)
+	}
+	refreshed, err := authSvc.Refresh(ctx, tokens.RefreshToken, bind(key, "https://auth/refresh", ""))
+	if err != nil {
+		t.Fatalf("expected refresh with the same key to succeed, got %v", err)
+	}
+	if refreshed.TokenType != "DPoP" {
+		t.Errorf("expected DPoP token type, got %s", refreshed.TokenType)
+	}
+}
+
+type MemoryAPIKeys struct {
+	keys map[string]models.APIKey
+}
+
+func (m *MemoryAPIKeys) CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	key.CreatedAt = time.Now()
+	m.keys[key.ID] = key
+	return key, nil
+}
+
+func (m *MemoryAPIKeys) GetAPIKeyByHash(ctx context.Context, hash []byte) (models.APIKey, error) {
+	for _, key := range m.keys {
+		if string(key.Hash) == string(hash) {
+			return key, nil
+		}
+	}
+	return models.APIKey{}, storage.ErrAPIKeyNotFound
+}
+
+func (m *MemoryAPIKeys) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	for _, key := range m.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MemoryAPIKeys) DeleteAPIKey(ctx context.Context, userID int, id string) error {
+	if key, ok := m.keys[id]; !ok || key.UserID != userID {
+		return storage.ErrAPIKeyNotFound
+	}
+	delete(m.keys, id)
+	return nil
+}
+
+func (m *MemoryAPIKeys) TouchAPIKey(ctx context.Context, id string) error {
+	key := m.keys[id]
+	key.LastUsedAt = time.Now()
+	m.keys[id] = key
+	return nil
+}
+
+func TestAuthService_APIKeys(t *testing.T) {
+	jwt := &jwtman.JWTManager{
+		SecretKey:     []byte("test"),
+		TokenDuration: 15 * time.Minute,
+	}
+	authSvc := auth.NewAuth(slog.Default(), &MockStorage{}, &MockRedisStorage{}, jwt)
+	keys := &MemoryAPIKeys{keys: map[string]models.APIKey{}}
+	authSvc.APIKeys = keys
+	ctx := context.Background()
+
+	_, _, err := authSvc.CreateAPIKey(ctx, 1, "", []string{"bad scope"}, time.Now().Add(-time.Hour))
+	var verr *auth.ValidationError
+	if !errors.As(err, &verr) || len(verr.Violations) != 3 {
+		t.Fatalf("expected three violations, got %v", err)
+	}
+
+	key, secret, err := authSvc.CreateAPIKey(ctx, 1, "ci", []string{"read", "admin"}, time.Time{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(secret, auth.APIKeyPrefix) || !strings.HasPrefix(secret, key.Hint) {
+		t.Errorf("unexpected key %q with hint %q", secret, key.Hint)
+	}
+	if string(key.Hash) == secret {
+		t.Error("expected the key to be stored hashed")
+	}
+
+	claims, err := authSvc.VerifyAccessToken(ctx, auth.PresentedToken{Token: secret, Scheme: "Bearer"})
+	if err != nil {
+		t.Fatalf("expected key to be valid, got %v", err)
+	}
+	if claims.UserID != "1" || claims.Scope != "admin read" || claims.APIKeyID != key.ID {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if keys.keys[key.ID].LastUsedAt.IsZero() {
+		t.Error("expected last use to be recorded")
+	}
+	if _, err := authSvc.RequireUser(jwtman.NewContext(ctx, claims)); !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("expected API keys not to manage the account, got %v", err)
+	}
+
+	tampered := secret[:len(secret)-1] + string(secret[len(secret)-1]^1)
+	if _, err := authSvc.VerifyAccessToken(ctx, auth.PresentedToken{Token: tampered}); !errors.Is(err, auth.ErrTokenInvalid) {
+		t.Errorf("expected ErrTokenInvalid for a bad checksum, got %v", err)
+	}
+
+	expired := keys.keys[key.ID]
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	keys.keys[key.ID] = expired
+	if _, err := authSvc.VerifyAccessToken(ctx, auth.PresentedToken{Token: secret}); !errors.Is(err, auth.ErrTokenExpired) {
+		t.Errorf("expected ErrTokenExpired, got %v", err)
+	}
+
+	if err := authSvc.RevokeAPIKey(ctx, 2, key.ID); !errors.Is(err, auth.ErrAPIKeyNotFound) {
+		t.Errorf("expected another user's key not to be found, got %v", err)
+	}
+	if err := authSvc.RevokeAPIKey(ctx, 1, key.ID); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := authSvc.VerifyAccessToken(ctx, auth.PresentedToken{Token: secret}); !errors.Is(err, auth.ErrTokenInvalid) {
+		t.Errorf("expected revoked key to be invalid, got %v", err)
+	}
+}
+
+func TestAuthService_Realms(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("examplepass"), bcrypt.DefaultCost)
+	mockStorage := &MockStorage{
+		user: models.User{UID: 1, Email: "test123@example.com", HashPass: hash},
+	}
+	sessions := &MemorySessionStorage{data: map[string]string{}}
+	jwt := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), mockStorage, sessions, jwt)
+
+	acme := &realm.Realm{
+		Name:     "acme",
+		JWT:      &jwtman.JWTManager{SecretKey: []byte("acme"), TokenDuration: 5 * time.Minute, Issuer: "https://acme.example.com"},
+		Password: realm.PasswordPolicy{MinLength: 12},
+	}
+	acmeCtx := realm.NewContext(context.Background(), acme)
+	defaultCtx := realm.NewContext(context.Background(), &realm.Realm{Name: realm.Default, JWT: jwt})
+
+	var verr *auth.ValidationError
+	if err := authSvc.Register(acmeCtx, models.NewUser{Email: "new@example.com", HashPass: []byte("short")}); !errors.As(err, &verr) {
+		t.Errorf("expected the realm's password policy to apply, got %v", err)
+	}
+	if err := authSvc.Register(defaultCtx, models.NewUser{Email: "new@example.com", HashPass: []byte("short")}); err != nil {
+		t.Errorf("expected the default realm to accept the password, got %v", err)
+	}
+
+	user := models.NewUser{Email: "new@example.com", HashPass: []byte("short")}
+	tokens, err := authSvc.Login(acmeCtx, user, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	claims, err := authSvc.VerifyAccessToken(acmeCtx, auth.PresentedToken{Token: tokens.AccessToken})
+	if err != nil || claims.Issuer != "https://acme.example.com" {
+		t.Errorf("expected a token with the realm's issuer, got %+v: %v", claims, err)
+	}
+	if _, err := authSvc.VerifyAccessToken(defaultCtx, auth.PresentedToken{Token: tokens.AccessToken}); !errors.Is(err, auth.ErrTokenInvalid) {
+		t.Errorf("expected tokens of another realm to be rejected, got %v", err)
+	}
+	if _, err := authSvc.Refresh(defaultCtx, tokens.RefreshToken, auth.TokenBinding{}); !errors.Is(err, auth.ErrTokenInvalid) {
+		t.Errorf("expected refresh tokens of another realm to be rejected, got %v", err)
+	}
+	if _, err := authSvc.Refresh(acmeCtx, tokens.RefreshToken, auth.TokenBinding{}); err != nil {
+		t.Errorf("expected the refresh token to be valid in its realm, got %v", err)
+	}
+}
+
+func TestAuthService_AccountStatus(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("examplepass"), bcrypt.DefaultCost)
+	mockStorage := &MockStorage{
+		user: models.User{UID: 1, Email: "test123@example.com", HashPass: hash, Status: models.UserActive},
+	}
+	jwt := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), mockStorage, &MemorySessionStorage{data: map[string]string{}}, jwt)
+	ctx := context.Background()
+	user := models.NewUser{Email: "test123@example.com", HashPass: []byte("examplepass")}
+
+	tokens, err := authSvc.Login(ctx, user, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	mockStorage.user.Status = models.UserLocked
+	if _, err := authSvc.Login(ctx, user, auth.TokenBinding{}); !errors.Is(err, auth.ErrAccountLocked) {
+		t.Errorf("expected ErrAccountLocked, got %v", err)
+	}
+	if _, err := authSvc.Refresh(ctx, tokens.RefreshToken, auth.TokenBinding{}); !errors.Is(err, auth.ErrAccountLocked) {
+		t.Errorf("expected refresh of a locked user to be refused, got %v", err)
+	}
+	mockStorage.user.Status = models.UserPendingVerification
+	if _, err := authSvc.IssueTokens(ctx, auth.Grant{UserID: 1}, auth.TokenBinding{}); !errors.Is(err, auth.ErrAccountPending) {
+		t.Errorf("expected ErrAccountPending, got %v", err)
+	}
+
+	if _, err := authSvc.VerifyAccessToken(ctx, auth.PresentedToken{Token: tokens.AccessToken}); err != nil {
+		t.Fatalf("expected the access token to be valid before revocation, got %v", err)
+	}
+	if err := authSvc.RevokeAccessTokens(ctx, 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := authSvc.VerifyAccessToken(ctx, auth.PresentedToken{Token: tokens.AccessToken}); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("expected ErrTokenRevoked, got %v", err)
+	}
+
+	// tokens issued after the second of the revocation are valid again
+	mockStorage.user.Status = models.UserActive
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	tokens, err = authSvc.Login(ctx, user, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := authSvc.VerifyAccessToken(ctx, auth.PresentedToken{Token: tokens.AccessToken}); err != nil {
+		t.Errorf("expected a new token to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/dpop"
+	"auth_service/internal/clientcert"
+	"context"
+	"errors"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// TokenBinding describes the proof of possession keys of the caller. Tokens
+// issued with a binding can only be used together with the same key.
+type TokenBinding struct {
+	CertThumbprint string
+	// DPoPProof is the unverified DPoP proof sent with the request
+	DPoPProof string
+	// DPoPRequest is the request the proof has to be bound to
+	DPoPRequest dpop.Request
+}
+
+// BindingFromContext collects the client certificate of the connection and
+// the DPoP proof sent in the "dpop" metadata
+func BindingFromContext(ctx context.Context) TokenBinding {
+	var b TokenBinding
+	if id := clientcert.FromContext(ctx); id != nil {
+		b.CertThumbprint = id.Thumbprint()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if proofs := md.Get("dpop"); len(proofs) == 1 {
+			b.DPoPProof = proofs[0]
+			b.DPoPRequest = dpopRequest(ctx, md)
+		}
+	}
+	return b
+}
+
+// dpopRequest returns the HTTP request for gateway calls. Native gRPC calls
+// are HTTP/2 POST requests to the method path on the :authority.
+func dpopRequest(ctx context.Context, md metadata.MD) dpop.Request {
+	if req, ok := dpop.RequestFromContext(ctx); ok {
+		return req
+	}
+	scheme := "http"
+	if p, ok := peer.FromContext(ctx); ok {
+		if _, tls := p.AuthInfo.(credentials.TLSInfo); tls {
+			scheme = "https"
+		}
+	}
+	var authority string
+	if a := md.Get(":authority"); len(a) > 0 {
+		authority = a[0]
+	}
+	method, _ := grpc.Method(ctx)
+	return dpop.Request{Method: "POST", URL: scheme + "://" + authority + method}
+}
+
+// PresentedToken is an access token together with the keys it was presented with
+type PresentedToken struct {
+	Token string
+	// Scheme is the authorization scheme, "Bearer" or "DPoP"
+	Scheme  string
+	Binding TokenBinding
+}
+
+// ProofKeys are the verified proof of possession keys of a request
+type ProofKeys struct {
+	CertThumbprint string
+	JKT            string
+}
+
+// VerifyProofKeys verifies the DPoP proof of the binding, if any. Proofs are
+// single use, so requests that check several tokens against the caller's keys
+// verify it once.
+func (auth *Auth) VerifyProofKeys(ctx context.Context, b TokenBinding) (ProofKeys, error) {
+	jkt, err := auth.proofKey(ctx, b, "")
+	if err != nil {
+		return ProofKeys{}, err
+	}
+	return ProofKeys{CertThumbprint: b.CertThumbprint, JKT: jkt}, nil
+}
+
+// proofKey verifies the DPoP proof of the binding, if any, and returns the
+// thumbprint of its key
+func (auth *Auth) proofKey(ctx context.Context, b TokenBinding, accessToken string) (string, error) {
+	if b.DPoPProof == "" {
+		return "", nil
+	}
+	if auth.DPoP == nil {
+		return "", ErrInvalidDPoPProof
+	}
+	proof, err := auth.DPoP.Verify(ctx, b.DPoPProof, b.DPoPRequest, accessToken)
+	if err != nil {
+		if errors.Is(err, dpop.ErrInvalidProof) {
+			auth.Logger.Debug("DPoP proof rejected", slog.Any("reason", err))
+			return "", ErrInvalidDPoPProof
+		}
+		return "", err
+	}
+	return proof.JKT, nil
+}
+
+func tokenOptions(certThumbprint, jkt string) []jwtman.TokenOption {
+	return []jwtman.TokenOption{jwtman.WithCertThumbprint(certThumbprint), jwtman.WithJKT(jkt)}
+}
+
+func tokenType(jkt string) string {
+	if jkt != "" {
+		return dpop.Scheme
+	}
+	return "Bearer"
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/storage"
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// CredentialChecker verifies a login name and password and returns the local
+// user they belong to. Unknown users and wrong passwords are reported as
+// ErrInvalidCredentials.
+type CredentialChecker interface {
+	CheckCredentials(ctx context.Context, user models.NewUser) (models.User, error)
+}
+
+// LocalCredentials checks passwords against the bcrypt hashes in the users
+// table
+type LocalCredentials struct {
+	Logger *slog.Logger
+	Users  UserRepository
+}
+
+func (l *LocalCredentials) CheckCredentials(ctx context.Context, user models.NewUser) (models.User, error) {
+	storedUser, err := l.Users.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, ErrInvalidCredentials
+		}
+		return models.User{}, err
+	}
+
+	if ok := CheckPasswordHash(user.HashPass, storedUser.HashPass); !ok {
+		l.Logger.Info("Wrong password from user", slog.String("email", user.Email))
+		return models.User{}, ErrInvalidCredentials
+	}
+	return storedUser, nil
+}
+
+// ChainedCredentials tries the checkers in order until one accepts the
+// credentials. Errors other than ErrInvalidCredentials stop the chain.
+type ChainedCredentials []CredentialChecker
+
+func (c ChainedCredentials) CheckCredentials(ctx context.Context, user models.NewUser) (models.User, error) {
+	for _, checker := range c {
+		storedUser, err := checker.CheckCredentials(ctx, user)
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return storedUser, err
+		}
+	}
+	return models.User{}, ErrInvalidCredentials
+}
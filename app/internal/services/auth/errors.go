@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrEmailTaken         = errors.New("email already taken")
+	ErrTokenExpired       = errors.New("token expired or invalid")
+	ErrTokenReused        = errors.New("refresh token already used")
+	ErrTokenInvalid       = errors.New("invalid token")
+	ErrUnauthenticated    = errors.New("authentication required")
+	ErrBindingMismatch    = errors.New("token is bound to a different key")
+	ErrInvalidDPoPProof   = errors.New("invalid dpop proof")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrAccountDisabled    = errors.New("account is disabled")
+	ErrAccountLocked      = errors.New("account is locked")
+	ErrAccountPending     = errors.New("account is pending verification")
+	ErrAccountDeleted     = errors.New("account is scheduled for deletion")
+	ErrTokenRevoked       = errors.New("token revoked")
+	// ErrLocalAccount is returned by directory logins for an email registered
+	// here; directories don't take over such accounts
+	ErrLocalAccount = errors.New("a local account is registered with this email")
+)
+
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// ValidationError is returned when request fields fail validation
+type ValidationError struct {
+	Violations []FieldViolation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Field+": "+v.Description)
+	}
+	return "invalid request: " + strings.Join(msgs, ", ")
+}
+
+// Add records a violation for the field
+func (e *ValidationError) Add(field, description string) {
+	e.Violations = append(e.Violations, FieldViolation{Field: field, Description: description})
+}
+
+// Err returns nil if there are no violations
+func (e *ValidationError) Err() error {
+	if len(e.Violations) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"auth_service/internal/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SessionIndex keeps the refresh tokens of each user, so the sessions of a
+// user can be listed and revoked together
+type SessionIndex interface {
+	AddToIndex(ctx context.Context, key, member string, ttl time.Duration) error
+	IndexMembers(ctx context.Context, key string) ([]string, error)
+	RemoveFromIndex(ctx context.Context, key string, members ...string) error
+}
+
+func userSessionsKey(userID string) string {
+	return "user_sessions:" + userID
+}
+
+// SessionID identifies a session without revealing its refresh token
+func SessionID(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:8])
+}
+
+// indexSession adds the refresh token to the sessions of the user. The index
+// only serves listing and revocation, so failures don't fail the login.
+func (auth *Auth) indexSession(ctx context.Context, userID, refreshToken string) {
+	if auth.Sessions == nil || userID == "" {
+		return
+	}
+	if err := auth.Sessions.AddToIndex(ctx, userSessionsKey(userID), refreshToken, auth.refreshTTL(ctx)); err != nil {
+		auth.Logger.Warn("Failed index session", slog.String("user_id", userID), slog.Any("error", err))
+	}
+}
+
+// ListSessions returns the active sessions of the user. Refresh tokens that
+// expired or were logged out are dropped from the index on the way.
+func (auth *Auth) ListSessions(ctx context.Context, uid int) ([]models.Session, error) {
+	sessions := []models.Session{}
+	if auth.Sessions == nil {
+		return sessions, nil
+	}
+	key := userSessionsKey(strconv.Itoa(uid))
+	tokens, err := auth.Sessions.IndexMembers(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, token := range tokens {
+		value, err := auth.Redis.GetSession(ctx, fmt.Sprintf("refresh:%s", token))
+		if errors.Is(err, redis.Nil) {
+			stale = append(stale, token)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		session, err := decodeSession(value)
+		if err != nil {
+			return nil, err
+		}
+		session.ID = SessionID(token)
+		sessions = append(sessions, session)
+	}
+	if err := auth.Sessions.RemoveFromIndex(ctx, key, stale...); err != nil {
+		auth.Logger.Warn("Failed prune session index", slog.Int("uid", uid), slog.Any("error", err))
+	}
+	return sessions, nil
+}
+
+// RevokeSessions deletes all refresh tokens of the user and returns how many
+// sessions were active. Access tokens stay valid until they expire.
+func (auth *Auth) RevokeSessions(ctx context.Context, uid int) (int, error) {
+	sessions, err := auth.ListSessions(ctx, uid)
+	if err != nil || len(sessions) == 0 {
+		return 0, err
+	}
+	key := userSessionsKey(strconv.Itoa(uid))
+	tokens, err := auth.Sessions.IndexMembers(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	for _, token := range tokens {
+		if err := auth.Redis.DeleteSession(ctx, fmt.Sprintf("refresh:%s", token)); err != nil {
+			return 0, err
+		}
+	}
+	if err := auth.Sessions.RemoveFromIndex(ctx, key, tokens...); err != nil {
+		return 0, err
+	}
+	auth.Logger.Info("Sessions revoked", slog.Int("uid", uid), slog.Int("count", len(sessions)))
+	return len(sessions), nil
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StatusError returns the error reported to users with the status, or nil
+// for active users
+func StatusError(user models.User) error {
+	switch {
+	case user.Active():
+		return nil
+	case user.Status == models.UserLocked:
+		return ErrAccountLocked
+	case user.Status == models.UserPendingVerification:
+		return ErrAccountPending
+	case user.Status == models.UserDeleted:
+		return ErrAccountDeleted
+	}
+	return ErrAccountDisabled
+}
+
+// AccountInactive reports whether err refuses a user that isn't active
+func AccountInactive(err error) bool {
+	return errors.Is(err, ErrAccountDisabled) || errors.Is(err, ErrAccountLocked) ||
+		errors.Is(err, ErrAccountPending) || errors.Is(err, ErrAccountDeleted)
+}
+
+// requireActive looks up the user and fails unless it is active
+func (auth *Auth) requireActive(ctx context.Context, uid int) error {
+	user, err := auth.Storage.GetUserByID(ctx, uid)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if err := StatusError(user); err != nil {
+		auth.Logger.Info("Tokens for inactive user refused", slog.Int("uid", uid), slog.String("status", user.Status))
+		return err
+	}
+	return nil
+}
+
+func revokedTokensKey(userID string) string {
+	return "revoked_tokens:" + userID
+}
+
+// RevokeAccessTokens denylists the access tokens issued to the user until
+// now. The entry is kept as long as the longest of them is valid.
+func (auth *Auth) RevokeAccessTokens(ctx context.Context, uid int) error {
+	ttl := auth.TokenManager(ctx).TokenDuration
+	err := auth.Redis.SetSession(ctx, revokedTokensKey(strconv.Itoa(uid)), strconv.FormatInt(time.Now().Unix(), 10), ttl)
+	if err != nil {
+		return err
+	}
+	auth.Logger.Info("Access tokens revoked", slog.Int("uid", uid))
+	return nil
+}
+
+// tokenRevoked reports whether the access token of a user was issued before
+// the user's tokens were revoked. iat has whole seconds, so tokens issued in
+// the second of the revocation are revoked too.
+func (auth *Auth) tokenRevoked(ctx context.Context, claims *jwtman.Claims) (bool, error) {
+	if _, err := strconv.Atoi(claims.UserID); err != nil || claims.IssuedAt == nil {
+		return false, nil
+	}
+	value, err := auth.Redis.GetSession(ctx, revokedTokensKey(claims.UserID))
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	revokedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid token revocation time: %w", err)
+	}
+	return claims.IssuedAt.Unix() <= revokedAt, nil
+}
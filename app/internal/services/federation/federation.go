@@ -0,0 +1,237 @@
+// Package federation signs users in with upstream OpenID Connect providers
+// ("Sign in with ...") and links the upstream accounts to local users.
+package federation
+
+import (
+	"auth_service/internal/JWT/idtoken"
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/oauth"
+	"auth_service/internal/storage"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown identity provider")
+	ErrInvalidState     = errors.New("login state is invalid or expired")
+	ErrEmailNotVerified = errors.New("the identity provider did not verify the email address")
+	ErrUpstream         = errors.New("identity provider request failed")
+)
+
+// DefaultScopes are requested from providers configured without scopes
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type IdentityRepository interface {
+	GetLinkedIdentity(ctx context.Context, provider, subject string) (models.LinkedIdentity, error)
+	LinkIdentity(ctx context.Context, identity models.LinkedIdentity) error
+	CreateFederatedUser(ctx context.Context, email string, identity models.LinkedIdentity) (models.User, error)
+}
+
+type Federation struct {
+	Logger     *slog.Logger
+	Auth       *auth.Auth
+	Identities IdentityRepository
+	Store      auth.SessionStorage
+	// StateTTL is how long a user has to complete the login at the provider
+	StateTTL  time.Duration
+	providers map[string]*provider
+}
+
+// NewFederation validates the providers of the config. Their discovery
+// documents are fetched on first use, so an unavailable provider doesn't
+// prevent startup.
+func NewFederation(logger *slog.Logger, authSvc *auth.Auth, identities IdentityRepository, store auth.SessionStorage, cfg Config, client *http.Client) (*Federation, error) {
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil || !base.IsAbs() {
+		return nil, fmt.Errorf("federation base_url %q must be an absolute url", cfg.BaseURL)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	f := &Federation{
+		Logger:     logger,
+		Auth:       authSvc,
+		Identities: identities,
+		Store:      store,
+		StateTTL:   10 * time.Minute,
+		providers:  map[string]*provider{},
+	}
+	for _, pc := range cfg.Providers {
+		if !providerName.MatchString(pc.Name) {
+			return nil, fmt.Errorf("invalid provider name %q", pc.Name)
+		}
+		if _, ok := f.providers[pc.Name]; ok {
+			return nil, fmt.Errorf("duplicate provider %q", pc.Name)
+		}
+		if !strings.HasSuffix(pc.DiscoveryURL, discoveryPath) {
+			return nil, fmt.Errorf("provider %q: discovery_url must end with %s", pc.Name, discoveryPath)
+		}
+		if pc.ClientID == "" {
+			return nil, fmt.Errorf("provider %q: client_id is required", pc.Name)
+		}
+		if len(pc.Scopes) == 0 {
+			pc.Scopes = DefaultScopes
+		} else if !slices.Contains(pc.Scopes, "openid") {
+			pc.Scopes = append([]string{"openid"}, pc.Scopes...)
+		}
+		f.providers[pc.Name] = &provider{
+			ProviderConfig: pc,
+			baseURL:        strings.TrimSuffix(cfg.BaseURL, "/"),
+			client:         client,
+		}
+	}
+	return f, nil
+}
+
+// Providers returns the names of the configured providers
+func (f *Federation) Providers() []string {
+	names := make([]string, 0, len(f.providers))
+	for name := range f.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// loginState is kept in Redis between the redirect and the callback
+type loginState struct {
+	Provider string `json:"provider"`
+	// Realm the login started in, which the callback has to be in as well
+	Realm    string `json:"realm"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Start begins a login at the provider. It returns the URL to send the
+// browser to and the state the callback must present.
+func (f *Federation) Start(ctx context.Context, name string) (string, string, error) {
+	p, ok := f.providers[name]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state := randomString(32)
+	ls := loginState{Provider: name, Realm: realm.Name(ctx), Verifier: randomString(32), Nonce: randomString(16)}
+	authURL, err := p.authURL(ctx, state, ls.Nonce, oauth.S256Challenge(ls.Verifier))
+	if err != nil {
+		return "", "", err
+	}
+	value, err := json.Marshal(ls)
+	if err != nil {
+		return "", "", err
+	}
+	if err := f.Store.SetSession(ctx, "federation_state:"+state, string(value), f.StateTTL); err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// Callback completes a login: it redeems the code at the provider, verifies
+// the ID token and issues tokens for the linked user
+func (f *Federation) Callback(ctx context.Context, name, state, code string, binding auth.TokenBinding) (*auth.AuthResponse, error) {
+	p, ok := f.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	if state == "" || code == "" {
+		return nil, ErrInvalidState
+	}
+
+	key := "federation_state:" + state
+	value, err := f.Store.GetSession(ctx, key)
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, err
+	}
+	// states are single use
+	if err := f.Store.DeleteSession(ctx, key); err != nil {
+		return nil, err
+	}
+	var ls loginState
+	if err := json.Unmarshal([]byte(value), &ls); err != nil {
+		return nil, fmt.Errorf("invalid stored login state: %w", err)
+	}
+	if ls.Provider != name || ls.Realm != realm.Name(ctx) {
+		return nil, ErrInvalidState
+	}
+
+	rawIDToken, err := p.exchange(ctx, code, ls.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := p.verify(ctx, rawIDToken, ls.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := f.resolveUser(ctx, name, claims)
+	if err != nil {
+		return nil, err
+	}
+	f.Logger.Info("Federated login", slog.String("provider", name), slog.Int("uid", uid))
+	return f.Auth.IssueTokens(ctx, auth.Grant{UserID: uid, AuthTime: time.Now()}, binding)
+}
+
+// resolveUser finds the user linked to the upstream account. Unlinked
+// accounts are linked to the user with the same email, or a new user is
+// created, but only if the provider verified the email.
+func (f *Federation) resolveUser(ctx context.Context, name string, claims *idtoken.Claims) (int, error) {
+	identity, err := f.Identities.GetLinkedIdentity(ctx, name, claims.Subject)
+	if err == nil {
+		return identity.UserID, nil
+	}
+	if !errors.Is(err, storage.ErrIdentityNotFound) {
+		return 0, err
+	}
+
+	if claims.Email == "" || claims.EmailVerified == nil || !*claims.EmailVerified {
+		return 0, ErrEmailNotVerified
+	}
+	identity = models.LinkedIdentity{Provider: name, Subject: claims.Subject, Email: claims.Email}
+
+	user, err := f.Auth.Storage.GetUserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		identity.UserID = user.UID
+		if err := f.Identities.LinkIdentity(ctx, identity); err != nil {
+			return 0, err
+		}
+		f.Logger.Info("Identity linked by email", slog.String("provider", name), slog.Int("uid", user.UID))
+		return user.UID, nil
+	case errors.Is(err, storage.ErrUserNotFound):
+		user, err := f.Identities.CreateFederatedUser(ctx, claims.Email, identity)
+		if err != nil {
+			return 0, err
+		}
+		f.Logger.Info("User created by federated login", slog.String("provider", name), slog.Int("uid", user.UID))
+		return user.UID, nil
+	default:
+		return 0, err
+	}
+}
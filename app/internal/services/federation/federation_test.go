@@ -0,0 +1,270 @@
+package federation_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/idtoken"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/federation"
+	"auth_service/internal/services/oauth"
+	"auth_service/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeIdP is an in-process OpenID Provider. authorize stands in for the user
+// signing in at the provider.
+type fakeIdP struct {
+	*httptest.Server
+	signer *idtoken.Signer
+	mu     sync.Mutex
+	codes  map[string]*idtoken.Claims
+	// challenges of the issued codes
+	challenges map[string]string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	signer, err := idtoken.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{signer: signer, codes: map[string]*idtoken.Claims{}, challenges: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(idp.signer.JWKS())
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "local" || secret != "s3cret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		code := r.PostFormValue("code")
+		idp.mu.Lock()
+		claims, ok := idp.codes[code]
+		challenge := idp.challenges[code]
+		delete(idp.codes, code)
+		idp.mu.Unlock()
+		if !ok || oauth.S256Challenge(r.PostFormValue("code_verifier")) != challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		token, _ := idp.signer.Sign(claims)
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "upstream", "id_token": token})
+	})
+	idp.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// authorize approves the authorization request for the account and returns
+// the code the provider would redirect back with
+func (idp *fakeIdP) authorize(t *testing.T, authURL, sub, email string, verified bool) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != "local" || q.Get("code_challenge_method") != "S256" ||
+		q.Get("redirect_uri") != "https://auth.example.com/federation/fake/callback" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	now := time.Now()
+	code = "code-" + sub + q.Get("state")[:8]
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.challenges[code] = q.Get("code_challenge")
+	idp.codes[code] = &idtoken.Claims{
+		Nonce:         q.Get("nonce"),
+		Email:         email,
+		EmailVerified: &verified,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    idp.URL,
+			Subject:   sub,
+			Audience:  jwt.ClaimStrings{"local"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+	return code, q.Get("state")
+}
+
+type MockUsers struct {
+	users map[string]models.User
+}
+
+func (m *MockUsers) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	user, ok := m.users[email]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (m *MockUsers) GetUserByID(ctx context.Context, UID int) (models.User, error) {
+	for _, user := range m.users {
+		if user.UID == UID {
+			return user, nil
+		}
+	}
+	return models.User{}, storage.ErrUserNotFound
+}
+
+func (m *MockUsers) CreateNewUser(ctx context.Context, user models.NewUser) error {
+	return nil
+}
+
+func (m *MockUsers) IsAdmin(ctx context.Context, UID int) bool {
+	return false
+}
+
+type MemoryIdentities struct {
+	users      *MockUsers
+	identities map[string]models.LinkedIdentity
+}
+
+func (m *MemoryIdentities) GetLinkedIdentity(ctx context.Context, provider, subject string) (models.LinkedIdentity, error) {
+	identity, ok := m.identities[provider+"/"+subject]
+	if !ok {
+		return models.LinkedIdentity{}, storage.ErrIdentityNotFound
+	}
+	return identity, nil
+}
+
+func (m *MemoryIdentities) LinkIdentity(ctx context.Context, identity models.LinkedIdentity) error {
+	m.identities[identity.Provider+"/"+identity.Subject] = identity
+	return nil
+}
+
+func (m *MemoryIdentities) CreateFederatedUser(ctx context.Context, email string, identity models.LinkedIdentity) (models.User, error) {
+	user := models.User{UID: len(m.users.users) + 1, Email: email, EmailVerified: true}
+	m.users.users[email] = user
+	identity.UserID = user.UID
+	return user, m.LinkIdentity(ctx, identity)
+}
+
+type MemoryStore struct {
+	data map[string]string
+}
+
+func (m *MemoryStore) SetSession(ctx context.Context, key string, value string, ttl time.Duration) error {
+	m.data[key] = value
+	return nil
+}
+
+func (m *MemoryStore) GetSession(ctx context.Context, key string) (string, error) {
+	v, ok := m.data[key]
+	if !ok {
+		return "", redis.Nil
+	}
+	return v, nil
+}
+
+func (m *MemoryStore) DeleteSession(ctx context.Context, key string) error {
+	delete(m.data, key)
+	return nil
+}
+
+func TestFederation_Login(t *testing.T) {
+	idp := newFakeIdP(t)
+	users := &MockUsers{users: map[string]models.User{"existing@example.com": {UID: 1, Email: "existing@example.com"}}}
+	identities := &MemoryIdentities{users: users, identities: map[string]models.LinkedIdentity{}}
+	jwtManager := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), users, &MemoryStore{data: map[string]string{}}, jwtManager)
+
+	fed, err := federation.NewFederation(slog.Default(), authSvc, identities, &MemoryStore{data: map[string]string{}}, federation.Config{
+		BaseURL: "https://auth.example.com",
+		Providers: []federation.ProviderConfig{{
+			Name:         "fake",
+			DiscoveryURL: idp.URL + "/.well-known/openid-configuration",
+			ClientID:     "local",
+			ClientSecret: "s3cret",
+		}},
+	}, idp.Client())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ctx := context.Background()
+
+	login := func(sub, email string, verified bool) (*auth.AuthResponse, error) {
+		authURL, _, err := fed.Start(ctx, "fake")
+		if err != nil {
+			t.Fatalf("expected login to start, got %v", err)
+		}
+		code, state := idp.authorize(t, authURL, sub, email, verified)
+		return fed.Callback(ctx, "fake", state, code, auth.TokenBinding{})
+	}
+	userOf := func(resp *auth.AuthResponse) string {
+		claims, err := jwtManager.VerifyToken(resp.AccessToken)
+		if err != nil {
+			t.Fatalf("expected a valid access token, got %v", err)
+		}
+		return claims.UserID
+	}
+
+	// a verified email matching a local user links the account
+	resp, err := login("upstream-1", "existing@example.com", true)
+	if err != nil {
+		t.Fatalf("expected tokens, got %v", err)
+	}
+	if uid := userOf(resp); uid != "1" || resp.RefreshToken == "" {
+		t.Errorf("expected tokens for the existing user, got uid %s", uid)
+	}
+	if identities.identities["fake/upstream-1"].UserID != 1 {
+		t.Error("expected the identity to be linked")
+	}
+
+	// a new verified email creates a user; the link is used afterwards even
+	// if the email changes upstream
+	resp, err = login("upstream-2", "new@example.com", true)
+	if err != nil || userOf(resp) != "2" {
+		t.Fatalf("expected a new user, got %v", err)
+	}
+	resp, err = login("upstream-2", "renamed@example.com", false)
+	if err != nil || userOf(resp) != "2" {
+		t.Fatalf("expected the linked user, got %v", err)
+	}
+
+	if _, err := login("upstream-3", "existing@example.com", false); !errors.Is(err, federation.ErrEmailNotVerified) {
+		t.Errorf("expected ErrEmailNotVerified, got %v", err)
+	}
+
+	authURL, _, _ := fed.Start(ctx, "fake")
+	code, state := idp.authorize(t, authURL, "upstream-1", "existing@example.com", true)
+	if _, err := fed.Callback(ctx, "fake", "forged", code, auth.TokenBinding{}); !errors.Is(err, federation.ErrInvalidState) {
+		t.Errorf("expected ErrInvalidState for an unknown state, got %v", err)
+	}
+	idp.codes[code].Nonce = "replayed"
+	if _, err := fed.Callback(ctx, "fake", state, code, auth.TokenBinding{}); !errors.Is(err, federation.ErrUpstream) {
+		t.Errorf("expected a nonce mismatch to be rejected, got %v", err)
+	}
+	if _, err := fed.Callback(ctx, "fake", state, code, auth.TokenBinding{}); !errors.Is(err, federation.ErrInvalidState) {
+		t.Errorf("expected the state to be single use, got %v", err)
+	}
+
+	if _, _, err := fed.Start(ctx, "other"); !errors.Is(err, federation.ErrUnknownProvider) {
+		t.Errorf("expected ErrUnknownProvider, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package federation
+
+import (
+	"auth_service/internal/JWT/idtoken"
+	"auth_service/internal/JWT/jwk"
+	"auth_service/internal/config"
+	"auth_service/internal/realm"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// keyRefreshInterval limits JWKS fetches for unknown key ids
+const keyRefreshInterval = time.Minute
+
+// ProviderConfig is an upstream OpenID Provider
+type ProviderConfig struct {
+	// Name identifies the provider in URLs and linked identities
+	Name string `yaml:"name"`
+	// DiscoveryURL is the provider's /.well-known/openid-configuration URL
+	DiscoveryURL string   `yaml:"discovery_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// Config is the content of the federation config file
+type Config struct {
+	// BaseURL is the public URL of this service the callbacks are served under
+	BaseURL   string           `yaml:"base_url"`
+	Providers []ProviderConfig `yaml:"providers"`
+}
+
+// LoadConfig reads the YAML config file. ${VAR} references are replaced by
+// environment variables so secrets can stay out of the file.
+func LoadConfig(file string) (Config, error) {
+	var cfg Config
+	if err := config.LoadYAML(file, &cfg); err != nil {
+		return Config{}, fmt.Errorf("load federation config: %w", err)
+	}
+	return cfg, nil
+}
+
+// metadata is the part of the provider's discovery document we use
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type provider struct {
+	ProviderConfig
+	baseURL string
+	client  *http.Client
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func (p *provider) getJSON(ctx context.Context, rawURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return p.do(req, v)
+}
+
+func (p *provider) do(req *http.Request, v any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d: %s", ErrUpstream, req.URL.Redacted(), resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: invalid response from %s: %v", ErrUpstream, req.URL.Redacted(), err)
+	}
+	return nil
+}
+
+// metadata fetches the discovery document once
+func (p *provider) metadata(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta metadata
+	if err := p.getJSON(ctx, p.DiscoveryURL, &meta); err != nil {
+		return nil, err
+	}
+	// OpenID Connect Discovery section 4.3
+	if strings.TrimSuffix(meta.Issuer, "/")+discoveryPath != p.DiscoveryURL {
+		return nil, fmt.Errorf("%w: issuer %q does not match the discovery url", ErrUpstream, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete discovery document", ErrUpstream)
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// key returns the signing key with the id, fetching the JWKS again when the
+// provider may have rotated its keys
+func (p *provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set jwk.Set
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			// providers may publish key types we don't support
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds the key by id; tokens without a kid match a single key
+func (p *provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// redirectURL is the callback of the realm of ctx, so the login completes in
+// the realm it started in
+func (p *provider) redirectURL(ctx context.Context) string {
+	return p.baseURL + realm.BasePath(ctx) + "/federation/" + p.Name + "/callback"
+}
+
+// authURL builds the authorization request
+func (p *provider) authURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %v", ErrUpstream, err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.redirectURL(ctx))
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// exchange redeems the authorization code and returns the ID token
+func (p *provider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL(ctx)},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// RFC 6749 section 2.3.1 form encodes the credentials before basic auth
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	var resp tokenResponse
+	if err := p.do(req, &resp); err != nil {
+		return "", err
+	}
+	if resp.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in the token response", ErrUpstream)
+	}
+	return resp.IDToken, nil
+}
+
+// verify checks the ID token signature, issuer, audience, expiry and nonce
+func (p *provider) verify(ctx context.Context, raw, nonce string) (*idtoken.Claims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := jwt.ParseWithClaims(raw, &idtoken.Claims{}, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "PS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid id_token: %v", ErrUpstream, err)
+	}
+	claims := token.Claims.(*idtoken.Claims)
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: id_token nonce does not match", ErrUpstream)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: id_token has no subject", ErrUpstream)
+	}
+	return claims, nil
+}
@@ -0,0 +1,50 @@
+package ldapauth
+
+import (
+	"auth_service/internal/config"
+	"fmt"
+	"time"
+)
+
+// DefaultFilter finds person entries by uid
+const DefaultFilter = "(&(objectClass=person)(uid={username}))"
+
+// Config is the content of the LDAP config file
+type Config struct {
+	// URL of the directory, ldap:// or ldaps://
+	URL string `yaml:"url"`
+	// StartTLS upgrades ldap:// connections before binding
+	StartTLS bool `yaml:"start_tls"`
+	// CAFile verifies the directory's certificate instead of the system roots
+	CAFile string `yaml:"ca_file"`
+	// BindDN and BindPassword are the service account the users are searched
+	// with; empty for anonymous search
+	BindDN       string `yaml:"bind_dn"`
+	BindPassword string `yaml:"bind_password"`
+	// BaseDN is the subtree users are searched in
+	BaseDN string `yaml:"base_dn"`
+	// Filter finds the user's entry; {username} is replaced by the escaped
+	// login name
+	Filter string `yaml:"filter"`
+	// EmailAttribute holds the email address users are provisioned with
+	EmailAttribute string `yaml:"email_attribute"`
+	// GroupAttribute lists the DNs of the groups a user is a member of
+	GroupAttribute string `yaml:"group_attribute"`
+	// GroupRoles maps group DNs to the roles their members get
+	GroupRoles map[string]string `yaml:"group_roles"`
+	// GrantAdmin makes users with the admin role administrators; without it
+	// the directory's roles don't grant administrator rights
+	GrantAdmin bool `yaml:"grant_admin"`
+	// Timeout bounds dialing and each request
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// LoadConfig reads the YAML config file. ${VAR} references are replaced by
+// environment variables so secrets can stay out of the file.
+func LoadConfig(file string) (Config, error) {
+	var cfg Config
+	if err := config.LoadYAML(file, &cfg); err != nil {
+		return Config{}, fmt.Errorf("load ldap config: %w", err)
+	}
+	return cfg, nil
+}
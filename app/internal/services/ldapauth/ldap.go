@@ -0,0 +1,209 @@
+// Package ldapauth checks login credentials against an LDAP directory and
+// provisions the users in the users table on their first login.
+package ldapauth
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/storage"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var ErrNoEmail = errors.New("directory entry has no email address")
+
+type UserProvisioner interface {
+	ProvisionUser(ctx context.Context, user models.ProvisionedUser) (models.User, error)
+}
+
+// LDAP is a credential checker that searches the user's entry with the
+// service account and then binds as the user
+type LDAP struct {
+	Logger *slog.Logger
+	Users  UserProvisioner
+	cfg    Config
+	tls    *tls.Config
+	groups map[*ldap.DN]string
+}
+
+// NewLDAP validates the config. The directory is contacted on the first login.
+func NewLDAP(logger *slog.Logger, users UserProvisioner, cfg Config) (*LDAP, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("ldap url %q must be an ldap:// or ldaps:// url", cfg.URL)
+	}
+	if cfg.StartTLS && u.Scheme == "ldaps" {
+		return nil, errors.New("ldap start_tls can't be used with ldaps://")
+	}
+	if cfg.BaseDN == "" {
+		return nil, errors.New("ldap base_dn is required")
+	}
+	if cfg.Filter == "" {
+		cfg.Filter = DefaultFilter
+	}
+	if !strings.Contains(cfg.Filter, "{username}") {
+		return nil, errors.New("ldap filter must contain {username}")
+	}
+	if _, err := ldap.CompileFilter(strings.ReplaceAll(cfg.Filter, "{username}", "x")); err != nil {
+		return nil, fmt.Errorf("invalid ldap filter: %w", err)
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = "mail"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	l := &LDAP{
+		Logger: logger,
+		Users:  users,
+		cfg:    cfg,
+		tls:    &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12},
+		groups: make(map[*ldap.DN]string, len(cfg.GroupRoles)),
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ldap ca_file: %w", err)
+		}
+		l.tls.RootCAs = x509.NewCertPool()
+		if !l.tls.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in ldap ca_file %s", cfg.CAFile)
+		}
+	}
+	for group, role := range cfg.GroupRoles {
+		dn, err := ldap.ParseDN(group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ldap group %q: %w", group, err)
+		}
+		l.groups[dn] = role
+	}
+	return l, nil
+}
+
+// SetTLSConfig replaces the TLS config used for ldaps:// and StartTLS
+func (l *LDAP) SetTLSConfig(cfg *tls.Config) {
+	l.tls = cfg
+}
+
+func (l *LDAP) CheckCredentials(ctx context.Context, user models.NewUser) (models.User, error) {
+	// unauthenticated binds succeed without a password (RFC 4513 section 5.1.2)
+	if user.Email == "" || len(user.HashPass) == 0 {
+		return models.User{}, auth.ErrInvalidCredentials
+	}
+
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer conn.Close()
+
+	entry, err := l.search(conn, user.Email)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := conn.Bind(entry.DN, string(user.HashPass)); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			l.Logger.Info("Wrong LDAP password from user", slog.String("dn", entry.DN))
+			return models.User{}, auth.ErrInvalidCredentials
+		}
+		return models.User{}, fmt.Errorf("ldap bind: %w", err)
+	}
+
+	email := entry.GetEqualFoldAttributeValue(l.cfg.EmailAttribute)
+	if email == "" {
+		l.Logger.Warn("LDAP entry without email", slog.String("dn", entry.DN))
+		return models.User{}, ErrNoEmail
+	}
+	roles := l.roles(entry.GetEqualFoldAttributeValues(l.cfg.GroupAttribute))
+	provisioned, err := l.Users.ProvisionUser(ctx, models.ProvisionedUser{Email: email, Roles: roles, GrantAdmin: l.cfg.GrantAdmin})
+	if errors.Is(err, storage.ErrUserExists) {
+		l.Logger.Warn("LDAP login for a local account", slog.String("dn", entry.DN))
+		return models.User{}, auth.ErrLocalAccount
+	}
+	return provisioned, err
+}
+
+func (l *LDAP) dial(ctx context.Context) (*ldap.Conn, error) {
+	timeout := l.cfg.Timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+	conn, err := ldap.DialURL(l.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(l.tls),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	conn.SetTimeout(timeout)
+
+	if l.cfg.StartTLS {
+		if err := conn.StartTLS(l.tls); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	if l.cfg.BindDN != "" {
+		if err := conn.Bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// search finds the single entry of the user
+func (l *LDAP) search(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(l.cfg.Filter, "{username}", ldap.EscapeFilter(username))
+	req := ldap.NewSearchRequest(
+		l.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(l.cfg.Timeout.Seconds()), false, filter,
+		[]string{l.cfg.EmailAttribute, l.cfg.GroupAttribute}, nil,
+	)
+	result, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	switch {
+	case result == nil || len(result.Entries) == 0:
+		return nil, auth.ErrInvalidCredentials
+	case len(result.Entries) > 1:
+		l.Logger.Warn("LDAP filter matches several entries", slog.String("username", username))
+		return nil, auth.ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+// roles maps the user's groups to roles; group DNs compare case insensitively
+func (l *LDAP) roles(groups []string) []string {
+	roles := []string{}
+	for _, group := range groups {
+		dn, err := ldap.ParseDN(group)
+		if err != nil {
+			continue
+		}
+		for groupDN, role := range l.groups {
+			if groupDN.EqualFold(dn) && !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	slices.Sort(roles)
+	return roles
+}
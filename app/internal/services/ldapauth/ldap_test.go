@@ -0,0 +1,307 @@
+package ldapauth_test
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/ldapauth"
+	"auth_service/internal/testutil"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+type entry struct {
+	dn       string
+	password string
+	mail     string
+	groups   []string
+}
+
+// fakeDirectory is a minimal LDAP server: simple binds, searches on uid and
+// StartTLS. Searches require the service account to be bound.
+type fakeDirectory struct {
+	net.Listener
+	cert    tls.Certificate
+	service entry
+	users   map[string]entry
+}
+
+func newFakeDirectory(t *testing.T, users ...entry) (*fakeDirectory, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDirectory{
+		Listener: ln,
+		cert:     tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		service:  entry{dn: "cn=svc,dc=example,dc=com", password: "svc-pass"},
+		users:    map[string]entry{},
+	}
+	for _, u := range users {
+		uid := strings.TrimPrefix(strings.Split(u.dn, ",")[0], "uid=")
+		d.users[uid] = u
+	}
+	t.Cleanup(func() { ln.Close() })
+	go d.serve()
+	return d, roots
+}
+
+func (d *fakeDirectory) serve() {
+	for {
+		conn, err := d.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeDirectory) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	var bound string
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if u, ok := d.lookupDN(name); ok && password != "" && u.password == password {
+				code, bound = ldap.LDAPResultSuccess, name
+			}
+			d.write(conn, id, result(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			if bound != d.service.dn {
+				d.write(conn, id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				continue
+			}
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+			for uid, u := range d.users {
+				if matchUID(filter, uid) {
+					d.write(conn, id, searchEntry(u))
+				}
+			}
+			d.write(conn, id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationExtendedRequest:
+			if op.Children[0].Data.String() != startTLSOID {
+				d.write(conn, id, result(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				continue
+			}
+			d.write(conn, id, result(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{d.cert}})
+		default:
+			// unbind
+			return
+		}
+	}
+}
+
+// matchUID evaluates the uid equality or prefix assertion of the filter
+func matchUID(filter, uid string) bool {
+	_, value, ok := strings.Cut(filter, "(uid=")
+	if !ok {
+		return false
+	}
+	value, _, _ = strings.Cut(value, ")")
+	if prefix, ok := strings.CutSuffix(value, "*"); ok {
+		return strings.HasPrefix(uid, prefix)
+	}
+	return value == uid
+}
+
+func (d *fakeDirectory) lookupDN(dn string) (entry, bool) {
+	if dn == d.service.dn {
+		return d.service, true
+	}
+	for _, u := range d.users {
+		if u.dn == dn {
+			return u, true
+		}
+	}
+	return entry{}, false
+}
+
+func (d *fakeDirectory) write(w io.Writer, id int64, op *ber.Packet) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	_, _ = w.Write(packet.Bytes())
+}
+
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return op
+}
+
+func searchEntry(u entry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, u.dn, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range map[string][]string{"mail": {u.mail}, "memberOf": u.groups} {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return op
+}
+
+func TestLDAP_CheckCredentials(t *testing.T) {
+	dir, roots := newFakeDirectory(t,
+		entry{
+			dn:       "uid=alice,ou=people,dc=example,dc=com",
+			password: "alice-pass",
+			mail:     "alice@example.com",
+			groups:   []string{"cn=Admins,ou=Groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+		},
+		entry{dn: "uid=bob,ou=people,dc=example,dc=com", password: "bob-pass", mail: "bob@example.com"},
+	)
+	users := &testutil.UserStore{}
+	checker, err := ldapauth.NewLDAP(slog.Default(), users, ldapauth.Config{
+		URL:          "ldap://" + dir.Addr().String(),
+		StartTLS:     true,
+		BindDN:       "cn=svc,dc=example,dc=com",
+		BindPassword: "svc-pass",
+		BaseDN:       "dc=example,dc=com",
+		GroupRoles: map[string]string{
+			"cn=admins,ou=groups,dc=example,dc=com": models.RoleAdmin,
+			"cn=staff,ou=groups,dc=example,dc=com":  "staff",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	checker.SetTLSConfig(&tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+	ctx := context.Background()
+
+	user, err := checker.CheckCredentials(ctx, models.NewUser{Email: "alice", HashPass: []byte("alice-pass")})
+	if err != nil {
+		t.Fatalf("expected alice to log in, got %v", err)
+	}
+	if user.Email != "alice@example.com" || !reflect.DeepEqual(user.Roles, []string{models.RoleAdmin, "staff"}) {
+		t.Errorf("expected alice to be provisioned with her roles, got %+v", user)
+	}
+	if stored, err := users.GetUserByEmail(ctx, "alice@example.com"); err != nil || stored.IsAdmin {
+		t.Errorf("expected alice to be provisioned without grant_admin making her an administrator, got %+v %v", stored, err)
+	}
+
+	user, err = checker.CheckCredentials(ctx, models.NewUser{Email: "bob", HashPass: []byte("bob-pass")})
+	if err != nil || len(user.Roles) != 0 {
+		t.Errorf("expected bob to log in without roles, got %+v, %v", user, err)
+	}
+
+	for name, creds := range map[string]models.NewUser{
+		"wrong password":   {Email: "alice", HashPass: []byte("bob-pass")},
+		"empty password":   {Email: "alice"},
+		"unknown user":     {Email: "carol", HashPass: []byte("carol-pass")},
+		"filter injection": {Email: "ali*", HashPass: []byte("alice-pass")},
+	} {
+		if _, err := checker.CheckCredentials(ctx, creds); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", name, err)
+		}
+	}
+}
+
+func TestLDAP_Chained(t *testing.T) {
+	dir, _ := newFakeDirectory(t,
+		entry{dn: "uid=alice,ou=people,dc=example,dc=com", password: "alice-pass", mail: "alice@example.com"},
+		entry{
+			dn:       "uid=admin,ou=people,dc=example,dc=com",
+			password: "directory-pass",
+			mail:     "admin@example.com",
+			groups:   []string{"cn=admins,ou=groups,dc=example,dc=com"},
+		},
+	)
+	hash, _ := auth.HashPassword([]byte("local-pass"))
+	users := &testutil.UserStore{Users: []models.User{{UID: 1, Email: "admin@example.com", HashPass: hash, Roles: []string{"support"}}}}
+	checker, err := ldapauth.NewLDAP(slog.Default(), users, ldapauth.Config{
+		URL:          "ldap://" + dir.Addr().String(),
+		BindDN:       "cn=svc,dc=example,dc=com",
+		BindPassword: "svc-pass",
+		BaseDN:       "dc=example,dc=com",
+		GroupRoles:   map[string]string{"cn=admins,ou=groups,dc=example,dc=com": models.RoleAdmin},
+		GrantAdmin:   true,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	local := &auth.LocalCredentials{Logger: slog.Default(), Users: users}
+	chain := auth.ChainedCredentials{local, checker}
+	ctx := context.Background()
+
+	if user, err := chain.CheckCredentials(ctx, models.NewUser{Email: "admin@example.com", HashPass: []byte("local-pass")}); err != nil || user.UID != 1 {
+		t.Errorf("expected the local user, got %+v, %v", user, err)
+	}
+	// a directory entry with the email of a local user doesn't take it over
+	if _, err := chain.CheckCredentials(ctx, models.NewUser{Email: "admin", HashPass: []byte("directory-pass")}); !errors.Is(err, auth.ErrLocalAccount) {
+		t.Errorf("expected ErrLocalAccount, got %v", err)
+	}
+	if user := users.Users[0]; user.IsAdmin || !reflect.DeepEqual(user.Roles, []string{"support"}) {
+		t.Errorf("expected the local user to keep its roles, got %+v", user)
+	}
+	if user, err := chain.CheckCredentials(ctx, models.NewUser{Email: "alice", HashPass: []byte("alice-pass")}); err != nil || user.Email != "alice@example.com" {
+		t.Errorf("expected the directory user, got %+v, %v", user, err)
+	}
+	if _, err := chain.CheckCredentials(ctx, models.NewUser{Email: "alice", HashPass: []byte("local-pass")}); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	if _, err := ldapauth.NewLDAP(slog.Default(), nil, ldapauth.Config{URL: "ldaps://ldap.example.com", StartTLS: true, BaseDN: "dc=example"}); err == nil {
+		t.Error("expected StartTLS over ldaps:// to be rejected")
+	}
+	if _, err := ldapauth.NewLDAP(slog.Default(), nil, ldapauth.Config{URL: "ldap://ldap.example.com", BaseDN: "dc=example", Filter: "(uid=admin)"}); err == nil {
+		t.Error("expected a filter without {username} to be rejected")
+	}
+}
@@ -0,0 +1,256 @@
+package oauth
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// GrantDeviceCode is the grant type of the Device Authorization Grant (RFC 8628)
+const GrantDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// userCodeAlphabet has no vowels or look-alike characters (RFC 8628 section 6.1)
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+var ErrUnknownUserCode = errors.New("unknown or expired user code")
+
+type deviceStatus string
+
+const (
+	devicePending  deviceStatus = "pending"
+	deviceApproved deviceStatus = "approved"
+	deviceDenied   deviceStatus = "denied"
+)
+
+// deviceCode is the record stored for a device authorization
+type deviceCode struct {
+	ClientID  string       `json:"client_id"`
+	Scope     string       `json:"scope"`
+	UserCode  string       `json:"user_code"`
+	Status    deviceStatus `json:"status"`
+	UserID    string       `json:"uid,omitempty"`
+	AuthTime  time.Time    `json:"auth_time,omitzero"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceRequest is a pending device authorization shown to the user
+type DeviceRequest struct {
+	UserCode string
+	Client   models.OAuthClient
+	Scope    string
+}
+
+func newUserCode() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	code := make([]byte, 0, 9)
+	for i, v := range b {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		// 256 is not a multiple of 20; the bias is negligible for this use
+		code = append(code, userCodeAlphabet[int(v)%len(userCodeAlphabet)])
+	}
+	return string(code)
+}
+
+// NormalizeUserCode makes user input comparable to issued codes
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// AuthorizeDevice starts a device authorization for the client
+func (o *OAuth) AuthorizeDevice(ctx context.Context, clientID, secret, scope, verificationURI string) (*DeviceAuthorization, error) {
+	client, err := o.authenticateClient(ctx, clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(GrantDeviceCode) {
+		return nil, newError(ErrorUnauthorizedClient, "the client can't use the device authorization grant")
+	}
+	if scope, err = resolveScope(client, scope); err != nil {
+		return nil, err
+	}
+
+	record := deviceCode{
+		ClientID:  client.ClientID,
+		Scope:     scope,
+		UserCode:  newUserCode(),
+		Status:    devicePending,
+		ExpiresAt: time.Now().Add(o.DeviceCodeTTL),
+	}
+	code := randomString(32)
+	if err := o.saveDeviceCode(ctx, code, record); err != nil {
+		return nil, err
+	}
+	userKey := "device_user_code:" + NormalizeUserCode(record.UserCode)
+	if err := o.Store.SetSession(ctx, userKey, code, o.DeviceCodeTTL); err != nil {
+		return nil, err
+	}
+
+	o.Logger.Debug("Device authorization started", slog.String("client_id", client.ClientID))
+	return &DeviceAuthorization{
+		DeviceCode:              code,
+		UserCode:                record.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + record.UserCode,
+		ExpiresIn:               int64(o.DeviceCodeTTL.Seconds()),
+		Interval:                int64(o.DevicePollInterval.Seconds()),
+	}, nil
+}
+
+func (o *OAuth) saveDeviceCode(ctx context.Context, code string, record deviceCode) error {
+	ttl := time.Until(record.ExpiresAt)
+	if ttl <= 0 {
+		return ErrUnknownUserCode
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return o.Store.SetSession(ctx, "device_code:"+code, string(value), ttl)
+}
+
+func (o *OAuth) loadDeviceCode(ctx context.Context, code string) (deviceCode, error) {
+	value, err := o.Store.GetSession(ctx, "device_code:"+code)
+	if err != nil {
+		return deviceCode{}, err
+	}
+	var record deviceCode
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return deviceCode{}, fmt.Errorf("invalid stored device code: %w", err)
+	}
+	return record, nil
+}
+
+// pendingDevice finds the pending authorization for a user code
+func (o *OAuth) pendingDevice(ctx context.Context, userCode string) (string, deviceCode, error) {
+	code, err := o.Store.GetSession(ctx, "device_user_code:"+NormalizeUserCode(userCode))
+	if errors.Is(err, redis.Nil) {
+		return "", deviceCode{}, ErrUnknownUserCode
+	}
+	if err != nil {
+		return "", deviceCode{}, err
+	}
+	record, err := o.loadDeviceCode(ctx, code)
+	if errors.Is(err, redis.Nil) || err == nil && record.Status != devicePending {
+		return "", deviceCode{}, ErrUnknownUserCode
+	}
+	return code, record, err
+}
+
+// LookupDevice returns the pending request for the user code so the user can
+// confirm it
+func (o *OAuth) LookupDevice(ctx context.Context, userCode string) (DeviceRequest, error) {
+	_, record, err := o.pendingDevice(ctx, userCode)
+	if err != nil {
+		return DeviceRequest{}, err
+	}
+	client, err := o.client(ctx, record.ClientID)
+	if err != nil {
+		return DeviceRequest{}, err
+	}
+	return DeviceRequest{UserCode: record.UserCode, Client: client, Scope: record.Scope}, nil
+}
+
+// CompleteDevice records the decision of the signed in user. The user code
+// can only be used once.
+func (o *OAuth) CompleteDevice(ctx context.Context, userCode string, session models.Session, approve bool) error {
+	code, record, err := o.pendingDevice(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	if err := o.Store.DeleteSession(ctx, "device_user_code:"+NormalizeUserCode(userCode)); err != nil {
+		return err
+	}
+
+	record.Status = deviceDenied
+	if approve {
+		record.Status = deviceApproved
+		record.UserID = session.UserID
+		record.AuthTime = session.CreatedAt
+	}
+	if err := o.saveDeviceCode(ctx, code, record); err != nil {
+		return err
+	}
+	o.Logger.Info("Device authorization completed", slog.String("client_id", record.ClientID),
+		slog.String("user_id", session.UserID), slog.Bool("approved", approve))
+	return nil
+}
+
+// exchangeDeviceCode handles a poll of the token endpoint
+func (o *OAuth) exchangeDeviceCode(ctx context.Context, client models.OAuthClient, req TokenRequest) (*auth.AuthResponse, error) {
+	if req.DeviceCode == "" {
+		return nil, newError(ErrorInvalidRequest, "device_code is required")
+	}
+	// polls within the interval are answered with slow_down
+	first, err := o.Store.MarkUsed(ctx, "device_poll:"+req.DeviceCode, o.DevicePollInterval)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, newError(ErrorSlowDown, "")
+	}
+
+	record, err := o.loadDeviceCode(ctx, req.DeviceCode)
+	if errors.Is(err, redis.Nil) {
+		return nil, newError(ErrorExpiredToken, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.ClientID != client.ClientID {
+		return nil, newError(ErrorInvalidGrant, "device code was issued to another client")
+	}
+
+	switch record.Status {
+	case devicePending:
+		return nil, newError(ErrorAuthorizationPending, "")
+	case deviceDenied:
+		_ = o.Store.DeleteSession(ctx, "device_code:"+req.DeviceCode)
+		return nil, newError(ErrorAccessDenied, "")
+	}
+
+	// an approved code yields tokens once
+	first, err = o.Store.MarkUsed(ctx, "device_code_used:"+req.DeviceCode, time.Until(record.ExpiresAt))
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, newError(ErrorInvalidGrant, "device code already used")
+	}
+	if err := o.Store.DeleteSession(ctx, "device_code:"+req.DeviceCode); err != nil {
+		o.Logger.Warn("Failed delete device code", slog.Any("error", err))
+	}
+
+	uid, err := strconv.Atoi(record.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored user id: %w", err)
+	}
+	return o.Auth.IssueTokens(ctx, auth.Grant{UserID: uid, ClientID: client.ClientID, Scope: record.Scope, AuthTime: record.AuthTime}, req.Binding)
+}
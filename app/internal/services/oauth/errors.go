@@ -0,0 +1,50 @@
+package oauth
+
+import "errors"
+
+// Error codes of RFC 6749 section 4.1.2.1 and 5.2
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+	ErrorServerError             = "server_error"
+	// ErrorInvalidDPoPProof is defined by RFC 9449
+	ErrorInvalidDPoPProof = "invalid_dpop_proof"
+	// Token endpoint errors of the device grant (RFC 8628 section 3.5)
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorExpiredToken         = "expired_token"
+	// ErrorInvalidTarget is defined by RFC 8693 for audiences the client
+	// can't request
+	ErrorInvalidTarget = "invalid_target"
+)
+
+var (
+	// ErrUnknownClient and ErrInvalidRedirectURI can't be reported to the
+	// client with a redirect, because the redirect target is not trusted
+	ErrUnknownClient      = errors.New("unknown client")
+	ErrInvalidRedirectURI = errors.New("redirect uri is not registered for the client")
+	ErrNoSession          = errors.New("no login session")
+)
+
+// Error is an OAuth error response
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func newError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
@@ -0,0 +1,146 @@
+package oauth
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Token Exchange (RFC 8693)
+const (
+	GrantTokenExchange   = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+	// maxActorDepth bounds the act claim chain of exchanged tokens
+	maxActorDepth = 5
+)
+
+// exchangeToken swaps the subject token for one restricted to the requested
+// audiences and scopes. The actor token, or without one the client itself,
+// becomes the new actor unless the client's policy allows impersonation.
+func (o *OAuth) exchangeToken(ctx context.Context, client models.OAuthClient, req TokenRequest) (*auth.AuthResponse, error) {
+	if req.SubjectToken == "" {
+		return nil, newError(ErrorInvalidRequest, "subject_token is required")
+	}
+	if req.SubjectTokenType != TokenTypeAccessToken {
+		return nil, newError(ErrorInvalidRequest, "unsupported subject_token_type")
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeAccessToken {
+		return nil, newError(ErrorInvalidRequest, "unsupported requested_token_type")
+	}
+	keys, err := o.Auth.VerifyProofKeys(ctx, req.Binding)
+	if err != nil {
+		return nil, err
+	}
+	subject, err := o.verifyExchangeToken(ctx, req.SubjectToken, "subject_token", keys)
+	if err != nil {
+		return nil, err
+	}
+
+	audience, err := exchangeAudience(client, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := exchangeScope(client, subject, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	act := subject.Act
+	switch {
+	case req.ActorToken != "":
+		if req.ActorTokenType != TokenTypeAccessToken {
+			return nil, newError(ErrorInvalidRequest, "unsupported actor_token_type")
+		}
+		actor, err := o.verifyExchangeToken(ctx, req.ActorToken, "actor_token", keys)
+		if err != nil {
+			return nil, err
+		}
+		act = &jwtman.Actor{Subject: actor.SubjectID(), ClientID: actor.ClientID, Act: subject.Act}
+	case !client.Exchange.Impersonation:
+		act = &jwtman.Actor{Subject: client.ClientID, ClientID: client.ClientID, Act: subject.Act}
+	}
+	if act.Depth() > maxActorDepth {
+		return nil, newError(ErrorInvalidRequest, "delegation chain is too long")
+	}
+
+	o.Logger.Info("Token exchanged", slog.String("client_id", client.ClientID),
+		slog.String("sub", subject.SubjectID()), slog.Any("aud", audience), slog.Bool("delegated", act != nil))
+	return o.Auth.IssueExchangedToken(ctx, auth.Exchange{
+		Subject:  subject,
+		Actor:    act,
+		ClientID: client.ClientID,
+		Scope:    scope,
+		Audience: audience,
+		Keys:     keys,
+	})
+}
+
+// verifyExchangeToken checks the token like an access token presented with
+// the keys of the token request, so revoked tokens and tokens of inactive
+// users can't be exchanged
+func (o *OAuth) verifyExchangeToken(ctx context.Context, token, param string, keys auth.ProofKeys) (*jwtman.Claims, error) {
+	claims, err := o.Auth.VerifyExchangeToken(ctx, token, keys)
+	switch {
+	case err == nil:
+		return claims, nil
+	case errors.Is(err, auth.ErrTokenExpired):
+		return nil, newError(ErrorInvalidGrant, param+" has expired")
+	case errors.Is(err, auth.ErrTokenRevoked):
+		return nil, newError(ErrorInvalidGrant, param+" has been revoked")
+	case errors.Is(err, auth.ErrBindingMismatch):
+		return nil, newError(ErrorInvalidGrant, param+" is bound to another key")
+	case auth.AccountInactive(err):
+		return nil, newError(ErrorInvalidGrant, "the user of the "+param+" is not active")
+	case errors.Is(err, auth.ErrTokenInvalid), errors.Is(err, auth.ErrUserNotFound):
+		return nil, newError(ErrorInvalidGrant, param+" is invalid")
+	}
+	return nil, err
+}
+
+// exchangeAudience checks the requested audiences against the client's
+// policy. Without a request the only allowed audience is used.
+func exchangeAudience(client models.OAuthClient, requested []string) ([]string, error) {
+	allowed := client.Exchange.Audiences
+	if len(requested) == 0 {
+		if len(allowed) != 1 {
+			return nil, newError(ErrorInvalidRequest, "audience is required")
+		}
+		return allowed, nil
+	}
+	for _, aud := range requested {
+		if !slices.Contains(allowed, aud) {
+			return nil, newError(ErrorInvalidTarget, fmt.Sprintf("audience %q is not allowed for the client", aud))
+		}
+	}
+	return requested, nil
+}
+
+// exchangeScope narrows the scope: the result must be allowed for the client
+// and, for scoped subject tokens, already granted to the subject token.
+// Without a request all scopes that satisfy both are granted.
+func exchangeScope(client models.OAuthClient, subject *jwtman.Claims, requested string) (string, error) {
+	scope, err := resolveScope(client, requested)
+	if err != nil {
+		return "", err
+	}
+	if subject.Scope == "" {
+		return scope, nil
+	}
+	granted := strings.Fields(subject.Scope)
+	var result []string
+	for _, s := range strings.Fields(scope) {
+		if slices.Contains(granted, s) {
+			result = append(result, s)
+		} else if requested != "" {
+			return "", newError(ErrorInvalidScope, fmt.Sprintf("scope %q was not granted to the subject token", s))
+		}
+	}
+	return strings.Join(result, " "), nil
+}
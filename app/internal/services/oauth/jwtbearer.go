@@ -0,0 +1,36 @@
+package oauth
+
+import (
+	"auth_service/internal/services/serviceaccount"
+	"context"
+	"errors"
+)
+
+// GrantJWTBearer is the JWT authorization grant of RFC 7523 section 2.1. It
+// is used by service accounts, which authenticate with the assertion instead
+// of client credentials.
+const GrantJWTBearer = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+func (o *OAuth) jwtBearer(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+	if o.ServiceAccounts == nil {
+		return nil, newError(ErrorUnsupportedGrantType, "")
+	}
+	if req.ClientID != "" || req.ClientSecret != "" {
+		return nil, newError(ErrorInvalidRequest, "service account assertions are not used with client authentication")
+	}
+	if req.Assertion == "" {
+		return nil, newError(ErrorInvalidRequest, "assertion is required")
+	}
+	tokens, err := o.ServiceAccounts.Token(ctx, req.Assertion, req.Binding)
+	if errors.Is(err, serviceaccount.ErrInvalidAssertion) {
+		return nil, newError(ErrorInvalidGrant, err.Error())
+	}
+	if err != nil {
+		return nil, tokenError(err)
+	}
+	return &TokenResponse{
+		AccessToken: tokens.AccessToken,
+		TokenType:   tokens.TokenType,
+		ExpiresIn:   int64(o.Auth.TokenManager(ctx).TokenDuration.Seconds()),
+	}, nil
+}
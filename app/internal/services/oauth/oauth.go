@@ -0,0 +1,547 @@
+// Package oauth implements the OAuth 2.0 authorization server on top of the
+// auth service: client registration, the authorization code grant with PKCE,
+// the refresh token grant, the client credentials grant, the device
+// authorization grant, token exchange and the JWT bearer grant of service
+// accounts.
+package oauth
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/serviceaccount"
+	"auth_service/internal/storage"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+
+	ResponseTypeCode = "code"
+)
+
+type ClientRepository interface {
+	GetClient(ctx context.Context, clientID string) (models.OAuthClient, error)
+	CreateClient(ctx context.Context, client models.OAuthClient) error
+	RotateClientSecret(ctx context.Context, clientID string, secretHash []byte, previousExpiresAt time.Time) error
+}
+
+// DefaultGrantTypes are allowed for clients registered without grant types
+var DefaultGrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken}
+
+var supportedGrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials, GrantDeviceCode, GrantTokenExchange}
+
+// CodeStorage keeps authorization codes and login sessions
+type CodeStorage interface {
+	auth.SessionStorage
+	MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+type OAuth struct {
+	Logger  *slog.Logger
+	Auth    *auth.Auth
+	Clients ClientRepository
+	Store   CodeStorage
+	// CodeTTL is the lifetime of authorization codes
+	CodeTTL time.Duration
+	// SessionTTL is how long a user stays logged in at the authorization endpoint
+	SessionTTL time.Duration
+	// DeviceCodeTTL is the lifetime of device codes and DevicePollInterval
+	// the minimum time between two polls of the token endpoint
+	DeviceCodeTTL      time.Duration
+	DevicePollInterval time.Duration
+	// OIDC enables OpenID Connect; nil disables it
+	OIDC *Provider
+	// ServiceAccounts enables the JWT bearer grant; nil disables it
+	ServiceAccounts *serviceaccount.Service
+}
+
+func NewOAuth(logger *slog.Logger, authSvc *auth.Auth, clients ClientRepository, store CodeStorage, codeTTL, sessionTTL time.Duration) *OAuth {
+	return &OAuth{
+		Logger:             logger,
+		Auth:               authSvc,
+		Clients:            clients,
+		Store:              store,
+		CodeTTL:            codeTTL,
+		SessionTTL:         sessionTTL,
+		DeviceCodeTTL:      10 * time.Minute,
+		DevicePollInterval: 5 * time.Second,
+	}
+}
+
+// AuthorizeRequest holds the parameters of an authorization request
+type AuthorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce is echoed in the ID token (OpenID Connect)
+	Nonce string
+}
+
+// authorizationCode is the record stored for an issued code
+type authorizationCode struct {
+	ClientID      string    `json:"client_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scope         string    `json:"scope"`
+	CodeChallenge string    `json:"code_challenge"`
+	UserID        string    `json:"uid"`
+	AuthTime      time.Time `json:"auth_time"`
+	Nonce         string    `json:"nonce,omitempty"`
+}
+
+// TokenRequest holds the parameters of a token request
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	DeviceCode   string
+	Scope        string
+	// token exchange parameters
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	ActorTokenType     string
+	RequestedTokenType string
+	Audience           []string
+	// Assertion is the JWT of the JWT bearer grant
+	Assertion string
+	Binding   auth.TokenBinding
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// IssuedTokenType is set for token exchange
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	ExpiresIn       int64  `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.IsAbs() && u.Host != "" && u.Fragment == ""
+}
+
+// RegisterClient creates a client and returns it with its secret. Public
+// clients get no secret. Only administrators may register clients.
+func (o *OAuth) RegisterClient(ctx context.Context, client models.OAuthClient) (models.OAuthClient, string, error) {
+	if _, err := o.Auth.RequireAdmin(ctx); err != nil {
+		return models.OAuthClient{}, "", err
+	}
+
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = DefaultGrantTypes
+	}
+
+	verr := &auth.ValidationError{}
+	if client.Name == "" {
+		verr.Add("name", "name is required")
+	}
+	for _, gt := range client.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, gt) {
+			verr.Add("grant_types", fmt.Sprintf("unsupported grant type %q", gt))
+		}
+	}
+	if client.Public && client.AllowsGrant(GrantClientCredentials) {
+		verr.Add("grant_types", "public clients can't use client_credentials")
+	}
+	if client.AllowsGrant(GrantTokenExchange) {
+		if client.Public {
+			verr.Add("grant_types", "public clients can't use token exchange")
+		}
+		if len(client.Exchange.Audiences) == 0 {
+			verr.Add("exchange_policy.audiences", "at least one audience is required for token exchange")
+		}
+	}
+	for _, aud := range client.Exchange.Audiences {
+		if aud == "" || strings.ContainsAny(aud, " \"\\") {
+			verr.Add("exchange_policy.audiences", fmt.Sprintf("invalid audience %q", aud))
+		}
+	}
+	if client.AllowsGrant(GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		verr.Add("redirect_uris", "at least one redirect uri is required")
+	}
+	for _, uri := range client.RedirectURIs {
+		if !validRedirectURI(uri) {
+			verr.Add("redirect_uris", fmt.Sprintf("invalid redirect uri %q", uri))
+		}
+	}
+	for _, scope := range client.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \"\\") {
+			verr.Add("scopes", fmt.Sprintf("invalid scope %q", scope))
+		}
+	}
+	if err := verr.Err(); err != nil {
+		return models.OAuthClient{}, "", err
+	}
+
+	client.ClientID = randomString(16)
+	var secret string
+	if !client.Public {
+		secret = randomString(32)
+		hash, err := auth.HashPassword([]byte(secret))
+		if err != nil {
+			return models.OAuthClient{}, "", err
+		}
+		client.SecretHash = hash
+	}
+	if err := o.Clients.CreateClient(ctx, client); err != nil {
+		return models.OAuthClient{}, "", err
+	}
+
+	o.Logger.Info("OAuth client registered", slog.String("client_id", client.ClientID), slog.Bool("public", client.Public))
+	return client, secret, nil
+}
+
+func (o *OAuth) client(ctx context.Context, clientID string) (models.OAuthClient, error) {
+	client, err := o.Clients.GetClient(ctx, clientID)
+	if errors.Is(err, storage.ErrClientNotFound) {
+		return models.OAuthClient{}, ErrUnknownClient
+	}
+	return client, err
+}
+
+// resolveScope checks the requested scopes against the client's allow-list.
+// No requested scope means all allowed scopes.
+func resolveScope(client models.OAuthClient, requested string) (string, error) {
+	if requested == "" {
+		return strings.Join(client.Scopes, " "), nil
+	}
+	scopes := strings.Fields(requested)
+	for _, s := range scopes {
+		if !slices.Contains(client.Scopes, s) {
+			return "", newError(ErrorInvalidScope, fmt.Sprintf("scope %q is not allowed for the client", s))
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// ValidateAuthorize checks an authorization request and fills in the
+// redirect uri and scope defaults. ErrUnknownClient and ErrInvalidRedirectURI
+// must be shown to the user; an *Error is sent to the redirect uri.
+func (o *OAuth) ValidateAuthorize(ctx context.Context, req AuthorizeRequest) (AuthorizeRequest, models.OAuthClient, error) {
+	client, err := o.client(ctx, req.ClientID)
+	if err != nil {
+		return req, models.OAuthClient{}, err
+	}
+
+	if req.RedirectURI == "" {
+		if len(client.RedirectURIs) != 1 {
+			return req, client, ErrInvalidRedirectURI
+		}
+		req.RedirectURI = client.RedirectURIs[0]
+	} else if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return req, client, ErrInvalidRedirectURI
+	}
+
+	if !client.AllowsGrant(GrantAuthorizationCode) {
+		return req, client, newError(ErrorUnauthorizedClient, "the client can't use the authorization code grant")
+	}
+	if req.ResponseType != ResponseTypeCode {
+		return req, client, newError(ErrorUnsupportedResponseType, "only the code response type is supported")
+	}
+	if req.CodeChallengeMethod != PKCEMethodS256 || !validChallenge(req.CodeChallenge) {
+		return req, client, newError(ErrorInvalidRequest, "a S256 code_challenge is required")
+	}
+	if req.Scope, err = resolveScope(client, req.Scope); err != nil {
+		return req, client, err
+	}
+	return req, client, nil
+}
+
+// IssueCode stores an authorization code for a validated request approved by
+// the user of the session
+func (o *OAuth) IssueCode(ctx context.Context, req AuthorizeRequest, session models.Session) (string, error) {
+	code := randomString(32)
+	value, err := json.Marshal(authorizationCode{
+		ClientID:      req.ClientID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		UserID:        session.UserID,
+		AuthTime:      session.CreatedAt,
+		Nonce:         req.Nonce,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := o.Store.SetSession(ctx, "oauth_code:"+code, string(value), o.CodeTTL); err != nil {
+		return "", err
+	}
+	o.Logger.Debug("Authorization code issued", slog.String("client_id", req.ClientID), slog.String("user_id", session.UserID))
+	return code, nil
+}
+
+// Login authenticates the user at the authorization endpoint and starts a
+// login session
+func (o *OAuth) Login(ctx context.Context, user models.NewUser) (string, models.Session, error) {
+	storedUser, err := o.Auth.Authenticate(ctx, user)
+	if err != nil {
+		return "", models.Session{}, err
+	}
+	id := randomString(32)
+	session := models.Session{UserID: strconv.Itoa(storedUser.UID), CreatedAt: time.Now(), Realm: realm.Name(ctx)}
+	value, err := json.Marshal(session)
+	if err != nil {
+		return "", models.Session{}, err
+	}
+	if err := o.Store.SetSession(ctx, "idp_session:"+id, string(value), o.SessionTTL); err != nil {
+		return "", models.Session{}, err
+	}
+	return id, session, nil
+}
+
+// Session returns the login session with the id
+func (o *OAuth) Session(ctx context.Context, id string) (models.Session, error) {
+	if id == "" {
+		return models.Session{}, ErrNoSession
+	}
+	value, err := o.Store.GetSession(ctx, "idp_session:"+id)
+	if errors.Is(err, redis.Nil) {
+		return models.Session{}, ErrNoSession
+	}
+	if err != nil {
+		return models.Session{}, err
+	}
+	var session models.Session
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return models.Session{}, fmt.Errorf("invalid stored session: %w", err)
+	}
+	// realms served under one host share the session cookie
+	if cmp.Or(session.Realm, realm.Default) != realm.Name(ctx) {
+		return models.Session{}, ErrNoSession
+	}
+	return session, nil
+}
+
+// EndSession logs the user out of the authorization endpoint
+func (o *OAuth) EndSession(ctx context.Context, id string) error {
+	return o.Store.DeleteSession(ctx, "idp_session:"+id)
+}
+
+// authenticateClient checks the client credentials of a token request
+func (o *OAuth) authenticateClient(ctx context.Context, clientID, secret string) (models.OAuthClient, error) {
+	if clientID == "" {
+		return models.OAuthClient{}, newError(ErrorInvalidClient, "client_id is required")
+	}
+	client, err := o.client(ctx, clientID)
+	if errors.Is(err, ErrUnknownClient) {
+		return models.OAuthClient{}, newError(ErrorInvalidClient, "unknown client")
+	}
+	if err != nil {
+		return models.OAuthClient{}, err
+	}
+	if client.Public {
+		if secret != "" {
+			return models.OAuthClient{}, newError(ErrorInvalidClient, "public clients have no secret")
+		}
+		return client, nil
+	}
+	if secret == "" || !checkSecret(client, secret) {
+		o.Logger.Info("Client authentication failed", slog.String("client_id", clientID))
+		return models.OAuthClient{}, newError(ErrorInvalidClient, "invalid client credentials")
+	}
+	return client, nil
+}
+
+// checkSecret accepts the current secret and, during a rollover, the previous one
+func checkSecret(client models.OAuthClient, secret string) bool {
+	if auth.CheckPasswordHash([]byte(secret), client.SecretHash) {
+		return true
+	}
+	return len(client.PreviousSecretHash) > 0 && time.Now().Before(client.PreviousSecretExpiresAt) &&
+		auth.CheckPasswordHash([]byte(secret), client.PreviousSecretHash)
+}
+
+// RotateClientSecret issues a new secret for a confidential client. The old
+// secret stays valid for the grace period; a secret rotated before that is
+// dropped immediately.
+func (o *OAuth) RotateClientSecret(ctx context.Context, clientID string, grace time.Duration) (string, time.Time, error) {
+	if _, err := o.Auth.RequireAdmin(ctx); err != nil {
+		return "", time.Time{}, err
+	}
+	client, err := o.client(ctx, clientID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if client.Public {
+		return "", time.Time{}, &auth.ValidationError{Violations: []auth.FieldViolation{{Field: "client_id", Description: "public clients have no secret"}}}
+	}
+	if grace < 0 {
+		return "", time.Time{}, &auth.ValidationError{Violations: []auth.FieldViolation{{Field: "grace_period", Description: "grace period can't be negative"}}}
+	}
+
+	secret := randomString(32)
+	hash, err := auth.HashPassword([]byte(secret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	previousExpiresAt := time.Now().Add(grace)
+	if err := o.Clients.RotateClientSecret(ctx, clientID, hash, previousExpiresAt); err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return "", time.Time{}, ErrUnknownClient
+		}
+		return "", time.Time{}, err
+	}
+
+	o.Logger.Info("OAuth client secret rotated", slog.String("client_id", clientID), slog.Time("previous_expires_at", previousExpiresAt))
+	return secret, previousExpiresAt, nil
+}
+
+// Token handles a request to the token endpoint
+func (o *OAuth) Token(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+	if req.GrantType == GrantJWTBearer {
+		return o.jwtBearer(ctx, req)
+	}
+	client, err := o.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GrantType == "" {
+		return nil, newError(ErrorInvalidRequest, "grant_type is required")
+	}
+	if !slices.Contains(supportedGrantTypes, req.GrantType) {
+		return nil, newError(ErrorUnsupportedGrantType, "")
+	}
+	if !client.AllowsGrant(req.GrantType) {
+		return nil, newError(ErrorUnauthorizedClient, fmt.Sprintf("the client can't use the %s grant", req.GrantType))
+	}
+
+	var tokens *auth.AuthResponse
+	var nonce string
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		tokens, nonce, err = o.exchangeCode(ctx, client, req)
+	case GrantRefreshToken:
+		tokens, err = o.Auth.RefreshGrant(ctx, req.RefreshToken, client.ClientID, req.Binding)
+	case GrantDeviceCode:
+		tokens, err = o.exchangeDeviceCode(ctx, client, req)
+	case GrantTokenExchange:
+		tokens, err = o.exchangeToken(ctx, client, req)
+	case GrantClientCredentials:
+		var scope string
+		if scope, err = resolveScope(client, req.Scope); err == nil {
+			tokens, err = o.Auth.IssueClientToken(ctx, client.ClientID, scope, req.Binding)
+		}
+	}
+	if err != nil {
+		return nil, tokenError(err)
+	}
+
+	resp := &TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    tokens.TokenType,
+		ExpiresIn:    int64(o.Auth.TokenManager(ctx).TokenDuration.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		Scope:        tokens.Scope,
+	}
+	if req.GrantType == GrantTokenExchange {
+		resp.IssuedTokenType = TokenTypeAccessToken
+	}
+	if o.OIDC != nil && tokens.Grant.UserID != 0 && hasScope(tokens.Scope, ScopeOpenID) {
+		if resp.IDToken, err = o.idToken(ctx, tokens.Grant, nonce, tokens.AccessToken); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// tokenError converts auth service errors into OAuth errors
+func tokenError(err error) error {
+	var oerr *Error
+	var verr *auth.ValidationError
+	switch {
+	case errors.As(err, &oerr):
+		return err
+	case errors.As(err, &verr):
+		return newError(ErrorInvalidRequest, verr.Error())
+	case errors.Is(err, auth.ErrInvalidDPoPProof):
+		return newError(ErrorInvalidDPoPProof, "")
+	case errors.Is(err, auth.ErrTokenExpired), errors.Is(err, auth.ErrTokenReused),
+		errors.Is(err, auth.ErrTokenInvalid), errors.Is(err, auth.ErrBindingMismatch),
+		errors.Is(err, auth.ErrUserNotFound), auth.AccountInactive(err):
+		return newError(ErrorInvalidGrant, err.Error())
+	}
+	return err
+}
+
+func (o *OAuth) exchangeCode(ctx context.Context, client models.OAuthClient, req TokenRequest) (*auth.AuthResponse, string, error) {
+	if req.Code == "" {
+		return nil, "", newError(ErrorInvalidRequest, "code is required")
+	}
+	// codes are single use even when two requests race
+	first, err := o.Store.MarkUsed(ctx, "oauth_code_used:"+req.Code, o.CodeTTL)
+	if err != nil {
+		return nil, "", err
+	}
+	if !first {
+		o.Logger.Warn("Authorization code reused", slog.String("client_id", client.ClientID))
+		return nil, "", newError(ErrorInvalidGrant, "code already used")
+	}
+
+	key := "oauth_code:" + req.Code
+	value, err := o.Store.GetSession(ctx, key)
+	if errors.Is(err, redis.Nil) {
+		return nil, "", newError(ErrorInvalidGrant, "code expired or invalid")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := o.Store.DeleteSession(ctx, key); err != nil {
+		o.Logger.Warn("Failed delete authorization code", slog.Any("error", err))
+	}
+
+	var code authorizationCode
+	if err := json.Unmarshal([]byte(value), &code); err != nil {
+		return nil, "", fmt.Errorf("invalid stored code: %w", err)
+	}
+	if code.ClientID != client.ClientID {
+		return nil, "", newError(ErrorInvalidGrant, "code was issued to another client")
+	}
+	if req.RedirectURI != "" && req.RedirectURI != code.RedirectURI {
+		return nil, "", newError(ErrorInvalidGrant, "redirect_uri does not match")
+	}
+	if !verifyPKCE(code.CodeChallenge, req.CodeVerifier) {
+		return nil, "", newError(ErrorInvalidGrant, "code_verifier does not match the code challenge")
+	}
+
+	uid, err := strconv.Atoi(code.UserID)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid stored user id: %w", err)
+	}
+	tokens, err := o.Auth.IssueTokens(ctx, auth.Grant{UserID: uid, ClientID: client.ClientID, Scope: code.Scope, AuthTime: code.AuthTime}, req.Binding)
+	return tokens, code.Nonce, err
+}
@@ -0,0 +1,592 @@
+package oauth_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/idtoken"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/oauth"
+	"auth_service/internal/storage"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type MockUsers struct {
+	user models.User
+}
+
+func (m *MockUsers) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if email != m.user.Email {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return m.user, nil
+}
+
+func (m *MockUsers) GetUserByID(ctx context.Context, UID int) (models.User, error) {
+	if UID != m.user.UID {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return m.user, nil
+}
+
+func (m *MockUsers) CreateNewUser(ctx context.Context, user models.NewUser) error {
+	return nil
+}
+
+func (m *MockUsers) IsAdmin(ctx context.Context, UID int) bool {
+	return UID == m.user.UID
+}
+
+type MemoryClients struct {
+	clients map[string]models.OAuthClient
+}
+
+func (m *MemoryClients) GetClient(ctx context.Context, clientID string) (models.OAuthClient, error) {
+	client, ok := m.clients[clientID]
+	if !ok {
+		return models.OAuthClient{}, storage.ErrClientNotFound
+	}
+	return client, nil
+}
+
+func (m *MemoryClients) CreateClient(ctx context.Context, client models.OAuthClient) error {
+	m.clients[client.ClientID] = client
+	return nil
+}
+
+func (m *MemoryClients) RotateClientSecret(ctx context.Context, clientID string, secretHash []byte, previousExpiresAt time.Time) error {
+	client, ok := m.clients[clientID]
+	if !ok {
+		return storage.ErrClientNotFound
+	}
+	client.PreviousSecretHash, client.PreviousSecretExpiresAt = client.SecretHash, previousExpiresAt
+	client.SecretHash = secretHash
+	m.clients[clientID] = client
+	return nil
+}
+
+type MemoryStore struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (m *MemoryStore) SetSession(ctx context.Context, key string, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	return nil
+}
+
+func (m *MemoryStore) GetSession(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[key]
+	if !ok {
+		return "", redis.Nil
+	}
+	return v, nil
+}
+
+func (m *MemoryStore) DeleteSession(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *MemoryStore) MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[key]; ok {
+		return false, nil
+	}
+	m.data[key] = "1"
+	return true, nil
+}
+
+const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func newOAuth(t *testing.T) (*oauth.OAuth, context.Context) {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("examplepass"), bcrypt.MinCost)
+	users := &MockUsers{user: models.User{UID: 7, Email: "admin@example.com", HashPass: hash}}
+	store := &MemoryStore{data: map[string]string{}}
+	jwt := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), users, store, jwt)
+	svc := oauth.NewOAuth(slog.Default(), authSvc, &MemoryClients{clients: map[string]models.OAuthClient{}}, store, time.Minute, time.Hour)
+
+	// an administrator calling with a first party token
+	ctx := jwtman.NewContext(context.Background(), &jwtman.Claims{UserID: "7"})
+	return svc, ctx
+}
+
+func TestOAuth_AuthorizationCodeFlow(t *testing.T) {
+	svc, adminCtx := newOAuth(t)
+	ctx := context.Background()
+
+	client, secret, err := svc.RegisterClient(adminCtx, models.OAuthClient{
+		Name:         "app",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		Scopes:       []string{"read", "write"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if secret == "" {
+		t.Fatal("expected a secret for a confidential client")
+	}
+
+	req, _, err := svc.ValidateAuthorize(ctx, oauth.AuthorizeRequest{
+		ClientID:            client.ClientID,
+		ResponseType:        "code",
+		Scope:               "read",
+		CodeChallenge:       oauth.S256Challenge(verifier),
+		CodeChallengeMethod: "S256",
+	})
+	if err != nil {
+		t.Fatalf("expected valid request, got %v", err)
+	}
+	if req.RedirectURI != "https://app.example.com/callback" {
+		t.Errorf("expected the only registered redirect uri, got %q", req.RedirectURI)
+	}
+
+	_, session, err := svc.Login(ctx, models.NewUser{Email: "admin@example.com", HashPass: []byte("examplepass")})
+	if err != nil {
+		t.Fatalf("expected login, got %v", err)
+	}
+	code, err := svc.IssueCode(ctx, req, session)
+	if err != nil {
+		t.Fatalf("expected code, got %v", err)
+	}
+
+	tokenReq := oauth.TokenRequest{
+		GrantType:    oauth.GrantAuthorizationCode,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		Code:         code,
+		CodeVerifier: strings.Repeat("a", 43),
+	}
+	var oerr *oauth.Error
+	if _, err := svc.Token(ctx, tokenReq); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorInvalidGrant {
+		t.Fatalf("expected invalid_grant for a wrong verifier, got %v", err)
+	}
+
+	// the failed attempt consumed the code
+	tokenReq.CodeVerifier = verifier
+	if _, err := svc.Token(ctx, tokenReq); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorInvalidGrant {
+		t.Fatalf("expected invalid_grant for a used code, got %v", err)
+	}
+
+	code, _ = svc.IssueCode(ctx, req, session)
+	tokenReq.Code = code
+	resp, err := svc.Token(ctx, tokenReq)
+	if err != nil {
+		t.Fatalf("expected tokens, got %v", err)
+	}
+	if resp.Scope != "read" || resp.RefreshToken == "" || resp.ExpiresIn != 900 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	claims, err := svc.Auth.JWT.VerifyToken(resp.AccessToken)
+	if err != nil || claims.ClientID != client.ClientID || claims.Scope != "read" || claims.UserID != "7" {
+		t.Errorf("unexpected claims %+v: %v", claims, err)
+	}
+
+	// first party refresh can't rotate a client's refresh token
+	if _, err := svc.Auth.Refresh(ctx, resp.RefreshToken, auth.TokenBinding{}); !errors.Is(err, auth.ErrTokenInvalid) {
+		t.Errorf("expected ErrTokenInvalid, got %v", err)
+	}
+	refreshed, err := svc.Token(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantRefreshToken,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		RefreshToken: resp.RefreshToken,
+	})
+	if err != nil || refreshed.Scope != "read" {
+		t.Fatalf("expected refreshed tokens with the same scope, got %+v, %v", refreshed, err)
+	}
+}
+
+func TestOAuth_ValidateAuthorize(t *testing.T) {
+	svc, adminCtx := newOAuth(t)
+	client, _, err := svc.RegisterClient(adminCtx, models.OAuthClient{
+		Name:         "spa",
+		RedirectURIs: []string{"https://a.example.com/cb", "https://b.example.com/cb"},
+		Scopes:       []string{"read"},
+		Public:       true,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	valid := oauth.AuthorizeRequest{
+		ClientID:            client.ClientID,
+		RedirectURI:         "https://a.example.com/cb",
+		ResponseType:        "code",
+		CodeChallenge:       oauth.S256Challenge(verifier),
+		CodeChallengeMethod: "S256",
+	}
+	tests := []struct {
+		name   string
+		modify func(*oauth.AuthorizeRequest)
+		err    error
+		code   string
+	}{
+		{"valid", func(r *oauth.AuthorizeRequest) {}, nil, ""},
+		{"unknown client", func(r *oauth.AuthorizeRequest) { r.ClientID = "nope" }, oauth.ErrUnknownClient, ""},
+		{"unregistered redirect", func(r *oauth.AuthorizeRequest) { r.RedirectURI = "https://evil.example.com/cb" }, oauth.ErrInvalidRedirectURI, ""},
+		{"ambiguous redirect", func(r *oauth.AuthorizeRequest) { r.RedirectURI = "" }, oauth.ErrInvalidRedirectURI, ""},
+		{"no pkce", func(r *oauth.AuthorizeRequest) { r.CodeChallenge = "" }, nil, oauth.ErrorInvalidRequest},
+		{"plain pkce", func(r *oauth.AuthorizeRequest) { r.CodeChallengeMethod = "plain" }, nil, oauth.ErrorInvalidRequest},
+		{"token response", func(r *oauth.AuthorizeRequest) { r.ResponseType = "token" }, nil, oauth.ErrorUnsupportedResponseType},
+		{"scope", func(r *oauth.AuthorizeRequest) { r.Scope = "read admin" }, nil, oauth.ErrorInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+			_, _, err := svc.ValidateAuthorize(context.Background(), req)
+			var oerr *oauth.Error
+			switch {
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+			case tt.code != "":
+				if !errors.As(err, &oerr) || oerr.Code != tt.code {
+					t.Errorf("expected %s, got %v", tt.code, err)
+				}
+			case err != nil:
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOAuth_RegisterClientRequiresAdmin(t *testing.T) {
+	svc, _ := newOAuth(t)
+	ctx := jwtman.NewContext(context.Background(), &jwtman.Claims{UserID: "8"})
+	_, _, err := svc.RegisterClient(ctx, models.OAuthClient{Name: "x", RedirectURIs: []string{"https://x.example.com"}})
+	if !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+}
+
+func TestOAuth_ClientCredentials(t *testing.T) {
+	svc, adminCtx := newOAuth(t)
+	ctx := context.Background()
+
+	client, secret, err := svc.RegisterClient(adminCtx, models.OAuthClient{
+		Name:       "billing job",
+		Scopes:     []string{"invoices:read", "invoices:write"},
+		GrantTypes: []string{oauth.GrantClientCredentials},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	req := oauth.TokenRequest{GrantType: oauth.GrantClientCredentials, ClientID: client.ClientID, ClientSecret: secret, Scope: "invoices:read"}
+	resp, err := svc.Token(ctx, req)
+	if err != nil {
+		t.Fatalf("expected token, got %v", err)
+	}
+	if resp.RefreshToken != "" {
+		t.Error("client credentials grant must not issue a refresh token")
+	}
+	claims, err := svc.Auth.JWT.VerifyToken(resp.AccessToken)
+	if err != nil || claims.Subject != client.ClientID || claims.UserID != "" || claims.Scope != "invoices:read" {
+		t.Errorf("unexpected claims %+v: %v", claims, err)
+	}
+
+	var oerr *oauth.Error
+	if _, err := svc.Token(ctx, oauth.TokenRequest{GrantType: oauth.GrantAuthorizationCode, ClientID: client.ClientID, ClientSecret: secret, Code: "x"}); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorUnauthorizedClient {
+		t.Errorf("expected unauthorized_client, got %v", err)
+	}
+
+	// both secrets work during the rollover, only the new one after a second rotation
+	newSecret, _, err := svc.RotateClientSecret(adminCtx, client.ClientID, time.Hour)
+	if err != nil {
+		t.Fatalf("expected rotation, got %v", err)
+	}
+	for _, s := range []string{secret, newSecret} {
+		req.ClientSecret = s
+		if _, err := svc.Token(ctx, req); err != nil {
+			t.Errorf("expected secret to be valid during rollover, got %v", err)
+		}
+	}
+	if _, _, err := svc.RotateClientSecret(adminCtx, client.ClientID, 0); err != nil {
+		t.Fatalf("expected rotation, got %v", err)
+	}
+	req.ClientSecret = newSecret
+	if _, err := svc.Token(ctx, req); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorInvalidClient {
+		t.Errorf("expected invalid_client after the grace period, got %v", err)
+	}
+}
+
+func TestOAuth_OpenIDConnect(t *testing.T) {
+	svc, adminCtx := newOAuth(t)
+	signer, err := idtoken.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.OIDC = &oauth.Provider{Issuer: "https://id.example.com", Signer: signer}
+	ctx := context.Background()
+
+	client, secret, _ := svc.RegisterClient(adminCtx, models.OAuthClient{
+		Name:         "rp",
+		RedirectURIs: []string{"https://rp.example.com/cb"},
+		Scopes:       []string{"openid", "email"},
+	})
+	req, _, err := svc.ValidateAuthorize(ctx, oauth.AuthorizeRequest{
+		ClientID:            client.ClientID,
+		ResponseType:        "code",
+		Scope:               "openid email",
+		Nonce:               "n-0S6_WzA2Mj",
+		CodeChallenge:       oauth.S256Challenge(verifier),
+		CodeChallengeMethod: "S256",
+	})
+	if err != nil {
+		t.Fatalf("expected valid request, got %v", err)
+	}
+	_, session, _ := svc.Login(ctx, models.NewUser{Email: "admin@example.com", HashPass: []byte("examplepass")})
+	code, _ := svc.IssueCode(ctx, req, session)
+
+	resp, err := svc.Token(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantAuthorizationCode,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		Code:         code,
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		t.Fatalf("expected tokens, got %v", err)
+	}
+
+	claims, err := signer.Verify(resp.IDToken, jwtlib.WithIssuer("https://id.example.com"), jwtlib.WithAudience(client.ClientID))
+	if err != nil {
+		t.Fatalf("invalid id token: %v", err)
+	}
+	if claims.Subject != "7" || claims.Nonce != "n-0S6_WzA2Mj" || claims.Email != "admin@example.com" || claims.EmailVerified == nil {
+		t.Errorf("unexpected id token claims %+v", claims)
+	}
+	if claims.AtHash != signer.AccessTokenHash(resp.AccessToken) {
+		t.Error("at_hash does not match the access token")
+	}
+	if claims.AuthTime == nil || !claims.AuthTime.Time.Equal(session.CreatedAt.Truncate(time.Second)) {
+		t.Errorf("expected auth_time of the login session, got %v", claims.AuthTime)
+	}
+
+	access, _ := svc.Auth.JWT.VerifyToken(resp.AccessToken)
+	info, err := svc.UserInfo(ctx, access)
+	if err != nil || info.Subject != "7" || info.Email != "admin@example.com" {
+		t.Errorf("unexpected userinfo %+v: %v", info, err)
+	}
+
+	if d := svc.Discovery(ctx); d.JWKSURI != "https://id.example.com/.well-known/jwks.json" || d.IDTokenSigningAlgValuesSupported[0] != "ES256" {
+		t.Errorf("unexpected discovery document %+v", d)
+	}
+}
+
+func TestOAuth_DeviceAuthorization(t *testing.T) {
+	svc, adminCtx := newOAuth(t)
+	ctx := context.Background()
+
+	client, _, err := svc.RegisterClient(adminCtx, models.OAuthClient{
+		Name:       "cli",
+		Scopes:     []string{"read"},
+		Public:     true,
+		GrantTypes: []string{oauth.GrantDeviceCode, oauth.GrantRefreshToken},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	device, err := svc.AuthorizeDevice(ctx, client.ClientID, "", "", "https://auth.example.com/oauth/device")
+	if err != nil {
+		t.Fatalf("expected device authorization, got %v", err)
+	}
+	if len(device.UserCode) != 9 || device.Interval != 5 || device.ExpiresIn != 600 ||
+		device.VerificationURIComplete != "https://auth.example.com/oauth/device?user_code="+device.UserCode {
+		t.Errorf("unexpected response %+v", device)
+	}
+
+	poll := oauth.TokenRequest{GrantType: oauth.GrantDeviceCode, ClientID: client.ClientID, DeviceCode: device.DeviceCode}
+	var oerr *oauth.Error
+	if _, err := svc.Token(ctx, poll); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorAuthorizationPending {
+		t.Fatalf("expected authorization_pending, got %v", err)
+	}
+	if _, err := svc.Token(ctx, poll); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorSlowDown {
+		t.Fatalf("expected slow_down for a poll within the interval, got %v", err)
+	}
+
+	// user codes are accepted in any case and without the dash
+	req, err := svc.LookupDevice(ctx, strings.ToLower(strings.ReplaceAll(device.UserCode, "-", "")))
+	if err != nil || req.Client.ClientID != client.ClientID || req.Scope != "read" {
+		t.Fatalf("unexpected lookup %+v: %v", req, err)
+	}
+	_, session, err := svc.Login(ctx, models.NewUser{Email: "admin@example.com", HashPass: []byte("examplepass")})
+	if err != nil {
+		t.Fatalf("expected login, got %v", err)
+	}
+	if err := svc.CompleteDevice(ctx, device.UserCode, session, true); err != nil {
+		t.Fatalf("expected approval, got %v", err)
+	}
+	if err := svc.CompleteDevice(ctx, device.UserCode, session, false); !errors.Is(err, oauth.ErrUnknownUserCode) {
+		t.Errorf("expected the user code to be single use, got %v", err)
+	}
+
+	// let the poll interval pass
+	_ = svc.Store.DeleteSession(ctx, "device_poll:"+device.DeviceCode)
+	resp, err := svc.Token(ctx, poll)
+	if err != nil {
+		t.Fatalf("expected tokens, got %v", err)
+	}
+	claims, err := svc.Auth.JWT.VerifyToken(resp.AccessToken)
+	if err != nil || claims.UserID != "7" || claims.ClientID != client.ClientID || resp.RefreshToken == "" {
+		t.Errorf("unexpected tokens %+v, claims %+v: %v", resp, claims, err)
+	}
+
+	_ = svc.Store.DeleteSession(ctx, "device_poll:"+device.DeviceCode)
+	if _, err := svc.Token(ctx, poll); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorExpiredToken {
+		t.Errorf("expected a used device code to be gone, got %v", err)
+	}
+
+	denied, _ := svc.AuthorizeDevice(ctx, client.ClientID, "", "", "https://auth.example.com/oauth/device")
+	if err := svc.CompleteDevice(ctx, denied.UserCode, session, false); err != nil {
+		t.Fatalf("expected denial, got %v", err)
+	}
+	poll.DeviceCode = denied.DeviceCode
+	if _, err := svc.Token(ctx, poll); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorAccessDenied {
+		t.Errorf("expected access_denied, got %v", err)
+	}
+}
+
+func TestOAuth_TokenExchange(t *testing.T) {
+	svc, adminCtx := newOAuth(t)
+	ctx := context.Background()
+
+	if _, _, err := svc.RegisterClient(adminCtx, models.OAuthClient{
+		Name:       "no policy",
+		GrantTypes: []string{oauth.GrantTokenExchange},
+	}); err == nil {
+		t.Fatal("expected token exchange without audiences to be rejected")
+	}
+	frontend, secret, err := svc.RegisterClient(adminCtx, models.OAuthClient{
+		Name:       "frontend",
+		Scopes:     []string{"read", "write", "admin"},
+		GrantTypes: []string{oauth.GrantTokenExchange, oauth.GrantClientCredentials},
+		Exchange:   models.ExchangePolicy{Audiences: []string{"https://orders.internal", "https://billing.internal"}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	user, err := svc.Auth.IssueTokens(ctx, auth.Grant{UserID: 7, ClientID: "spa", Scope: "read write"}, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected tokens, got %v", err)
+	}
+	bound, err := svc.Auth.IssueTokens(ctx, auth.Grant{UserID: 7, ClientID: "spa", Scope: "read write"}, auth.TokenBinding{CertThumbprint: "thumb"})
+	if err != nil {
+		t.Fatalf("expected tokens, got %v", err)
+	}
+	req := oauth.TokenRequest{
+		GrantType:        oauth.GrantTokenExchange,
+		ClientID:         frontend.ClientID,
+		ClientSecret:     secret,
+		SubjectToken:     user.AccessToken,
+		SubjectTokenType: oauth.TokenTypeAccessToken,
+		Audience:         []string{"https://orders.internal"},
+	}
+
+	var oerr *oauth.Error
+	tests := []struct {
+		name   string
+		modify func(r *oauth.TokenRequest)
+		code   string
+	}{
+		{"scope not granted to the subject", func(r *oauth.TokenRequest) { r.Scope = "admin" }, oauth.ErrorInvalidScope},
+		{"audience not in policy", func(r *oauth.TokenRequest) { r.Audience = []string{"https://other.internal"} }, oauth.ErrorInvalidTarget},
+		{"ambiguous default audience", func(r *oauth.TokenRequest) { r.Audience = nil }, oauth.ErrorInvalidRequest},
+		{"invalid subject token", func(r *oauth.TokenRequest) { r.SubjectToken = "x" }, oauth.ErrorInvalidGrant},
+		{"bound subject token without its key", func(r *oauth.TokenRequest) { r.SubjectToken = bound.AccessToken }, oauth.ErrorInvalidGrant},
+		{"missing token type", func(r *oauth.TokenRequest) { r.SubjectTokenType = "" }, oauth.ErrorInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := req
+			tt.modify(&r)
+			if _, err := svc.Token(ctx, r); !errors.As(err, &oerr) || oerr.Code != tt.code {
+				t.Errorf("expected %s, got %v", tt.code, err)
+			}
+		})
+	}
+
+	req.Scope = "read"
+	resp, err := svc.Token(ctx, req)
+	if err != nil {
+		t.Fatalf("expected exchanged token, got %v", err)
+	}
+	if resp.IssuedTokenType != oauth.TokenTypeAccessToken || resp.RefreshToken != "" || resp.Scope != "read" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	claims, err := svc.Auth.JWT.VerifyToken(resp.AccessToken)
+	if err != nil {
+		t.Fatalf("expected valid token, got %v", err)
+	}
+	if claims.UserID != "7" || claims.ClientID != frontend.ClientID || len(claims.Audience) != 1 ||
+		claims.Audience[0] != "https://orders.internal" || claims.Act == nil || claims.Act.Subject != frontend.ClientID {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	// exchanging the exchanged token with an actor token extends the chain
+	actor, err := svc.Token(ctx, oauth.TokenRequest{GrantType: oauth.GrantClientCredentials, ClientID: frontend.ClientID, ClientSecret: secret, Scope: "read"})
+	if err != nil {
+		t.Fatalf("expected client token, got %v", err)
+	}
+	req.SubjectToken = resp.AccessToken
+	req.ActorToken = actor.AccessToken
+	req.ActorTokenType = oauth.TokenTypeAccessToken
+	req.Audience = []string{"https://billing.internal"}
+	req.Scope = ""
+	resp, err = svc.Token(ctx, req)
+	if err != nil {
+		t.Fatalf("expected exchanged token, got %v", err)
+	}
+	claims, _ = svc.Auth.JWT.VerifyToken(resp.AccessToken)
+	if claims.Scope != "read" || claims.Act.Depth() != 2 || claims.Act.Act.Subject != frontend.ClientID {
+		t.Errorf("unexpected claims %+v, act %+v", claims, claims.Act)
+	}
+
+	// disabling the user stops the exchange of their unexpired tokens at once
+	users := svc.Auth.Storage.(*MockUsers)
+	users.user.Status = models.UserDisabled
+	if err := svc.Auth.RevokeAccessTokens(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Token(ctx, req); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorInvalidGrant {
+		t.Errorf("expected invalid_grant for a revoked subject token, got %v", err)
+	}
+	users.user.Status = models.UserActive
+	if _, err := svc.Token(ctx, req); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorInvalidGrant {
+		t.Errorf("expected the subject token to stay revoked after enable, got %v", err)
+	}
+	// the status is checked as well, for tokens the denylist doesn't cover
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	fresh, err := svc.Auth.IssueTokens(ctx, auth.Grant{UserID: 7, ClientID: "spa", Scope: "read"}, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected tokens, got %v", err)
+	}
+	users.user.Status = models.UserLocked
+	req.SubjectToken, req.ActorToken, req.ActorTokenType = fresh.AccessToken, "", ""
+	if _, err := svc.Token(ctx, req); !errors.As(err, &oerr) || oerr.Code != oauth.ErrorInvalidGrant {
+		t.Errorf("expected invalid_grant for the token of a locked user, got %v", err)
+	}
+}
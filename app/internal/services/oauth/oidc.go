@@ -0,0 +1,162 @@
+package oauth
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/dpop"
+	"auth_service/internal/JWT/idtoken"
+	"auth_service/internal/realm"
+	"auth_service/internal/services/auth"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes defined by OpenID Connect Core 1.0 that change the issued claims
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// ErrInsufficientScope is returned by UserInfo for tokens without openid
+var ErrInsufficientScope = errors.New("token lacks the openid scope")
+
+// Provider is the OpenID Connect configuration
+type Provider struct {
+	// Issuer is the https URL the endpoints are served under
+	Issuer string
+	Signer *idtoken.Signer
+}
+
+// Discovery is the OpenID Provider Metadata document
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported"`
+	AuthorizationResponseIssParameter bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// Issuer returns the issuer of the realm of ctx, by default the provider's
+func (o *OAuth) Issuer(ctx context.Context) string {
+	if r := realm.FromContext(ctx); r != nil && r.Issuer != "" {
+		return r.Issuer
+	}
+	return strings.TrimSuffix(o.OIDC.Issuer, "/")
+}
+
+// Signer returns the ID token signer of the realm of ctx, by default the
+// provider's
+func (o *OAuth) Signer(ctx context.Context) *idtoken.Signer {
+	if r := realm.FromContext(ctx); r != nil && r.IDTokens != nil {
+		return r.IDTokens
+	}
+	return o.OIDC.Signer
+}
+
+// Discovery describes the provider of the realm of ctx for
+// /.well-known/openid-configuration
+func (o *OAuth) Discovery(ctx context.Context) Discovery {
+	issuer := o.Issuer(ctx)
+	grantTypes := supportedGrantTypes
+	if o.ServiceAccounts != nil {
+		grantTypes = append(slices.Clip(grantTypes), GrantJWTBearer)
+	}
+	return Discovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		DeviceAuthorizationEndpoint:       issuer + "/oauth/device_authorization",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		ResponseTypesSupported:            []string{ResponseTypeCode},
+		GrantTypesSupported:               grantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{o.Signer(ctx).Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{PKCEMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "email", "email_verified", "preferred_username"},
+		DPoPSigningAlgValuesSupported:     dpop.Algorithms,
+		AuthorizationResponseIssParameter: true,
+	}
+}
+
+func hasScope(scope, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
+
+// userClaims fills the claims the scope grants access to
+func (o *OAuth) userClaims(ctx context.Context, uid int, scope string, claims *idtoken.Claims) error {
+	if !hasScope(scope, ScopeEmail) && !hasScope(scope, ScopeProfile) {
+		return nil
+	}
+	user, err := o.Auth.Storage.GetUserByID(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if hasScope(scope, ScopeEmail) {
+		claims.Email = user.Email
+		claims.EmailVerified = &user.EmailVerified
+	}
+	if hasScope(scope, ScopeProfile) {
+		claims.PreferredUsername = user.Email
+	}
+	return nil
+}
+
+func (o *OAuth) idToken(ctx context.Context, grant auth.Grant, nonce, accessToken string) (string, error) {
+	now := time.Now()
+	signer := o.Signer(ctx)
+	claims := &idtoken.Claims{
+		Nonce:  nonce,
+		AtHash: signer.AccessTokenHash(accessToken),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    o.Issuer(ctx),
+			Subject:   strconv.Itoa(grant.UserID),
+			Audience:  jwt.ClaimStrings{grant.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(o.Auth.TokenManager(ctx).TokenDuration)),
+		},
+	}
+	if !grant.AuthTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(grant.AuthTime)
+	}
+	if err := o.userClaims(ctx, grant.UserID, grant.Scope, claims); err != nil {
+		return "", err
+	}
+	return signer.Sign(claims)
+}
+
+// UserInfo returns the claims about the user of an access token with the
+// openid scope
+func (o *OAuth) UserInfo(ctx context.Context, token *jwtman.Claims) (*idtoken.Claims, error) {
+	if !hasScope(token.Scope, ScopeOpenID) {
+		return nil, ErrInsufficientScope
+	}
+	uid, err := strconv.Atoi(token.UserID)
+	if err != nil {
+		return nil, ErrInsufficientScope
+	}
+	claims := &idtoken.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: token.UserID}}
+	if err := o.userClaims(ctx, uid, token.Scope, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
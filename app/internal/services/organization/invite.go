@@ -0,0 +1,118 @@
+package organization
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/storage"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// inviteKey derives the key invite tokens are signed with from the access
+// token key of the realm, so an invite token is never a valid access token
+func (s *Service) inviteKey(ctx context.Context) []byte {
+	mac := hmac.New(sha256.New, s.Auth.TokenManager(ctx).SecretKey)
+	mac.Write([]byte("organization invitation"))
+	return mac.Sum(nil)
+}
+
+// Invite creates an invitation for the email to join with the role, member
+// if empty, and returns the signed invite token to deliver to it. Owners and
+// admins invite; only owners invite owners.
+func (s *Service) Invite(ctx context.Context, orgID, email, role string) (string, time.Time, error) {
+	if role == "" {
+		role = models.OrgRoleMember
+	}
+	verr := &auth.ValidationError{}
+	if _, err := mail.ParseAddress(email); err != nil {
+		verr.Add("email", "invalid email")
+	}
+	if err := validateRole(role); err != nil {
+		verr.Add("role", "role must be owner, admin or member")
+	}
+	if err := verr.Err(); err != nil {
+		return "", time.Time{}, err
+	}
+	caller, err := s.manager(ctx, orgID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if role == models.OrgRoleOwner && caller.Role != models.OrgRoleOwner {
+		return "", time.Time{}, auth.ErrPermissionDenied
+	}
+
+	now := time.Now()
+	inv := models.Invitation{
+		ID:        uuid.NewString(),
+		OrgID:     orgID,
+		Email:     strings.TrimSpace(email),
+		Role:      role,
+		InvitedBy: caller.UserID,
+		ExpiresAt: now.Add(s.InviteTTL).Truncate(time.Second),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ID:        inv.ID,
+		Subject:   inv.Email,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(inv.ExpiresAt),
+	}).SignedString(s.inviteKey(ctx))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := s.Orgs.CreateInvitation(ctx, inv); err != nil {
+		return "", time.Time{}, mapError(err)
+	}
+	s.Logger.Info("Member invited", slog.String("org_id", orgID), slog.String("role", role), slog.Int("by", caller.UserID))
+	return token, inv.ExpiresAt, nil
+}
+
+// Accept adds the invited user to the organization and returns tokens with
+// it active. The password authenticates the account of the invited email,
+// or registers it if there is none.
+func (s *Service) Accept(ctx context.Context, token, password string, binding auth.TokenBinding) (*auth.AuthResponse, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return s.inviteKey(ctx), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		s.Logger.Info("Invite token rejected", slog.Any("reason", err))
+		return nil, ErrInvalidInvitation
+	}
+	inv, err := s.Orgs.GetInvitation(ctx, claims.ID)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	if !inv.AcceptedAt.IsZero() || time.Now().After(inv.ExpiresAt) || inv.Email != claims.Subject {
+		return nil, ErrInvalidInvitation
+	}
+
+	credentials := models.NewUser{Email: inv.Email, HashPass: []byte(password)}
+	user, err := s.Auth.Storage.GetUserByEmail(ctx, inv.Email)
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		if err = s.Auth.Register(ctx, credentials); err == nil {
+			user, err = s.Auth.Storage.GetUserByEmail(ctx, inv.Email)
+		}
+	case err == nil:
+		user, err = s.Auth.Authenticate(ctx, credentials)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	member := models.Membership{OrgID: inv.OrgID, UserID: user.UID, Role: inv.Role}
+	if err := s.Orgs.AcceptInvitation(ctx, inv.ID, member); err != nil {
+		return nil, mapError(err)
+	}
+	s.Logger.Info("Invitation accepted", slog.String("org_id", inv.OrgID), slog.Int("uid", user.UID), slog.String("role", inv.Role))
+	return s.Auth.IssueTokens(ctx, auth.Grant{UserID: user.UID, AuthTime: time.Now(), OrgID: inv.OrgID, OrgRole: inv.Role}, binding)
+}
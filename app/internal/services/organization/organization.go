@@ -0,0 +1,241 @@
+// Package organization groups users into organizations with owner, admin
+// and member roles and lets members invite others by email.
+package organization
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/storage"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrMemberNotFound       = errors.New("member not found")
+	ErrMemberExists         = errors.New("user is already a member of the organization")
+	// ErrInvalidInvitation is returned for invite tokens that are forged,
+	// expired or already accepted
+	ErrInvalidInvitation = errors.New("invalid or expired invitation")
+	ErrLastOwner         = errors.New("the organization must keep an owner")
+)
+
+// roles lists the member roles, most privileged first
+var roles = []string{models.OrgRoleOwner, models.OrgRoleAdmin, models.OrgRoleMember}
+
+type Repository interface {
+	CreateOrganization(ctx context.Context, org models.Organization, ownerID int) (models.Organization, error)
+	ListUserOrganizations(ctx context.Context, userID int) ([]models.Membership, error)
+	GetMembership(ctx context.Context, orgID string, userID int) (models.Membership, error)
+	ListMembers(ctx context.Context, orgID string) ([]models.Membership, error)
+	UpdateMemberRole(ctx context.Context, orgID string, userID int, role string) error
+	RemoveMember(ctx context.Context, orgID string, userID int) error
+	CreateInvitation(ctx context.Context, inv models.Invitation) error
+	GetInvitation(ctx context.Context, id string) (models.Invitation, error)
+	AcceptInvitation(ctx context.Context, id string, member models.Membership) error
+}
+
+type Service struct {
+	Logger *slog.Logger
+	Auth   *auth.Auth
+	Orgs   Repository
+	// InviteTTL is the lifetime of invite tokens
+	InviteTTL time.Duration
+}
+
+func NewService(logger *slog.Logger, authSvc *auth.Auth, orgs Repository) *Service {
+	return &Service{
+		Logger:    logger,
+		Auth:      authSvc,
+		Orgs:      orgs,
+		InviteTTL: 7 * 24 * time.Hour,
+	}
+}
+
+func mapError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrOrganizationNotFound):
+		return ErrOrganizationNotFound
+	case errors.Is(err, storage.ErrMemberNotFound):
+		return ErrMemberNotFound
+	case errors.Is(err, storage.ErrMemberExists):
+		return ErrMemberExists
+	case errors.Is(err, storage.ErrInvitationNotFound), errors.Is(err, storage.ErrInvitationAccepted):
+		return ErrInvalidInvitation
+	}
+	return err
+}
+
+func validateRole(role string) error {
+	if !slices.Contains(roles, role) {
+		return &auth.ValidationError{Violations: []auth.FieldViolation{{Field: "role", Description: "role must be owner, admin or member"}}}
+	}
+	return nil
+}
+
+// member returns the caller's membership. Organizations the caller isn't a
+// member of are reported as not found.
+func (s *Service) member(ctx context.Context, orgID string) (models.Membership, error) {
+	uid, err := s.Auth.RequireUser(ctx)
+	if err != nil {
+		return models.Membership{}, err
+	}
+	membership, err := s.Orgs.GetMembership(ctx, orgID, uid)
+	if errors.Is(err, storage.ErrMemberNotFound) {
+		return models.Membership{}, ErrOrganizationNotFound
+	}
+	return membership, err
+}
+
+// manager returns the caller's membership if it is an owner or admin
+func (s *Service) manager(ctx context.Context, orgID string) (models.Membership, error) {
+	membership, err := s.member(ctx, orgID)
+	if err != nil {
+		return models.Membership{}, err
+	}
+	if membership.Role != models.OrgRoleOwner && membership.Role != models.OrgRoleAdmin {
+		return models.Membership{}, auth.ErrPermissionDenied
+	}
+	return membership, nil
+}
+
+// Create adds an organization owned by the caller
+func (s *Service) Create(ctx context.Context, name string) (models.Organization, error) {
+	uid, err := s.Auth.RequireUser(ctx)
+	if err != nil {
+		return models.Organization{}, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > 100 {
+		return models.Organization{}, &auth.ValidationError{Violations: []auth.FieldViolation{{Field: "name", Description: "name must be 1 to 100 characters"}}}
+	}
+	org, err := s.Orgs.CreateOrganization(ctx, models.Organization{ID: uuid.NewString(), Name: name}, uid)
+	if err != nil {
+		return models.Organization{}, err
+	}
+	s.Logger.Info("Organization created", slog.String("org_id", org.ID), slog.Int("owner", uid))
+	return org, nil
+}
+
+// List returns the caller's memberships
+func (s *Service) List(ctx context.Context) ([]models.Membership, error) {
+	uid, err := s.Auth.RequireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Orgs.ListUserOrganizations(ctx, uid)
+}
+
+// Members lists the members of an organization the caller belongs to
+func (s *Service) Members(ctx context.Context, orgID string) ([]models.Membership, error) {
+	if _, err := s.member(ctx, orgID); err != nil {
+		return nil, err
+	}
+	return s.Orgs.ListMembers(ctx, orgID)
+}
+
+// UpdateRole changes the role of a member. Owners and admins manage roles;
+// only owners grant or take away the owner role.
+func (s *Service) UpdateRole(ctx context.Context, orgID string, userID int, role string) error {
+	if err := validateRole(role); err != nil {
+		return err
+	}
+	caller, err := s.manager(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	target, err := s.Orgs.GetMembership(ctx, orgID, userID)
+	if err != nil {
+		return mapError(err)
+	}
+	if (role == models.OrgRoleOwner || target.Role == models.OrgRoleOwner) && caller.Role != models.OrgRoleOwner {
+		return auth.ErrPermissionDenied
+	}
+	if target.Role == models.OrgRoleOwner && role != models.OrgRoleOwner {
+		if err := s.keepOwner(ctx, orgID, userID); err != nil {
+			return err
+		}
+	}
+	if err := s.Orgs.UpdateMemberRole(ctx, orgID, userID, role); err != nil {
+		return mapError(err)
+	}
+	s.Logger.Info("Member role changed", slog.String("org_id", orgID), slog.Int("uid", userID),
+		slog.String("role", role), slog.Int("by", caller.UserID))
+	return nil
+}
+
+// Remove takes a member out of the organization. Members can leave on their
+// own; removing others takes the owner or admin role, and owners are
+// removed by owners only.
+func (s *Service) Remove(ctx context.Context, orgID string, userID int) error {
+	caller, err := s.member(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	target := caller
+	if userID != caller.UserID {
+		if caller.Role != models.OrgRoleOwner && caller.Role != models.OrgRoleAdmin {
+			return auth.ErrPermissionDenied
+		}
+		if target, err = s.Orgs.GetMembership(ctx, orgID, userID); err != nil {
+			return mapError(err)
+		}
+		if target.Role == models.OrgRoleOwner && caller.Role != models.OrgRoleOwner {
+			return auth.ErrPermissionDenied
+		}
+	}
+	if target.Role == models.OrgRoleOwner {
+		if err := s.keepOwner(ctx, orgID, userID); err != nil {
+			return err
+		}
+	}
+	if err := s.Orgs.RemoveMember(ctx, orgID, userID); err != nil {
+		return mapError(err)
+	}
+	s.Logger.Info("Member removed", slog.String("org_id", orgID), slog.Int("uid", userID), slog.Int("by", caller.UserID))
+	return nil
+}
+
+// keepOwner fails if userID is the only owner of the organization
+func (s *Service) keepOwner(ctx context.Context, orgID string, userID int) error {
+	members, err := s.Orgs.ListMembers(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.Role == models.OrgRoleOwner && m.UserID != userID {
+			return nil
+		}
+	}
+	return ErrLastOwner
+}
+
+// Switch issues tokens for the caller with the organization active, or
+// without an organization if orgID is empty. They keep the auth_time of the
+// caller's token, as switching is not a sign-in.
+func (s *Service) Switch(ctx context.Context, orgID string, binding auth.TokenBinding) (*auth.AuthResponse, error) {
+	uid, err := s.Auth.RequireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	grant := auth.Grant{UserID: uid}
+	if claims, ok := jwtman.FromContext(ctx); ok && claims.AuthTime != nil {
+		grant.AuthTime = claims.AuthTime.Time
+	}
+	if orgID != "" {
+		membership, err := s.member(ctx, orgID)
+		if err != nil {
+			return nil, err
+		}
+		grant.OrgID, grant.OrgRole = membership.OrgID, membership.Role
+	}
+	return s.Auth.IssueTokens(ctx, grant, binding)
+}
@@ -0,0 +1,203 @@
+package organization_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/organization"
+	"auth_service/internal/storage"
+	"auth_service/internal/testutil"
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type MemoryOrgs struct {
+	users       *testutil.UserStore
+	orgs        map[string]models.Organization
+	members     []models.Membership
+	invitations map[string]models.Invitation
+}
+
+func (m *MemoryOrgs) CreateOrganization(ctx context.Context, org models.Organization, ownerID int) (models.Organization, error) {
+	org.CreatedAt = time.Now()
+	m.orgs[org.ID] = org
+	m.members = append(m.members, models.Membership{OrgID: org.ID, OrgName: org.Name, UserID: ownerID, Role: models.OrgRoleOwner})
+	return org, nil
+}
+
+func (m *MemoryOrgs) ListUserOrganizations(ctx context.Context, userID int) ([]models.Membership, error) {
+	var memberships []models.Membership
+	for _, member := range m.members {
+		if member.UserID == userID {
+			memberships = append(memberships, member)
+		}
+	}
+	return memberships, nil
+}
+
+func (m *MemoryOrgs) GetMembership(ctx context.Context, orgID string, userID int) (models.Membership, error) {
+	for _, member := range m.members {
+		if member.OrgID == orgID && member.UserID == userID {
+			return member, nil
+		}
+	}
+	return models.Membership{}, storage.ErrMemberNotFound
+}
+
+func (m *MemoryOrgs) ListMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	var members []models.Membership
+	for _, member := range m.members {
+		if member.OrgID == orgID {
+			user, _ := m.users.GetUserByID(ctx, member.UserID)
+			member.Email = user.Email
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+func (m *MemoryOrgs) UpdateMemberRole(ctx context.Context, orgID string, userID int, role string) error {
+	for i, member := range m.members {
+		if member.OrgID == orgID && member.UserID == userID {
+			m.members[i].Role = role
+			return nil
+		}
+	}
+	return storage.ErrMemberNotFound
+}
+
+func (m *MemoryOrgs) RemoveMember(ctx context.Context, orgID string, userID int) error {
+	for i, member := range m.members {
+		if member.OrgID == orgID && member.UserID == userID {
+			m.members = append(m.members[:i], m.members[i+1:]...)
+			return nil
+		}
+	}
+	return storage.ErrMemberNotFound
+}
+
+func (m *MemoryOrgs) CreateInvitation(ctx context.Context, inv models.Invitation) error {
+	if _, ok := m.orgs[inv.OrgID]; !ok {
+		return storage.ErrOrganizationNotFound
+	}
+	m.invitations[inv.ID] = inv
+	return nil
+}
+
+func (m *MemoryOrgs) GetInvitation(ctx context.Context, id string) (models.Invitation, error) {
+	inv, ok := m.invitations[id]
+	if !ok {
+		return models.Invitation{}, storage.ErrInvitationNotFound
+	}
+	return inv, nil
+}
+
+func (m *MemoryOrgs) AcceptInvitation(ctx context.Context, id string, member models.Membership) error {
+	inv := m.invitations[id]
+	if !inv.AcceptedAt.IsZero() {
+		return storage.ErrInvitationAccepted
+	}
+	if _, err := m.GetMembership(ctx, member.OrgID, member.UserID); err == nil {
+		return storage.ErrMemberExists
+	}
+	inv.AcceptedAt = time.Now()
+	m.invitations[id] = inv
+	m.members = append(m.members, member)
+	return nil
+}
+
+func userContext(uid int) context.Context {
+	return jwtman.NewContext(context.Background(), &jwtman.Claims{UserID: strconv.Itoa(uid)})
+}
+
+func TestService_Invitations(t *testing.T) {
+	users := &testutil.UserStore{}
+	users.CreateNewUser(context.Background(), models.NewUser{Email: "owner@example.com"})
+	orgs := &MemoryOrgs{users: users, orgs: map[string]models.Organization{}, invitations: map[string]models.Invitation{}}
+	jwtManager := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), users, testutil.NewSessionStore(), jwtManager)
+	authSvc.Memberships = orgs
+	svc := organization.NewService(slog.Default(), authSvc, orgs)
+	ownerCtx := userContext(1)
+
+	org, err := svc.Create(ownerCtx, "Acme")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	token, _, err := svc.Invite(ownerCtx, org.ID, "new@example.com", models.OrgRoleAdmin)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := authSvc.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: token}); err == nil {
+		t.Error("expected the invite token not to be a valid access token")
+	}
+	if _, err := svc.Accept(context.Background(), token+"x", "examplepass", auth.TokenBinding{}); !errors.Is(err, organization.ErrInvalidInvitation) {
+		t.Errorf("expected ErrInvalidInvitation, got %v", err)
+	}
+
+	tokens, err := svc.Accept(context.Background(), token, "examplepass", auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected the invitee to be registered, got %v", err)
+	}
+	claims, err := authSvc.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: tokens.AccessToken})
+	if err != nil || claims.OrgID != org.ID || claims.OrgRole != models.OrgRoleAdmin {
+		t.Errorf("expected a token for the organization, got %+v: %v", claims, err)
+	}
+	if _, err := svc.Accept(context.Background(), token, "examplepass", auth.TokenBinding{}); !errors.Is(err, organization.ErrInvalidInvitation) {
+		t.Errorf("expected the invitation to be usable once, got %v", err)
+	}
+
+	adminCtx := userContext(2)
+	if err := svc.UpdateRole(adminCtx, org.ID, 1, models.OrgRoleMember); !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("expected admins not to demote owners, got %v", err)
+	}
+	if err := svc.Remove(ownerCtx, org.ID, 1); !errors.Is(err, organization.ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner, got %v", err)
+	}
+	if err := svc.UpdateRole(ownerCtx, org.ID, 2, models.OrgRoleMember); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	// the refreshed token carries the member's current role
+	refreshed, err := authSvc.Refresh(context.Background(), tokens.RefreshToken, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	claims, _ = authSvc.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: refreshed.AccessToken})
+	if claims.OrgRole != models.OrgRoleMember {
+		t.Errorf("expected the role to be updated on refresh, got %q", claims.OrgRole)
+	}
+
+	if _, err := svc.Switch(userContext(3), org.ID, auth.TokenBinding{}); !errors.Is(err, organization.ErrOrganizationNotFound) {
+		t.Errorf("expected non-members not to switch, got %v", err)
+	}
+	switched, err := svc.Switch(ownerCtx, org.ID, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	claims, _ = authSvc.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: switched.AccessToken})
+	if claims.OrgID != org.ID || claims.OrgRole != models.OrgRoleOwner {
+		t.Errorf("expected the owner's organization claims, got %+v", claims)
+	}
+
+	// switching is not a sign-in, so it must not refresh auth_time
+	if claims.AuthTime != nil {
+		t.Errorf("expected no auth_time without one in the caller's token, got %v", claims.AuthTime)
+	}
+	signedIn := time.Now().Add(-time.Hour).Truncate(time.Second)
+	staleCtx := jwtman.NewContext(context.Background(), &jwtman.Claims{UserID: "1", AuthTime: jwt.NewNumericDate(signedIn)})
+	switched, err = svc.Switch(staleCtx, "", auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	claims, _ = authSvc.VerifyAccessToken(context.Background(), auth.PresentedToken{Token: switched.AccessToken})
+	if claims.AuthTime == nil || !claims.AuthTime.Time.Equal(signedIn) {
+		t.Errorf("expected the caller's auth_time %v, got %v", signedIn, claims.AuthTime)
+	}
+}
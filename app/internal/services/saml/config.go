@@ -0,0 +1,41 @@
+package saml
+
+import (
+	"auth_service/internal/config"
+	"fmt"
+)
+
+// ConnectionConfig is an identity provider users can sign in with
+type ConnectionConfig struct {
+	// Name identifies the connection in URLs
+	Name string `yaml:"name"`
+	// IdPMetadataFile is the metadata document the provider publishes
+	IdPMetadataFile string `yaml:"idp_metadata_file"`
+	// EmailAttribute holds the user's email; the NameID is used when empty
+	EmailAttribute string `yaml:"email_attribute"`
+	// RoleAttribute lists the user's groups, mapped to roles by RoleMap
+	RoleAttribute string            `yaml:"role_attribute"`
+	RoleMap       map[string]string `yaml:"role_map"`
+	// GrantAdmin makes users with the admin role administrators; without it
+	// the provider's roles don't grant administrator rights
+	GrantAdmin bool `yaml:"grant_admin"`
+}
+
+// Config is the content of the SAML config file
+type Config struct {
+	// BaseURL is the public URL of this service the endpoints are served under
+	BaseURL string `yaml:"base_url"`
+	// EntityID of the service provider, <base_url>/saml/metadata by default
+	EntityID    string             `yaml:"entity_id"`
+	Connections []ConnectionConfig `yaml:"connections"`
+}
+
+// LoadConfig reads the YAML config file. ${VAR} references are replaced by
+// environment variables so secrets can stay out of the file.
+func LoadConfig(file string) (Config, error) {
+	var cfg Config
+	if err := config.LoadYAML(file, &cfg); err != nil {
+		return Config{}, fmt.Errorf("load saml config: %w", err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,120 @@
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+	bindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	nameIDEmail   = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	methodBearer  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// idpMetadata is the part of an IdP's EntityDescriptor we use
+type idpMetadata struct {
+	EntityID string `xml:"entityID,attr"`
+	IDP      *struct {
+		Keys []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SSO []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"SingleSignOnService"`
+	} `xml:"IDPSSODescriptor"`
+}
+
+// identityProvider is what a connection needs from the IdP metadata
+type identityProvider struct {
+	entityID string
+	ssoURL   string
+	certs    []*x509.Certificate
+}
+
+func parseIdPMetadata(data []byte) (*identityProvider, error) {
+	var md idpMetadata
+	if err := xml.Unmarshal(data, &md); err != nil {
+		return nil, fmt.Errorf("parse idp metadata: %w", err)
+	}
+	if md.EntityID == "" || md.IDP == nil {
+		return nil, errors.New("idp metadata has no IDPSSODescriptor")
+	}
+
+	idp := &identityProvider{entityID: md.EntityID}
+	for _, sso := range md.IDP.SSO {
+		if sso.Binding == bindingRedirect {
+			idp.ssoURL = sso.Location
+		}
+	}
+	if idp.ssoURL == "" {
+		return nil, errors.New("idp metadata has no HTTP-Redirect SingleSignOnService")
+	}
+	for _, key := range md.IDP.Keys {
+		if key.Use != "" && key.Use != "signing" {
+			continue
+		}
+		for _, encoded := range key.Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+			if err != nil {
+				return nil, fmt.Errorf("invalid idp certificate: %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("invalid idp certificate: %w", err)
+			}
+			idp.certs = append(idp.certs, cert)
+		}
+	}
+	if len(idp.certs) == 0 {
+		return nil, errors.New("idp metadata has no signing certificate")
+	}
+	return idp, nil
+}
+
+type spMetadata struct {
+	XMLName  xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID string   `xml:"entityID,attr"`
+	SP       struct {
+		AuthnRequestsSigned bool          `xml:"AuthnRequestsSigned,attr"`
+		Protocols           string        `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat        string        `xml:"NameIDFormat"`
+		ACS                 []acsEndpoint `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+type acsEndpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+	Index    int    `xml:"index,attr"`
+}
+
+// Metadata returns the SP metadata document listing the assertion consumer
+// service of every connection in the realm of ctx
+func (sp *ServiceProvider) Metadata(ctx context.Context) ([]byte, error) {
+	md := spMetadata{EntityID: sp.EntityID}
+	md.SP.Protocols = nsProtocol
+	md.SP.NameIDFormat = nameIDEmail
+	for i, name := range sp.Connections() {
+		c, _ := sp.connection(ctx, name)
+		md.SP.ACS = append(md.SP.ACS, acsEndpoint{Binding: bindingPOST, Location: c.acsURL, Index: i})
+	}
+	body, err := xml.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
@@ -0,0 +1,272 @@
+package saml
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	// maxClockSkew is tolerated between us and the identity providers
+	maxClockSkew = 2 * time.Minute
+	// maxResponseSize bounds the encoded SAMLResponse
+	maxResponseSize = 512 << 10
+)
+
+// response is the envelope of the assertion
+type response struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Destination  string   `xml:"Destination,attr"`
+	Issuer       string   `xml:"Issuer"`
+	Status       struct {
+		Code struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"StatusCode"`
+	} `xml:"Status"`
+	Assertions []assertion `xml:"Assertion"`
+}
+
+type assertion struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	ID      string   `xml:"ID,attr"`
+	Issuer  string   `xml:"Issuer"`
+	Subject struct {
+		NameID        string `xml:"NameID"`
+		Confirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				Recipient    string    `xml:"Recipient,attr"`
+				InResponseTo string    `xml:"InResponseTo,attr"`
+				NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions *struct {
+		NotBefore            time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter         time.Time `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"Audience"`
+		} `xml:"AudienceRestriction"`
+	} `xml:"Conditions"`
+	Attributes []attribute `xml:"AttributeStatement>Attribute"`
+}
+
+type attribute struct {
+	Name   string   `xml:"Name,attr"`
+	Values []string `xml:"AttributeValue"`
+}
+
+// values returns the values of the attributes with the name
+func (a *assertion) values(name string) []string {
+	var values []string
+	for _, attr := range a.Attributes {
+		if attr.Name == name {
+			values = append(values, attr.Values...)
+		}
+	}
+	return values
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidResponse, fmt.Sprintf(format, args...))
+}
+
+// verify checks the response to the request and returns its assertion and
+// when the assertion expires
+func (c *connection) verify(encoded, requestID, audience string, now time.Time) (*assertion, time.Time, error) {
+	if len(encoded) > maxResponseSize {
+		return nil, time.Time{}, invalid("response is too large")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, time.Time{}, invalid("response is not base64")
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil || doc.Root() == nil {
+		return nil, time.Time{}, invalid("response is not xml")
+	}
+
+	// the envelope is checked before the signature as error responses are
+	// often unsigned; its fields only narrow what is accepted
+	var resp response
+	if err := xml.Unmarshal(raw, &resp); err != nil {
+		return nil, time.Time{}, invalid("malformed response: %v", err)
+	}
+	if resp.Status.Code.Value != statusSuccess {
+		return nil, time.Time{}, invalid("identity provider returned status %s", resp.Status.Code.Value)
+	}
+	if resp.Destination != "" && resp.Destination != c.acsURL {
+		return nil, time.Time{}, invalid("response destination %s is not %s", resp.Destination, c.acsURL)
+	}
+	if resp.InResponseTo != "" && resp.InResponseTo != requestID {
+		return nil, time.Time{}, invalid("response answers another request")
+	}
+	if resp.Issuer != "" && resp.Issuer != c.idp.entityID {
+		return nil, time.Time{}, invalid("unexpected response issuer %s", resp.Issuer)
+	}
+
+	a, err := c.verifiedAssertion(doc.Root())
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	expiry, err := c.checkAssertion(a, requestID, audience, now)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return a, expiry, nil
+}
+
+// verifiedAssertion returns the assertion covered by a valid signature of
+// the identity provider, on either the response or the assertion. Only the
+// signed content is read, so unsigned elements wrapped around or next to it
+// are ignored.
+func (c *connection) verifiedAssertion(root *etree.Element) (*assertion, error) {
+	if sig, _ := etreeutils.NSFindOneChild(root, dsig.Namespace, "Signature"); sig != nil {
+		el, err := c.validator.Validate(root)
+		if err != nil {
+			return nil, invalid("response signature: %v", err)
+		}
+		var resp response
+		if err := unmarshalElement(el, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Assertions) != 1 {
+			return nil, invalid("expected one assertion, got %d", len(resp.Assertions))
+		}
+		return &resp.Assertions[0], nil
+	}
+
+	var found []*etree.Element
+	for _, child := range root.ChildElements() {
+		if child.NamespaceURI() != nsAssertion {
+			continue
+		}
+		switch child.Tag {
+		case "Assertion":
+			found = append(found, child)
+		case "EncryptedAssertion":
+			return nil, invalid("encrypted assertions are not supported")
+		}
+	}
+	if len(found) != 1 {
+		return nil, invalid("expected one assertion, got %d", len(found))
+	}
+	// the assertion is validated on its own, with the namespaces declared
+	// by its ancestors
+	ctx, err := etreeutils.NSBuildParentContext(found[0])
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	detached, err := etreeutils.NSDetatch(ctx, found[0])
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	el, err := c.validator.Validate(detached)
+	if err != nil {
+		return nil, invalid("assertion signature: %v", err)
+	}
+	var a assertion
+	if err := unmarshalElement(el, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func unmarshalElement(el *etree.Element, v any) error {
+	doc := etree.NewDocument()
+	doc.SetRoot(el)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		return invalid("%v", err)
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return invalid("malformed signed content: %v", err)
+	}
+	return nil
+}
+
+// checkAssertion checks the issuer, the bearer subject confirmation and the
+// conditions and returns when the assertion expires
+func (c *connection) checkAssertion(a *assertion, requestID, audience string, now time.Time) (time.Time, error) {
+	if a.ID == "" {
+		return time.Time{}, invalid("assertion has no id")
+	}
+	if a.Issuer != c.idp.entityID {
+		return time.Time{}, invalid("unexpected assertion issuer %s", a.Issuer)
+	}
+
+	var expiry time.Time
+	for _, sc := range a.Subject.Confirmations {
+		d := sc.Data
+		if sc.Method == methodBearer && d.Recipient == c.acsURL && d.InResponseTo == requestID &&
+			!d.NotOnOrAfter.IsZero() && now.Before(d.NotOnOrAfter.Add(maxClockSkew)) {
+			expiry = d.NotOnOrAfter
+			break
+		}
+	}
+	if expiry.IsZero() {
+		return time.Time{}, invalid("no bearer subject confirmation for %s is valid", c.acsURL)
+	}
+
+	cond := a.Conditions
+	if cond == nil {
+		return time.Time{}, invalid("assertion has no conditions")
+	}
+	if !cond.NotBefore.IsZero() && now.Add(maxClockSkew).Before(cond.NotBefore) {
+		return time.Time{}, invalid("assertion is not valid before %s", cond.NotBefore)
+	}
+	if !cond.NotOnOrAfter.IsZero() {
+		if !now.Before(cond.NotOnOrAfter.Add(maxClockSkew)) {
+			return time.Time{}, invalid("assertion expired at %s", cond.NotOnOrAfter)
+		}
+		if cond.NotOnOrAfter.Before(expiry) {
+			expiry = cond.NotOnOrAfter
+		}
+	}
+	// every audience restriction must include us (SAML core section 2.5.1.4)
+	if len(cond.AudienceRestrictions) == 0 {
+		return time.Time{}, invalid("assertion has no audience restriction")
+	}
+	for _, ar := range cond.AudienceRestrictions {
+		if !slices.Contains(ar.Audiences, audience) {
+			return time.Time{}, invalid("assertion is not intended for %s", audience)
+		}
+	}
+	return expiry, nil
+}
+
+// mapAttributes returns the user's email and the roles of their groups
+func (c *connection) mapAttributes(a *assertion) (string, []string, error) {
+	email := a.Subject.NameID
+	if c.EmailAttribute != "" {
+		values := a.values(c.EmailAttribute)
+		if len(values) == 0 {
+			return "", nil, invalid("assertion has no %s attribute", c.EmailAttribute)
+		}
+		email = values[0]
+	}
+	email = strings.TrimSpace(email)
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return "", nil, invalid("assertion has no valid email address")
+	}
+
+	roles := []string{}
+	if c.RoleAttribute != "" {
+		for _, group := range a.values(c.RoleAttribute) {
+			if role, ok := c.RoleMap[strings.TrimSpace(group)]; ok && !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	slices.Sort(roles)
+	return email, roles, nil
+}
@@ -0,0 +1,270 @@
+// Package saml lets users sign in with SAML 2.0 identity providers. The
+// service acts as the service provider: it sends AuthnRequests with the
+// HTTP-Redirect binding and consumes signed responses posted back to the
+// assertion consumer service of the connection.
+package saml
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/storage"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+var (
+	ErrUnknownConnection = errors.New("unknown SAML connection")
+	ErrUnsolicited       = errors.New("SAML response does not answer a pending login")
+	ErrInvalidResponse   = errors.New("invalid SAML response")
+)
+
+var connectionName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type UserProvisioner interface {
+	ProvisionUser(ctx context.Context, user models.ProvisionedUser) (models.User, error)
+}
+
+// Store keeps pending requests and remembers consumed assertions
+type Store interface {
+	auth.SessionStorage
+	MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+type connection struct {
+	ConnectionConfig
+	idp *identityProvider
+	// acsURL is the assertion consumer service of the realm of the request;
+	// see ServiceProvider.connection
+	acsURL    string
+	validator *dsig.ValidationContext
+}
+
+type ServiceProvider struct {
+	Logger *slog.Logger
+	Auth   *auth.Auth
+	Users  UserProvisioner
+	Store  Store
+	// EntityID identifies this service provider to the identity providers
+	EntityID string
+	// RequestTTL is how long a user has to sign in at the identity provider
+	RequestTTL  time.Duration
+	baseURL     string
+	connections map[string]*connection
+}
+
+// NewServiceProvider reads the IdP metadata of the connections
+func NewServiceProvider(logger *slog.Logger, authSvc *auth.Auth, users UserProvisioner, store Store, cfg Config) (*ServiceProvider, error) {
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil || !base.IsAbs() {
+		return nil, fmt.Errorf("saml base_url %q must be an absolute url", cfg.BaseURL)
+	}
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if cfg.EntityID == "" {
+		cfg.EntityID = baseURL + "/saml/metadata"
+	}
+
+	sp := &ServiceProvider{
+		Logger:      logger,
+		Auth:        authSvc,
+		Users:       users,
+		Store:       store,
+		EntityID:    cfg.EntityID,
+		RequestTTL:  10 * time.Minute,
+		baseURL:     baseURL,
+		connections: map[string]*connection{},
+	}
+	for _, cc := range cfg.Connections {
+		if !connectionName.MatchString(cc.Name) {
+			return nil, fmt.Errorf("invalid saml connection name %q", cc.Name)
+		}
+		if _, ok := sp.connections[cc.Name]; ok {
+			return nil, fmt.Errorf("duplicate saml connection %q", cc.Name)
+		}
+		data, err := os.ReadFile(cc.IdPMetadataFile)
+		if err != nil {
+			return nil, fmt.Errorf("saml connection %q: %w", cc.Name, err)
+		}
+		idp, err := parseIdPMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("saml connection %q: %w", cc.Name, err)
+		}
+		sp.connections[cc.Name] = &connection{
+			ConnectionConfig: cc,
+			idp:              idp,
+			validator:        dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: idp.certs}),
+		}
+	}
+	return sp, nil
+}
+
+// connection returns the named connection with the assertion consumer service
+// of the realm of ctx, so logins complete in the realm they started in
+func (sp *ServiceProvider) connection(ctx context.Context, name string) (*connection, bool) {
+	c, ok := sp.connections[name]
+	if !ok {
+		return nil, false
+	}
+	rc := *c
+	rc.acsURL = sp.baseURL + realm.BasePath(ctx) + "/saml/" + name + "/acs"
+	return &rc, true
+}
+
+// pendingRequest is the value of a pending request: its connection and the
+// realm it was sent from
+func pendingRequest(ctx context.Context, name string) string {
+	return realm.Name(ctx) + "/" + name
+}
+
+// Connections returns the names of the configured connections
+func (sp *ServiceProvider) Connections() []string {
+	names := make([]string, 0, len(sp.connections))
+	for name := range sp.connections {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+type authnRequest struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID           string   `xml:"ID,attr"`
+	Version      string   `xml:"Version,attr"`
+	IssueInstant string   `xml:"IssueInstant,attr"`
+	Destination  string   `xml:"Destination,attr"`
+	ACSURL       string   `xml:"AssertionConsumerServiceURL,attr"`
+	Binding      string   `xml:"ProtocolBinding,attr"`
+	Issuer       struct {
+		XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		Value   string   `xml:",chardata"`
+	}
+	NameIDPolicy struct {
+		Format      string `xml:"Format,attr"`
+		AllowCreate bool   `xml:"AllowCreate,attr"`
+	} `xml:"NameIDPolicy"`
+}
+
+// Start begins a login at the identity provider. It returns the URL to send
+// the browser to and the id of the request the response must answer.
+func (sp *ServiceProvider) Start(ctx context.Context, name string) (string, string, error) {
+	c, ok := sp.connection(ctx, name)
+	if !ok {
+		return "", "", ErrUnknownConnection
+	}
+
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	// xs:ID values must not start with a digit
+	id := "_" + hex.EncodeToString(b)
+
+	req := authnRequest{
+		ID:           id,
+		Version:      "2.0",
+		IssueInstant: time.Now().UTC().Format(time.RFC3339),
+		Destination:  c.idp.ssoURL,
+		ACSURL:       c.acsURL,
+		Binding:      bindingPOST,
+	}
+	req.Issuer.Value = sp.EntityID
+	req.NameIDPolicy.Format = nameIDEmail
+	req.NameIDPolicy.AllowCreate = true
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return "", "", err
+	}
+
+	// HTTP-Redirect binding: DEFLATE, base64, query parameter
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	if _, err := w.Write(body); err != nil {
+		return "", "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", "", err
+	}
+	u, err := url.Parse(c.idp.ssoURL)
+	if err != nil {
+		return "", "", fmt.Errorf("saml connection %q: invalid sso url: %w", name, err)
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	u.RawQuery = q.Encode()
+
+	if err := sp.Store.SetSession(ctx, "saml_request:"+id, pendingRequest(ctx, name), sp.RequestTTL); err != nil {
+		return "", "", err
+	}
+	return u.String(), id, nil
+}
+
+// ACS consumes the SAMLResponse the identity provider posted for the request
+// and issues tokens for the user. Responses not answering a pending request
+// of this browser are rejected, so IdP initiated logins are not supported.
+func (sp *ServiceProvider) ACS(ctx context.Context, name, requestID, samlResponse string, binding auth.TokenBinding) (*auth.AuthResponse, error) {
+	c, ok := sp.connection(ctx, name)
+	if !ok {
+		return nil, ErrUnknownConnection
+	}
+	if requestID == "" {
+		return nil, ErrUnsolicited
+	}
+
+	key := "saml_request:" + requestID
+	pending, err := sp.Store.GetSession(ctx, key)
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUnsolicited
+	}
+	if err != nil {
+		return nil, err
+	}
+	// requests are answered once
+	if err := sp.Store.DeleteSession(ctx, key); err != nil {
+		return nil, err
+	}
+	if pending != pendingRequest(ctx, name) {
+		return nil, ErrUnsolicited
+	}
+
+	a, expiry, err := c.verify(samlResponse, requestID, sp.EntityID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	first, err := sp.Store.MarkUsed(ctx, "saml_assertion:"+name+":"+a.ID, time.Until(expiry)+maxClockSkew)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, fmt.Errorf("%w: assertion %s was already used", ErrInvalidResponse, a.ID)
+	}
+
+	email, roles, err := c.mapAttributes(a)
+	if err != nil {
+		return nil, err
+	}
+	user, err := sp.Users.ProvisionUser(ctx, models.ProvisionedUser{Email: email, Roles: roles, GrantAdmin: c.GrantAdmin})
+	if errors.Is(err, storage.ErrUserExists) {
+		sp.Logger.Warn("SAML login for a local account", slog.String("connection", name))
+		return nil, auth.ErrLocalAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+	sp.Logger.Info("SAML login", slog.String("connection", name), slog.Int("uid", user.UID), slog.Any("roles", roles))
+	return sp.Auth.IssueTokens(ctx, auth.Grant{UserID: user.UID, AuthTime: time.Now()}, binding)
+}
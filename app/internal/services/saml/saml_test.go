@@ -0,0 +1,335 @@
+package saml_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/saml"
+	"auth_service/internal/testutil"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/redis/go-redis/v9"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	idpEntityID = "https://idp.example.com/saml"
+	spEntityID  = "https://auth.example.com/saml/metadata"
+	acsURL      = "https://auth.example.com/saml/corp/acs"
+)
+
+// fixture describes a response of the test IdP; zero fields get valid
+// defaults
+type fixture struct {
+	requestID   string
+	assertionID string
+	email       string
+	groups      []string
+	audience    string
+	recipient   string
+	expires     time.Time
+	// signResponse signs the response instead of the assertion
+	signResponse bool
+}
+
+func loadSigner(t *testing.T) *dsig.SigningContext {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair("testdata/idp-cert.pem", "testdata/idp-key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSigner(t, cert.PrivateKey.(*rsa.PrivateKey), cert.Certificate)
+}
+
+func newSigner(t *testing.T, key *rsa.PrivateKey, certs [][]byte) *dsig.SigningContext {
+	t.Helper()
+	signer, err := dsig.NewSigningContext(key, certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	return signer
+}
+
+// build returns the base64 SAMLResponse signed by signer
+func (f fixture) build(t *testing.T, signer *dsig.SigningContext) string {
+	t.Helper()
+	now := time.Now().UTC()
+	if f.assertionID == "" {
+		f.assertionID = fmt.Sprintf("_a%d", now.UnixNano())
+	}
+	if f.email == "" {
+		f.email = "alice@example.com"
+	}
+	if f.audience == "" {
+		f.audience = spEntityID
+	}
+	if f.recipient == "" {
+		f.recipient = acsURL
+	}
+	if f.expires.IsZero() {
+		f.expires = now.Add(5 * time.Minute)
+	}
+	var attrs strings.Builder
+	for _, g := range f.groups {
+		attrs.WriteString("<saml:AttributeValue>" + g + "</saml:AttributeValue>")
+	}
+	instant, expires := now.Format(time.RFC3339), f.expires.UTC().Format(time.RFC3339)
+
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"` +
+		` ID="_r` + f.assertionID + `" Version="2.0" IssueInstant="` + instant + `" Destination="` + acsURL + `" InResponseTo="` + f.requestID + `">` +
+		`<saml:Issuer>` + idpEntityID + `</saml:Issuer>` +
+		`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>` +
+		`<saml:Assertion ID="` + f.assertionID + `" Version="2.0" IssueInstant="` + instant + `">` +
+		`<saml:Issuer>` + idpEntityID + `</saml:Issuer>` +
+		`<saml:Subject><saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">` + f.email + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+		`<saml:SubjectConfirmationData InResponseTo="` + f.requestID + `" NotOnOrAfter="` + expires + `" Recipient="` + f.recipient + `"/>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotBefore="` + instant + `" NotOnOrAfter="` + expires + `">` +
+		`<saml:AudienceRestriction><saml:Audience>` + f.audience + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+		`<saml:AttributeStatement><saml:Attribute Name="groups">` + attrs.String() + `</saml:Attribute></saml:AttributeStatement>` +
+		`</saml:Assertion></samlp:Response>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := doc.Root()
+	if f.signResponse {
+		signed, err := signer.SignEnveloped(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.SetRoot(signed)
+	} else {
+		assertion := root.SelectElement("Assertion")
+		ctx, _ := etreeutils.NSBuildParentContext(assertion)
+		detached, err := etreeutils.NSDetatch(ctx, assertion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := signer.SignEnveloped(detached)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root.RemoveChild(assertion)
+		root.AddChild(signed)
+	}
+	out, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(out))
+}
+
+type MemoryStore struct {
+	data map[string]string
+}
+
+func (m *MemoryStore) SetSession(ctx context.Context, key string, value string, ttl time.Duration) error {
+	m.data[key] = value
+	return nil
+}
+
+func (m *MemoryStore) GetSession(ctx context.Context, key string) (string, error) {
+	v, ok := m.data[key]
+	if !ok {
+		return "", redis.Nil
+	}
+	return v, nil
+}
+
+func (m *MemoryStore) DeleteSession(ctx context.Context, key string) error {
+	delete(m.data, key)
+	return nil
+}
+
+func (m *MemoryStore) MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if _, ok := m.data[key]; ok {
+		return false, nil
+	}
+	m.data[key] = "1"
+	return true, nil
+}
+
+func newServiceProvider(t *testing.T) (*saml.ServiceProvider, *testutil.UserStore) {
+	t.Helper()
+	users := &testutil.UserStore{}
+	store := &MemoryStore{data: map[string]string{}}
+	jwtManager := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), users, store, jwtManager)
+
+	sp, err := saml.NewServiceProvider(slog.Default(), authSvc, users, store, saml.Config{
+		BaseURL: "https://auth.example.com/",
+		Connections: []saml.ConnectionConfig{{
+			Name:            "corp",
+			IdPMetadataFile: "testdata/idp-metadata.xml",
+			RoleAttribute:   "groups",
+			RoleMap:         map[string]string{"auth-admins": models.RoleAdmin, "support": "support"},
+			GrantAdmin:      true,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return sp, users
+}
+
+func TestServiceProvider_Start(t *testing.T) {
+	sp, _ := newServiceProvider(t)
+
+	redirect, id, err := sp.Start(context.Background(), "corp")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	u, _ := url.Parse(redirect)
+	if u.Host != "idp.example.com" || u.Path != "/saml/sso" {
+		t.Errorf("expected a redirect to the IdP, got %s", redirect)
+	}
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`ID="` + id + `"`, `AssertionConsumerServiceURL="` + acsURL + `"`, ">" + spEntityID + "<"} {
+		if !strings.Contains(string(request), want) {
+			t.Errorf("expected the AuthnRequest to contain %s, got %s", want, request)
+		}
+	}
+
+	if _, _, err := sp.Start(context.Background(), "other"); !errors.Is(err, saml.ErrUnknownConnection) {
+		t.Errorf("expected ErrUnknownConnection, got %v", err)
+	}
+
+	metadata, err := sp.Metadata(context.Background())
+	if err != nil || !bytes.Contains(metadata, []byte(`entityID="`+spEntityID+`"`)) || !bytes.Contains(metadata, []byte(`Location="`+acsURL+`"`)) {
+		t.Errorf("expected SP metadata with the ACS, got %s, %v", metadata, err)
+	}
+}
+
+func TestServiceProvider_ACS(t *testing.T) {
+	sp, users := newServiceProvider(t)
+	signer := loadSigner(t)
+	ctx := context.Background()
+
+	start := func() string {
+		_, id, err := sp.Start(ctx, "corp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	id := start()
+	resp, err := sp.ACS(ctx, "corp", id, fixture{requestID: id, groups: []string{"auth-admins", "engineering"}}.build(t, signer), auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected tokens, got %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" {
+		t.Error("expected a token pair")
+	}
+	if user, _ := users.GetUserByEmail(ctx, "alice@example.com"); !reflect.DeepEqual(user.Roles, []string{models.RoleAdmin}) || !user.IsAdmin {
+		t.Errorf("expected alice to be provisioned as admin, got %+v", user)
+	}
+
+	id = start()
+	if _, err := sp.ACS(ctx, "corp", id, fixture{requestID: id, email: "bob@example.com", signResponse: true}.build(t, signer), auth.TokenBinding{}); err != nil {
+		t.Fatalf("expected a signed response to be accepted, got %v", err)
+	}
+	if user, _ := users.GetUserByEmail(ctx, "bob@example.com"); len(user.Roles) != 0 {
+		t.Errorf("expected bob without roles, got %+v", user)
+	}
+
+	// a local account with the asserted email is not taken over
+	if err := users.CreateNewUser(ctx, models.NewUser{Email: "carol@example.com", HashPass: []byte("hash")}); err != nil {
+		t.Fatal(err)
+	}
+	id = start()
+	carol := fixture{requestID: id, email: "carol@example.com", groups: []string{"auth-admins"}}
+	if _, err := sp.ACS(ctx, "corp", id, carol.build(t, signer), auth.TokenBinding{}); !errors.Is(err, auth.ErrLocalAccount) {
+		t.Errorf("expected ErrLocalAccount, got %v", err)
+	}
+	if user, _ := users.GetUserByEmail(ctx, "carol@example.com"); user.IsAdmin || len(user.Roles) != 0 {
+		t.Errorf("expected carol to keep her roles, got %+v", user)
+	}
+
+	// the request is consumed, and the assertion can't be used for another one
+	id = start()
+	replayed := fixture{requestID: id, assertionID: "_replayed"}
+	if _, err := sp.ACS(ctx, "corp", id, replayed.build(t, signer), auth.TokenBinding{}); err != nil {
+		t.Fatalf("expected tokens, got %v", err)
+	}
+	if _, err := sp.ACS(ctx, "corp", id, replayed.build(t, signer), auth.TokenBinding{}); !errors.Is(err, saml.ErrUnsolicited) {
+		t.Errorf("expected the request to be single use, got %v", err)
+	}
+	id = start()
+	replayed.requestID = id
+	if _, err := sp.ACS(ctx, "corp", id, replayed.build(t, signer), auth.TokenBinding{}); !errors.Is(err, saml.ErrInvalidResponse) {
+		t.Errorf("expected the replayed assertion to be rejected, got %v", err)
+	}
+
+	id = start()
+	if _, err := sp.ACS(ctx, "corp", "_forged", fixture{requestID: "_forged"}.build(t, signer), auth.TokenBinding{}); !errors.Is(err, saml.ErrUnsolicited) {
+		t.Errorf("expected an unknown request to be rejected, got %v", err)
+	}
+	if _, err := sp.ACS(ctx, "corp", id, fixture{requestID: "_other"}.build(t, signer), auth.TokenBinding{}); !errors.Is(err, saml.ErrInvalidResponse) {
+		t.Errorf("expected a response to another request to be rejected, got %v", err)
+	}
+
+	otherKey, otherCert, _ := dsig.RandomKeyStoreForTest().GetKeyPair()
+	tampered := func(encoded string) string {
+		raw, _ := base64.StdEncoding.DecodeString(encoded)
+		return base64.StdEncoding.EncodeToString(bytes.Replace(raw, []byte("alice@"), []byte("mallory@"), 1))
+	}
+	wrapped := func(encoded string) string {
+		raw, _ := base64.StdEncoding.DecodeString(encoded)
+		injected := `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_evil"><saml:Issuer>` + idpEntityID + `</saml:Issuer></saml:Assertion></samlp:Response>`
+		return base64.StdEncoding.EncodeToString(bytes.Replace(raw, []byte("</samlp:Response>"), []byte(injected), 1))
+	}
+	for name, build := range map[string]func(id string) string{
+		"wrong audience": func(id string) string {
+			return fixture{requestID: id, audience: "https://other.example.com"}.build(t, signer)
+		},
+		"wrong recipient": func(id string) string {
+			return fixture{requestID: id, recipient: "https://other.example.com/acs"}.build(t, signer)
+		},
+		"expired": func(id string) string {
+			return fixture{requestID: id, expires: time.Now().Add(-10 * time.Minute)}.build(t, signer)
+		},
+		"tampered":          func(id string) string { return tampered(fixture{requestID: id}.build(t, signer)) },
+		"tampered response": func(id string) string { return tampered(fixture{requestID: id, signResponse: true}.build(t, signer)) },
+		"wrapped":           func(id string) string { return wrapped(fixture{requestID: id}.build(t, signer)) },
+		"untrusted signer": func(id string) string {
+			return fixture{requestID: id}.build(t, newSigner(t, otherKey, [][]byte{otherCert}))
+		},
+		"not base64": func(id string) string { return "%%%" },
+	} {
+		id := start()
+		if _, err := sp.ACS(ctx, "corp", id, build(id), auth.TokenBinding{}); !errors.Is(err, saml.ErrInvalidResponse) {
+			t.Errorf("%s: expected ErrInvalidResponse, got %v", name, err)
+		}
+	}
+	if _, err := users.GetUserByEmail(ctx, "mallory@example.com"); err == nil {
+		t.Error("expected no user from a tampered response")
+	}
+}
@@ -0,0 +1,130 @@
+package serviceaccount
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/jwk"
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// assertionLeeway is the accepted clock difference for exp, nbf and iat
+const assertionLeeway = 30 * time.Second
+
+var assertionMethods = []string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "EdDSA"}
+
+// Token verifies a JWT bearer assertion (RFC 7523 section 2.1) and issues an
+// access token for the service account that signed it.
+//
+// The assertion must have iss and sub svc:<account id>, the id of a
+// registered key of the account as kid, one of the configured audiences as
+// aud, an exp at most MaxAssertionTTL away and a jti. Each jti is accepted
+// once.
+func (s *Service) Token(ctx context.Context, assertion string, binding auth.TokenBinding) (*auth.AuthResponse, error) {
+	account, keyID, err := s.verifyAssertion(ctx, assertion)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.Auth.IssueServiceAccountToken(ctx, account.ID, account.Roles, binding)
+	if err != nil {
+		return nil, err
+	}
+	s.record(ctx, account.ID, models.ServiceAccountTokenIssued, jwtman.ServiceAccountPrefix+account.ID, keyID)
+	return tokens, nil
+}
+
+// audience returns the accepted aud values: the configured ones in the
+// default realm, the token endpoint and issuer of the realm in others
+func (s *Service) audience(ctx context.Context) []string {
+	if r := realm.FromContext(ctx); r != nil && r.Name != realm.Default && r.Issuer != "" {
+		return []string{r.Issuer + "/oauth/token", r.Issuer}
+	}
+	return s.Audience
+}
+
+func (s *Service) verifyAssertion(ctx context.Context, assertion string) (models.ServiceAccount, string, error) {
+	if assertion == "" {
+		return models.ServiceAccount{}, "", fmt.Errorf("%w: assertion is required", ErrInvalidAssertion)
+	}
+	audience := s.audience(ctx)
+	if len(audience) == 0 {
+		s.Logger.Warn("Service account assertion rejected, no audience is configured")
+		return models.ServiceAccount{}, "", ErrInvalidAssertion
+	}
+
+	var account models.ServiceAccount
+	var keyID string
+	// lookupErr is a failure of the service rather than of the assertion
+	var lookupErr error
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(assertion, claims, func(token *jwt.Token) (any, error) {
+		keyID, _ = token.Header["kid"].(string)
+		if keyID == "" {
+			return nil, errors.New("kid header is required")
+		}
+		id, ok := strings.CutPrefix(claims.Issuer, jwtman.ServiceAccountPrefix)
+		if !ok || claims.Subject != claims.Issuer {
+			return nil, errors.New("iss and sub must be the service account subject")
+		}
+		var err error
+		if account, err = s.Accounts.GetServiceAccount(ctx, id); err != nil {
+			if !errors.Is(err, storage.ErrServiceAccountNotFound) {
+				lookupErr = err
+			}
+			return nil, err
+		}
+		key, err := s.Accounts.GetServiceAccountKey(ctx, account.ID, keyID)
+		if err != nil {
+			if !errors.Is(err, storage.ErrServiceAccountKeyNotFound) {
+				lookupErr = err
+			}
+			return nil, err
+		}
+		var stored jwk.Key
+		if err := json.Unmarshal(key.PublicKey, &stored); err != nil {
+			lookupErr = fmt.Errorf("invalid stored key: %w", err)
+			return nil, lookupErr
+		}
+		return stored.PublicKey()
+	},
+		jwt.WithValidMethods(assertionMethods),
+		jwt.WithAudience(audience...),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(assertionLeeway),
+	)
+	if lookupErr != nil {
+		return models.ServiceAccount{}, "", lookupErr
+	}
+	if err != nil {
+		s.Logger.Info("Service account assertion rejected", slog.String("iss", claims.Issuer), slog.Any("reason", err))
+		return models.ServiceAccount{}, "", ErrInvalidAssertion
+	}
+
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl > s.MaxAssertionTTL {
+		return models.ServiceAccount{}, "", fmt.Errorf("%w: exp is too far in the future", ErrInvalidAssertion)
+	}
+	if claims.ID == "" {
+		return models.ServiceAccount{}, "", fmt.Errorf("%w: jti is required", ErrInvalidAssertion)
+	}
+	first, err := s.Store.MarkUsed(ctx, "sa_assertion:"+account.ID+":"+claims.ID, ttl+assertionLeeway)
+	if err != nil {
+		return models.ServiceAccount{}, "", err
+	}
+	if !first {
+		s.Logger.Warn("Service account assertion replayed", slog.String("account_id", account.ID), slog.String("jti", claims.ID))
+		return models.ServiceAccount{}, "", fmt.Errorf("%w: assertion already used", ErrInvalidAssertion)
+	}
+	return account, keyID, nil
+}
@@ -0,0 +1,227 @@
+// Package serviceaccount manages service accounts, non-human principals owned
+// by a team, and authenticates them with JWT assertions signed by their keys
+// (RFC 7523).
+package serviceaccount
+
+import (
+	"auth_service/internal/JWT/jwk"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAccountNotFound = errors.New("service account not found")
+	ErrAccountExists   = errors.New("service account name already taken")
+	ErrKeyNotFound     = errors.New("service account key not found")
+	ErrKeyExists       = errors.New("key already registered for the service account")
+	// ErrInvalidAssertion is returned for assertions that don't authenticate
+	// an account; the reason is only logged
+	ErrInvalidAssertion = errors.New("invalid service account assertion")
+)
+
+// eventLimit is the number of audit events returned for an account
+const eventLimit = 100
+
+var (
+	accountName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+	roleName    = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]*$`)
+)
+
+type Repository interface {
+	CreateServiceAccount(ctx context.Context, account models.ServiceAccount) (models.ServiceAccount, error)
+	GetServiceAccount(ctx context.Context, id string) (models.ServiceAccount, error)
+	ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error)
+	DeleteServiceAccount(ctx context.Context, id string) error
+	AddServiceAccountKey(ctx context.Context, key models.ServiceAccountKey) error
+	GetServiceAccountKey(ctx context.Context, accountID, keyID string) (models.ServiceAccountKey, error)
+	DeleteServiceAccountKey(ctx context.Context, accountID, keyID string) error
+	AddServiceAccountEvent(ctx context.Context, event models.ServiceAccountEvent) error
+	ListServiceAccountEvents(ctx context.Context, accountID string, limit int) ([]models.ServiceAccountEvent, error)
+}
+
+// ReplayStore remembers the ids of used assertions
+type ReplayStore interface {
+	MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+type Service struct {
+	Logger   *slog.Logger
+	Auth     *auth.Auth
+	Accounts Repository
+	Store    ReplayStore
+	// Audience lists the values accepted as aud of assertions, usually the
+	// token endpoint URL and the issuer
+	Audience []string
+	// MaxAssertionTTL bounds how far in the future assertions may expire
+	MaxAssertionTTL time.Duration
+}
+
+func NewService(logger *slog.Logger, authSvc *auth.Auth, accounts Repository, store ReplayStore, audience []string) *Service {
+	return &Service{
+		Logger:          logger,
+		Auth:            authSvc,
+		Accounts:        accounts,
+		Store:           store,
+		Audience:        audience,
+		MaxAssertionTTL: time.Hour,
+	}
+}
+
+func mapError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrServiceAccountNotFound):
+		return ErrAccountNotFound
+	case errors.Is(err, storage.ErrServiceAccountExists):
+		return ErrAccountExists
+	case errors.Is(err, storage.ErrServiceAccountKeyNotFound):
+		return ErrKeyNotFound
+	case errors.Is(err, storage.ErrServiceAccountKeyExists):
+		return ErrKeyExists
+	}
+	return err
+}
+
+// record adds an event to the audit trail. Failures are logged by the
+// repository and don't fail the audited action.
+func (s *Service) record(ctx context.Context, accountID, action, actor, keyID string) {
+	_ = s.Accounts.AddServiceAccountEvent(ctx, models.ServiceAccountEvent{
+		AccountID: accountID,
+		Action:    action,
+		Actor:     actor,
+		KeyID:     keyID,
+	})
+	s.Logger.Info("Service account "+strings.ReplaceAll(action, "_", " "),
+		slog.String("account_id", accountID), slog.String("actor", actor), slog.String("key_id", keyID))
+}
+
+// Create adds a service account. Only administrators manage service accounts.
+func (s *Service) Create(ctx context.Context, account models.ServiceAccount) (models.ServiceAccount, error) {
+	admin, err := s.Auth.RequireAdmin(ctx)
+	if err != nil {
+		return models.ServiceAccount{}, err
+	}
+
+	verr := &auth.ValidationError{}
+	if !accountName.MatchString(account.Name) {
+		verr.Add("name", "name must be 1 to 63 lowercase letters, digits, '-' or '_'")
+	}
+	if strings.TrimSpace(account.Owner) == "" {
+		verr.Add("owner", "owner is required")
+	}
+	for _, role := range account.Roles {
+		if !roleName.MatchString(role) {
+			verr.Add("roles", fmt.Sprintf("invalid role %q", role))
+		}
+	}
+	if err := verr.Err(); err != nil {
+		return models.ServiceAccount{}, err
+	}
+
+	account.ID = uuid.NewString()
+	account.Owner = strings.TrimSpace(account.Owner)
+	roles := slices.Clone(account.Roles)
+	slices.Sort(roles)
+	account.Roles = append([]string{}, slices.Compact(roles)...)
+	created, err := s.Accounts.CreateServiceAccount(ctx, account)
+	if err != nil {
+		return models.ServiceAccount{}, mapError(err)
+	}
+	s.record(ctx, created.ID, models.ServiceAccountCreated, admin.SubjectID(), "")
+	return created, nil
+}
+
+func (s *Service) List(ctx context.Context) ([]models.ServiceAccount, error) {
+	if _, err := s.Auth.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return s.Accounts.ListServiceAccounts(ctx)
+}
+
+// Delete removes the account and its keys. Tokens already issued stay valid
+// until they expire.
+func (s *Service) Delete(ctx context.Context, id string) error {
+	admin, err := s.Auth.RequireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.Accounts.DeleteServiceAccount(ctx, id); err != nil {
+		return mapError(err)
+	}
+	s.record(ctx, id, models.ServiceAccountDeleted, admin.SubjectID(), "")
+	return nil
+}
+
+// AddKey registers a public key, given as JWK, for the account. The key id
+// used as kid of assertions is the key's thumbprint.
+func (s *Service) AddKey(ctx context.Context, accountID string, publicKey []byte) (models.ServiceAccountKey, error) {
+	admin, err := s.Auth.RequireAdmin(ctx)
+	if err != nil {
+		return models.ServiceAccountKey{}, err
+	}
+
+	var key jwk.Key
+	if err := json.Unmarshal(publicKey, &key); err != nil {
+		return models.ServiceAccountKey{}, keyViolation("public key must be a JWK")
+	}
+	if key.D != "" {
+		return models.ServiceAccountKey{}, keyViolation("public key must not contain private key material")
+	}
+	if _, err := key.PublicKey(); err != nil {
+		return models.ServiceAccountKey{}, keyViolation(err.Error())
+	}
+	thumbprint, err := key.Thumbprint()
+	if err != nil {
+		return models.ServiceAccountKey{}, keyViolation(err.Error())
+	}
+	// store only the members needed to verify, not use or alg hints
+	stored, err := json.Marshal(jwk.Key{Kty: key.Kty, Crv: key.Crv, X: key.X, Y: key.Y, N: key.N, E: key.E})
+	if err != nil {
+		return models.ServiceAccountKey{}, err
+	}
+
+	saKey := models.ServiceAccountKey{ID: thumbprint, AccountID: accountID, PublicKey: stored, CreatedAt: time.Now()}
+	if err := s.Accounts.AddServiceAccountKey(ctx, saKey); err != nil {
+		return models.ServiceAccountKey{}, mapError(err)
+	}
+	s.record(ctx, accountID, models.ServiceAccountKeyAdded, admin.SubjectID(), thumbprint)
+	return saKey, nil
+}
+
+func keyViolation(description string) error {
+	return &auth.ValidationError{Violations: []auth.FieldViolation{{Field: "public_key", Description: description}}}
+}
+
+// RemoveKey deletes a key of the account. Assertions signed with it are
+// rejected from then on.
+func (s *Service) RemoveKey(ctx context.Context, accountID, keyID string) error {
+	admin, err := s.Auth.RequireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.Accounts.DeleteServiceAccountKey(ctx, accountID, keyID); err != nil {
+		return mapError(err)
+	}
+	s.record(ctx, accountID, models.ServiceAccountKeyRemoved, admin.SubjectID(), keyID)
+	return nil
+}
+
+// Events returns the latest audit events of the account, newest first. The
+// events of deleted accounts are kept.
+func (s *Service) Events(ctx context.Context, accountID string) ([]models.ServiceAccountEvent, error) {
+	if _, err := s.Auth.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return s.Accounts.ListServiceAccountEvents(ctx, accountID, eventLimit)
+}
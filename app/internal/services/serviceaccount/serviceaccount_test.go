@@ -0,0 +1,286 @@
+package serviceaccount_test
+
+import (
+	jwtman "auth_service/internal/JWT/access"
+	"auth_service/internal/JWT/jwk"
+	"auth_service/internal/models"
+	"auth_service/internal/services/auth"
+	"auth_service/internal/services/serviceaccount"
+	"auth_service/internal/storage"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const audience = "https://auth.example.com/oauth/token"
+
+type MockUsers struct{}
+
+func (m *MockUsers) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	return models.User{}, storage.ErrUserNotFound
+}
+
+func (m *MockUsers) GetUserByID(ctx context.Context, UID int) (models.User, error) {
+	return models.User{}, storage.ErrUserNotFound
+}
+
+func (m *MockUsers) CreateNewUser(ctx context.Context, user models.NewUser) error {
+	return nil
+}
+
+func (m *MockUsers) IsAdmin(ctx context.Context, UID int) bool {
+	return UID == 1
+}
+
+type MemoryAccounts struct {
+	accounts map[string]models.ServiceAccount
+	keys     map[string]models.ServiceAccountKey
+	events   []models.ServiceAccountEvent
+}
+
+func (m *MemoryAccounts) CreateServiceAccount(ctx context.Context, account models.ServiceAccount) (models.ServiceAccount, error) {
+	for _, a := range m.accounts {
+		if a.Name == account.Name {
+			return models.ServiceAccount{}, storage.ErrServiceAccountExists
+		}
+	}
+	account.CreatedAt = time.Now()
+	m.accounts[account.ID] = account
+	return account, nil
+}
+
+func (m *MemoryAccounts) GetServiceAccount(ctx context.Context, id string) (models.ServiceAccount, error) {
+	account, ok := m.accounts[id]
+	if !ok {
+		return models.ServiceAccount{}, storage.ErrServiceAccountNotFound
+	}
+	return account, nil
+}
+
+func (m *MemoryAccounts) ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	var accounts []models.ServiceAccount
+	for _, a := range m.accounts {
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+func (m *MemoryAccounts) DeleteServiceAccount(ctx context.Context, id string) error {
+	if _, ok := m.accounts[id]; !ok {
+		return storage.ErrServiceAccountNotFound
+	}
+	delete(m.accounts, id)
+	return nil
+}
+
+func (m *MemoryAccounts) AddServiceAccountKey(ctx context.Context, key models.ServiceAccountKey) error {
+	account, ok := m.accounts[key.AccountID]
+	if !ok {
+		return storage.ErrServiceAccountNotFound
+	}
+	if _, ok := m.keys[key.AccountID+key.ID]; ok {
+		return storage.ErrServiceAccountKeyExists
+	}
+	m.keys[key.AccountID+key.ID] = key
+	account.KeyIDs = append(account.KeyIDs, key.ID)
+	m.accounts[key.AccountID] = account
+	return nil
+}
+
+func (m *MemoryAccounts) GetServiceAccountKey(ctx context.Context, accountID, keyID string) (models.ServiceAccountKey, error) {
+	key, ok := m.keys[accountID+keyID]
+	if !ok {
+		return models.ServiceAccountKey{}, storage.ErrServiceAccountKeyNotFound
+	}
+	return key, nil
+}
+
+func (m *MemoryAccounts) DeleteServiceAccountKey(ctx context.Context, accountID, keyID string) error {
+	if _, ok := m.keys[accountID+keyID]; !ok {
+		return storage.ErrServiceAccountKeyNotFound
+	}
+	delete(m.keys, accountID+keyID)
+	return nil
+}
+
+func (m *MemoryAccounts) AddServiceAccountEvent(ctx context.Context, event models.ServiceAccountEvent) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *MemoryAccounts) ListServiceAccountEvents(ctx context.Context, accountID string, limit int) ([]models.ServiceAccountEvent, error) {
+	var events []models.ServiceAccountEvent
+	for _, e := range slices.Backward(m.events) {
+		if e.AccountID == accountID && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+type MemoryReplay struct {
+	used map[string]bool
+}
+
+func (m *MemoryReplay) MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if m.used[key] {
+		return false, nil
+	}
+	m.used[key] = true
+	return true, nil
+}
+
+func newService(t *testing.T) (*serviceaccount.Service, context.Context) {
+	t.Helper()
+	jwt := &jwtman.JWTManager{SecretKey: []byte("test"), TokenDuration: 15 * time.Minute}
+	authSvc := auth.NewAuth(slog.Default(), &MockUsers{}, nil, jwt)
+	accounts := &MemoryAccounts{accounts: map[string]models.ServiceAccount{}, keys: map[string]models.ServiceAccountKey{}}
+	svc := serviceaccount.NewService(slog.Default(), authSvc, accounts, &MemoryReplay{used: map[string]bool{}}, []string{audience})
+	adminCtx := jwtman.NewContext(context.Background(), &jwtman.Claims{UserID: "1"})
+	return svc, adminCtx
+}
+
+func assertion(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwtlib.RegisteredClaims) string {
+	t.Helper()
+	token := jwtlib.NewWithClaims(jwtlib.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestService_AssertionGrant(t *testing.T) {
+	svc, adminCtx := newService(t)
+	ctx := context.Background()
+
+	if _, err := svc.Create(jwtman.NewContext(ctx, &jwtman.Claims{UserID: "2"}), models.ServiceAccount{Name: "ci", Owner: "platform"}); !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Fatalf("expected non-admins to be denied, got %v", err)
+	}
+	account, err := svc.Create(adminCtx, models.ServiceAccount{Name: "ci", Owner: "platform", Roles: []string{"deployer", "admin"}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := svc.Create(adminCtx, models.ServiceAccount{Name: "ci", Owner: "platform"}); !errors.Is(err, serviceaccount.ErrAccountExists) {
+		t.Errorf("expected ErrAccountExists, got %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, _ := jwk.FromPublicKey(&key.PublicKey)
+	raw, _ := json.Marshal(pub)
+	saKey, err := svc.AddKey(adminCtx, account.ID, raw)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if thumbprint, _ := pub.Thumbprint(); saKey.ID != thumbprint {
+		t.Errorf("expected the thumbprint as key id, got %s", saKey.ID)
+	}
+
+	subject := jwtman.ServiceAccountPrefix + account.ID
+	claims := func() jwtlib.RegisteredClaims {
+		return jwtlib.RegisteredClaims{
+			Issuer:    subject,
+			Subject:   subject,
+			Audience:  jwtlib.ClaimStrings{audience},
+			ExpiresAt: jwtlib.NewNumericDate(time.Now().Add(5 * time.Minute)),
+			IssuedAt:  jwtlib.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+		}
+	}
+
+	valid := assertion(t, key, saKey.ID, claims())
+	tokens, err := svc.Token(ctx, valid, auth.TokenBinding{})
+	if err != nil {
+		t.Fatalf("expected assertion to be accepted, got %v", err)
+	}
+	verified, err := svc.Auth.VerifyAccessToken(ctx, auth.PresentedToken{Token: tokens.AccessToken})
+	if err != nil {
+		t.Fatalf("expected access token to be valid, got %v", err)
+	}
+	if verified.SubjectID() != subject || !slices.Equal(verified.Roles, []string{"admin", "deployer"}) {
+		t.Errorf("unexpected claims %+v", verified)
+	}
+	if _, err := svc.List(jwtman.NewContext(ctx, verified)); err != nil {
+		t.Errorf("expected a service account with the admin role to be an administrator, got %v", err)
+	}
+	if _, err := svc.Token(ctx, valid, auth.TokenBinding{}); !errors.Is(err, serviceaccount.ErrInvalidAssertion) {
+		t.Errorf("expected a replayed assertion to be rejected, got %v", err)
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	wrongAudience := claims()
+	wrongAudience.Audience = jwtlib.ClaimStrings{"https://other.example.com"}
+	longLived := claims()
+	longLived.ExpiresAt = jwtlib.NewNumericDate(time.Now().Add(2 * time.Hour))
+	otherSubject := claims()
+	otherSubject.Subject = "svc:" + uuid.NewString()
+	noJTI := claims()
+	noJTI.ID = ""
+	for name, token := range map[string]string{
+		"wrong signer":   assertion(t, otherKey, saKey.ID, claims()),
+		"unknown kid":    assertion(t, key, "unknown", claims()),
+		"wrong audience": assertion(t, key, saKey.ID, wrongAudience),
+		"long lived":     assertion(t, key, saKey.ID, longLived),
+		"other subject":  assertion(t, key, saKey.ID, otherSubject),
+		"no jti":         assertion(t, key, saKey.ID, noJTI),
+	} {
+		if _, err := svc.Token(ctx, token, auth.TokenBinding{}); !errors.Is(err, serviceaccount.ErrInvalidAssertion) {
+			t.Errorf("%s: expected ErrInvalidAssertion, got %v", name, err)
+		}
+	}
+
+	if err := svc.RemoveKey(adminCtx, account.ID, saKey.ID); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := svc.Token(ctx, assertion(t, key, saKey.ID, claims()), auth.TokenBinding{}); !errors.Is(err, serviceaccount.ErrInvalidAssertion) {
+		t.Errorf("expected assertions of a removed key to be rejected, got %v", err)
+	}
+
+	if err := svc.Delete(adminCtx, account.ID); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	events, err := svc.Events(adminCtx, account.ID)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	want := []string{models.ServiceAccountDeleted, models.ServiceAccountKeyRemoved, models.ServiceAccountTokenIssued,
+		models.ServiceAccountKeyAdded, models.ServiceAccountCreated}
+	if !slices.Equal(actions, want) {
+		t.Errorf("expected audit trail %v, got %v", want, actions)
+	}
+	if events[2].Actor != subject || events[2].KeyID != saKey.ID {
+		t.Errorf("unexpected token event %+v", events[2])
+	}
+}
+
+func TestService_AddKeyRejectsPrivateKeys(t *testing.T) {
+	svc, adminCtx := newService(t)
+	account, err := svc.Create(adminCtx, models.ServiceAccount{Name: "ci", Owner: "platform"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, _ := jwk.FromPublicKey(&key.PublicKey)
+	pub.D = "c2VjcmV0"
+	raw, _ := json.Marshal(pub)
+
+	var verr *auth.ValidationError
+	if _, err := svc.AddKey(adminCtx, account.ID, raw); !errors.As(err, &verr) {
+		t.Errorf("expected ValidationError, got %v", err)
+	}
+}
@@ -24,6 +24,36 @@ func (r *RedisStorage) DeleteSession(ctx context.Context, token string) error {
 	return r.Redis.Del(ctx, token).Err()
 }
 
+// MarkUsed sets key only if it is absent and reports whether it was set
+func (r *RedisStorage) MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return r.Redis.SetNX(ctx, key, 1, ttl).Result()
+}
+
+// AddToIndex adds member to the set at key and extends the set's lifetime
+// to ttl
+func (r *RedisStorage) AddToIndex(ctx context.Context, key, member string, ttl time.Duration) error {
+	pipe := r.Redis.TxPipeline()
+	pipe.SAdd(ctx, key, member)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisStorage) IndexMembers(ctx context.Context, key string) ([]string, error) {
+	return r.Redis.SMembers(ctx, key).Result()
+}
+
+func (r *RedisStorage) RemoveFromIndex(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	args := make([]any, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	return r.Redis.SRem(ctx, key, args...).Err()
+}
+
 func NewRedisClient(Addr string) *redis.Client {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     Addr,
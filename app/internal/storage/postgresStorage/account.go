@@ -0,0 +1,105 @@
+package postgresstorage
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// anonymousActor replaces the subject of purged users in audit records
+const anonymousActor = "deleted_user"
+
+// ScheduleUserDeletion marks the user deleted and sets when it is purged
+func (p *Postgres) ScheduleUserDeletion(ctx context.Context, userID int, purgeAfter time.Time) error {
+	query := `UPDATE users SET status = $3, status_reason = '', status_changed_at = now(), purge_after = $4
+		WHERE uid = $1 AND realm = $2`
+	return p.updateUser(ctx, "Scheduling user deletion failed", query, userID, models.UserDeleted, purgeAfter)
+}
+
+// DueUserDeletions returns the ids of deleted users of all realms whose
+// grace period is over
+func (p *Postgres) DueUserDeletions(ctx context.Context, limit int) ([]int, error) {
+	query := `SELECT uid FROM users WHERE status = $1 AND purge_after <= now() ORDER BY purge_after LIMIT $2`
+	rows, err := p.Database.QueryContext(ctx, query, models.UserDeleted, limit)
+	if err != nil {
+		p.Logger.Error("Listing due user deletions failed", slog.Any("error", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PurgeUser deletes a deleted user with their API keys, linked identities,
+// memberships and pending invitations, and anonymizes the audit records
+// about and by the user. Organizations the user is the only owner of pass to
+// the member who joined first, admins before others, or are deleted if the
+// user is their only member. Users restored in the meantime are not found.
+func (p *Postgres) PurgeUser(ctx context.Context, userID int) error {
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var realmName, email string
+	query := `SELECT realm, email FROM users WHERE uid = $1 AND status = $2 AND purge_after <= now() FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, userID, models.UserDeleted).Scan(&realmName, &email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrUserNotFound
+		}
+		p.Logger.Error("Purging user failed", slog.Int("uid", userID), slog.Any("error", err))
+		return err
+	}
+
+	subject := strconv.Itoa(userID)
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		// memberships go with the user, so organizations left without an
+		// owner get one first
+		{`UPDATE organization_members m SET role = $2
+			FROM (
+				SELECT DISTINCT ON (c.org_id) c.org_id, c.user_id
+				FROM organization_members c
+				JOIN organization_members u ON u.org_id = c.org_id AND u.user_id = $1 AND u.role = $2
+				WHERE c.user_id <> $1 AND NOT EXISTS (
+					SELECT 1 FROM organization_members o
+					WHERE o.org_id = c.org_id AND o.user_id <> $1 AND o.role = $2)
+				ORDER BY c.org_id, c.role = $3 DESC, c.created_at, c.user_id
+			) heir
+			WHERE m.org_id = heir.org_id AND m.user_id = heir.user_id`,
+			[]any{userID, models.OrgRoleOwner, models.OrgRoleAdmin}},
+		{`DELETE FROM organizations o
+			WHERE EXISTS (SELECT 1 FROM organization_members m WHERE m.org_id = o.id AND m.user_id = $1)
+			AND NOT EXISTS (SELECT 1 FROM organization_members m WHERE m.org_id = o.id AND m.user_id <> $1)`,
+			[]any{userID}},
+		{`DELETE FROM users WHERE uid = $1`, []any{userID}},
+		{`DELETE FROM organization_invitations i USING organizations o
+			WHERE o.id = i.org_id AND o.realm = $1 AND i.email = $2`, []any{realmName, email}},
+		{`UPDATE admin_audit_log SET user_id = 0, details = '{}' WHERE user_id = $1`, []any{userID}},
+		{`UPDATE admin_audit_log SET actor = $2 WHERE actor = $1`, []any{subject, anonymousActor}},
+		{`UPDATE service_account_events SET actor = $2 WHERE actor = $1`, []any{subject, anonymousActor}},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			p.Logger.Error("Purging user failed", slog.Int("uid", userID), slog.Any("error", err))
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,126 @@
+package postgresstorage
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/storage"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+)
+
+// likeEscaper escapes the LIKE wildcards of a search term
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListUsers returns the users matching the filter ordered by id
+func (p *Postgres) ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+		WHERE realm = $1 AND uid > $2
+			AND ($3 = '' OR email ILIKE '%' || $3 || '%')
+			AND ($4 = '' OR status = $4)
+			AND (NOT $5 OR is_admin)
+		ORDER BY uid LIMIT $6`
+	rows, err := p.Database.QueryContext(ctx, query, realm.Name(ctx), filter.AfterID,
+		likeEscaper.Replace(filter.Query), filter.Status, filter.AdminsOnly, filter.Limit)
+	if err != nil {
+		p.Logger.Error("Listing users failed", slog.Any("error", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// SetUserStatus changes the status of the user and records the reason and
+// time. A pending purge of a deleted user is cancelled.
+func (p *Postgres) SetUserStatus(ctx context.Context, userID int, status, reason string) error {
+	query := `UPDATE users SET status = $3, status_reason = $4, status_changed_at = now(), purge_after = NULL
+		WHERE uid = $1 AND realm = $2`
+	return p.updateUser(ctx, "Updating user status failed", query, userID, status, reason)
+}
+
+func (p *Postgres) UpdateUserPassword(ctx context.Context, userID int, hash []byte) error {
+	query := `UPDATE users SET password = $3 WHERE uid = $1 AND realm = $2`
+	return p.updateUser(ctx, "Updating password failed", query, userID, hash)
+}
+
+// UpdateUserEmail changes the email and marks it unverified
+func (p *Postgres) UpdateUserEmail(ctx context.Context, userID int, email string) error {
+	query := `UPDATE users SET email = $3, email_verified = FALSE WHERE uid = $1 AND realm = $2`
+	return p.updateUser(ctx, "Updating email failed", query, userID, email)
+}
+
+// DeleteUser deletes the user. Keys, identities and memberships are deleted
+// with it.
+func (p *Postgres) DeleteUser(ctx context.Context, userID int) error {
+	query := `DELETE FROM users WHERE uid = $1 AND realm = $2`
+	return p.updateUser(ctx, "Deleting user failed", query, userID)
+}
+
+// updateUser runs a statement on the user of the realm with the arguments
+// following the id and realm
+func (p *Postgres) updateUser(ctx context.Context, msg, query string, userID int, args ...any) error {
+	res, err := p.Database.ExecContext(ctx, query, append([]any{userID, realm.Name(ctx)}, args...)...)
+	if err != nil {
+		if isPQError(err, uniqueViolation) {
+			return storage.ErrUserExists
+		}
+		p.Logger.Error(msg, slog.Int("uid", userID), slog.Any("error", err))
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrUserNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) AddAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO admin_audit_log (realm, actor, action, user_id, details) VALUES ($1, $2, $3, $4, $5)`
+	_, err = p.Database.ExecContext(ctx, query, realm.Name(ctx), event.Actor, event.Action, event.UserID, details)
+	if err != nil {
+		p.Logger.Error("Recording audit event failed", slog.String("action", event.Action), slog.Int("uid", event.UserID), slog.Any("error", err))
+	}
+	return err
+}
+
+// ListAuditEvents returns audit events older than beforeID, newest first.
+// A zero userID lists the events of all users, a zero beforeID starts with
+// the newest event.
+func (p *Postgres) ListAuditEvents(ctx context.Context, userID int, beforeID int64, limit int) ([]models.AuditEvent, error) {
+	query := `SELECT id, actor, action, user_id, details, created_at FROM admin_audit_log
+		WHERE realm = $1 AND ($2 = 0 OR user_id = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC LIMIT $4`
+	rows, err := p.Database.QueryContext(ctx, query, realm.Name(ctx), userID, beforeID, limit)
+	if err != nil {
+		p.Logger.Error("Listing audit events failed", slog.Any("error", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		var details []byte
+		if err := rows.Scan(&event.ID, &event.Actor, &event.Action, &event.UserID, &details, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &event.Details); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,101 @@
+package postgresstorage
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/lib/pq"
+)
+
+const apiKeyColumns = `id, user_id, name, hash, hint, scopes, expires_at, last_used_at, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var lastUsed sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Hash, &key.Hint,
+		pq.Array(&key.Scopes), &key.ExpiresAt, &lastUsed, &key.CreatedAt)
+	key.LastUsedAt = lastUsed.Time
+	return key, err
+}
+
+func (p *Postgres) CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	query := `INSERT INTO api_keys (id, user_id, name, hash, hint, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING ` + apiKeyColumns
+	created, err := scanAPIKey(p.Database.QueryRowContext(ctx, query,
+		key.ID, key.UserID, key.Name, key.Hash, key.Hint, pq.Array(key.Scopes), key.ExpiresAt))
+	if err != nil {
+		p.Logger.Error("Failure while creating api key", slog.Int("uid", key.UserID), slog.Any("error", err))
+		return models.APIKey{}, err
+	}
+	return created, nil
+}
+
+func (p *Postgres) GetAPIKeyByHash(ctx context.Context, hash []byte) (models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys
+		WHERE hash = $1 AND user_id IN (SELECT uid FROM users WHERE realm = $2)`
+	key, err := scanAPIKey(p.Database.QueryRowContext(ctx, query, hash, realm.Name(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIKey{}, storage.ErrAPIKeyNotFound
+		}
+		p.Logger.Error("Getting api key failed", slog.Any("error", err))
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (p *Postgres) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 ORDER BY created_at`
+	rows, err := p.Database.QueryContext(ctx, query, userID)
+	if err != nil {
+		p.Logger.Error("Listing api keys failed", slog.Int("uid", userID), slog.Any("error", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey removes the key if it belongs to the user
+func (p *Postgres) DeleteAPIKey(ctx context.Context, userID int, id string) error {
+	query := `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`
+	res, err := p.Database.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		var pqErr *pq.Error
+		// ids that are not uuids can't match a key
+		if errors.As(err, &pqErr) && pqErr.Code == invalidTextRepresentation {
+			return storage.ErrAPIKeyNotFound
+		}
+		p.Logger.Error("Deleting api key failed", slog.Int("uid", userID), slog.Any("error", err))
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records the use of the key, at most once a minute
+func (p *Postgres) TouchAPIKey(ctx context.Context, id string) error {
+	query := `UPDATE api_keys SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+	_, err := p.Database.ExecContext(ctx, query, id)
+	return err
+}
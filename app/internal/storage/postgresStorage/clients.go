@@ -0,0 +1,73 @@
+package postgresstorage
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func (p *Postgres) GetClient(ctx context.Context, clientID string) (models.OAuthClient, error) {
+	query := `SELECT client_id, name, secret_hash, redirect_uris, scopes, grant_types, public,
+		previous_secret_hash, previous_secret_expires_at, exchange_audiences, exchange_impersonation, created_at
+		FROM oauth_clients WHERE client_id = $1 AND realm = $2`
+
+	var client models.OAuthClient
+	var previousExpires sql.NullTime
+	err := p.Database.QueryRowContext(ctx, query, clientID, realm.Name(ctx)).Scan(
+		&client.ClientID, &client.Name, &client.SecretHash,
+		pq.Array(&client.RedirectURIs), pq.Array(&client.Scopes), pq.Array(&client.GrantTypes),
+		&client.Public, &client.PreviousSecretHash, &previousExpires,
+		pq.Array(&client.Exchange.Audiences), &client.Exchange.Impersonation, &client.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.OAuthClient{}, storage.ErrClientNotFound
+		}
+		p.Logger.Error("Getting client failed", slog.String("client_id", clientID), slog.Any("error", err))
+		return models.OAuthClient{}, err
+	}
+	client.PreviousSecretExpiresAt = previousExpires.Time
+	return client, nil
+}
+
+func (p *Postgres) CreateClient(ctx context.Context, client models.OAuthClient) error {
+	query := `INSERT INTO oauth_clients (client_id, name, secret_hash, redirect_uris, scopes, grant_types, public,
+		exchange_audiences, exchange_impersonation, realm)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := p.Database.ExecContext(ctx, query, client.ClientID, client.Name, client.SecretHash,
+		pq.Array(client.RedirectURIs), pq.Array(client.Scopes), pq.Array(client.GrantTypes), client.Public,
+		pq.Array(client.Exchange.Audiences), client.Exchange.Impersonation, realm.Name(ctx))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return storage.ErrClientExists
+		}
+		p.Logger.Error("Failure while creating client", slog.String("client_id", client.ClientID), slog.Any("error", err))
+		return err
+	}
+	return nil
+}
+
+// RotateClientSecret replaces the secret and keeps the current one valid
+// until previousExpiresAt
+func (p *Postgres) RotateClientSecret(ctx context.Context, clientID string, secretHash []byte, previousExpiresAt time.Time) error {
+	query := `UPDATE oauth_clients
+		SET previous_secret_hash = secret_hash, previous_secret_expires_at = $3, secret_hash = $2
+		WHERE client_id = $1 AND realm = $4`
+	res, err := p.Database.ExecContext(ctx, query, clientID, secretHash, previousExpiresAt, realm.Name(ctx))
+	if err != nil {
+		p.Logger.Error("Rotating client secret failed", slog.String("client_id", clientID), slog.Any("error", err))
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrClientNotFound
+	}
+	return nil
+}
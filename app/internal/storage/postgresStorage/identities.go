@@ -0,0 +1,120 @@
+package postgresstorage
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/lib/pq"
+)
+
+func (p *Postgres) GetLinkedIdentity(ctx context.Context, provider, subject string) (models.LinkedIdentity, error) {
+	query := `SELECT provider, subject, user_id, email, created_at
+		FROM linked_identities WHERE realm = $1 AND provider = $2 AND subject = $3`
+
+	var identity models.LinkedIdentity
+	var email sql.NullString
+	err := p.Database.QueryRowContext(ctx, query, realm.Name(ctx), provider, subject).Scan(
+		&identity.Provider, &identity.Subject, &identity.UserID, &email, &identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LinkedIdentity{}, storage.ErrIdentityNotFound
+		}
+		p.Logger.Error("Getting linked identity failed", slog.String("provider", provider), slog.Any("error", err))
+		return models.LinkedIdentity{}, err
+	}
+	identity.Email = email.String
+	return identity, nil
+}
+
+// LinkIdentity links the identity to an existing user. The provider verified
+// the user's email address, so it is marked verified as well.
+func (p *Postgres) LinkIdentity(ctx context.Context, identity models.LinkedIdentity) error {
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertIdentity(ctx, tx, identity); err != nil {
+		return p.identityError(identity, err)
+	}
+	query := `UPDATE users SET email_verified = TRUE WHERE uid = $1 AND email = $2`
+	if _, err := tx.ExecContext(ctx, query, identity.UserID, identity.Email); err != nil {
+		p.Logger.Error("Marking email verified failed", slog.Int("uid", identity.UserID), slog.Any("error", err))
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateFederatedUser creates a user with a verified email and no password
+// and links the identity to it
+func (p *Postgres) CreateFederatedUser(ctx context.Context, email string, identity models.LinkedIdentity) (models.User, error) {
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	// an empty hash never matches a password
+	user := models.User{Email: email, HashPass: []byte{}, EmailVerified: true}
+	query := `INSERT INTO users (realm, email, password, email_verified) VALUES ($1, $2, $3, TRUE) RETURNING uid`
+	if err := tx.QueryRowContext(ctx, query, realm.Name(ctx), email, user.HashPass).Scan(&user.UID); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return models.User{}, storage.ErrUserExists
+		}
+		p.Logger.Error("Failure while creating federated user", slog.String("email", email), slog.Any("error", err))
+		return models.User{}, err
+	}
+
+	identity.UserID = user.UID
+	if err := insertIdentity(ctx, tx, identity); err != nil {
+		return models.User{}, p.identityError(identity, err)
+	}
+	return user, tx.Commit()
+}
+
+func insertIdentity(ctx context.Context, tx *sql.Tx, identity models.LinkedIdentity) error {
+	query := `INSERT INTO linked_identities (realm, provider, subject, user_id, email) VALUES ($1, $2, $3, $4, $5)`
+	_, err := tx.ExecContext(ctx, query, realm.Name(ctx), identity.Provider, identity.Subject, identity.UserID, identity.Email)
+	return err
+}
+
+func (p *Postgres) identityError(identity models.LinkedIdentity, err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return storage.ErrIdentityExists
+	}
+	p.Logger.Error("Linking identity failed", slog.String("provider", identity.Provider), slog.Int("uid", identity.UserID), slog.Any("error", err))
+	return err
+}
+
+// ListUserIdentities returns the identities linked to the user, oldest first
+func (p *Postgres) ListUserIdentities(ctx context.Context, userID int) ([]models.LinkedIdentity, error) {
+	query := `SELECT provider, subject, user_id, email, created_at
+		FROM linked_identities WHERE realm = $1 AND user_id = $2 ORDER BY created_at`
+	rows, err := p.Database.QueryContext(ctx, query, realm.Name(ctx), userID)
+	if err != nil {
+		p.Logger.Error("Listing linked identities failed", slog.Int("uid", userID), slog.Any("error", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.LinkedIdentity{}
+	for rows.Next() {
+		var identity models.LinkedIdentity
+		var email sql.NullString
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identity.Email = email.String
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
@@ -0,0 +1,212 @@
+package postgresstorage
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+// CreateOrganization creates the organization with the user as its owner
+func (p *Postgres) CreateOrganization(ctx context.Context, org models.Organization, ownerID int) (models.Organization, error) {
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Organization{}, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO organizations (id, realm, name) VALUES ($1, $2, $3) RETURNING created_at`
+	if err := tx.QueryRowContext(ctx, query, org.ID, realm.Name(ctx), org.Name).Scan(&org.CreatedAt); err != nil {
+		p.Logger.Error("Failure while creating organization", slog.String("name", org.Name), slog.Any("error", err))
+		return models.Organization{}, err
+	}
+	query = `INSERT INTO organization_members (org_id, user_id, role) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, query, org.ID, ownerID, models.OrgRoleOwner); err != nil {
+		p.Logger.Error("Adding organization owner failed", slog.String("org_id", org.ID), slog.Any("error", err))
+		return models.Organization{}, err
+	}
+	return org, tx.Commit()
+}
+
+func (p *Postgres) GetOrganization(ctx context.Context, id string) (models.Organization, error) {
+	query := `SELECT id, name, created_at FROM organizations WHERE id = $1 AND realm = $2`
+	var org models.Organization
+	err := p.Database.QueryRowContext(ctx, query, id, realm.Name(ctx)).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || isPQError(err, invalidTextRepresentation) {
+			return models.Organization{}, storage.ErrOrganizationNotFound
+		}
+		p.Logger.Error("Getting organization failed", slog.String("org_id", id), slog.Any("error", err))
+		return models.Organization{}, err
+	}
+	return org, nil
+}
+
+// ListUserOrganizations returns the memberships of the user with the names
+// of the organizations
+func (p *Postgres) ListUserOrganizations(ctx context.Context, userID int) ([]models.Membership, error) {
+	query := `SELECT m.org_id, o.name, m.user_id, m.role, o.created_at
+		FROM organization_members m JOIN organizations o ON o.id = m.org_id
+		WHERE m.user_id = $1 AND o.realm = $2 ORDER BY o.name`
+	rows, err := p.Database.QueryContext(ctx, query, userID, realm.Name(ctx))
+	if err != nil {
+		p.Logger.Error("Listing organizations failed", slog.Int("uid", userID), slog.Any("error", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []models.Membership{}
+	for rows.Next() {
+		var m models.Membership
+		if err := rows.Scan(&m.OrgID, &m.OrgName, &m.UserID, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+func (p *Postgres) GetMembership(ctx context.Context, orgID string, userID int) (models.Membership, error) {
+	query := `SELECT m.org_id, o.name, m.user_id, m.role, m.created_at
+		FROM organization_members m JOIN organizations o ON o.id = m.org_id
+		WHERE m.org_id = $1 AND m.user_id = $2 AND o.realm = $3`
+	var m models.Membership
+	err := p.Database.QueryRowContext(ctx, query, orgID, userID, realm.Name(ctx)).Scan(&m.OrgID, &m.OrgName, &m.UserID, &m.Role, &m.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || isPQError(err, invalidTextRepresentation) {
+			return models.Membership{}, storage.ErrMemberNotFound
+		}
+		p.Logger.Error("Getting membership failed", slog.String("org_id", orgID), slog.Int("uid", userID), slog.Any("error", err))
+		return models.Membership{}, err
+	}
+	return m, nil
+}
+
+// ListMembers returns the members of the organization with their emails
+func (p *Postgres) ListMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	query := `SELECT m.org_id, m.user_id, u.email, m.role, m.created_at
+		FROM organization_members m JOIN users u ON u.uid = m.user_id
+		WHERE m.org_id = $1 AND u.realm = $2 ORDER BY m.created_at, m.user_id`
+	rows, err := p.Database.QueryContext(ctx, query, orgID, realm.Name(ctx))
+	if err != nil {
+		if isPQError(err, invalidTextRepresentation) {
+			return []models.Membership{}, nil
+		}
+		p.Logger.Error("Listing members failed", slog.String("org_id", orgID), slog.Any("error", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.Membership{}
+	for rows.Next() {
+		var m models.Membership
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (p *Postgres) UpdateMemberRole(ctx context.Context, orgID string, userID int, role string) error {
+	query := `UPDATE organization_members SET role = $3 WHERE org_id = $1 AND user_id = $2`
+	res, err := p.Database.ExecContext(ctx, query, orgID, userID, role)
+	if err != nil {
+		if isPQError(err, invalidTextRepresentation) {
+			return storage.ErrMemberNotFound
+		}
+		p.Logger.Error("Updating member role failed", slog.String("org_id", orgID), slog.Int("uid", userID), slog.Any("error", err))
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrMemberNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) RemoveMember(ctx context.Context, orgID string, userID int) error {
+	query := `DELETE FROM organization_members WHERE org_id = $1 AND user_id = $2`
+	res, err := p.Database.ExecContext(ctx, query, orgID, userID)
+	if err != nil {
+		if isPQError(err, invalidTextRepresentation) {
+			return storage.ErrMemberNotFound
+		}
+		p.Logger.Error("Removing member failed", slog.String("org_id", orgID), slog.Int("uid", userID), slog.Any("error", err))
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrMemberNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) CreateInvitation(ctx context.Context, inv models.Invitation) error {
+	query := `INSERT INTO organization_invitations (id, org_id, email, role, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := p.Database.ExecContext(ctx, query, inv.ID, inv.OrgID, inv.Email, inv.Role, inv.InvitedBy, inv.ExpiresAt)
+	if err != nil {
+		if isPQError(err, foreignKeyViolation) {
+			return storage.ErrOrganizationNotFound
+		}
+		p.Logger.Error("Failure while creating invitation", slog.String("org_id", inv.OrgID), slog.Any("error", err))
+		return err
+	}
+	return nil
+}
+
+func (p *Postgres) GetInvitation(ctx context.Context, id string) (models.Invitation, error) {
+	query := `SELECT i.id, i.org_id, i.email, i.role, i.invited_by, i.expires_at, i.accepted_at, i.created_at
+		FROM organization_invitations i JOIN organizations o ON o.id = i.org_id
+		WHERE i.id = $1 AND o.realm = $2`
+	var inv models.Invitation
+	var invitedBy sql.NullInt64
+	var acceptedAt sql.NullTime
+	err := p.Database.QueryRowContext(ctx, query, id, realm.Name(ctx)).Scan(
+		&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &invitedBy, &inv.ExpiresAt, &acceptedAt, &inv.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || isPQError(err, invalidTextRepresentation) {
+			return models.Invitation{}, storage.ErrInvitationNotFound
+		}
+		p.Logger.Error("Getting invitation failed", slog.String("id", id), slog.Any("error", err))
+		return models.Invitation{}, err
+	}
+	inv.InvitedBy = int(invitedBy.Int64)
+	inv.AcceptedAt = acceptedAt.Time
+	return inv, nil
+}
+
+// AcceptInvitation marks the invitation accepted and adds the member in one
+// transaction, so an invitation adds at most one member
+func (p *Postgres) AcceptInvitation(ctx context.Context, id string, member models.Membership) error {
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE organization_invitations SET accepted_at = now() WHERE id = $1 AND accepted_at IS NULL`
+	res, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		p.Logger.Error("Accepting invitation failed", slog.String("id", id), slog.Any("error", err))
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrInvitationAccepted
+	}
+	query = `INSERT INTO organization_members (org_id, user_id, role) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, query, member.OrgID, member.UserID, member.Role); err != nil {
+		switch {
+		case isPQError(err, uniqueViolation):
+			return storage.ErrMemberExists
+		case isPQError(err, foreignKeyViolation):
+			return storage.ErrOrganizationNotFound
+		}
+		p.Logger.Error("Adding member failed", slog.String("org_id", member.OrgID), slog.Int("uid", member.UserID), slog.Any("error", err))
+		return err
+	}
+	return tx.Commit()
+}
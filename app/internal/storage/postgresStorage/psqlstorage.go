@@ -2,12 +2,25 @@ package postgresstorage
 
 import (
 	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/storage"
 	"context"
 	"database/sql"
 	"errors"
 	"log/slog"
+	"slices"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+)
+
+// Postgres error codes
+const (
+	// uniqueViolation is raised for unique constraint violations
+	uniqueViolation = "23505"
+	// foreignKeyViolation is raised for references to missing rows
+	foreignKeyViolation = "23503"
+	// invalidTextRepresentation is raised for malformed values such as uuids
+	invalidTextRepresentation = "22P02"
 )
 
 type Postgres struct {
@@ -36,22 +49,38 @@ func NewPostgres(dsn string, logger *slog.Logger) *Postgres {
 	return &Postgres{Database: db, Logger: logger}
 }
 
-// ))))
 func (p *Postgres) IsAdmin(ctx context.Context, UID int) bool {
-	return false
+	var isAdmin bool
+	query := `SELECT COALESCE(is_admin, FALSE) FROM users WHERE uid = $1 AND realm = $2`
+	if err := p.Database.QueryRowContext(ctx, query, UID, realm.Name(ctx)).Scan(&isAdmin); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			p.Logger.Error("Checking admin failed", slog.Int("uid", UID), slog.Any("error", err))
+		}
+		return false
+	}
+	return isAdmin
 }
 
-func (p *Postgres) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+// userColumns are the columns scanUser reads
+const userColumns = `uid, email, password, email_verified, roles, COALESCE(is_admin, FALSE),
+	provisioned, status, status_reason, status_changed_at, purge_after, created_at`
 
+func scanUser(row rowScanner) (models.User, error) {
 	var user models.User
-	query := `SELECT uid,email,password FROM users WHERE email = $1`
-
-	row := p.Database.QueryRowContext(ctx, query, email)
+	var statusChangedAt, purgeAfter sql.NullTime
+	err := row.Scan(&user.UID, &user.Email, &user.HashPass, &user.EmailVerified, pq.Array(&user.Roles),
+		&user.IsAdmin, &user.Provisioned, &user.Status, &user.StatusReason, &statusChangedAt, &purgeAfter, &user.CreatedAt)
+	user.StatusChangedAt = statusChangedAt.Time
+	user.PurgeAfter = purgeAfter.Time
+	return user, err
+}
 
-	err := row.Scan(&user.UID, &user.Email, &user.HashPass)
+func (p *Postgres) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE realm = $1 AND email = $2`
+	user, err := scanUser(p.Database.QueryRowContext(ctx, query, realm.Name(ctx), email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.User{}, errors.New("user not found")
+			return models.User{}, storage.ErrUserNotFound
 		}
 		p.Logger.Error("Getting user failed", slog.String("email", email))
 		return models.User{}, err
@@ -60,12 +89,56 @@ func (p *Postgres) GetUserByEmail(ctx context.Context, email string) (models.Use
 	return user, nil
 }
 
+func (p *Postgres) GetUserByID(ctx context.Context, UID int) (models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE uid = $1 AND realm = $2`
+	user, err := scanUser(p.Database.QueryRowContext(ctx, query, UID, realm.Name(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, storage.ErrUserNotFound
+		}
+		p.Logger.Error("Getting user failed", slog.Int("uid", UID), slog.Any("error", err))
+		return models.User{}, err
+	}
+	return user, nil
+}
+
 func (p *Postgres) CreateNewUser(ctx context.Context, newUser models.NewUser) error {
-	query := `INSERT INTO users (email, password) VALUES ($1, $2)`
-	_, err := p.Database.ExecContext(ctx, query, newUser.Email, newUser.HashPass)
+	query := `INSERT INTO users (realm, email, password) VALUES ($1, $2, $3)`
+	_, err := p.Database.ExecContext(ctx, query, realm.Name(ctx), newUser.Email, newUser.HashPass)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return storage.ErrUserExists
+		}
 		p.Logger.Error("Failure while creating user", slog.String("email", newUser.Email), slog.Any("error", err))
 		return err
 	}
 	return nil
 }
+
+// ProvisionUser creates a user managed by an external directory, or updates
+// the roles of one a directory created. Users registered otherwise, with a
+// password or a linked identity, are not taken over: ErrUserExists is
+// returned for them. Provisioned users get no local password.
+func (p *Postgres) ProvisionUser(ctx context.Context, user models.ProvisionedUser) (models.User, error) {
+	roles := user.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	admin := user.GrantAdmin && slices.Contains(roles, models.RoleAdmin)
+	query := `INSERT INTO users (realm, email, password, roles, is_admin, provisioned) VALUES ($1, $2, $3, $4, $5, TRUE)
+		ON CONFLICT (realm, email) DO UPDATE SET roles = EXCLUDED.roles,
+			is_admin = CASE WHEN $6 THEN EXCLUDED.is_admin ELSE users.is_admin END
+		WHERE users.provisioned
+		RETURNING ` + userColumns
+
+	provisioned, err := scanUser(p.Database.QueryRowContext(ctx, query, realm.Name(ctx), user.Email, []byte{}, pq.Array(roles), admin, user.GrantAdmin))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, storage.ErrUserExists
+		}
+		p.Logger.Error("Provisioning user failed", slog.String("email", user.Email), slog.Any("error", err))
+		return models.User{}, err
+	}
+	return provisioned, nil
+}
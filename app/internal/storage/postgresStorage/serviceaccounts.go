@@ -0,0 +1,183 @@
+package postgresstorage
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/realm"
+	"auth_service/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/lib/pq"
+)
+
+// serviceAccountQuery selects accounts with the ids of their keys
+const serviceAccountQuery = `SELECT a.id, a.name, a.description, a.owner, a.roles,
+	coalesce(array_agg(k.key_id ORDER BY k.created_at) FILTER (WHERE k.key_id IS NOT NULL), '{}'), a.created_at
+	FROM service_accounts a LEFT JOIN service_account_keys k ON k.account_id = a.id`
+
+func scanServiceAccount(row rowScanner) (models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	err := row.Scan(&account.ID, &account.Name, &account.Description, &account.Owner,
+		pq.Array(&account.Roles), pq.Array(&account.KeyIDs), &account.CreatedAt)
+	return account, err
+}
+
+// isPQError reports whether err is a postgres error with the code
+func isPQError(err error, code pq.ErrorCode) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == code
+}
+
+func (p *Postgres) CreateServiceAccount(ctx context.Context, account models.ServiceAccount) (models.ServiceAccount, error) {
+	query := `INSERT INTO service_accounts (id, name, description, owner, roles, realm)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`
+	err := p.Database.QueryRowContext(ctx, query, account.ID, account.Name, account.Description,
+		account.Owner, pq.Array(account.Roles), realm.Name(ctx)).Scan(&account.CreatedAt)
+	if err != nil {
+		if isPQError(err, uniqueViolation) {
+			return models.ServiceAccount{}, storage.ErrServiceAccountExists
+		}
+		p.Logger.Error("Failure while creating service account", slog.String("name", account.Name), slog.Any("error", err))
+		return models.ServiceAccount{}, err
+	}
+	account.KeyIDs = []string{}
+	return account, nil
+}
+
+func (p *Postgres) GetServiceAccount(ctx context.Context, id string) (models.ServiceAccount, error) {
+	query := serviceAccountQuery + ` WHERE a.id = $1 AND a.realm = $2 GROUP BY a.id`
+	account, err := scanServiceAccount(p.Database.QueryRowContext(ctx, query, id, realm.Name(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || isPQError(err, invalidTextRepresentation) {
+			return models.ServiceAccount{}, storage.ErrServiceAccountNotFound
+		}
+		p.Logger.Error("Getting service account failed", slog.String("id", id), slog.Any("error", err))
+		return models.ServiceAccount{}, err
+	}
+	return account, nil
+}
+
+func (p *Postgres) ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	query := serviceAccountQuery + ` WHERE a.realm = $1 GROUP BY a.id ORDER BY a.name`
+	rows, err := p.Database.QueryContext(ctx, query, realm.Name(ctx))
+	if err != nil {
+		p.Logger.Error("Listing service accounts failed", slog.Any("error", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []models.ServiceAccount{}
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// DeleteServiceAccount removes the account and its keys. Its events are kept.
+func (p *Postgres) DeleteServiceAccount(ctx context.Context, id string) error {
+	res, err := p.Database.ExecContext(ctx, `DELETE FROM service_accounts WHERE id = $1 AND realm = $2`, id, realm.Name(ctx))
+	if err != nil {
+		if isPQError(err, invalidTextRepresentation) {
+			return storage.ErrServiceAccountNotFound
+		}
+		p.Logger.Error("Deleting service account failed", slog.String("id", id), slog.Any("error", err))
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) AddServiceAccountKey(ctx context.Context, key models.ServiceAccountKey) error {
+	query := `INSERT INTO service_account_keys (account_id, key_id, public_key)
+		SELECT id, $2, $3 FROM service_accounts WHERE id = $1 AND realm = $4`
+	res, err := p.Database.ExecContext(ctx, query, key.AccountID, key.ID, key.PublicKey, realm.Name(ctx))
+	if err != nil {
+		switch {
+		case isPQError(err, uniqueViolation):
+			return storage.ErrServiceAccountKeyExists
+		case isPQError(err, foreignKeyViolation), isPQError(err, invalidTextRepresentation):
+			return storage.ErrServiceAccountNotFound
+		}
+		p.Logger.Error("Adding service account key failed", slog.String("account_id", key.AccountID), slog.Any("error", err))
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) GetServiceAccountKey(ctx context.Context, accountID, keyID string) (models.ServiceAccountKey, error) {
+	query := `SELECT account_id, key_id, public_key, created_at FROM service_account_keys
+		WHERE account_id = $1 AND key_id = $2
+		AND account_id IN (SELECT id FROM service_accounts WHERE realm = $3)`
+	var key models.ServiceAccountKey
+	err := p.Database.QueryRowContext(ctx, query, accountID, keyID, realm.Name(ctx)).Scan(&key.AccountID, &key.ID, &key.PublicKey, &key.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || isPQError(err, invalidTextRepresentation) {
+			return models.ServiceAccountKey{}, storage.ErrServiceAccountKeyNotFound
+		}
+		p.Logger.Error("Getting service account key failed", slog.String("account_id", accountID), slog.Any("error", err))
+		return models.ServiceAccountKey{}, err
+	}
+	return key, nil
+}
+
+func (p *Postgres) DeleteServiceAccountKey(ctx context.Context, accountID, keyID string) error {
+	query := `DELETE FROM service_account_keys WHERE account_id = $1 AND key_id = $2
+		AND account_id IN (SELECT id FROM service_accounts WHERE realm = $3)`
+	res, err := p.Database.ExecContext(ctx, query, accountID, keyID, realm.Name(ctx))
+	if err != nil {
+		if isPQError(err, invalidTextRepresentation) {
+			return storage.ErrServiceAccountKeyNotFound
+		}
+		p.Logger.Error("Deleting service account key failed", slog.String("account_id", accountID), slog.Any("error", err))
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrServiceAccountKeyNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) AddServiceAccountEvent(ctx context.Context, event models.ServiceAccountEvent) error {
+	query := `INSERT INTO service_account_events (account_id, action, actor, key_id, realm) VALUES ($1, $2, $3, $4, $5)`
+	_, err := p.Database.ExecContext(ctx, query, event.AccountID, event.Action, event.Actor, event.KeyID, realm.Name(ctx))
+	if err != nil {
+		p.Logger.Error("Recording service account event failed", slog.String("account_id", event.AccountID), slog.Any("error", err))
+	}
+	return err
+}
+
+// ListServiceAccountEvents returns the latest events of the account, newest first
+func (p *Postgres) ListServiceAccountEvents(ctx context.Context, accountID string, limit int) ([]models.ServiceAccountEvent, error) {
+	query := `SELECT account_id, action, actor, key_id, created_at FROM service_account_events
+		WHERE account_id = $1 AND realm = $3 ORDER BY created_at DESC, id DESC LIMIT $2`
+	rows, err := p.Database.QueryContext(ctx, query, accountID, limit, realm.Name(ctx))
+	if err != nil {
+		if isPQError(err, invalidTextRepresentation) {
+			return []models.ServiceAccountEvent{}, nil
+		}
+		p.Logger.Error("Listing service account events failed", slog.String("account_id", accountID), slog.Any("error", err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.ServiceAccountEvent{}
+	for rows.Next() {
+		var event models.ServiceAccountEvent
+		if err := rows.Scan(&event.AccountID, &event.Action, &event.Actor, &event.KeyID, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,26 @@
+package storage
+
+import "errors"
+
+var (
+	ErrUserExists     = errors.New("user already exists")
+	ErrUserNotFound   = errors.New("user not found")
+	ErrClientExists   = errors.New("client already exists")
+	ErrClientNotFound = errors.New("client not found")
+
+	ErrIdentityExists   = errors.New("identity already linked")
+	ErrIdentityNotFound = errors.New("identity not found")
+
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	ErrServiceAccountExists      = errors.New("service account already exists")
+	ErrServiceAccountNotFound    = errors.New("service account not found")
+	ErrServiceAccountKeyExists   = errors.New("service account key already registered")
+	ErrServiceAccountKeyNotFound = errors.New("service account key not found")
+
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrMemberExists         = errors.New("user is already a member")
+	ErrMemberNotFound       = errors.New("member not found")
+	ErrInvitationNotFound   = errors.New("invitation not found")
+	ErrInvitationAccepted   = errors.New("invitation already accepted")
+)
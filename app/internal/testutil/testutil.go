@@ -0,0 +1,282 @@
+// Package testutil has in-memory fakes of the user and OAuth client
+// repositories and the Redis session storage, shared by the tests of the
+// services built on them.
+package testutil
+
+import (
+	"auth_service/internal/models"
+	"auth_service/internal/storage"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// UserStore keeps users, their identities and API keys and the audit log in
+// memory. Users are numbered from 1 in the order they are created.
+type UserStore struct {
+	Users      []models.User
+	Events     []models.AuditEvent
+	Identities []models.LinkedIdentity
+	APIKeys    []models.APIKey
+	// Admins are the ids of the administrators
+	Admins []int
+}
+
+func (m *UserStore) find(uid int) int {
+	return slices.IndexFunc(m.Users, func(u models.User) bool { return u.UID == uid })
+}
+
+// update applies fn to the user with the id
+func (m *UserStore) update(uid int, fn func(u *models.User)) error {
+	i := m.find(uid)
+	if i < 0 {
+		return storage.ErrUserNotFound
+	}
+	fn(&m.Users[i])
+	return nil
+}
+
+func (m *UserStore) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	for _, u := range m.Users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return models.User{}, storage.ErrUserNotFound
+}
+
+func (m *UserStore) GetUserByID(ctx context.Context, UID int) (models.User, error) {
+	if i := m.find(UID); i >= 0 {
+		return m.Users[i], nil
+	}
+	return models.User{}, storage.ErrUserNotFound
+}
+
+func (m *UserStore) CreateNewUser(ctx context.Context, user models.NewUser) error {
+	if _, err := m.GetUserByEmail(ctx, user.Email); err == nil {
+		return storage.ErrUserExists
+	}
+	m.Users = append(m.Users, models.User{UID: len(m.Users) + 1, Email: user.Email, HashPass: user.HashPass, Status: models.UserActive})
+	return nil
+}
+
+// ProvisionUser only updates users it created, like the database
+func (m *UserStore) ProvisionUser(ctx context.Context, user models.ProvisionedUser) (models.User, error) {
+	i := slices.IndexFunc(m.Users, func(u models.User) bool { return u.Email == user.Email })
+	if i < 0 {
+		m.Users = append(m.Users, models.User{UID: len(m.Users) + 1, Email: user.Email, HashPass: []byte{}, Status: models.UserActive, Provisioned: true})
+		i = len(m.Users) - 1
+	} else if !m.Users[i].Provisioned {
+		return models.User{}, storage.ErrUserExists
+	}
+	u := &m.Users[i]
+	u.Roles = user.Roles
+	if user.GrantAdmin {
+		u.IsAdmin = slices.Contains(user.Roles, models.RoleAdmin)
+	}
+	return *u, nil
+}
+
+func (m *UserStore) IsAdmin(ctx context.Context, UID int) bool {
+	return slices.Contains(m.Admins, UID)
+}
+
+func (m *UserStore) ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+	var users []models.User
+	for _, u := range m.Users {
+		if u.UID > filter.AfterID && strings.Contains(u.Email, filter.Query) &&
+			(filter.Status == "" || filter.Status == u.Status) && len(users) < filter.Limit {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (m *UserStore) ListUserIdentities(ctx context.Context, userID int) ([]models.LinkedIdentity, error) {
+	identities := []models.LinkedIdentity{}
+	for _, identity := range m.Identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (m *UserStore) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	for _, key := range m.APIKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// SetUserStatus cancels the purge of deleted users like the database does
+func (m *UserStore) SetUserStatus(ctx context.Context, userID int, status, reason string) error {
+	return m.update(userID, func(u *models.User) {
+		u.Status, u.StatusReason, u.StatusChangedAt, u.PurgeAfter = status, reason, time.Now(), time.Time{}
+	})
+}
+
+func (m *UserStore) UpdateUserPassword(ctx context.Context, userID int, hash []byte) error {
+	return m.update(userID, func(u *models.User) { u.HashPass = hash })
+}
+
+func (m *UserStore) UpdateUserEmail(ctx context.Context, userID int, email string) error {
+	if _, err := m.GetUserByEmail(ctx, email); err == nil {
+		return storage.ErrUserExists
+	}
+	return m.update(userID, func(u *models.User) { u.Email, u.EmailVerified = email, false })
+}
+
+func (m *UserStore) DeleteUser(ctx context.Context, userID int) error {
+	i := m.find(userID)
+	if i < 0 {
+		return storage.ErrUserNotFound
+	}
+	m.Users = slices.Delete(m.Users, i, i+1)
+	return nil
+}
+
+func (m *UserStore) ScheduleUserDeletion(ctx context.Context, userID int, purgeAfter time.Time) error {
+	return m.update(userID, func(u *models.User) { u.Status, u.PurgeAfter = models.UserDeleted, purgeAfter })
+}
+
+func (m *UserStore) DueUserDeletions(ctx context.Context, limit int) ([]int, error) {
+	ids := []int{}
+	for _, u := range m.Users {
+		if u.Status == models.UserDeleted && !u.PurgeAfter.After(time.Now()) && len(ids) < limit {
+			ids = append(ids, u.UID)
+		}
+	}
+	return ids, nil
+}
+
+// PurgeUser deletes a deleted user; restored users are not found
+func (m *UserStore) PurgeUser(ctx context.Context, userID int) error {
+	i := m.find(userID)
+	if i < 0 || m.Users[i].Status != models.UserDeleted {
+		return storage.ErrUserNotFound
+	}
+	m.Users = slices.Delete(m.Users, i, i+1)
+	return nil
+}
+
+func (m *UserStore) AddAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	event.ID = int64(len(m.Events) + 1)
+	m.Events = append(m.Events, event)
+	return nil
+}
+
+func (m *UserStore) ListAuditEvents(ctx context.Context, userID int, beforeID int64, limit int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	for _, e := range slices.Backward(m.Events) {
+		if (userID == 0 || e.UserID == userID) && (beforeID == 0 || e.ID < beforeID) && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// ClientStore keeps OAuth clients in memory
+type ClientStore struct {
+	Clients map[string]models.OAuthClient
+}
+
+func (m *ClientStore) GetClient(ctx context.Context, clientID string) (models.OAuthClient, error) {
+	client, ok := m.Clients[clientID]
+	if !ok {
+		return models.OAuthClient{}, storage.ErrClientNotFound
+	}
+	return client, nil
+}
+
+func (m *ClientStore) CreateClient(ctx context.Context, client models.OAuthClient) error {
+	if m.Clients == nil {
+		m.Clients = map[string]models.OAuthClient{}
+	}
+	m.Clients[client.ClientID] = client
+	return nil
+}
+
+func (m *ClientStore) RotateClientSecret(ctx context.Context, clientID string, secretHash []byte, previousExpiresAt time.Time) error {
+	client, ok := m.Clients[clientID]
+	if !ok {
+		return storage.ErrClientNotFound
+	}
+	client.PreviousSecretHash, client.PreviousSecretExpiresAt = client.SecretHash, previousExpiresAt
+	client.SecretHash = secretHash
+	m.Clients[clientID] = client
+	return nil
+}
+
+// SessionStore is the Redis session storage and session index in memory.
+// Expiry is not simulated.
+type SessionStore struct {
+	mu      sync.Mutex
+	data    map[string]string
+	indexes map[string][]string
+}
+
+func NewSessionStore() *SessionStore {
+	return &SessionStore{data: map[string]string{}, indexes: map[string][]string{}}
+}
+
+func (m *SessionStore) SetSession(ctx context.Context, key string, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	return nil
+}
+
+func (m *SessionStore) GetSession(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[key]
+	if !ok {
+		return "", redis.Nil
+	}
+	return v, nil
+}
+
+func (m *SessionStore) DeleteSession(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *SessionStore) MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[key]; ok {
+		return false, nil
+	}
+	m.data[key] = "1"
+	return true, nil
+}
+
+func (m *SessionStore) AddToIndex(ctx context.Context, key, member string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.indexes[key] = append(m.indexes[key], member)
+	return nil
+}
+
+func (m *SessionStore) IndexMembers(ctx context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.indexes[key]), nil
+}
+
+func (m *SessionStore) RemoveFromIndex(ctx context.Context, key string, members ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.indexes[key] = slices.DeleteFunc(m.indexes[key], func(s string) bool { return slices.Contains(members, s) })
+	return nil
+}
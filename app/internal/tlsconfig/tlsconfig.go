@@ -0,0 +1,172 @@
+// Package tlsconfig builds server TLS configs whose certificate and client CA
+// bundle are reloaded when the files change on disk.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type ClientAuth string
+
+const (
+	ClientAuthNone     ClientAuth = "none"
+	ClientAuthOptional ClientAuth = "optional"
+	ClientAuthRequire  ClientAuth = "require"
+)
+
+type keyPair struct {
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes []time.Time
+}
+
+// Reloader keeps the current certificate and client CA pool
+type Reloader struct {
+	Logger     *slog.Logger
+	CertFile   string
+	KeyFile    string
+	ClientCA   string
+	ClientAuth ClientAuth
+	current    atomic.Pointer[keyPair]
+}
+
+func NewReloader(logger *slog.Logger, certFile, keyFile, clientCA string, clientAuth ClientAuth) (*Reloader, error) {
+	r := &Reloader{
+		Logger:     logger,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ClientCA:   clientCA,
+		ClientAuth: clientAuth,
+	}
+	switch clientAuth {
+	case ClientAuthNone, ClientAuthOptional, ClientAuthRequire:
+	default:
+		return nil, fmt.Errorf("unknown client auth mode %q", clientAuth)
+	}
+	if clientAuth != ClientAuthNone && clientCA == "" {
+		return nil, errors.New("client certificate verification requires a CA bundle")
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.CertFile, r.KeyFile}
+	if r.ClientCA != "" {
+		files = append(files, r.ClientCA)
+	}
+	return files
+}
+
+func (r *Reloader) modTimes() ([]time.Time, error) {
+	var times []time.Time
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, info.ModTime())
+	}
+	return times, nil
+}
+
+func (r *Reloader) reload() error {
+	times, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+	kp := &keyPair{cert: &cert, modTimes: times}
+
+	if r.ClientCA != "" {
+		pem, err := os.ReadFile(r.ClientCA)
+		if err != nil {
+			return fmt.Errorf("read client ca: %w", err)
+		}
+		kp.clientCA = x509.NewCertPool()
+		if !kp.clientCA.AppendCertsFromPEM(pem) {
+			return errors.New("client ca bundle contains no certificates")
+		}
+	}
+
+	r.current.Store(kp)
+	return nil
+}
+
+// changed reports whether any file has a different mod time than the loaded one
+func (r *Reloader) changed() bool {
+	times, err := r.modTimes()
+	if err != nil {
+		return false
+	}
+	for i, t := range r.current.Load().modTimes {
+		if !t.Equal(times[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch polls the files and reloads them when they change. A failed reload
+// keeps serving the previous certificate.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			r.Logger.Error("TLS reload failed", slog.Any("error", err))
+			continue
+		}
+		r.Logger.Info("TLS certificates reloaded", slog.String("cert", r.CertFile))
+	}
+}
+
+func (r *Reloader) clientAuthType() tls.ClientAuthType {
+	switch r.ClientAuth {
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.NoClientCert
+}
+
+// Config returns a server config that always uses the latest loaded files
+func (r *Reloader) Config(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			kp := r.current.Load()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*kp.cert},
+				ClientCAs:    kp.clientCA,
+				ClientAuth:   r.clientAuthType(),
+			}, nil
+		},
+	}
+}
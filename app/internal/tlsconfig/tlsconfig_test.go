@@ -0,0 +1,164 @@
+package tlsconfig_test
+
+import (
+	"auth_service/internal/clientcert"
+	"auth_service/internal/tlsconfig"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM encoded certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, serial int64, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"tests"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader_MutualTLSAndReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	start := time.Now().Add(-time.Minute)
+	serverCert, serverKey := ca.issue(t, 10, "localhost", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, serverCert, start)
+	writeFile(t, keyFile, serverKey, start)
+	writeFile(t, caFile, ca.pem, start)
+
+	reloader, err := tlsconfig.NewReloader(slog.Default(), certFile, keyFile, caFile, tlsconfig.ClientAuthRequire)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	srv := httptest.NewUnstartedServer(clientcert.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := clientcert.FromContext(r.Context()); id != nil {
+			w.Write([]byte(id.CommonName))
+		}
+	})))
+	srv.TLS = reloader.Config("http/1.1")
+	srv.StartTLS()
+	defer srv.Close()
+
+	clientCert, clientKey := ca.issue(t, 20, "admin-tool", x509.ExtKeyUsageClientAuth)
+	pair, _ := tls.X509KeyPair(clientCert, clientKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	get := func(certs []tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+		return client.Get(srv.URL)
+	}
+
+	if _, err := get(nil); err == nil {
+		t.Error("expected handshake to fail without client certificate")
+	}
+
+	resp, err := get([]tls.Certificate{pair})
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "admin-tool" {
+		t.Errorf("expected client identity admin-tool, got %q", body)
+	}
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 10 {
+		t.Fatalf("expected serial 10, got %d", serial)
+	}
+
+	newCert, newKey := ca.issue(t, 11, "localhost", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, newCert, time.Now())
+	writeFile(t, keyFile, newKey, time.Now())
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := get([]tls.Certificate{pair})
+		if err == nil {
+			resp.Body.Close()
+			if resp.TLS.PeerCertificates[0].SerialNumber.Int64() == 11 {
+				return
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("certificate was not reloaded")
+}
+
+func TestNewReloader_RequiresCA(t *testing.T) {
+	if _, err := tlsconfig.NewReloader(slog.Default(), "a", "b", "", tlsconfig.ClientAuthRequire); err == nil {
+		t.Error("expected error without client CA")
+	}
+}
@@ -0,0 +1,4 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN grant_types,
+    DROP COLUMN previous_secret_hash,
+    DROP COLUMN previous_secret_expires_at;
@@ -0,0 +1,4 @@
+ALTER TABLE oauth_clients
+    ADD COLUMN grant_types TEXT[] NOT NULL DEFAULT '{authorization_code,refresh_token}',
+    ADD COLUMN previous_secret_hash BYTEA,
+    ADD COLUMN previous_secret_expires_at TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN exchange_audiences,
+    DROP COLUMN exchange_impersonation;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE oauth_clients
+    ADD COLUMN exchange_audiences TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN exchange_impersonation BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE linked_identities;
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE linked_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INT NOT NULL REFERENCES users (uid) ON DELETE CASCADE,
+    email TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX linked_identities_user_id_idx ON linked_identities (user_id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE service_account_events;
+DROP TABLE service_account_keys;
+DROP TABLE service_accounts;
\ No newline at end of file
@@ -0,0 +1,28 @@
+CREATE TABLE service_accounts (
+    id UUID PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    owner TEXT NOT NULL,
+    roles TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE service_account_keys (
+    account_id UUID NOT NULL REFERENCES service_accounts (id) ON DELETE CASCADE,
+    key_id TEXT NOT NULL,
+    public_key JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (account_id, key_id)
+);
+
+-- events outlive the account they belong to
+CREATE TABLE service_account_events (
+    id BIGSERIAL PRIMARY KEY,
+    account_id UUID NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    key_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX service_account_events_account_id_idx ON service_account_events (account_id, created_at);
\ No newline at end of file
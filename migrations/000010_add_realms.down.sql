@@ -0,0 +1,14 @@
+ALTER TABLE service_account_events DROP COLUMN realm;
+ALTER TABLE service_accounts DROP CONSTRAINT service_accounts_realm_name_key;
+ALTER TABLE service_accounts DROP COLUMN realm;
+ALTER TABLE service_accounts ADD CONSTRAINT service_accounts_name_key UNIQUE (name);
+
+ALTER TABLE linked_identities DROP CONSTRAINT linked_identities_pkey;
+ALTER TABLE linked_identities DROP COLUMN realm;
+ALTER TABLE linked_identities ADD PRIMARY KEY (provider, subject);
+
+ALTER TABLE oauth_clients DROP COLUMN realm;
+
+ALTER TABLE users DROP CONSTRAINT users_realm_email_key;
+ALTER TABLE users DROP COLUMN realm;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
\ No newline at end of file
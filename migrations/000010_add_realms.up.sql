@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN realm TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_realm_email_key UNIQUE (realm, email);
+
+ALTER TABLE oauth_clients ADD COLUMN realm TEXT NOT NULL DEFAULT 'default';
+
+ALTER TABLE linked_identities ADD COLUMN realm TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE linked_identities DROP CONSTRAINT linked_identities_pkey;
+ALTER TABLE linked_identities ADD PRIMARY KEY (realm, provider, subject);
+
+ALTER TABLE service_accounts ADD COLUMN realm TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE service_accounts DROP CONSTRAINT service_accounts_name_key;
+ALTER TABLE service_accounts ADD CONSTRAINT service_accounts_realm_name_key UNIQUE (realm, name);
+ALTER TABLE service_account_events ADD COLUMN realm TEXT NOT NULL DEFAULT 'default';
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP TABLE admin_audit_log;
+ALTER TABLE users DROP COLUMN status_changed_at;
+ALTER TABLE users DROP COLUMN status_reason;
+ALTER TABLE users DROP COLUMN status;
+ALTER TABLE users DROP COLUMN created_at;
\ No newline at end of file
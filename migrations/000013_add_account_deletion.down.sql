@@ -0,0 +1,7 @@
+DROP INDEX users_purge_after_idx;
+UPDATE users SET status = 'disabled' WHERE status = 'deleted';
+
+ALTER TABLE users DROP COLUMN purge_after;
+ALTER TABLE users DROP CONSTRAINT users_status_check;
+ALTER TABLE users ADD CONSTRAINT users_status_check
+    CHECK (status IN ('active', 'disabled', 'locked', 'pending_verification'));
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE users DROP CONSTRAINT users_status_check;
+ALTER TABLE users ADD CONSTRAINT users_status_check
+    CHECK (status IN ('active', 'disabled', 'locked', 'pending_verification', 'deleted'));
+ALTER TABLE users ADD COLUMN purge_after TIMESTAMPTZ;
+
+CREATE INDEX users_purge_after_idx ON users (purge_after) WHERE status = 'deleted';
\ No newline at end of file
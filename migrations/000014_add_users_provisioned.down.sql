@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN provisioned;
\ No newline at end of file
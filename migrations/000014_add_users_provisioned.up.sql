@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN provisioned BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- users created by LDAP or SAML logins have no password and no linked identity
+UPDATE users SET provisioned = TRUE
+WHERE octet_length(password) = 0
+    AND NOT EXISTS (SELECT 1 FROM linked_identities WHERE linked_identities.user_id = users.uid);
\ No newline at end of file